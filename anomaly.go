@@ -0,0 +1,150 @@
+package httplog
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnomalyFactor        = 3
+	defaultAnomalyBaselineAlpha = 0.01
+	defaultAnomalyCurrentAlpha  = 0.2
+	defaultAnomalyMinRequests   = 100
+	defaultAnomalyMinErrorRate  = 0.05
+	defaultAnomalyCooldown      = time.Minute
+)
+
+// AnomalyDetector flags handlers whose latency or error rate departs from
+// their own baseline, for teams without alerting on metrics. Set it as
+// Server.Anomalies.
+//
+// For each handler it keeps two exponentially weighted moving averages of
+// latency and of the fraction of requests logged at LevelError: a slow
+// baseline and a fast current value. When the current value exceeds the
+// baseline by Factor an "anomaly" entry is logged at Warn level with
+// handler, signal ("latency" or "error_rate"), current, baseline, and
+// factor, the ratio of the two, and httplog_anomalies_total is
+// incremented. Latency is in milliseconds.
+type AnomalyDetector struct {
+	// Factor is how many times the baseline the current value must reach.
+	// The default is 3.
+	Factor float64
+	// BaselineAlpha and CurrentAlpha weight each request in the baseline
+	// and current averages; higher values react faster. The defaults are
+	// 0.01 and 0.2.
+	BaselineAlpha float64
+	CurrentAlpha  float64
+	// MinRequests is the number of requests a handler must serve before its
+	// baseline is trusted. The default is 100.
+	MinRequests int
+	// MinErrorRate is the lowest current error rate reported, so a handler
+	// with a baseline near zero isn't flagged for a single error. The
+	// default is 0.05.
+	MinErrorRate float64
+	// Cooldown is the minimum time between anomalies logged for the same
+	// handler and signal. The default is one minute.
+	Cooldown time.Duration
+
+	mtx      sync.Mutex
+	handlers map[string]*anomalyState
+}
+
+type anomalyState struct {
+	requests         int
+	baselineLatency  float64
+	currentLatency   float64
+	baselineErrors   float64
+	currentErrors    float64
+	lastLatencyAlert time.Time
+	lastErrorAlert   time.Time
+}
+
+// anomaly is a deviation found by an AnomalyDetector.
+type anomaly struct {
+	signal   string
+	current  float64
+	baseline float64
+}
+
+// observe records a request and returns the anomalies it reveals.
+func (d *AnomalyDetector) observe(handlerName string, duration time.Duration, isError bool, now time.Time) []anomaly {
+	if d == nil {
+		return nil
+	}
+	factor := orDefault(d.Factor, defaultAnomalyFactor)
+	baselineAlpha := orDefault(d.BaselineAlpha, defaultAnomalyBaselineAlpha)
+	currentAlpha := orDefault(d.CurrentAlpha, defaultAnomalyCurrentAlpha)
+	minErrorRate := orDefault(d.MinErrorRate, defaultAnomalyMinErrorRate)
+	minRequests := d.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultAnomalyMinRequests
+	}
+	cooldown := d.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultAnomalyCooldown
+	}
+
+	latency := float64(duration) / float64(time.Millisecond)
+	errorValue := 0.0
+	if isError {
+		errorValue = 1
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.handlers == nil {
+		d.handlers = make(map[string]*anomalyState)
+	}
+	s, ok := d.handlers[handlerName]
+	if !ok {
+		s = &anomalyState{baselineLatency: latency, currentLatency: latency}
+		d.handlers[handlerName] = s
+	}
+
+	s.requests++
+	s.baselineLatency += baselineAlpha * (latency - s.baselineLatency)
+	s.currentLatency += currentAlpha * (latency - s.currentLatency)
+	s.baselineErrors += baselineAlpha * (errorValue - s.baselineErrors)
+	s.currentErrors += currentAlpha * (errorValue - s.currentErrors)
+	if s.requests < minRequests {
+		return nil
+	}
+
+	var found []anomaly
+	if s.currentLatency > s.baselineLatency*factor && now.Sub(s.lastLatencyAlert) >= cooldown {
+		s.lastLatencyAlert = now
+		found = append(found, anomaly{signal: "latency", current: s.currentLatency, baseline: s.baselineLatency})
+	}
+	if s.currentErrors >= minErrorRate && s.currentErrors > s.baselineErrors*factor && now.Sub(s.lastErrorAlert) >= cooldown {
+		s.lastErrorAlert = now
+		found = append(found, anomaly{signal: "error_rate", current: s.currentErrors, baseline: s.baselineErrors})
+	}
+	return found
+}
+
+// reportAnomalies logs and counts the anomalies found for a request.
+func (svr *Server) reportAnomalies(handlerName string, found []anomaly) {
+	for _, a := range found {
+		anomaliesTotal.WithLabelValues(handlerName, a.signal).Inc()
+
+		fields := map[string]interface{}{
+			"handler":  handlerName,
+			"signal":   a.signal,
+			"current":  a.current,
+			"baseline": a.baseline,
+		}
+		if a.baseline > 0 {
+			fields["factor"] = a.current / a.baseline
+		}
+		entry := svr.newEntry()
+		entry.AddFields(fields)
+		entry.Warn("anomaly")
+	}
+}
+
+func orDefault(v, def float64) float64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,46 @@
+package httplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	// arrange
+	d := &AnomalyDetector{MinRequests: 50}
+	now := time.Now()
+
+	// act
+	for i := 0; i < 200; i++ {
+		if found := d.observe("orders", 10*time.Millisecond, false, now); len(found) > 0 {
+			t.Fatalf("steady traffic want no anomalies got: %+v", found)
+		}
+	}
+	var latency, errorRate []anomaly
+	for i := 0; i < 20; i++ {
+		for _, a := range d.observe("orders", 100*time.Millisecond, true, now) {
+			if a.signal == "latency" {
+				latency = append(latency, a)
+			} else {
+				errorRate = append(errorRate, a)
+			}
+		}
+	}
+
+	// assert
+	if len(latency) != 1 || len(errorRate) != 1 {
+		t.Fatalf("want one latency and one error_rate anomaly during cooldown got: %d %d", len(latency), len(errorRate))
+	}
+	if latency[0].current <= latency[0].baseline*3 {
+		t.Errorf("latency current want > 3x baseline got: %v %v", latency[0].current, latency[0].baseline)
+	}
+}
+
+func TestAnomalyDetectorWarmup(t *testing.T) {
+	d := &AnomalyDetector{}
+	for i := 0; i < defaultAnomalyMinRequests-1; i++ {
+		if found := d.observe("orders", time.Duration(i)*time.Second, true, time.Now()); len(found) > 0 {
+			t.Fatalf("want no anomalies before MinRequests got: %+v", found)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package httplog
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// utmParams are the UTM query parameters logged by Handler.Attribution.
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// addAttributionFields adds the request's UTM parameters and the domain of
+// its Referer header.
+func addAttributionFields(fields map[string]interface{}, r *http.Request) {
+	if r.URL.RawQuery != "" {
+		q := r.URL.Query()
+		for _, name := range utmParams {
+			if v := q.Get(name); v != "" {
+				fields[name] = v
+			}
+		}
+	}
+
+	if domain := referrerDomain(r.Referer()); domain != "" {
+		fields["referrer_domain"] = domain
+	}
+}
+
+// referrerDomain returns the lowercased host of referer without its port.
+func referrerDomain(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
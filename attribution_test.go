@@ -0,0 +1,27 @@
+package httplog
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAttributionFields(t *testing.T) {
+	// arrange
+	r := httptest.NewRequest("GET", "/landing?utm_source=news&utm_campaign=fall&id=7", nil)
+	r.Header.Set("Referer", "https://WWW.Example.com:8443/article?id=1")
+	fields := make(map[string]interface{})
+
+	// act
+	addAttributionFields(fields, r)
+
+	// assert
+	expected := map[string]interface{}{
+		"utm_source":      "news",
+		"utm_campaign":    "fall",
+		"referrer_domain": "www.example.com",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("want: %v got: %v", expected, fields)
+	}
+}
@@ -0,0 +1,99 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Go runs fn in a background goroutine tied to the Server's lifecycle. The
+// context passed to fn is cancelled when Shutdown is called, and Shutdown
+// waits for fn to return, up to ShutdownTimeout.
+//
+// When fn returns its result is logged with task and time_taken. Errors and
+// panics are logged with their stack trace and passed to OnError with the
+// task name as the HandlerName.
+func (svr *Server) Go(name string, fn func(ctx context.Context) error) {
+	svr.goTask(func(ctx context.Context) {
+		start := time.Now()
+		panicked, err := callRecover(func() error { return fn(ctx) })
+		duration := time.Since(start)
+
+		entry := svr.newEntry()
+		entry.AddFields(map[string]interface{}{
+			"task":       name,
+			"time_taken": int64(duration / time.Millisecond),
+		})
+		if err == nil {
+			entry.Info("background task finished")
+			return
+		}
+
+		entry.AddError(err)
+		if panicked {
+			entry.AddField("panic", true)
+		}
+		entry.Error("background task failed")
+
+		if onError := svr.OnError; onError != nil {
+			onError(ErrorEvent{
+				HandlerName: name,
+				Err:         err,
+				Panic:       panicked,
+				Time:        start,
+			})
+		}
+	})
+}
+
+// goTask runs fn in a goroutine with the Server's background context and
+// tracks it so Shutdown can wait for it.
+func (svr *Server) goTask(fn func(ctx context.Context)) {
+	ctx := svr.backgroundContext()
+	svr.tasks.Add(1)
+	go func() {
+		defer svr.tasks.Done()
+		fn(ctx)
+	}()
+}
+
+func (svr *Server) backgroundContext() context.Context {
+	svr.tasksOnce.Do(func() {
+		svr.tasksCtx, svr.cancelTasks = context.WithCancel(context.Background())
+	})
+	return svr.tasksCtx
+}
+
+// stopTasks cancels background tasks and waits up to timeout for them to
+// return. It returns false if the timeout elapsed first.
+func (svr *Server) stopTasks(timeout time.Duration) bool {
+	svr.backgroundContext()
+	svr.cancelTasks()
+
+	done := make(chan struct{})
+	go func() {
+		svr.tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// callRecover calls fn, returning a panic as an error with a stack trace.
+func callRecover(fn func() error) (panicked bool, err error) {
+	defer func() {
+		if perr := recover(); perr != nil {
+			panicErr, ok := perr.(error)
+			if !ok {
+				panicErr = fmt.Errorf("%v", perr)
+			}
+			panicked, err = true, withStack(panicErr)
+		}
+	}()
+	return false, withStack(fn())
+}
@@ -0,0 +1,31 @@
+package httplog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGoCancelledOnShutdown(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.ShutdownTimeout = 5 * time.Second
+
+	stopped := make(chan struct{})
+	s.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+
+	// act
+	s.Shutdown()
+
+	// assert
+	select {
+	case <-stopped:
+	default:
+		t.Error("want task stopped before Shutdown returns")
+	}
+}
@@ -0,0 +1,174 @@
+package httplog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// BatchWriter writes a batch of access log records at once. See
+// NewBatchSink.
+type BatchWriter interface {
+	WriteBatch(recs []*AccessRecord) error
+}
+
+// BatchSink buffers records and writes them to a BatchWriter when the batch
+// is full or the flush interval elapses, whichever comes first. Remaining
+// records are flushed by Close; records written after Close are dropped and
+// counted.
+type BatchSink struct {
+	writer   BatchWriter
+	maxSize  int
+	interval time.Duration
+
+	mtx     sync.Mutex
+	batch   []*AccessRecord
+	closed  bool
+	dropped int64
+
+	flushMtx sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewBatchSink returns a BatchSink which writes to w. maxSize is the number
+// of records which triggers a flush; the default is 100. interval is the
+// maximum time a record waits before being flushed; the default is 1s.
+func NewBatchSink(w BatchWriter, maxSize int, interval time.Duration) *BatchSink {
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	s := &BatchSink{
+		writer:   w,
+		maxSize:  maxSize,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteRecord adds rec to the current batch, flushing it if it's full. If
+// the sink is closed the record is dropped.
+func (s *BatchSink) WriteRecord(rec *AccessRecord) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.dropped++
+		s.mtx.Unlock()
+		sinkDroppedTotal.Inc()
+		return nil
+	}
+	s.batch = append(s.batch, rec)
+	full := len(s.batch) >= s.maxSize
+	s.mtx.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes the current batch.
+func (s *BatchSink) Flush() error {
+	s.flushMtx.Lock()
+	defer s.flushMtx.Unlock()
+
+	s.mtx.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mtx.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	err := s.writer.WriteBatch(batch)
+	sinkFlushDuration.Observe(time.Since(start).Seconds())
+	sinkBatchSize.Observe(float64(len(batch)))
+	if err != nil {
+		sinkErrorsTotal.Add(float64(len(batch)))
+	}
+	return err
+}
+
+// Dropped returns the number of records dropped because the sink was
+// closed.
+func (s *BatchSink) Dropped() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.dropped
+}
+
+// Close stops the flush timer, flushes the current batch, and closes the
+// BatchWriter if it implements io.Closer. Later calls do nothing.
+func (s *BatchSink) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mtx.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	err := s.Flush()
+	if closer, ok := s.writer.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (s *BatchSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+type jsonLinesWriter struct {
+	w io.Writer
+}
+
+// NewJSONLinesWriter returns a BatchWriter which writes each record to w as
+// a line of JSON. If w implements io.Closer it's closed with the BatchSink.
+func NewJSONLinesWriter(w io.Writer) BatchWriter {
+	return &jsonLinesWriter{w: w}
+}
+
+func (jw *jsonLinesWriter) WriteBatch(recs []*AccessRecord) error {
+	bw := bufio.NewWriter(jw.w)
+	enc := json.NewEncoder(bw)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (jw *jsonLinesWriter) Close() error {
+	if closer, ok := jw.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
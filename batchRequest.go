@@ -0,0 +1,255 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultBatchMaxItems    = 100
+	defaultBatchParallelism = 8
+	defaultBatchMaxDepth    = 4
+)
+
+// BatchOptions configures Server.BatchHandler.
+type BatchOptions struct {
+	// MaxItems is the most sub-requests accepted in one batch. Larger
+	// batches are rejected with StatusRequestEntityTooLarge (413). The
+	// default is 100.
+	MaxItems int
+	// Parallelism is the most sub-requests served at once. The default is
+	// 8.
+	Parallelism int
+	// MaxDepth is the deepest X-Request-Depth a sub-request may have, so
+	// batches which contain the batch endpoint can't recurse without
+	// bound. Deeper items respond with StatusLoopDetected (508). The
+	// default is 4.
+	MaxDepth int
+}
+
+// BatchItem is a sub-request of a batch.
+type BatchItem struct {
+	// ID is echoed in the item's result so clients can match them.
+	// Optional.
+	ID      string            `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResult is the response to a BatchItem. Body holds the response body
+// as JSON when it's valid JSON, otherwise as a string.
+type BatchResult struct {
+	ID      string            `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchHandler returns a Handler which serves a JSON array of BatchItem by
+// dispatching each to mux, usually the http.ServeMux the Server's other
+// handlers are registered with, and responds with an array of BatchResult
+// in the same order. The batch itself always responds with StatusOK (200)
+// and logs batch_items and batch_failed, the number of items with a 4xx or
+// 5xx status. An item whose handler panics without being recovered, such as
+// one not wrapped by Handle, responds with StatusInternalServerError (500);
+// the panics are counted in batch_panics and logged as the batch's error.
+//
+// Sub-requests carry the batch request's headers, overridden by the item's,
+// and are linked to the batch with X-Parent-Request-ID, so each is logged
+// and measured under its own handler name. Their X-Request-Depth is one more
+// than the batch's, and items deeper than MaxDepth aren't served. Since the
+// batch holds a slot while its items run, MaxConcurrentRequests must allow
+// for Parallelism more.
+func (svr *Server) BatchHandler(name string, mux http.Handler, opts BatchOptions) Handler {
+	maxItems := opts.MaxItems
+	if maxItems <= 0 {
+		maxItems = defaultBatchMaxItems
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultBatchParallelism
+	}
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultBatchMaxDepth
+	}
+
+	return Handler{
+		Name:     name,
+		Consumes: []string{"application/json"},
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			var items []BatchItem
+			if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+				return Response{Status: http.StatusBadRequest}, err
+			}
+			entry.AddField("batch_items", len(items))
+			if len(items) > maxItems {
+				return Response{Status: http.StatusRequestEntityTooLarge}, nil
+			}
+
+			results := make([]BatchResult, len(items))
+			panics := make([]error, len(items))
+			sem := make(chan struct{}, parallelism)
+			var wg sync.WaitGroup
+			for i := range items {
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(i int) {
+					defer func() {
+						// mux handlers not wrapped by Handle would otherwise
+						// crash the process, since net/http only recovers
+						// panics on the connection's goroutine
+						if perr := recover(); perr != nil {
+							panicErr, ok := perr.(error)
+							if !ok {
+								panicErr = fmt.Errorf("%v", perr)
+							}
+							panics[i] = withStack(fmt.Errorf("batch item %d: %w", i, panicErr))
+							results[i] = BatchResult{
+								ID:     items[i].ID,
+								Status: http.StatusInternalServerError,
+								Body:   batchErrorBody(http.StatusText(http.StatusInternalServerError)),
+							}
+						}
+						<-sem
+						wg.Done()
+					}()
+					results[i] = serveBatchItem(r, mux, items[i], maxDepth)
+				}(i)
+			}
+			wg.Wait()
+
+			failed := 0
+			for _, result := range results {
+				if result.Status >= 400 {
+					failed++
+				}
+			}
+			entry.AddField("batch_failed", failed)
+
+			// panics are logged with the batch, whose response is still sent
+			var panicErrs []error
+			for _, err := range panics {
+				if err != nil {
+					panicErrs = append(panicErrs, err)
+				}
+			}
+			if len(panicErrs) > 0 {
+				entry.AddField("batch_panics", len(panicErrs))
+				return Response{Body: results}, errors.Join(panicErrs...)
+			}
+			return Response{Body: results}, nil
+		},
+	}
+}
+
+// serveBatchItem serves item with mux as a sub-request of r, unless the
+// sub-request would be deeper than maxDepth.
+func serveBatchItem(r *http.Request, mux http.Handler, item BatchItem, maxDepth int) BatchResult {
+	result := BatchResult{ID: item.ID}
+
+	state := getRequestState(r.Context())
+	depth := 1
+	if state != nil {
+		depth = state.depth + 1
+	}
+	if depth > maxDepth {
+		result.Status = http.StatusLoopDetected
+		result.Body = batchErrorBody(fmt.Sprintf("request depth %d exceeds %d", depth, maxDepth))
+		return result
+	}
+
+	method := strings.ToUpper(item.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !strings.HasPrefix(item.Path, "/") {
+		result.Status = http.StatusBadRequest
+		result.Body = batchErrorBody(fmt.Sprintf("invalid path %q", item.Path))
+		return result
+	}
+
+	sub, err := http.NewRequestWithContext(r.Context(), method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Body = batchErrorBody(err.Error())
+		return result
+	}
+	sub.Header = r.Header.Clone()
+	sub.Header.Del("Content-Length")
+	sub.Header.Del(requestIDHeader)
+	// results are compressed with the batch response
+	sub.Header.Del("Accept-Encoding")
+	if len(item.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	} else {
+		sub.Header.Del("Content-Type")
+	}
+	for name, value := range item.Headers {
+		sub.Header.Set(name, value)
+	}
+	sub.Host = r.Host
+	sub.RemoteAddr = r.RemoteAddr
+	sub.TLS = r.TLS
+	if state != nil {
+		sub.Header.Set(parentRequestIDHeader, state.requestID)
+	}
+	sub.Header.Set(requestDepthHeader, strconv.Itoa(depth))
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	mux.ServeHTTP(w, sub)
+
+	result.Status = w.status
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if len(w.header) > 0 {
+		result.Headers = make(map[string]string, len(w.header))
+		for name := range w.header {
+			result.Headers[name] = w.header.Get(name)
+		}
+	}
+
+	body := w.body.Bytes()
+	if json.Valid(body) {
+		result.Body = body
+	} else if len(body) > 0 {
+		result.Body, _ = json.Marshal(string(body))
+	}
+	return result
+}
+
+func batchErrorBody(msg string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"error": msg})
+	return body
+}
+
+// batchResponseWriter buffers the response to a sub-request.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header { return w.header }
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
@@ -0,0 +1,240 @@
+package httplog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchHandler(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders", s.Handle(Handler{Name: "get_orders", Func: func(r *http.Request, _ Entry) (Response, error) {
+		return Response{Body: map[string]string{"user": r.Header.Get("X-User")}}, nil
+	}}))
+	mux.HandleFunc("/echo", s.Handle(Handler{Name: "echo", Func: func(r *http.Request, _ Entry) (Response, error) {
+		var v interface{}
+		err := json.NewDecoder(r.Body).Decode(&v)
+		return Response{Status: http.StatusCreated, Body: v}, err
+	}}))
+	mux.HandleFunc("/batch", s.Handle(s.BatchHandler("batch", mux, BatchOptions{Parallelism: 2})))
+
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(`[
+		{"id":"a","method":"GET","path":"/orders","headers":{"X-User":"bob"}},
+		{"id":"b","method":"POST","path":"/echo","body":{"n":1}},
+		{"id":"c","path":"/missing"},
+		{"id":"d","path":"/orders"}
+	]`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+
+	// act
+	mux.ServeHTTP(w, r)
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusOK {
+		t.Fatalf("status want: %d got: %d", http.StatusOK, w.Code)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		ID     string
+		Status int
+		Body   string
+	}{
+		{"a", 200, `{"user":"bob"}`},
+		{"b", 201, `{"n":1}`},
+		{"c", 404, `"404 page not found\n"`},
+		{"d", 200, `{"user":"alice"}`},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("results want: %d got: %d", len(expected), len(results))
+	}
+	for i, e := range expected {
+		got := results[i]
+		if got.ID != e.ID || got.Status != e.Status || string(got.Body) != e.Body {
+			t.Errorf("result %d want: %s %d %s got: %s %d %s", i, e.ID, e.Status, e.Body, got.ID, got.Status, got.Body)
+		}
+	}
+
+	// the batch and its three handled items are logged
+	if len(sink.records) != 4 {
+		t.Fatalf("records want: 4 got: %d", len(sink.records))
+	}
+	var batch *AccessRecord
+	for _, rec := range sink.records {
+		if rec.Handler == "batch" {
+			batch = rec
+		}
+	}
+	if batch == nil {
+		t.Fatal("want batch record")
+	}
+	if batch.Fields["batch_items"] != 4 || batch.Fields["batch_failed"] != 1 {
+		t.Errorf("batch_items, batch_failed want: 4, 1 got: %v, %v", batch.Fields["batch_items"], batch.Fields["batch_failed"])
+	}
+	for _, rec := range sink.records {
+		if rec.Handler != "batch" && rec.Fields["parent_request_id"] != batch.Fields["request_id"] {
+			t.Errorf("%s parent_request_id want: %v got: %v", rec.Handler, batch.Fields["request_id"], rec.Fields["parent_request_id"])
+		}
+	}
+}
+
+func TestBatchHandlerMaxDepth(t *testing.T) {
+	cases := []struct {
+		name       string
+		maxDepth   int
+		depth      string
+		wantStatus int
+		wantDepth  interface{}
+	}{
+		{name: "top level", wantStatus: http.StatusOK, wantDepth: 1},
+		{name: "below default", depth: "3", wantStatus: http.StatusOK, wantDepth: 4},
+		{name: "over default", depth: "4", wantStatus: http.StatusLoopDetected},
+		{name: "custom limit", maxDepth: 1, depth: "1", wantStatus: http.StatusLoopDetected},
+		{name: "invalid depth ignored", maxDepth: 1, depth: "-5", wantStatus: http.StatusOK, wantDepth: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/orders", s.Handle(Handler{Name: "get_orders", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{}, nil
+			}}))
+			mux.HandleFunc("/batch", s.Handle(s.BatchHandler("batch", mux, BatchOptions{MaxDepth: c.maxDepth})))
+			r := httptest.NewRequest("POST", "/batch", strings.NewReader(`[{"path":"/orders"}]`))
+			r.Header.Set("Content-Type", "application/json")
+			if c.depth != "" {
+				r.Header.Set("X-Request-Depth", c.depth)
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			mux.ServeHTTP(w, r)
+			s.Shutdown()
+
+			// assert
+			var results []BatchResult
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Status != c.wantStatus {
+				t.Fatalf("status want: %d got: %+v", c.wantStatus, results)
+			}
+			var gotDepth interface{}
+			for _, rec := range sink.records {
+				if rec.Handler == "get_orders" {
+					gotDepth = rec.Fields["request_depth"]
+				}
+			}
+			if gotDepth != c.wantDepth {
+				t.Errorf("request_depth want: %v got: %v", c.wantDepth, gotDepth)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerRecursion(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/batch", s.Handle(s.BatchHandler("batch", mux, BatchOptions{MaxDepth: 2})))
+	// each batch posts itself to the batch endpoint
+	body := `[{"method":"POST","path":"/batch","body":[{"method":"POST","path":"/batch","body":[{"method":"POST","path":"/batch","body":[]}]}]}]`
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	// act
+	mux.ServeHTTP(w, r)
+	s.Shutdown()
+
+	// assert
+	// the batches at depths 1 and 2 run; the one at depth 3 doesn't
+	var statuses []int
+	results := w.Body.Bytes()
+	for len(results) > 0 {
+		var batch []BatchResult
+		if err := json.Unmarshal(results, &batch); err != nil || len(batch) != 1 {
+			t.Fatalf("want one result got: %s", results)
+		}
+		statuses = append(statuses, batch[0].Status)
+		if batch[0].Status != http.StatusOK {
+			break
+		}
+		results = batch[0].Body
+	}
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusLoopDetected}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("statuses want: %v got: %v", expected, statuses)
+	}
+}
+
+func TestBatchHandlerItemPanic(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	mux := http.NewServeMux()
+	// not wrapped by Handle, so nothing else recovers its panic
+	mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/orders", s.Handle(Handler{Name: "get_orders", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, nil
+	}}))
+	mux.HandleFunc("/batch", s.Handle(s.BatchHandler("batch", mux, BatchOptions{})))
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(`[{"id":"a","path":"/panic"},{"id":"b","path":"/orders"}]`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	// act
+	mux.ServeHTTP(w, r)
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusOK {
+		t.Fatalf("status want: %d got: %d", http.StatusOK, w.Code)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != "a" || results[0].Status != http.StatusInternalServerError || results[1].Status != http.StatusOK {
+		t.Errorf("results want: a 500, b 200 got: %+v", results)
+	}
+	var batch *AccessRecord
+	for _, rec := range sink.records {
+		if rec.Handler == "batch" {
+			batch = rec
+		}
+	}
+	if batch == nil {
+		t.Fatal("want batch record")
+	}
+	if batch.Fields["batch_panics"] != 1 || batch.Fields["batch_failed"] != 1 {
+		t.Errorf("batch_panics, batch_failed want: 1, 1 got: %v, %v", batch.Fields["batch_panics"], batch.Fields["batch_failed"])
+	}
+	if batch.Err == nil || !strings.Contains(batch.Err.Error(), "boom") {
+		t.Errorf("error want: boom got: %v", batch.Err)
+	}
+}
@@ -0,0 +1,140 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingBatchWriter struct {
+	mtx     sync.Mutex
+	batches [][]*AccessRecord
+	closed  int
+}
+
+func (w *recordingBatchWriter) WriteBatch(recs []*AccessRecord) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.batches = append(w.batches, recs)
+	return nil
+}
+
+func (w *recordingBatchWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.closed++
+	return nil
+}
+
+func (w *recordingBatchWriter) sizes() []int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	var sizes []int
+	for _, batch := range w.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func TestBatchSink(t *testing.T) {
+	cases := []struct {
+		name          string
+		maxSize       int
+		interval      time.Duration
+		records       int
+		wait          time.Duration
+		expectedSizes []int
+	}{
+		{"flush when full", 2, time.Hour, 5, 0, []int{2, 2, 1}},
+		{"flush on interval", 100, 20 * time.Millisecond, 3, 200 * time.Millisecond, []int{3}},
+		{"flush on close", 100, time.Hour, 3, 0, []int{3}},
+		{"empty", 100, time.Hour, 0, 0, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			w := &recordingBatchWriter{}
+			s := NewBatchSink(w, c.maxSize, c.interval)
+
+			// act
+			for i := 0; i < c.records; i++ {
+				_ = s.WriteRecord(&AccessRecord{})
+			}
+			time.Sleep(c.wait)
+			beforeClose := len(w.sizes())
+			err := s.Close()
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.sizes(); !reflect.DeepEqual(got, c.expectedSizes) {
+				t.Errorf("batch sizes want: %v got: %v", c.expectedSizes, got)
+			}
+			if c.wait > 0 && beforeClose != len(c.expectedSizes) {
+				t.Errorf("batches before Close want: %d got: %d", len(c.expectedSizes), beforeClose)
+			}
+			if w.closed != 1 {
+				t.Errorf("writer closed want: 1 got: %d", w.closed)
+			}
+		})
+	}
+}
+
+func TestBatchSinkClose(t *testing.T) {
+	// arrange
+	w := &recordingBatchWriter{}
+	s := NewBatchSink(w, 100, time.Hour)
+	_ = s.WriteRecord(&AccessRecord{})
+
+	// act
+	first := s.Close()
+	second := s.Close()
+	_ = s.WriteRecord(&AccessRecord{})
+	_ = s.Flush()
+
+	// assert
+	if first != nil || second != nil {
+		t.Errorf("Close want: nil nil got: %v %v", first, second)
+	}
+	if w.closed != 1 {
+		t.Errorf("writer closed want: 1 got: %d", w.closed)
+	}
+	if got := w.sizes(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("batch sizes want: [1] got: %v", got)
+	}
+	if got := s.Dropped(); got != 1 {
+		t.Errorf("dropped want: 1 got: %d", got)
+	}
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	// arrange
+	var buf bytes.Buffer
+	w := NewJSONLinesWriter(&buf)
+	recs := []*AccessRecord{
+		{Handler: "a", Fields: map[string]interface{}{"http_status": 200}},
+		{Handler: "b", Fields: map[string]interface{}{"http_status": 500}},
+	}
+
+	// act
+	err := w.WriteBatch(recs)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines want: 2 got: %d", len(lines))
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil || m["handler"] != "b" {
+		t.Errorf("unexpected line %s: %v", lines[1], err)
+	}
+}
@@ -0,0 +1,172 @@
+package httplog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultMultipartMemory = 32 << 20
+
+// Error codes returned by DecodeBody.
+var (
+	ErrBodyUnsupported = NewErrorCode("BODY-415", http.StatusUnsupportedMediaType, "unsupported request body media type")
+	ErrBodyMalformed   = NewErrorCode("BODY-400", http.StatusBadRequest, "malformed request body")
+	ErrBodyTooLarge    = NewErrorCode("BODY-413", http.StatusRequestEntityTooLarge, "request body too large")
+)
+
+// BodyPolicy limits and parses request bodies of a media type. See
+// Server.BodyPolicies.
+type BodyPolicy struct {
+	// MaxBytes is the largest body accepted. Zero is unlimited.
+	MaxBytes int64
+	// Parse decodes the body into v for DecodeBody. Optional; JSON types,
+	// application/x-www-form-urlencoded, and multipart/form-data have
+	// default parsers.
+	Parse func(r *http.Request, v interface{}) error
+}
+
+// bodyPolicy returns the BodyPolicy for mediaType: an exact match, then a
+// subtype wildcard such as "text/*", then "*/*".
+func (svr *Server) bodyPolicy(mediaType string) (BodyPolicy, bool) {
+	if len(svr.BodyPolicies) == 0 {
+		return BodyPolicy{}, false
+	}
+	if policy, ok := svr.BodyPolicies[mediaType]; ok {
+		return policy, true
+	}
+	if i := strings.Index(mediaType, "/"); i != -1 {
+		if policy, ok := svr.BodyPolicies[mediaType[:i]+"/*"]; ok {
+			return policy, true
+		}
+	}
+	policy, ok := svr.BodyPolicies["*/*"]
+	return policy, ok
+}
+
+// limitBody applies the BodyPolicy for r's media type, returning false if
+// the request's Content-Length is over the limit. Bodies without a
+// Content-Length are cut off at the limit.
+func (svr *Server) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength == 0 || (r.ContentLength < 0 && len(r.TransferEncoding) == 0) {
+		return true
+	}
+
+	policy, ok := svr.bodyPolicy(requestMediaType(r))
+	if !ok || policy.MaxBytes <= 0 {
+		return true
+	}
+	if r.ContentLength > policy.MaxBytes {
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, policy.MaxBytes)
+	return true
+}
+
+func requestMediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "" {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// DecodeBody parses r's body into v with the parser of the Server's
+// BodyPolicy for its media type, or a default parser:
+//
+//	application/json, */*+json           v is decoded with encoding/json
+//	application/x-www-form-urlencoded    v must be a *url.Values
+//	multipart/form-data                  v must be a *multipart.Form
+//
+// Multipart forms are held in memory up to 32 MiB and spill to temporary
+// files beyond that; stream large uploads with ReceiveUpload instead.
+//
+// Errors carry ErrBodyUnsupported, ErrBodyMalformed, or ErrBodyTooLarge, so
+// returning them from a handler responds with 415, 400, or 413.
+func DecodeBody(r *http.Request, v interface{}) error {
+	mediaType := requestMediaType(r)
+
+	var parse func(r *http.Request, v interface{}) error
+	if state := getRequestState(r.Context()); state != nil {
+		if policy, ok := state.svr.bodyPolicy(mediaType); ok {
+			parse = policy.Parse
+		}
+	}
+	if parse == nil {
+		parse = defaultBodyParser(mediaType)
+	}
+	if parse == nil {
+		return ErrBodyUnsupported.Wrap(fmt.Errorf("content type %q", mediaType))
+	}
+
+	err := parse(r, v)
+	if err == nil {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrBodyTooLarge.Wrap(err)
+	}
+	var targetErr bodyTargetError
+	if _, ok := errorCodeOf(err); ok || errors.As(err, &targetErr) {
+		return err
+	}
+	return ErrBodyMalformed.Wrap(err)
+}
+
+// bodyTargetError is returned by the default parsers when v has the wrong
+// type. It's a programming error, not a malformed body.
+type bodyTargetError struct {
+	mediaType string
+	want      string
+	got       interface{}
+}
+
+func (e bodyTargetError) Error() string {
+	return fmt.Sprintf("httplog: %s body requires %s, got %T", e.mediaType, e.want, e.got)
+}
+
+func defaultBodyParser(mediaType string) func(r *http.Request, v interface{}) error {
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return parseJSONBody
+	case mediaType == "application/x-www-form-urlencoded":
+		return parseFormBody
+	case mediaType == "multipart/form-data":
+		return parseMultipartBody
+	}
+	return nil
+}
+
+func parseJSONBody(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func parseFormBody(r *http.Request, v interface{}) error {
+	values, ok := v.(*url.Values)
+	if !ok {
+		return bodyTargetError{mediaType: "form", want: "*url.Values", got: v}
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	*values = r.PostForm
+	return nil
+}
+
+func parseMultipartBody(r *http.Request, v interface{}) error {
+	form, ok := v.(*multipart.Form)
+	if !ok {
+		return bodyTargetError{mediaType: "multipart", want: "*multipart.Form", got: v}
+	}
+	if err := r.ParseMultipartForm(defaultMultipartMemory); err != nil {
+		return err
+	}
+	*form = *r.MultipartForm
+	return nil
+}
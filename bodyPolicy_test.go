@@ -0,0 +1,81 @@
+package httplog
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyPolicies(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.BodyPolicies = map[string]BodyPolicy{
+		"application/json":    {MaxBytes: 32},
+		"multipart/form-data": {MaxBytes: 1 << 20},
+	}
+	handler := s.Handle(Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			var form multipart.Form
+			if err := DecodeBody(r, &form); err != nil {
+				return Response{}, err
+			}
+			return Response{Body: form.Value["name"][0]}, nil
+		}
+		var v struct{ Name string }
+		if err := DecodeBody(r, &v); err != nil {
+			return Response{}, err
+		}
+		return Response{Body: v.Name}, nil
+	}})
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	_ = mw.WriteField("name", "upload")
+	fw, _ := mw.CreateFormFile("file", "a.bin")
+	_, _ = fw.Write(bytes.Repeat([]byte{1}, 64<<10))
+	_ = mw.Close()
+
+	large := `{"Name":"` + strings.Repeat("a", 64) + `"}`
+
+	cases := []struct {
+		Name           string
+		ContentType    string
+		Body           string
+		Chunked        bool
+		ExpectedStatus int
+		ExpectedBody   string
+	}{
+		{"small json", "application/json", `{"Name":"a"}`, false, 200, "a"},
+		{"large json", "application/json", large, false, 413, ""},
+		{"large chunked json", "application/json", large, true, 413, `{"error_code":"BODY-413"`},
+		{"malformed json", "application/json", `{"Name":`, false, 400, `{"error_code":"BODY-400"`},
+		{"multipart", mw.FormDataContentType(), multipartBody.String(), false, 200, "upload"},
+		{"unsupported", "text/plain", "a", false, 415, `{"error_code":"BODY-415"`},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(c.Body))
+		r.Header.Set("Content-Type", c.ContentType)
+		if c.Chunked {
+			r.ContentLength = -1
+			r.TransferEncoding = []string{"chunked"}
+		}
+		w := httptest.NewRecorder()
+
+		// act
+		handler(w, r)
+
+		// assert
+		if w.Code != c.ExpectedStatus {
+			t.Errorf("%s: status want: %d got: %d", c.Name, c.ExpectedStatus, w.Code)
+		}
+		if !strings.HasPrefix(w.Body.String(), c.ExpectedBody) {
+			t.Errorf("%s: body want prefix: %q got: %q", c.Name, c.ExpectedBody, w.Body.String())
+		}
+	}
+	s.Shutdown()
+}
@@ -0,0 +1,67 @@
+package httplog
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var startTime = time.Now()
+
+var (
+	buildMtx      sync.Mutex
+	buildVersion  string
+	buildRevision string
+)
+
+func init() {
+	startTimeGauge.Set(float64(startTime.UnixNano()) / 1e9)
+
+	version, revision := "unknown", "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	SetBuildInfo(version, revision)
+}
+
+// SetBuildInfo sets the version and revision labels of httplog_build_info.
+// They default to the main module's version and VCS revision from the
+// binary's build information; call SetBuildInfo to use values set with
+// -ldflags instead.
+func SetBuildInfo(version, revision string) {
+	buildMtx.Lock()
+	buildVersion, buildRevision = version, revision
+	buildMtx.Unlock()
+
+	buildInfoGauge.Reset()
+	buildInfoGauge.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
+
+// BuildInfo returns the version and revision labels of httplog_build_info,
+// for tagging other telemetry the same way.
+func BuildInfo() (version, revision string) {
+	buildMtx.Lock()
+	defer buildMtx.Unlock()
+	return buildVersion, buildRevision
+}
+
+// exportConfig sets httplog_config to the Server's settings so dashboards
+// can annotate changes after deploys. With more than one Server in a process
+// the last to register a handler wins.
+func (svr *Server) exportConfig() {
+	seconds := func(d time.Duration) float64 { return d.Seconds() }
+	configGauge.WithLabelValues("max_concurrent_requests").Set(float64(svr.MaxConcurrentRequests))
+	configGauge.WithLabelValues("shutdown_timeout").Set(seconds(svr.ShutdownTimeout))
+	configGauge.WithLabelValues("slow_threshold").Set(seconds(svr.SlowThreshold))
+	configGauge.WithLabelValues("log_queue_size").Set(float64(svr.LogQueueSize))
+	configGauge.WithLabelValues("job_retention").Set(seconds(svr.JobRetention))
+	configGauge.WithLabelValues("stats_window").Set(seconds(svr.StatsWindow))
+}
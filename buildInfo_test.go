@@ -0,0 +1,81 @@
+package httplog
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSetBuildInfo(t *testing.T) {
+	version, revision := BuildInfo()
+	defer SetBuildInfo(version, revision)
+
+	cases := []struct {
+		name     string
+		version  string
+		revision string
+	}{
+		{name: "set", version: "v1.2.3", revision: "abc123"},
+		{name: "replaced", version: "v1.2.4", revision: "def456"},
+		{name: "empty", version: "", revision: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			SetBuildInfo(c.version, c.revision)
+
+			// assert
+			if gotVersion, gotRevision := BuildInfo(); gotVersion != c.version || gotRevision != c.revision {
+				t.Errorf("BuildInfo want: %q %q got: %q %q", c.version, c.revision, gotVersion, gotRevision)
+			}
+			ch := make(chan prometheus.Metric, 10)
+			buildInfoGauge.Collect(ch)
+			close(ch)
+			var series int
+			for m := range ch {
+				series++
+				labels := make(map[string]string)
+				for _, l := range readMetric(t, m).GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["version"] != c.version || labels["revision"] != c.revision || labels["go_version"] != runtime.Version() {
+					t.Errorf("labels want: %q %q %q got: %v", c.version, c.revision, runtime.Version(), labels)
+				}
+				if got := readMetric(t, m).GetGauge().GetValue(); got != 1 {
+					t.Errorf("httplog_build_info want: 1 got: %v", got)
+				}
+			}
+			if series != 1 {
+				t.Errorf("series want: 1 got: %d", series)
+			}
+		})
+	}
+}
+
+func TestExportConfig(t *testing.T) {
+	// arrange
+	s := Server{
+		MaxConcurrentRequests: 8,
+		ShutdownTimeout:       3 * time.Second,
+		SlowThreshold:         250 * time.Millisecond,
+	}
+
+	// act
+	s.exportConfig()
+
+	// assert
+	want := map[string]float64{
+		"max_concurrent_requests": 8,
+		"shutdown_timeout":        3,
+		"slow_threshold":          0.25,
+		"log_queue_size":          0,
+	}
+	for setting, v := range want {
+		if got := readMetric(t, configGauge.WithLabelValues(setting)).GetGauge().GetValue(); got != v {
+			t.Errorf("httplog_config %s want: %v got: %v", setting, v, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCacheMiss is returned by a Cache's Get when the key isn't cached.
+var ErrCacheMiss = errors.New("httplog: cache miss")
+
+// Cache is the subset of a Redis or memcache client instrumented by
+// InstrumentCache. Adapting a client takes a few lines, for example with
+// go-redis:
+//
+//	type redisCache struct{ c *redis.Client }
+//
+//	func (rc redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+//		return rc.c.Get(ctx, key).Bytes()
+//	}
+//
+// with CacheOptions.IsMiss set to func(err error) bool { return err == redis.Nil }.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// CacheOptions configures InstrumentCache.
+type CacheOptions struct {
+	// Name identifies the cache in metrics, for example "redis". The
+	// default is "cache".
+	Name string
+	// IsMiss reports whether an error returned by Get means the key isn't
+	// cached. The default matches ErrCacheMiss.
+	IsMiss func(err error) bool
+}
+
+// CacheCall describes a call to a cache made while serving a request. See
+// ObserveCacheCall.
+type CacheCall struct {
+	// Name identifies the cache, for example "redis".
+	Name string
+	// Op is the operation, for example "get" or "set".
+	Op       string
+	Duration time.Duration
+	// Hits and Misses are the keys found and not found by a lookup. A
+	// multi-key lookup may have both; a write has neither.
+	Hits   int
+	Misses int
+	// Err is the error returned by the call, other than a miss.
+	Err error
+}
+
+// InstrumentCache returns a Cache which observes each call to c with
+// ObserveCacheCall. Misses are returned from Get unchanged.
+func InstrumentCache(c Cache, opts CacheOptions) Cache {
+	if opts.Name == "" {
+		opts.Name = "cache"
+	}
+	if opts.IsMiss == nil {
+		opts.IsMiss = func(err error) bool { return errors.Is(err, ErrCacheMiss) }
+	}
+	return &instrumentedCache{cache: c, opts: opts}
+}
+
+type instrumentedCache struct {
+	cache Cache
+	opts  CacheOptions
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	value, err := c.cache.Get(ctx, key)
+	call := CacheCall{Name: c.opts.Name, Op: "get", Duration: time.Since(start)}
+	switch {
+	case err == nil:
+		call.Hits = 1
+	case c.opts.IsMiss(err):
+		call.Misses = 1
+	default:
+		call.Err = err
+	}
+	ObserveCacheCall(ctx, call)
+	return value, err
+}
+
+func (c *instrumentedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := c.cache.Set(ctx, key, value, ttl)
+	ObserveCacheCall(ctx, CacheCall{Name: c.opts.Name, Op: "set", Duration: time.Since(start), Err: err})
+	return err
+}
+
+func (c *instrumentedCache) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := c.cache.Delete(ctx, key)
+	ObserveCacheCall(ctx, CacheCall{Name: c.opts.Name, Op: "delete", Duration: time.Since(start), Err: err})
+	return err
+}
+
+// ObserveCacheCall records a cache call made with ctx, for clients which
+// don't fit Cache or which expose hooks, such as go-redis's Hook. Calls made
+// with the context of a request served by Handle are summed onto its access
+// log as cache_calls, cache_hits, cache_misses, cache_hit_ratio,
+// cache_time_ms, and cache_errors. All calls are counted in
+// httplog_cache_calls_total and observed in
+// httplog_cache_call_duration_seconds.
+func ObserveCacheCall(ctx context.Context, call CacheCall) {
+	result := "ok"
+	switch {
+	case call.Err != nil:
+		result = "error"
+	case call.Hits > 0 && call.Misses == 0:
+		result = "hit"
+	case call.Misses > 0 && call.Hits == 0:
+		result = "miss"
+	case call.Misses > 0:
+		result = "partial"
+	}
+	cacheCallsTotal.WithLabelValues(call.Name, call.Op, result).Inc()
+	cacheCallDuration.WithLabelValues(call.Name, call.Op).Observe(call.Duration.Seconds())
+
+	state := getRequestState(ctx)
+	if state == nil {
+		return
+	}
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.cacheCalls++
+	state.cacheHits += call.Hits
+	state.cacheMisses += call.Misses
+	state.cacheTime += call.Duration
+	if call.Err != nil {
+		state.cacheErrors++
+	}
+}
+
+// addCacheFields adds the totals of the cache calls made while serving a
+// request.
+func (state *requestState) addCacheFields(fields map[string]interface{}) {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	if state.cacheCalls == 0 {
+		return
+	}
+	fields["cache_calls"] = state.cacheCalls
+	fields["cache_time_ms"] = int64(state.cacheTime / time.Millisecond)
+	if lookups := state.cacheHits + state.cacheMisses; lookups > 0 {
+		fields["cache_hits"] = state.cacheHits
+		fields["cache_misses"] = state.cacheMisses
+		fields["cache_hit_ratio"] = float64(state.cacheHits) / float64(lookups)
+	}
+	if state.cacheErrors > 0 {
+		fields["cache_errors"] = state.cacheErrors
+	}
+}
@@ -0,0 +1,67 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInstrumentCache(t *testing.T) {
+	// arrange
+	cache := InstrumentCache(mapCache{"a": []byte("1")}, CacheOptions{Name: "test"})
+
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		if _, err := cache.Get(r.Context(), "a"); err != nil {
+			return Response{}, err
+		}
+		if _, err := cache.Get(r.Context(), "b"); err != ErrCacheMiss {
+			t.Errorf("miss want: ErrCacheMiss got: %v", err)
+		}
+		if err := cache.Set(r.Context(), "b", []byte("2"), time.Minute); err == nil {
+			t.Error("want set error")
+		}
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	fields := sink.records[0].Fields
+	if fields["cache_calls"] != 3 || fields["cache_hits"] != 1 || fields["cache_misses"] != 1 || fields["cache_errors"] != 1 {
+		t.Errorf("unexpected cache fields %v", fields)
+	}
+	if fields["cache_hit_ratio"] != 0.5 {
+		t.Errorf("cache_hit_ratio want: 0.5 got: %v", fields["cache_hit_ratio"])
+	}
+}
+
+// mapCache is a read-only Cache.
+type mapCache map[string][]byte
+
+func (c mapCache) Get(_ context.Context, key string) ([]byte, error) {
+	if v, ok := c[key]; ok {
+		return v, nil
+	}
+	return nil, ErrCacheMiss
+}
+
+func (c mapCache) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("read-only")
+}
+
+func (c mapCache) Delete(context.Context, string) error {
+	return errors.New("read-only")
+}
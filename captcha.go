@@ -0,0 +1,146 @@
+package httplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Captcha verification endpoints.
+const (
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	RecaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// Captcha verifies hCaptcha or reCAPTCHA tokens before a handler runs. Use
+// Wrap on each handler which requires verification.
+//
+// The verification response's score, when present, is logged as
+// captcha_score. Requests without a token, with a token which fails
+// verification, or with a score below MinScore are rejected with
+// StatusForbidden (403). If the verification API can't be reached the
+// request is rejected with StatusServiceUnavailable (503).
+type Captcha struct {
+	// VerifyURL is the provider's verification endpoint, such as
+	// HCaptchaVerifyURL or RecaptchaVerifyURL.
+	VerifyURL string
+	// Secret is the site's secret key.
+	Secret string
+	// MinScore is the lowest score accepted, for providers which return a
+	// score such as reCAPTCHA v3. Optional; by default only a successful
+	// verification is required.
+	MinScore float64
+	// TokenHeader is the request header carrying the token. The default is
+	// X-Captcha-Token. When the header is absent the h-captcha-response and
+	// g-recaptcha-response form fields are checked.
+	TokenHeader string
+	// Client is used to call VerifyURL. The default client uses Transport,
+	// so the call is logged and linked to the request, and has a 10s
+	// timeout.
+	Client *http.Client
+}
+
+type captchaResult struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+var defaultCaptchaClient = &http.Client{Transport: &Transport{}, Timeout: 10 * time.Second}
+
+// Wrap returns a copy of h which verifies the request's captcha token before
+// calling h.Func.
+func (c *Captcha) Wrap(h Handler) Handler {
+	next := h.Func
+	h.Func = func(r *http.Request, entry Entry) (Response, error) {
+		token := c.token(r)
+		if token == "" {
+			entry.AddField("captcha_error", "missing-token")
+			return captchaForbidden(), nil
+		}
+
+		result, err := c.verify(r, token)
+		if err != nil {
+			return Response{Status: http.StatusServiceUnavailable}, fmt.Errorf("captcha verification: %w", err)
+		}
+
+		if result.Score != nil {
+			entry.AddField("captcha_score", *result.Score)
+		}
+		if !result.Success {
+			if len(result.ErrorCodes) > 0 {
+				entry.AddField("captcha_error", strings.Join(result.ErrorCodes, ","))
+			} else {
+				entry.AddField("captcha_error", "verification-failed")
+			}
+			return captchaForbidden(), nil
+		}
+		if result.Score != nil && *result.Score < c.MinScore {
+			entry.AddField("captcha_error", "score-below-threshold")
+			return captchaForbidden(), nil
+		}
+
+		return next(r, entry)
+	}
+	return h
+}
+
+func (c *Captcha) token(r *http.Request) string {
+	header := c.TokenHeader
+	if header == "" {
+		header = "X-Captcha-Token"
+	}
+	if token := r.Header.Get(header); token != "" {
+		return token
+	}
+
+	if r.Method != "POST" && r.Method != "PUT" && r.Method != "PATCH" {
+		return ""
+	}
+	if token := r.PostFormValue("h-captcha-response"); token != "" {
+		return token
+	}
+	return r.PostFormValue("g-recaptcha-response")
+}
+
+func (c *Captcha) verify(r *http.Request, token string) (*captchaResult, error) {
+	form := url.Values{
+		"secret":   {c.Secret},
+		"response": {token},
+		"remoteip": {requestInfo(r).ClientIP},
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), "POST", c.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := c.Client
+	if client == nil {
+		client = defaultCaptchaClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var result captchaResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func captchaForbidden() Response {
+	return Response{Status: http.StatusForbidden, Body: "captcha verification failed"}
+}
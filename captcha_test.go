@@ -0,0 +1,207 @@
+package httplog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptchaWrap(t *testing.T) {
+	cases := []struct {
+		name       string
+		token      string
+		status     int
+		body       string
+		minScore   float64
+		wantStatus int
+		wantFields map[string]interface{}
+		wantCalled bool
+	}{
+		{
+			name:       "missing token",
+			wantStatus: http.StatusForbidden,
+			wantFields: map[string]interface{}{"captcha_error": "missing-token"},
+		},
+		{
+			name:       "success",
+			token:      "good",
+			status:     http.StatusOK,
+			body:       `{"success":true}`,
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{},
+			wantCalled: true,
+		},
+		{
+			name:       "verification failed",
+			token:      "bad",
+			status:     http.StatusOK,
+			body:       `{"success":false,"error-codes":["invalid-input-response"]}`,
+			wantStatus: http.StatusForbidden,
+			wantFields: map[string]interface{}{"captcha_error": "invalid-input-response"},
+		},
+		{
+			name:       "verification failed without codes",
+			token:      "bad",
+			status:     http.StatusOK,
+			body:       `{"success":false}`,
+			wantStatus: http.StatusForbidden,
+			wantFields: map[string]interface{}{"captcha_error": "verification-failed"},
+		},
+		{
+			name:       "score above threshold",
+			token:      "good",
+			status:     http.StatusOK,
+			body:       `{"success":true,"score":0.9}`,
+			minScore:   0.5,
+			wantStatus: http.StatusOK,
+			wantFields: map[string]interface{}{"captcha_score": 0.9},
+			wantCalled: true,
+		},
+		{
+			name:       "score below threshold",
+			token:      "good",
+			status:     http.StatusOK,
+			body:       `{"success":true,"score":0.1}`,
+			minScore:   0.5,
+			wantStatus: http.StatusForbidden,
+			wantFields: map[string]interface{}{"captcha_score": 0.1, "captcha_error": "score-below-threshold"},
+		},
+		{
+			name:       "verify endpoint error",
+			token:      "good",
+			status:     http.StatusInternalServerError,
+			wantStatus: http.StatusServiceUnavailable,
+			wantFields: map[string]interface{}{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var gotToken, gotSecret string
+			verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotToken = r.PostFormValue("response")
+				gotSecret = r.PostFormValue("secret")
+				w.WriteHeader(c.status)
+				fmt.Fprint(w, c.body)
+			}))
+			defer verifier.Close()
+
+			captcha := &Captcha{
+				VerifyURL: verifier.URL,
+				Secret:    "shh",
+				MinScore:  c.minScore,
+				Client:    verifier.Client(),
+			}
+			var called bool
+			h := captcha.Wrap(Handler{Func: func(r *http.Request, entry Entry) (Response, error) {
+				called = true
+				return Response{Status: http.StatusOK}, nil
+			}})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.token != "" {
+				r.Header.Set("X-Captcha-Token", c.token)
+			}
+			entry := newFieldEntry(&nullLogger{})
+
+			// act
+			resp, err := h.Func(r, entry)
+
+			// assert
+			if resp.Status != c.wantStatus {
+				t.Errorf("status want: %d got: %d", c.wantStatus, resp.Status)
+			}
+			if called != c.wantCalled {
+				t.Errorf("handler called want: %v got: %v", c.wantCalled, called)
+			}
+			if !reflect.DeepEqual(c.wantFields, entry.fields) {
+				t.Errorf("fields want: %v got: %v", c.wantFields, entry.fields)
+			}
+			if c.status == http.StatusInternalServerError {
+				if err == nil || !strings.Contains(err.Error(), "unexpected status 500") {
+					t.Errorf("want verification error got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("want nil error got: %v", err)
+			}
+			if c.token != "" && (gotToken != c.token || gotSecret != "shh") {
+				t.Errorf("verify request want: %s/shh got: %s/%s", c.token, gotToken, gotSecret)
+			}
+		})
+	}
+}
+
+func TestCaptchaToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		method string
+		form   string
+		set    map[string]string
+		want   string
+	}{
+		{name: "default header", method: "GET", set: map[string]string{"X-Captcha-Token": "a"}, want: "a"},
+		{name: "custom header", header: "X-Token", method: "GET", set: map[string]string{"X-Token": "b"}, want: "b"},
+		{name: "hcaptcha form", method: "POST", form: "h-captcha-response=c", want: "c"},
+		{name: "recaptcha form", method: "POST", form: "g-recaptcha-response=d", want: "d"},
+		{name: "form ignored on GET", method: "GET", form: "h-captcha-response=e", want: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			captcha := &Captcha{TokenHeader: c.header}
+			r := httptest.NewRequest(c.method, "/", strings.NewReader(c.form))
+			if c.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for k, v := range c.set {
+				r.Header.Set(k, v)
+			}
+
+			// act
+			got := captcha.token(r)
+
+			// assert
+			if got != c.want {
+				t.Errorf("want: %q got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestCaptchaWrapsVerifyError(t *testing.T) {
+	// arrange
+	verifyErr := errors.New("dial failed")
+	captcha := &Captcha{
+		VerifyURL: "http://captcha.invalid/verify",
+		Client:    &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, verifyErr })},
+	}
+	h := captcha.Wrap(Handler{Func: func(r *http.Request, entry Entry) (Response, error) {
+		return Response{}, nil
+	}})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Captcha-Token", "a")
+
+	// act
+	resp, err := h.Func(r, newFieldEntry(&nullLogger{}))
+
+	// assert
+	if resp.Status != http.StatusServiceUnavailable {
+		t.Errorf("status want: %d got: %d", http.StatusServiceUnavailable, resp.Status)
+	}
+	if !errors.Is(err, verifyErr) {
+		t.Errorf("want wrapped %v got: %v", verifyErr, err)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,144 @@
+package httplog
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cdnProviderHeaders identify the CDN which forwarded a request when it
+// doesn't send CDN-Loop.
+var cdnProviderHeaders = []struct {
+	header   string
+	provider string
+}{
+	{"CF-Ray", "cloudflare"},
+	{"X-Amz-Cf-Id", "cloudfront"},
+	{"Fastly-FF", "fastly"},
+	{"Akamai-Origin-Hop", "akamai"},
+}
+
+// cacheableStatuses are the statuses shared caches may store by default
+// (RFC 9110 section 15.1).
+var cacheableStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cdnFields returns the fields describing how a CDN handled a request which
+// reached the origin, or nil if the request didn't come through one. See
+// Server.LogCDN.
+func (svr *Server) cdnFields(r *http.Request, status int, respHeader http.Header) map[string]interface{} {
+	if !svr.LogCDN {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+	if provider := cdnProvider(r.Header); provider != "" {
+		fields["cdn"] = provider
+	}
+	if cacheStatus := cdnCacheStatus(r.Header); cacheStatus != "" {
+		fields["cdn_cache_status"] = cacheStatus
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if age, err := strconv.Atoi(r.Header.Get("Age")); err == nil && age >= 0 {
+		fields["cdn_age"] = age
+	}
+	noCache := hasDirective(r.Header.Values("Cache-Control"), "no-cache") || hasDirective(r.Header.Values("Pragma"), "no-cache")
+	if noCache {
+		fields["cdn_request_no_cache"] = true
+	}
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		fields["cdn_revalidation"] = true
+	}
+	cacheable := (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		cacheableStatuses[status] && sharedCacheable(respHeader)
+	fields["cdn_cacheable"] = cacheable
+
+	cacheStatus, _ := fields["cdn_cache_status"].(string)
+	cdnRequestsTotal.WithLabelValues(cacheStatus, strconv.FormatBool(cacheable)).Inc()
+	return fields
+}
+
+// cdnProvider returns the first CDN named in CDN-Loop (RFC 8586), or one
+// identified by its request headers.
+func cdnProvider(h http.Header) string {
+	if loop := firstHeaderValue(h.Get("CDN-Loop")); loop != "" {
+		name, _, _ := strings.Cut(loop, ";")
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+	for _, p := range cdnProviderHeaders {
+		if h.Get(p.header) != "" {
+			return p.provider
+		}
+	}
+	return ""
+}
+
+// cdnCacheStatus returns the lowercased cache status forwarded by a CDN in
+// Cache-Status (RFC 9211), CF-Cache-Status, or X-Cache, for example "miss",
+// "expired", or "bypass".
+func cdnCacheStatus(h http.Header) string {
+	if cs := h.Get("Cache-Status"); cs != "" {
+		// the first cache listed is the one closest to the origin
+		_, params, _ := strings.Cut(firstHeaderValue(cs), ";")
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.ToLower(name) {
+			case "hit":
+				return "hit"
+			case "fwd":
+				return strings.ToLower(strings.Trim(value, `"`))
+			}
+		}
+	}
+	if cs := h.Get("CF-Cache-Status"); cs != "" {
+		return strings.ToLower(strings.TrimSpace(cs))
+	}
+	if xc := firstHeaderValue(h.Get("X-Cache")); xc != "" {
+		// for example "Miss from cloudfront" or "MISS, HIT"
+		status, _, _ := strings.Cut(xc, " ")
+		return strings.ToLower(status)
+	}
+	return ""
+}
+
+// sharedCacheable reports whether a response with header may be stored by a
+// shared cache such as a CDN for some time.
+func sharedCacheable(header http.Header) bool {
+	cc := header.Values("Cache-Control")
+	if hasDirective(cc, "no-store") || hasDirective(cc, "private") || hasDirective(cc, "no-cache") {
+		return false
+	}
+	for _, value := range cc {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "public":
+				return true
+			case "max-age", "s-maxage":
+				if n, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil && n > 0 {
+					return true
+				}
+			}
+		}
+	}
+	return header.Get("Expires") != ""
+}
+
+// hasDirective reports whether one of the comma separated header values
+// contains name, ignoring arguments and case.
+func hasDirective(values []string, name string) bool {
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			d, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(d, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
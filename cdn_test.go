@@ -0,0 +1,72 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCDNFields(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+	s.LogCDN = true
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Headers: []Header{{Name: "Cache-Control", Value: "public, max-age=60"}}}, nil
+	}}
+
+	requests := []map[string]string{
+		{"CDN-Loop": "cloudflare; loops=1", "CF-Cache-Status": "EXPIRED", "Age": "75"},
+		{"CF-Ray": "8a1b2c3d", "Cache-Control": "no-cache"},
+		{"Cache-Status": `OriginShield; fwd=uri-miss, Edge; fwd=miss`, "Fastly-FF": "abc"},
+		{},
+	}
+	for _, headers := range requests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+
+		// act
+		s.Handle(handler)(httptest.NewRecorder(), r)
+	}
+	s.Shutdown()
+
+	// assert
+	cf := sink.records[0].Fields
+	if cf["cdn"] != "cloudflare" || cf["cdn_cache_status"] != "expired" || cf["cdn_age"] != 75 || cf["cdn_cacheable"] != true {
+		t.Errorf("cloudflare want: cloudflare expired 75 true got: %v %v %v %v", cf["cdn"], cf["cdn_cache_status"], cf["cdn_age"], cf["cdn_cacheable"])
+	}
+	if noCache := sink.records[1].Fields; noCache["cdn"] != "cloudflare" || noCache["cdn_request_no_cache"] != true {
+		t.Errorf("no-cache want: cloudflare true got: %v %v", noCache["cdn"], noCache["cdn_request_no_cache"])
+	}
+	if fastly := sink.records[2].Fields; fastly["cdn"] != "fastly" || fastly["cdn_cache_status"] != "uri-miss" {
+		t.Errorf("fastly want: fastly uri-miss got: %v %v", fastly["cdn"], fastly["cdn_cache_status"])
+	}
+	if _, ok := sink.records[3].Fields["cdn_cacheable"]; ok {
+		t.Error("direct request want no cdn fields")
+	}
+}
+
+func TestSharedCacheable(t *testing.T) {
+	cases := map[string]bool{
+		"public, max-age=60":  true,
+		"s-maxage=10":         true,
+		"max-age=0":           false,
+		"private, max-age=60": false,
+		"no-store":            false,
+		"":                    false,
+	}
+	for cc, want := range cases {
+		h := http.Header{}
+		if cc != "" {
+			h.Set("Cache-Control", cc)
+		}
+		if got := sharedCacheable(h); got != want {
+			t.Errorf("%q: want: %v got: %v", cc, want, got)
+		}
+	}
+}
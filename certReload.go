@@ -0,0 +1,128 @@
+package httplog
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultCertReloadInterval = time.Minute
+
+// CertReloader serves a TLS certificate which is reloaded without a
+// restart. Set its GetCertificate method as tls.Config.GetCertificate. See
+// Server.ReloadCertificate and Server.ReloadCertificateFunc.
+type CertReloader struct {
+	svr  *Server
+	load func() (*tls.Certificate, error)
+
+	mtx         sync.RWMutex
+	cert        *tls.Certificate
+	fingerprint string
+}
+
+// ReloadCertificate loads the certificate and key from certFile and keyFile
+// and reloads them every interval, one minute by default, until Shutdown.
+// Renewed certificates are picked up without a restart; if a reload fails
+// the previous certificate is kept and the error is logged.
+//
+// Each changed certificate is logged with cert_subject, cert_not_after, and
+// cert_expiry_days, and the days until expiry are exported in
+// httplog_tls_cert_expiry_days.
+func (svr *Server) ReloadCertificate(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	return svr.ReloadCertificateFunc(func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		return &cert, err
+	}, interval)
+}
+
+// ReloadCertificateFunc is like ReloadCertificate but calls load to get the
+// certificate, for example from a secret store.
+func (svr *Server) ReloadCertificateFunc(load func() (*tls.Certificate, error), interval time.Duration) (*CertReloader, error) {
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	cr := &CertReloader{svr: svr, load: load}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+
+	svr.goTask(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = cr.Reload()
+			}
+		}
+	})
+
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate. It implements
+// tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mtx.RLock()
+	defer cr.mtx.RUnlock()
+	return cr.cert, nil
+}
+
+// Reload loads the certificate now. Errors are logged and returned; the
+// previous certificate is kept.
+func (cr *CertReloader) Reload() error {
+	cert, leaf, err := cr.loadLeaf()
+	if err != nil {
+		entry := cr.svr.newEntry()
+		entry.AddError(err)
+		entry.Error("tls certificate reload failed")
+		return err
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	days := time.Until(leaf.NotAfter).Hours() / 24
+	certExpiryDays.WithLabelValues(leaf.Subject.CommonName).Set(days)
+
+	cr.mtx.Lock()
+	changed := fingerprint != cr.fingerprint
+	cr.cert, cr.fingerprint = cert, fingerprint
+	cr.mtx.Unlock()
+
+	if changed {
+		entry := cr.svr.newEntry()
+		entry.AddFields(map[string]interface{}{
+			"cert_subject":     leaf.Subject.String(),
+			"cert_not_after":   leaf.NotAfter.Format(time.RFC3339),
+			"cert_expiry_days": int(days),
+			"cert_fingerprint": fingerprint,
+		})
+		entry.Info("tls certificate loaded")
+	}
+	return nil
+}
+
+func (cr *CertReloader) loadLeaf() (*tls.Certificate, *x509.Certificate, error) {
+	cert, err := cr.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, nil, errors.New("tls certificate reload: no certificate")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cert, leaf, nil
+}
@@ -0,0 +1,90 @@
+package httplog
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadCertificate(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	cr, err := s.ReloadCertificate(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	// act
+	writeTestCert(t, certFile, keyFile, "second")
+	reloadErr := cr.Reload()
+	_ = os.WriteFile(certFile, []byte("garbage"), 0600)
+	badReloadErr := cr.Reload()
+
+	// assert
+	if reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+	if badReloadErr == nil {
+		t.Error("want error reloading invalid certificate")
+	}
+	cert, _ := cr.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("CommonName want: second got: %s", leaf.Subject.CommonName)
+	}
+}
+
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	der, key := newTestCert(t, commonName)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCert returns a self-signed certificate for commonName and its key.
+func newTestCert(t *testing.T, commonName string) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
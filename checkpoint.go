@@ -0,0 +1,56 @@
+package httplog
+
+import (
+	"context"
+	"time"
+)
+
+// CheckpointTiming is a named point reached while serving a request. See
+// Checkpoint.
+type CheckpointTiming struct {
+	Name string `json:"name"`
+	// AtMs is the time since the request started, in milliseconds.
+	AtMs float64 `json:"at_ms"`
+	// DeltaMs is the time since the previous checkpoint, or the start of the
+	// request, in milliseconds.
+	DeltaMs float64 `json:"delta_ms"`
+}
+
+// Checkpoint records that the request being served by Handle reached the
+// named point, for example "auth" or "db_query". The checkpoints are logged
+// in order as checkpoints with the time since the request started and since
+// the previous checkpoint, a lightweight per-request profile. If
+// Server.OnCheckpoint is set it's also called, for example to add an event
+// to a trace span. Checkpoint does nothing if ctx doesn't belong to a
+// request.
+func Checkpoint(ctx context.Context, name string) {
+	state := getRequestState(ctx)
+	if state == nil {
+		return
+	}
+
+	since := time.Since(state.start)
+	state.mtx.Lock()
+	state.checkpoints = append(state.checkpoints, CheckpointTiming{
+		Name:    name,
+		AtMs:    durationMs(since),
+		DeltaMs: durationMs(since - state.lastCheckpoint),
+	})
+	state.lastCheckpoint = since
+	state.mtx.Unlock()
+
+	if onCheckpoint := state.svr.OnCheckpoint; onCheckpoint != nil {
+		onCheckpoint(ctx, name, since)
+	}
+}
+
+func (state *requestState) checkpointTimings() []CheckpointTiming {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return state.checkpoints
+}
+
+// durationMs returns d in milliseconds with microsecond precision.
+func durationMs(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
+}
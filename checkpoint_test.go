@@ -0,0 +1,44 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var hooked []string
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.OnCheckpoint = func(_ context.Context, name string, _ time.Duration) {
+		hooked = append(hooked, name)
+	}
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		Checkpoint(r.Context(), "auth")
+		time.Sleep(5 * time.Millisecond)
+		Checkpoint(r.Context(), "query")
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+	Checkpoint(context.Background(), "ignored")
+
+	// assert
+	checkpoints, _ := sink.records[0].Fields["checkpoints"].([]CheckpointTiming)
+	if len(checkpoints) != 2 || checkpoints[0].Name != "auth" || checkpoints[1].Name != "query" {
+		t.Fatalf("checkpoints want: auth, query got: %+v", checkpoints)
+	}
+	if checkpoints[1].DeltaMs < 5 || checkpoints[1].AtMs < checkpoints[1].DeltaMs {
+		t.Errorf("query checkpoint want delta >= 5ms got: %+v", checkpoints[1])
+	}
+	if len(hooked) != 2 {
+		t.Errorf("OnCheckpoint calls want: 2 got: %d", len(hooked))
+	}
+}
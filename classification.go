@@ -0,0 +1,144 @@
+package httplog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// FieldClass is the sensitivity class of a log field. See
+// Server.FieldClasses.
+type FieldClass int
+
+const (
+	// ClassPublic fields can be shipped anywhere.
+	ClassPublic FieldClass = iota
+	// ClassInternal fields shouldn't leave the organization.
+	ClassInternal
+	// ClassPII fields identify a person.
+	ClassPII
+)
+
+func (c FieldClass) String() string {
+	switch c {
+	case ClassInternal:
+		return "internal"
+	case ClassPII:
+		return "pii"
+	default:
+		return "public"
+	}
+}
+
+// FieldAction is what a FieldPolicy does with a field.
+type FieldAction int
+
+const (
+	// FieldKeep passes the field through unchanged.
+	FieldKeep FieldAction = iota
+	// FieldHash replaces the field's value with a short hash, so equal
+	// values can still be correlated.
+	FieldHash
+	// FieldDrop removes the field.
+	FieldDrop
+)
+
+// FieldPolicy maps field classes to the action a policy sink applies.
+// Classes which aren't in the policy are kept.
+type FieldPolicy map[FieldClass]FieldAction
+
+type policySink struct {
+	sink   Sink
+	policy FieldPolicy
+}
+
+// NewPolicySink returns a Sink which applies policy to each record's fields
+// before writing it to sink. For example, an analytics sink might hash PII
+// while an audit sink keeps it:
+//
+//	svr.Sinks = []httplog.Sink{
+//		httplog.NewPolicySink(analytics, httplog.FieldPolicy{
+//			httplog.ClassPII:      httplog.FieldHash,
+//			httplog.ClassInternal: httplog.FieldDrop,
+//		}),
+//		audit,
+//	}
+func NewPolicySink(sink Sink, policy FieldPolicy) Sink {
+	return &policySink{sink: sink, policy: policy}
+}
+
+func (s *policySink) WriteRecord(rec *AccessRecord) error {
+	filtered := *rec
+	filtered.Fields = make(map[string]interface{}, len(rec.Fields))
+	for k, v := range rec.Fields {
+		switch s.policy[rec.Classes[k]] {
+		case FieldHash:
+			filtered.Fields[k] = hashValue(fmt.Sprint(v))
+		case FieldDrop:
+		default:
+			filtered.Fields[k] = v
+		}
+	}
+	return s.sink.WriteRecord(&filtered)
+}
+
+func (s *policySink) Close() error {
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// classify returns the class of each field which isn't ClassPublic.
+func (svr *Server) classify(fields map[string]interface{}) map[string]FieldClass {
+	if len(svr.FieldClasses) == 0 {
+		return nil
+	}
+	classes := make(map[string]FieldClass)
+	for k := range fields {
+		if class := svr.FieldClasses[k]; class != ClassPublic {
+			classes[k] = class
+		}
+	}
+	return classes
+}
+
+// fieldEntry wraps a request's Entry, keeping the fields added to it so
+// they're included in the AccessRecord passed to Sinks.
+type fieldEntry struct {
+	Entry
+
+	mtx    sync.Mutex
+	fields map[string]interface{}
+}
+
+func newFieldEntry(entry Entry) *fieldEntry {
+	return &fieldEntry{Entry: entry, fields: make(map[string]interface{})}
+}
+
+func (e *fieldEntry) AddField(key string, value interface{}) {
+	e.mtx.Lock()
+	e.fields[key] = value
+	e.mtx.Unlock()
+	e.Entry.AddField(key, value)
+}
+
+func (e *fieldEntry) AddFields(fields map[string]interface{}) {
+	e.mtx.Lock()
+	for k, v := range fields {
+		e.fields[k] = v
+	}
+	e.mtx.Unlock()
+	e.Entry.AddFields(fields)
+}
+
+// snapshot returns a copy of the fields added to the entry.
+func (e *fieldEntry) snapshot() map[string]interface{} {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	fields := make(map[string]interface{}, len(e.fields))
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	return fields
+}
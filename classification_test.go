@@ -0,0 +1,60 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recordingSink struct {
+	mtx     sync.Mutex
+	records []*AccessRecord
+}
+
+func (s *recordingSink) WriteRecord(rec *AccessRecord) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func TestPolicySink(t *testing.T) {
+	// arrange
+	analytics, audit := &recordingSink{}, &recordingSink{}
+
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.FieldClasses = map[string]FieldClass{"email": ClassPII, "ip": ClassInternal}
+	s.Sinks = []Sink{
+		NewPolicySink(analytics, FieldPolicy{ClassPII: FieldHash, ClassInternal: FieldDrop}),
+		audit,
+	}
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, entry Entry) (Response, error) {
+		entry.AddField("email", "user@example.com")
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if len(analytics.records) != 1 || len(audit.records) != 1 {
+		t.Fatalf("records want: 1 and 1 got: %d and %d", len(analytics.records), len(audit.records))
+	}
+
+	if got := audit.records[0].Fields["email"]; got != "user@example.com" {
+		t.Errorf("audit email want: user@example.com got: %v", got)
+	}
+	if got := analytics.records[0].Fields["email"]; got != hashValue("user@example.com") {
+		t.Errorf("analytics email want: hash got: %v", got)
+	}
+	if _, ok := analytics.records[0].Fields["ip"]; ok {
+		t.Error("want analytics ip dropped")
+	}
+	if _, ok := analytics.records[0].Fields["http_status"]; !ok {
+		t.Error("want analytics http_status kept")
+	}
+}
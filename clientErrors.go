@@ -0,0 +1,175 @@
+package httplog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxClientErrorBytes   = 16 << 10
+	maxClientErrorMessage = 1024
+)
+
+// Kinds of client errors, for ClientErrorReport.Kind. Other kinds are
+// logged as sent but counted as ClientErrorOther.
+const (
+	ClientErrorParse            = "parse"
+	ClientErrorUnexpectedStatus = "unexpected_status"
+	ClientErrorTimeout          = "timeout"
+	ClientErrorNetwork          = "network"
+	ClientErrorOther            = "other"
+)
+
+// ClientErrorReport is a client's report of a failure handling a response,
+// such as a body it couldn't parse or a status it didn't expect.
+type ClientErrorReport struct {
+	// RequestID is the X-Request-ID of the failed request. Required.
+	RequestID string `json:"request_id"`
+	// Kind classifies the failure, for example ClientErrorParse.
+	Kind string `json:"kind"`
+	// Status is the HTTP status the client received, if any.
+	Status int `json:"status,omitempty"`
+	// Message describes the failure. It's truncated to 1024 bytes.
+	Message string `json:"message,omitempty"`
+	// Client identifies the client, for example "ios/4.2.0". Optional.
+	Client string `json:"client,omitempty"`
+}
+
+// ReportClientError logs rep as "client error report" with the failed
+// request's request_id, so it can be found next to the request's access log,
+// along with client_error_kind, client_error_status, client_error_message,
+// and client. If the request is among the last ClientErrorHistory served,
+// its handler, http_status, and the seconds since it was served,
+// report_delay_s, are logged too. Reports are counted in
+// httplog_client_errors_total by handler and kind.
+//
+// When ctx belongs to a request served by Handle, that request's access log
+// gets reported_request_id.
+func (svr *Server) ReportClientError(ctx context.Context, rep ClientErrorReport) error {
+	if rep.RequestID == "" {
+		return errors.New("httplog: client error report without request_id")
+	}
+	if len(rep.Message) > maxClientErrorMessage {
+		rep.Message = rep.Message[:maxClientErrorMessage]
+	}
+
+	fields := map[string]interface{}{
+		"request_id":        rep.RequestID,
+		"client_error_kind": rep.Kind,
+	}
+	if rep.Status != 0 {
+		fields["client_error_status"] = rep.Status
+	}
+	if rep.Message != "" {
+		fields["client_error_message"] = rep.Message
+	}
+	if rep.Client != "" {
+		fields["client"] = rep.Client
+	}
+	handlerName := "unknown"
+	if req, ok := svr.recentRequests().lookup(rep.RequestID); ok {
+		handlerName = req.handler
+		fields["handler"] = req.handler
+		fields["http_status"] = req.status
+		fields["report_delay_s"] = int64(time.Since(req.time) / time.Second)
+	}
+
+	kind := rep.Kind
+	switch kind {
+	case ClientErrorParse, ClientErrorUnexpectedStatus, ClientErrorTimeout, ClientErrorNetwork:
+	default:
+		kind = ClientErrorOther
+	}
+	clientErrorsTotal.WithLabelValues(handlerName, kind).Inc()
+
+	if entry := EntryFromContext(ctx); entry != nil {
+		entry.AddField("reported_request_id", rep.RequestID)
+	}
+	entry := svr.newEntry()
+	entry.AddFields(fields)
+	entry.Warn("client error report")
+	return nil
+}
+
+// ClientErrorHandler returns a Handler which accepts a ClientErrorReport as
+// JSON and passes it to ReportClientError, responding with StatusNoContent
+// (204), or StatusBadRequest (400) if the report is invalid. Set
+// ClientErrorHistory so reports are linked to the handler which served the
+// failed request.
+func (svr *Server) ClientErrorHandler() Handler {
+	return Handler{
+		Name:     "httplog_client_errors",
+		Methods:  []string{"POST"},
+		Consumes: []string{"application/json"},
+		Func: func(r *http.Request, _ Entry) (Response, error) {
+			var rep ClientErrorReport
+			dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxClientErrorBytes))
+			if err := dec.Decode(&rep); err != nil {
+				return Response{Status: http.StatusBadRequest}, err
+			}
+			if err := svr.ReportClientError(r.Context(), rep); err != nil {
+				return Response{Status: http.StatusBadRequest}, err
+			}
+			return Response{Status: http.StatusNoContent}, nil
+		},
+	}
+}
+
+type recentRequest struct {
+	handler string
+	status  int
+	time    time.Time
+}
+
+// recentRequestLog remembers the most recent requests by ID, evicting the
+// oldest when full.
+type recentRequestLog struct {
+	mtx      sync.Mutex
+	requests map[string]recentRequest
+	ids      []string
+	next     int
+}
+
+func (svr *Server) recentRequests() *recentRequestLog {
+	svr.recentRequestsOnce.Do(func() {
+		if svr.ClientErrorHistory > 0 {
+			svr.recent = &recentRequestLog{
+				requests: make(map[string]recentRequest, svr.ClientErrorHistory),
+				ids:      make([]string, svr.ClientErrorHistory),
+			}
+		}
+	})
+	return svr.recent
+}
+
+func (l *recentRequestLog) add(id string, req recentRequest) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, ok := l.requests[id]; ok {
+		l.requests[id] = req
+		return
+	}
+	if old := l.ids[l.next]; old != "" {
+		delete(l.requests, old)
+	}
+	l.ids[l.next] = id
+	l.next = (l.next + 1) % len(l.ids)
+	l.requests[id] = req
+}
+
+func (l *recentRequestLog) lookup(id string) (recentRequest, bool) {
+	if l == nil {
+		return recentRequest{}, false
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	req, ok := l.requests[id]
+	return req, ok
+}
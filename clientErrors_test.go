@@ -0,0 +1,64 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientErrorHandler(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var entries []*fieldEntry
+	var s Server
+	s.NewLogEntry = func() Entry {
+		entry := newFieldEntry(&nullLogger{})
+		mtx.Lock()
+		entries = append(entries, entry)
+		mtx.Unlock()
+		return entry
+	}
+	s.ClientErrorHistory = 10
+
+	orders := s.Handle(Handler{Name: "orders", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Body: "{"}, nil
+	}})
+	report := s.Handle(s.ClientErrorHandler())
+
+	order := httptest.NewRequest("GET", "/orders", nil)
+	order.Header.Set("X-Request-ID", "req-1")
+	post := func(body string) int {
+		r := httptest.NewRequest("POST", "/client-errors", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		report(rec, r)
+		return rec.Code
+	}
+
+	// act
+	orders(httptest.NewRecorder(), order)
+	ok := post(`{"request_id":"req-1","kind":"parse","status":200,"message":"unexpected end of JSON input"}`)
+	invalid := post(`{"kind":"parse"}`)
+	s.Shutdown()
+
+	// assert
+	if ok != http.StatusNoContent || invalid != http.StatusBadRequest {
+		t.Errorf("status want: 204 and 400 got: %d and %d", ok, invalid)
+	}
+	var found *fieldEntry
+	mtx.Lock()
+	for _, entry := range entries {
+		if entry.fields["client_error_kind"] != nil {
+			found = entry
+		}
+	}
+	mtx.Unlock()
+	if found == nil {
+		t.Fatal("want client error report logged")
+	}
+	if found.fields["request_id"] != "req-1" || found.fields["handler"] != "orders" || found.fields["http_status"] != http.StatusOK {
+		t.Errorf("unexpected fields %v", found.fields)
+	}
+}
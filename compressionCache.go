@@ -0,0 +1,121 @@
+package httplog
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+const defaultCompressionCacheSize = 32 << 20
+
+// lruCache is a size-bounded LRU cache of byte slices.
+type lruCache struct {
+	maxBytes int64
+
+	mtx   sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruCacheItem struct {
+	key  string
+	body []byte
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruCacheItem).body, true
+}
+
+func (c *lruCache) add(key string, body []byte) {
+	itemSize := int64(len(body) + len(key))
+	if itemSize > c.maxBytes {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&lruCacheItem{key: key, body: body})
+	c.size += itemSize
+
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lruCache) delete(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *lruCache) bytes() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.size
+}
+
+func (c *lruCache) remove(el *list.Element) {
+	item := c.ll.Remove(el).(*lruCacheItem)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.body) + len(item.key))
+}
+
+// compressCached returns body compressed with coding, using the Server's
+// compression cache keyed by the handler name and entity version.
+func (svr *Server) compressCached(handlerName, version, coding string, body []byte) ([]byte, error) {
+	svr.compressionCacheOnce.Do(func() {
+		maxBytes := svr.CompressionCacheSize
+		if maxBytes <= 0 {
+			maxBytes = defaultCompressionCacheSize
+		}
+		svr.compressionCache = newLRUCache(maxBytes)
+	})
+
+	key := handlerName + "\x00" + version + "\x00" + coding
+	if cached, ok := svr.compressionCache.get(key); ok {
+		compressionCacheRequests.WithLabelValues("hit").Inc()
+		return cached, nil
+	}
+	compressionCacheRequests.WithLabelValues("miss").Inc()
+
+	var buf bytes.Buffer
+	encoder, err := newEncoder(coding, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = encoder.Write(body); err != nil {
+		return nil, err
+	}
+	if err = encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	compressed := buf.Bytes()
+	svr.compressionCache.add(key, compressed)
+	compressionCacheBytes.Set(float64(svr.compressionCache.bytes()))
+	return compressed, nil
+}
@@ -0,0 +1,167 @@
+package httplog
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	type op struct {
+		add    string
+		get    string
+		delete string
+		size   int
+	}
+
+	cases := []struct {
+		name     string
+		maxBytes int64
+		ops      []op
+		wantKeys []string
+		wantSize int64
+	}{
+		{
+			name:     "under limit",
+			maxBytes: 100,
+			ops:      []op{{add: "a", size: 9}, {add: "b", size: 9}},
+			wantKeys: []string{"a", "b"},
+			wantSize: 20,
+		},
+		{
+			name:     "evicts least recently added",
+			maxBytes: 25,
+			ops:      []op{{add: "a", size: 9}, {add: "b", size: 9}, {add: "c", size: 9}},
+			wantKeys: []string{"b", "c"},
+			wantSize: 20,
+		},
+		{
+			name:     "get refreshes recency",
+			maxBytes: 25,
+			ops:      []op{{add: "a", size: 9}, {add: "b", size: 9}, {get: "a"}, {add: "c", size: 9}},
+			wantKeys: []string{"a", "c"},
+			wantSize: 20,
+		},
+		{
+			name:     "replace updates size",
+			maxBytes: 100,
+			ops:      []op{{add: "a", size: 9}, {add: "a", size: 49}},
+			wantKeys: []string{"a"},
+			wantSize: 50,
+		},
+		{
+			name:     "oversized item not added",
+			maxBytes: 25,
+			ops:      []op{{add: "a", size: 9}, {add: "big", size: 30}},
+			wantKeys: []string{"a"},
+			wantSize: 10,
+		},
+		{
+			name:     "delete",
+			maxBytes: 100,
+			ops:      []op{{add: "a", size: 9}, {add: "b", size: 9}, {delete: "a"}, {delete: "missing"}},
+			wantKeys: []string{"b"},
+			wantSize: 10,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			cache := newLRUCache(c.maxBytes)
+
+			// act
+			for _, o := range c.ops {
+				switch {
+				case o.add != "":
+					cache.add(o.add, make([]byte, o.size))
+				case o.get != "":
+					cache.get(o.get)
+				case o.delete != "":
+					cache.delete(o.delete)
+				}
+			}
+
+			// assert
+			var keys []string
+			for key := range cache.items {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(c.wantKeys, keys) {
+				t.Errorf("keys want: %v got: %v", c.wantKeys, keys)
+			}
+			if got := cache.bytes(); got != c.wantSize {
+				t.Errorf("size want: %d got: %d", c.wantSize, got)
+			}
+		})
+	}
+}
+
+func TestCacheCompressed(t *testing.T) {
+	first := strings.Repeat("first ", 500)
+	second := strings.Repeat("second ", 500)
+
+	cases := []struct {
+		name            string
+		cacheCompressed bool
+		versions        [2]string
+		want            string
+	}{
+		{name: "same version served from cache", cacheCompressed: true, versions: [2]string{"v1", "v1"}, want: first},
+		{name: "new version compressed", cacheCompressed: true, versions: [2]string{"v1", "v2"}, want: second},
+		{name: "no version not cached", cacheCompressed: true, want: second},
+		{name: "cache disabled", versions: [2]string{"v1", "v1"}, want: second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			calls := 0
+			handler := s.Handle(Handler{
+				Name:            "test",
+				CacheCompressed: c.cacheCompressed,
+				Func: func(_ *http.Request, _ Entry) (Response, error) {
+					body := first
+					if calls > 0 {
+						body = second
+					}
+					version := c.versions[calls]
+					calls++
+					return Response{Body: body, Version: version}, nil
+				},
+			})
+			get := func() string {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Accept-Encoding", "gzip")
+				w := httptest.NewRecorder()
+				handler(w, r)
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := ioutil.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(b)
+			}
+
+			// act
+			get()
+			got := get()
+
+			// assert
+			if !strings.Contains(got, strings.TrimSpace(c.want)) {
+				t.Errorf("second response want: %.12s... got: %.12s...", c.want, got)
+			}
+		})
+	}
+}
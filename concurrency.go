@@ -0,0 +1,24 @@
+package httplog
+
+import "context"
+
+// acquireSlot waits for one of the MaxConcurrentRequests slots, returning
+// false if ctx is done first. When MaxConcurrentRequests is zero it returns
+// immediately.
+func (svr *Server) acquireSlot(ctx context.Context) (release func(), ok bool) {
+	svr.slotsOnce.Do(func() {
+		if svr.MaxConcurrentRequests > 0 {
+			svr.slots = make(chan struct{}, svr.MaxConcurrentRequests)
+		}
+	})
+	if svr.slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case svr.slots <- struct{}{}:
+		return func() { <-svr.slots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -0,0 +1,161 @@
+package httplog
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const maskedValue = "[redacted]"
+
+// secretFieldNames are substrings of field names whose values Config masks.
+var secretFieldNames = []string{"secret", "password", "passwd", "token", "credential", "apikey", "privatekey", "salt"}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Config returns the Server's exported settings as a JSON-friendly map, so a
+// running instance's effective configuration can be inspected. Durations
+// are formatted as strings, functions as "set" when non-nil, values which
+// implement fmt.Stringer or encoding.TextMarshaler, such as *net.IPNet, as
+// their text, with passwords in URLs redacted, and values without exported
+// fields by type name. Strings held by fields whose names
+// look like secrets, such as Secret or APIToken, are masked.
+func (svr *Server) Config() map[string]interface{} {
+	return dumpValue(reflect.ValueOf(svr).Elem(), false, 0).(map[string]interface{})
+}
+
+// ConfigHandler returns a Handler which serves Config as JSON. Serve it only
+// on an admin listener.
+func (svr *Server) ConfigHandler() Handler {
+	return Handler{
+		Name: "httplog_config",
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			return Response{Body: svr.Config()}, nil
+		},
+	}
+}
+
+func dumpValue(v reflect.Value, secret bool, depth int) interface{} {
+	if depth > 8 {
+		return v.Type().String()
+	}
+
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if text, ok := textValue(v); ok {
+		if secret && text != "" {
+			return maskedValue
+		}
+		return text
+	}
+
+	switch v.Kind() {
+	case reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+		return "set"
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return dumpValue(v.Elem(), secret, depth+1)
+	case reflect.String:
+		if secret && v.Len() > 0 {
+			return maskedValue
+		}
+		return v.String()
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if secret && v.Len() > 0 {
+				return maskedValue
+			}
+			return fmt.Sprintf("%d bytes", v.Len())
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = dumpValue(v.Index(i), secret, depth+1)
+		}
+		return items
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			m[key] = dumpValue(iter.Value(), secret || isSecretName(key), depth+1)
+		}
+		return m
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			m[f.Name] = dumpValue(v.Field(i), secret || isSecretName(f.Name), depth+1)
+		}
+		if len(m) == 0 {
+			return reflect.PtrTo(t).String()
+		}
+		return m
+	case reflect.Chan, reflect.UnsafePointer:
+		return v.Type().String()
+	}
+
+	return v.Interface()
+}
+
+// textValue returns v's text if it, or its address, implements fmt.Stringer
+// or encoding.TextMarshaler.
+func textValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "", false
+		}
+	}
+	candidates := []reflect.Value{v}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		candidates = append(candidates, v.Addr())
+	}
+	for _, c := range candidates {
+		if !c.CanInterface() {
+			continue
+		}
+		switch x := c.Interface().(type) {
+		case *url.URL:
+			return x.Redacted(), true
+		case url.URL:
+			return x.Redacted(), true
+		case fmt.Stringer:
+			return x.String(), true
+		case encoding.TextMarshaler:
+			if text, err := x.MarshalText(); err == nil {
+				return string(text), true
+			}
+		}
+	}
+	return "", false
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFieldNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package httplog
+
+import "net/http"
+
+// noTrackingFields are removed from the access logs of requests which opt
+// out of tracking. See Server.NoTracking.
+var noTrackingFields = append([]string{"referrer_domain", "user_agent", "cookies"}, utmParams...)
+
+// DoNotTrack reports whether r asks not to be tracked with a DNT or Sec-GPC
+// (Global Privacy Control) header of 1. It can be used as Server.NoTracking,
+// or called from a function which also checks a consent cookie:
+//
+//	svr.NoTracking = func(r *http.Request) bool {
+//		if c, err := r.Cookie("consent"); err == nil {
+//			return c.Value != "analytics"
+//		}
+//		return httplog.DoNotTrack(r)
+//	}
+func DoNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// minimizeFields removes or anonymizes the identifying fields of the access
+// log of a request which opted out of tracking.
+func (svr *Server) minimizeFields(fields map[string]interface{}, info *RequestInfo) {
+	if _, ok := fields["ip"]; ok {
+		ip := anonymizeIP(info.ClientIP)
+		fields["ip"] = ip
+		fields["host"] = ip
+	}
+	for _, name := range noTrackingFields {
+		delete(fields, name)
+	}
+	for name, class := range svr.FieldClasses {
+		if class == ClassPII {
+			delete(fields, name)
+		}
+	}
+	fields["tracking_opt_out"] = true
+}
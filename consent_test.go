@@ -0,0 +1,93 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNoTracking(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+	s.NoTracking = DoNotTrack
+	s.FieldClasses = map[string]FieldClass{"email": ClassPII}
+	handler := Handler{Name: "test", Attribution: true, Func: func(_ *http.Request, entry Entry) (Response, error) {
+		entry.AddField("email", "user@example.com")
+		entry.AddField("order_id", 42)
+		return Response{}, nil
+	}}
+
+	for _, dnt := range []string{"1", ""} {
+		r := httptest.NewRequest("GET", "/?utm_source=news", nil)
+		r.RemoteAddr = "192.0.2.123:1234"
+		r.Header.Set("Referer", "https://search.example/")
+		r.Header.Set("DNT", dnt)
+
+		// act
+		s.Handle(handler)(httptest.NewRecorder(), r)
+	}
+	s.Shutdown()
+
+	// assert
+	optOut, tracked := sink.records[0].Fields, sink.records[1].Fields
+	if optOut["ip"] != "192.0.2.0" || optOut["host"] != "192.0.2.0" || optOut["tracking_opt_out"] != true {
+		t.Errorf("opt out want ip and host 192.0.2.0, tracking_opt_out got: %v %v %v", optOut["ip"], optOut["host"], optOut["tracking_opt_out"])
+	}
+	for _, name := range []string{"referrer_domain", "utm_source", "email"} {
+		if _, ok := optOut[name]; ok {
+			t.Errorf("opt out want no %s", name)
+		}
+		if _, ok := tracked[name]; !ok {
+			t.Errorf("tracked want %s", name)
+		}
+	}
+	if optOut["order_id"] != 42 {
+		t.Errorf("opt out want order_id 42 got: %v", optOut["order_id"])
+	}
+	if tracked["ip"] != "192.0.2.123" {
+		t.Errorf("tracked ip want: 192.0.2.123 got: %v", tracked["ip"])
+	}
+}
+
+func TestNoTrackingSkipsReverseDNS(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.NoTracking = DoNotTrack
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, nil
+	}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.77:1234"
+	r.Header.Set("DNT", "1")
+	var lookups []string
+	defer func(lookup func(string) ([]string, error)) { lookupAddr = lookup }(lookupAddr)
+	lookupAddr = func(addr string) ([]string, error) {
+		lookups = append(lookups, addr)
+		return nil, nil
+	}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), r)
+	s.Shutdown()
+
+	// assert
+	if len(lookups) != 0 {
+		t.Errorf("want no reverse DNS lookups got: %v", lookups)
+	}
+	ipHostMtx.RLock()
+	_, cached := ipHost["192.0.2.77"]
+	ipHostMtx.RUnlock()
+	if cached {
+		t.Error("want no host cache entry for 192.0.2.77")
+	}
+	if fields := sink.records[0].Fields; fields["ip"] != "192.0.2.0" || fields["host"] != "192.0.2.0" {
+		t.Errorf("ip and host want: 192.0.2.0 got: %v %v", fields["ip"], fields["host"])
+	}
+}
@@ -0,0 +1,36 @@
+package httplog
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// acceptsContentType reports whether the request body's media type is one of
+// consumes. Requests without a body are always accepted, as are all requests
+// when consumes is empty. Entries may use a subtype wildcard such as
+// "text/*".
+func acceptsContentType(consumes []string, r *http.Request) bool {
+	if len(consumes) == 0 {
+		return true
+	}
+	if r.ContentLength == 0 || (r.ContentLength < 0 && len(r.TransferEncoding) == 0) {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for _, c := range consumes {
+		c = strings.ToLower(c)
+		if c == mediaType {
+			return true
+		}
+		if strings.HasSuffix(c, "/*") && strings.HasPrefix(mediaType, c[:len(c)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConsumes(t *testing.T) {
+	cases := []struct {
+		name        string
+		consumes    []string
+		contentType string
+		body        string
+		chunked     bool
+		wantStatus  int
+		wantField   interface{}
+	}{
+		{name: "any type", contentType: "text/plain", body: "x", wantStatus: http.StatusOK},
+		{name: "exact match", consumes: []string{"application/json"}, contentType: "application/json", body: "{}", wantStatus: http.StatusOK},
+		{name: "parameters ignored", consumes: []string{"application/json"}, contentType: "Application/JSON; charset=utf-8", body: "{}", wantStatus: http.StatusOK},
+		{name: "case-insensitive list", consumes: []string{"Application/JSON"}, contentType: "application/json", body: "{}", wantStatus: http.StatusOK},
+		{name: "wildcard", consumes: []string{"image/*"}, contentType: "image/png", body: "x", wantStatus: http.StatusOK},
+		{name: "wildcard other type", consumes: []string{"image/*"}, contentType: "text/plain", body: "x", wantStatus: http.StatusUnsupportedMediaType, wantField: "text/plain"},
+		{name: "mismatch", consumes: []string{"application/json"}, contentType: "application/xml", body: "<a/>", wantStatus: http.StatusUnsupportedMediaType, wantField: "application/xml"},
+		{name: "missing type", consumes: []string{"application/json"}, body: "{}", wantStatus: http.StatusUnsupportedMediaType, wantField: ""},
+		{name: "invalid type", consumes: []string{"application/json"}, contentType: "/", body: "{}", wantStatus: http.StatusUnsupportedMediaType, wantField: "/"},
+		{name: "no body", consumes: []string{"application/json"}, contentType: "application/xml", wantStatus: http.StatusOK},
+		{name: "chunked body", consumes: []string{"application/json"}, contentType: "application/xml", body: "<a/>", chunked: true, wantStatus: http.StatusUnsupportedMediaType, wantField: "application/xml"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", Consumes: c.consumes, Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{}, nil
+			}}
+			r := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			if c.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, r)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.wantStatus {
+				t.Errorf("status want: %d got: %d", c.wantStatus, w.Code)
+			}
+			if got := sink.records[0].Fields["unsupported_content_type"]; got != c.wantField {
+				t.Errorf("unsupported_content_type want: %v got: %v", c.wantField, got)
+			}
+		})
+	}
+}
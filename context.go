@@ -0,0 +1,131 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	parentRequestIDHeader = "X-Parent-Request-ID"
+	requestDepthHeader    = "X-Request-Depth"
+)
+
+type ctxKey int
+
+const requestStateKey ctxKey = 0
+
+// requestState is attached to the context of each request served by Handle.
+type requestState struct {
+	svr             *Server
+	handlerName     string
+	requestID       string
+	parentRequestID string
+	depth           int
+	entry           Entry
+	info            *RequestInfo
+	start           time.Time
+
+	mtx         sync.Mutex
+	downstream  []DownstreamCall
+	escalation  string
+	checkpoints []CheckpointTiming
+	// lastCheckpoint is the time of the last checkpoint since start.
+	lastCheckpoint time.Duration
+	// outboxEvents are the events emitted by the handler, held until the
+	// response is written.
+	outboxEvents []OutboxEvent
+	// dbQueries, dbTime, and dbErrors sum the queries made through a
+	// wrapped database/sql driver.
+	dbQueries int
+	dbTime    time.Duration
+	dbErrors  int
+	// cacheCalls through cacheErrors sum the calls observed by
+	// ObserveCacheCall.
+	cacheCalls  int
+	cacheHits   int
+	cacheMisses int
+	cacheTime   time.Duration
+	cacheErrors int
+	// costs are the totals added by AddCost, by resource.
+	costs map[string]float64
+	// scopes are the caller's scopes set with SetScopes.
+	scopes []string
+}
+
+// DownstreamCall summarizes an outbound request made through Transport while
+// serving a request. See Server.LogDownstreamCalls.
+type DownstreamCall struct {
+	Host   string `json:"host"`
+	Status int    `json:"status"`
+	Ms     int64  `json:"ms"`
+}
+
+func newRequestState(svr *Server, handler Handler, r *http.Request, requestID string, entry Entry) *requestState {
+	info := newRequestInfo(r, svr.TrustedProxies)
+	info.Handler = handler.Name
+	info.Route = handler.Route
+	if info.Route == "" {
+		info.Route = handler.Name
+	}
+	info.RequestID = requestID
+
+	state := &requestState{
+		svr:         svr,
+		handlerName: handler.Name,
+		requestID:   requestID,
+		entry:       entry,
+		info:        info,
+		start:       time.Now(),
+	}
+	if parentID := r.Header.Get(parentRequestIDHeader); validRequestID(parentID) {
+		state.parentRequestID = parentID
+	}
+	if depth, err := strconv.Atoi(r.Header.Get(requestDepthHeader)); err == nil && depth > 0 {
+		state.depth = depth
+	}
+	return state
+}
+
+func getRequestState(ctx context.Context) *requestState {
+	state, _ := ctx.Value(requestStateKey).(*requestState)
+	return state
+}
+
+func (state *requestState) addDownstream(call DownstreamCall) {
+	if !state.svr.LogDownstreamCalls {
+		return
+	}
+	state.mtx.Lock()
+	state.downstream = append(state.downstream, call)
+	state.mtx.Unlock()
+}
+
+// downstreamCalls returns a copy of the calls recorded so far, so it can be
+// logged while calls from goroutines the handler started are still being
+// added.
+func (state *requestState) downstreamCalls() []DownstreamCall {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return append([]DownstreamCall(nil), state.downstream...)
+}
+
+// EntryFromContext returns the log Entry of the request being served by
+// Handle, or nil if ctx doesn't belong to such a request.
+func EntryFromContext(ctx context.Context) Entry {
+	if state := getRequestState(ctx); state != nil {
+		return state.entry
+	}
+	return nil
+}
+
+// RequestIDFromContext returns the ID of the request being served by Handle,
+// or "" if ctx doesn't belong to such a request.
+func RequestIDFromContext(ctx context.Context) string {
+	if state := getRequestState(ctx); state != nil {
+		return state.requestID
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDownstreamCallsCopy(t *testing.T) {
+	// arrange
+	state := &requestState{svr: &Server{LogDownstreamCalls: true}}
+	state.addDownstream(DownstreamCall{Host: "a", Status: 200, Ms: 1})
+
+	// act
+	calls := state.downstreamCalls()
+	calls[0].Host = "changed"
+	state.addDownstream(DownstreamCall{Host: "b", Status: 500, Ms: 2})
+
+	// assert
+	want := []DownstreamCall{{Host: "a", Status: 200, Ms: 1}, {Host: "b", Status: 500, Ms: 2}}
+	if got := state.downstreamCalls(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want: %v got: %v", want, got)
+	}
+	if len(calls) != 1 {
+		t.Errorf("copy want: 1 call got: %d", len(calls))
+	}
+}
+
+func TestParentRequestID(t *testing.T) {
+	cases := []struct {
+		name     string
+		parentID string
+		want     interface{}
+	}{
+		{name: "missing"},
+		{name: "valid", parentID: "abc123", want: "abc123"},
+		{name: "longest", parentID: strings.Repeat("a", 128), want: strings.Repeat("a", 128)},
+		{name: "too long", parentID: strings.Repeat("a", 129)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{}, nil
+			}}
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.parentID != "" {
+				r.Header.Set("X-Parent-Request-ID", c.parentID)
+			}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), r)
+			s.Shutdown()
+
+			// assert
+			if got := sink.records[0].Fields["parent_request_id"]; got != c.want {
+				t.Errorf("parent_request_id want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package httplog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// truncatedCookieLength is the number of characters of a cookie logged with
+// CookieTruncate.
+const truncatedCookieLength = 8
+
+// credentialCookieWords are parts of the names of cookies which likely hold
+// credentials. Such cookies are hashed even when CookieTruncate is selected.
+var credentialCookieWords = []string{
+	"session", "sess", "sid", "token", "auth", "jwt", "csrf", "xsrf",
+	"remember", "login", "cred", "key", "secret", "pass",
+}
+
+// CookieLogMode selects how a cookie's value is logged. See Server.LogCookies.
+type CookieLogMode int
+
+const (
+	// CookieHash logs a short HMAC-SHA256 of the value keyed with
+	// Server.IPHashSalt, so requests carrying the same cookie can be
+	// correlated without the value being recoverable from the logs.
+	CookieHash CookieLogMode = iota
+	// CookieTruncate logs the first 8 characters of the value. Cookies
+	// whose names suggest credentials, such as "session_id" or
+	// "auth_token", are hashed instead.
+	CookieTruncate
+)
+
+// cookieFields returns the request cookies selected by Server.LogCookies,
+// hashed or truncated.
+func (svr *Server) cookieFields(r *http.Request) map[string]string {
+	if len(svr.LogCookies) == 0 {
+		return nil
+	}
+
+	var cookies map[string]string
+	for _, c := range r.Cookies() {
+		mode, ok := svr.LogCookies[c.Name]
+		if !ok {
+			continue
+		}
+		if cookies == nil {
+			cookies = make(map[string]string)
+		}
+		if mode == CookieTruncate && !isCredentialCookie(c.Name) {
+			value := c.Value
+			if len(value) > truncatedCookieLength {
+				value = value[:truncatedCookieLength] + "..."
+			}
+			cookies[c.Name] = value
+		} else {
+			cookies[c.Name] = svr.hashCookie(c.Value)
+		}
+	}
+	return cookies
+}
+
+// hashCookie returns a short HMAC-SHA256 of value keyed with the Server's
+// hash salt. Unlike an unsalted hash, low-entropy values can't be recovered
+// by hashing candidates without the salt.
+func (svr *Server) hashCookie(value string) string {
+	mac := hmac.New(sha256.New, svr.hashSalt())
+	mac.Write([]byte("cookie\x00"))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func isCredentialCookie(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range credentialCookieWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseHeaderFields returns the response headers selected by
+// Server.LogResponseHeaders. Set-Cookie values are replaced so only cookie
+// names are logged.
+func (svr *Server) responseHeaderFields(h http.Header) map[string]string {
+	if len(svr.LogResponseHeaders) == 0 {
+		return nil
+	}
+
+	var headers map[string]string
+	for _, name := range svr.LogResponseHeaders {
+		name = http.CanonicalHeaderKey(name)
+		values := h.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if name == "Set-Cookie" {
+			stripped := make([]string, len(values))
+			for i, v := range values {
+				cookieName, _, _ := strings.Cut(v, "=")
+				stripped[i] = cookieName + "=[redacted]"
+			}
+			values = stripped
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
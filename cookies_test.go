@@ -0,0 +1,125 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCookieFields(t *testing.T) {
+	salted := &Server{IPHashSalt: []byte("test salt")}
+
+	cases := []struct {
+		name   string
+		mode   CookieLogMode
+		cookie string
+		value  string
+		want   string
+	}{
+		{
+			name:   "hash",
+			mode:   CookieHash,
+			cookie: "theme",
+			value:  "dark",
+			want:   salted.hashCookie("dark"),
+		},
+		{
+			name:   "truncate",
+			mode:   CookieTruncate,
+			cookie: "theme",
+			value:  "solarized-dark",
+			want:   "solarize...",
+		},
+		{
+			name:   "truncate short",
+			mode:   CookieTruncate,
+			cookie: "theme",
+			value:  "dark",
+			want:   "dark",
+		},
+		{
+			name:   "truncate session cookie",
+			mode:   CookieTruncate,
+			cookie: "session_id",
+			value:  "0123456789abcdef",
+			want:   salted.hashCookie("0123456789abcdef"),
+		},
+		{
+			name:   "truncate auth cookie",
+			mode:   CookieTruncate,
+			cookie: "X-Auth-Token",
+			value:  "0123456789abcdef",
+			want:   salted.hashCookie("0123456789abcdef"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			s := &Server{
+				IPHashSalt: []byte("test salt"),
+				LogCookies: map[string]CookieLogMode{c.cookie: c.mode},
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(&http.Cookie{Name: c.cookie, Value: c.value})
+			r.AddCookie(&http.Cookie{Name: "unlisted", Value: "x"})
+
+			// act
+			got := s.cookieFields(r)
+
+			// assert
+			want := map[string]string{c.cookie: c.want}
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("want: %v got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestHashCookie(t *testing.T) {
+	// arrange
+	s := &Server{IPHashSalt: []byte("test salt")}
+	other := &Server{IPHashSalt: []byte("other salt")}
+	random := &Server{}
+
+	// act
+	first := s.hashCookie("1234")
+	second := s.hashCookie("1234")
+	otherSalt := other.hashCookie("1234")
+	randomSalt := random.hashCookie("1234")
+
+	// assert
+	if first != second {
+		t.Errorf("want same hash for the same value got: %s %s", first, second)
+	}
+	if first == otherSalt || first == randomSalt {
+		t.Errorf("want different hashes for other salts got: %s %s %s", first, otherSalt, randomSalt)
+	}
+	if first == hashValue("1234") {
+		t.Errorf("want salted hash got unsalted: %s", first)
+	}
+	if len(first) != 16 {
+		t.Errorf("length want: 16 got: %d", len(first))
+	}
+}
+
+func TestResponseHeaderFieldsRedactsSetCookie(t *testing.T) {
+	// arrange
+	s := &Server{LogResponseHeaders: []string{"set-cookie", "content-type"}}
+	h := http.Header{}
+	h.Add("Set-Cookie", "session=secret; Path=/; HttpOnly")
+	h.Add("Set-Cookie", "theme=dark")
+	h.Set("Content-Type", "text/plain")
+
+	// act
+	got := s.responseHeaderFields(h)
+
+	// assert
+	if v := got["Set-Cookie"]; v != "session=[redacted], theme=[redacted]" {
+		t.Errorf("Set-Cookie want redacted got: %q", v)
+	}
+	if v := got["Content-Type"]; v != "text/plain" {
+		t.Errorf("Content-Type want: text/plain got: %q", v)
+	}
+}
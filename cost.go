@@ -0,0 +1,36 @@
+package httplog
+
+import "context"
+
+// AddCost adds amount to the request's total for resource, for example
+// AddCost(ctx, "tokens", 512) after calling a language model. Totals are
+// added to the access log as cost_<resource> fields, such as cost_tokens,
+// and counted in httplog_cost_total by handler and resource for chargeback
+// and anomaly detection. Costs added outside a request served by Handle are
+// only counted, with an empty handler.
+func AddCost(ctx context.Context, resource string, amount float64) {
+	if resource == "" || amount == 0 {
+		return
+	}
+	state := getRequestState(ctx)
+	handlerName := ""
+	if state != nil {
+		handlerName = state.handlerName
+		state.mtx.Lock()
+		if state.costs == nil {
+			state.costs = make(map[string]float64)
+		}
+		state.costs[resource] += amount
+		state.mtx.Unlock()
+	}
+	costTotal.WithLabelValues(handlerName, resource).Add(amount)
+}
+
+// addCostFields adds the cost_<resource> totals of a request.
+func (state *requestState) addCostFields(fields map[string]interface{}) {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	for resource, amount := range state.costs {
+		fields["cost_"+resource] = amount
+	}
+}
@@ -0,0 +1,35 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddCost(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		AddCost(r.Context(), "tokens", 100)
+		AddCost(r.Context(), "tokens", 50)
+		AddCost(r.Context(), "db_ms", 12.5)
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	fields := sink.records[0].Fields
+	if fields["cost_tokens"] != 150.0 || fields["cost_db_ms"] != 12.5 {
+		t.Errorf("want cost_tokens 150 and cost_db_ms 12.5 got: %v and %v", fields["cost_tokens"], fields["cost_db_ms"])
+	}
+}
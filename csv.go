@@ -0,0 +1,139 @@
+package httplog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const defaultCSVFlushEvery = 100
+
+// CSVResponse streams rows as CSV. Use it as a Response Body for large
+// exports; rows are written as they're produced rather than buffered.
+type CSVResponse struct {
+	// Filename, when set, is sent in a Content-Disposition attachment header.
+	Filename string
+	// Header is written as the first row when not empty.
+	Header []string
+	// Rows returns the next row, or io.EOF when there are no more rows.
+	Rows func() ([]string, error)
+	// FlushEvery is the number of rows written between flushes to the
+	// client. The default is 100.
+	FlushEvery int
+}
+
+// CSVRowsFromChannel adapts a channel of rows for CSVResponse.Rows. The
+// channel must be closed after the last row.
+func CSVRowsFromChannel(ch <-chan []string) func() ([]string, error) {
+	return func() ([]string, error) {
+		row, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return row, nil
+	}
+}
+
+func (c *CSVResponse) setHeaders(h http.Header) {
+	h.Set("Content-Type", "text/csv; charset=utf-8")
+	if c.Filename != "" {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": c.Filename}))
+	}
+}
+
+// stream writes the rows to w, calling flush every FlushEvery rows.
+func (c *CSVResponse) stream(w io.Writer, flush func() error) error {
+	if c.Rows == nil {
+		return fmt.Errorf("CSVResponse.Rows is nil")
+	}
+
+	flushEvery := c.FlushEvery
+	if flushEvery <= 0 {
+		flushEvery = defaultCSVFlushEvery
+	}
+
+	cw := csv.NewWriter(w)
+	flushCSV := func() error {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		return flush()
+	}
+
+	if len(c.Header) > 0 {
+		if err := cw.Write(c.Header); err != nil {
+			return err
+		}
+	}
+
+	for n := 1; ; n++ {
+		row, err := c.Rows()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = flushCSV()
+			return err
+		}
+		if err = cw.Write(row); err != nil {
+			return err
+		}
+		if n%flushEvery == 0 {
+			if err = flushCSV(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flushCSV()
+}
+
+// writeStream writes the status and streams a body produced by fn,
+// compressing it as negotiated from the request's Accept-Encoding unless
+// compress is false. It returns the number of body bytes produced by fn,
+// before compression.
+func writeStream(w http.ResponseWriter, r *http.Request, compress bool, writeHeader func(int), status int, fn func(w io.Writer, flush func() error) error) (int, error) {
+	wc := &writeCounter{writer: w}
+
+	flushClient := func() error {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	if compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if coding, ok := parseAcceptEncoding(r.Header).negotiate(); ok && coding != "identity" {
+			encoder, err := newEncoder(coding, wc)
+			if err != nil {
+				return 0, err
+			}
+			w.Header().Set("Content-Encoding", coding)
+			writeHeader(status)
+
+			flusher, _ := encoder.(interface{ Flush() error })
+			uc := &writeCounter{writer: encoder}
+			err = fn(uc, func() error {
+				if flusher != nil {
+					if err := flusher.Flush(); err != nil {
+						return err
+					}
+				}
+				return flushClient()
+			})
+			closeErr := encoder.Close()
+			if err == nil {
+				err = closeErr
+			}
+			return uc.count, err
+		}
+	}
+
+	writeHeader(status)
+	err := fn(wc, flushClient)
+	return wc.count, err
+}
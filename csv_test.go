@@ -0,0 +1,69 @@
+package httplog
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSVResponse(t *testing.T) {
+	cases := []struct {
+		name         string
+		rows         [][]string
+		rowErr       error
+		depth        int
+		expectedBody string
+	}{
+		{"rows", [][]string{{"1", "a"}, {"2", "b,c"}}, nil, 0, "id,name\n1,a\n2,\"b,c\"\n"},
+		{"row error", [][]string{{"1", "a"}}, errors.New("cursor closed"), 1, "id,name\n1,a\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.StackDepth = c.depth
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				i := 0
+				return Response{Body: &CSVResponse{
+					Header: []string{"id", "name"},
+					Rows: func() ([]string, error) {
+						if i == len(c.rows) {
+							if c.rowErr != nil {
+								return nil, c.rowErr
+							}
+							return nil, io.EOF
+						}
+						i++
+						return c.rows[i-1], nil
+					},
+				}}, nil
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if got := w.Body.String(); got != c.expectedBody {
+				t.Errorf("body want: %q got: %q", c.expectedBody, got)
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+				t.Errorf("Content-Type want: text/csv got: %s", got)
+			}
+			err := sink.records[0].Err
+			if !errors.Is(err, c.rowErr) {
+				t.Fatalf("logged error want: %v got: %v", c.rowErr, err)
+			}
+			if err != nil && len(ErrorStack(err)) != c.depth {
+				t.Errorf("frames want: %d (StackDepth) got: %d", c.depth, len(ErrorStack(err)))
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dependencyLatencyWeight = 0.2
+	dependencyPollInterval  = 10 * time.Millisecond
+)
+
+// DependencyPolicy sets when a dependency, such as a database or queue, is
+// unhealthy. See Server.DependencyPolicies.
+type DependencyPolicy struct {
+	// MaxLatency is the moving average latency at which the dependency is
+	// unhealthy. Zero disables the latency check.
+	MaxLatency time.Duration
+	// MaxQueueDepth is the queue depth at which the dependency is
+	// unhealthy. Zero disables the queue check.
+	MaxQueueDepth int
+	// Delay is how long PriorityNormal requests wait for an unhealthy
+	// dependency to recover before being shed. The default, 0, sheds them
+	// immediately.
+	Delay time.Duration
+}
+
+// DependencyReport is an observation of a dependency's health passed to
+// Server.ReportDependency.
+type DependencyReport struct {
+	// Latency is the duration of a call to the dependency. Zero isn't
+	// recorded.
+	Latency time.Duration
+	// QueueDepth is the dependency's current backlog, for example
+	// connections waiting for a pool or messages in a queue. Negative
+	// isn't recorded.
+	QueueDepth int
+}
+
+type dependencyState struct {
+	mtx        sync.Mutex
+	latency    float64 // moving average, seconds
+	queueDepth int
+	unhealthy  bool
+}
+
+// ReportDependency records an observation of the named dependency from a
+// handler or client wrapper:
+//
+//	start := time.Now()
+//	rows, err := db.QueryContext(ctx, query)
+//	svr.ReportDependency("orders_db", httplog.DependencyReport{Latency: time.Since(start), QueueDepth: -1})
+//
+// When the dependency's DependencyPolicy thresholds are crossed it becomes
+// unhealthy: requests to handlers listing it in Handler.Dependencies are
+// shed with StatusServiceUnavailable (503) before the handler is called,
+// PriorityNormal handlers after waiting up to Delay, and logged with
+// shed_reason dependency and shed_dependency. PriorityCritical handlers are
+// never shed. Changes in health are logged and exported in
+// httplog_dependency_healthy.
+func (svr *Server) ReportDependency(name string, report DependencyReport) {
+	policy, ok := svr.DependencyPolicies[name]
+	if !ok {
+		return
+	}
+
+	dep := svr.dependency(name)
+	dep.mtx.Lock()
+	if report.Latency > 0 {
+		if dep.latency == 0 {
+			dep.latency = report.Latency.Seconds()
+		} else {
+			dep.latency += dependencyLatencyWeight * (report.Latency.Seconds() - dep.latency)
+		}
+	}
+	if report.QueueDepth >= 0 {
+		dep.queueDepth = report.QueueDepth
+	}
+	latency := time.Duration(dep.latency * float64(time.Second))
+	unhealthy := (policy.MaxLatency > 0 && latency >= policy.MaxLatency) ||
+		(policy.MaxQueueDepth > 0 && dep.queueDepth >= policy.MaxQueueDepth)
+	changed := unhealthy != dep.unhealthy
+	dep.unhealthy = unhealthy
+	queueDepth := dep.queueDepth
+	dep.mtx.Unlock()
+
+	if !changed {
+		return
+	}
+
+	healthy := 1.0
+	msg := "dependency recovered"
+	if unhealthy {
+		healthy = 0
+		msg = "dependency unhealthy"
+	}
+	dependencyHealthy.WithLabelValues(name).Set(healthy)
+
+	entry := svr.newEntry()
+	entry.AddFields(map[string]interface{}{
+		"dependency":  name,
+		"latency_ms":  durationMs(latency),
+		"queue_depth": queueDepth,
+	})
+	entry.Warn(msg)
+}
+
+func (svr *Server) dependency(name string) *dependencyState {
+	svr.dependenciesMtx.Lock()
+	defer svr.dependenciesMtx.Unlock()
+
+	if svr.dependencies == nil {
+		svr.dependencies = make(map[string]*dependencyState)
+	}
+	dep, ok := svr.dependencies[name]
+	if !ok {
+		dep = &dependencyState{}
+		svr.dependencies[name] = dep
+	}
+	return dep
+}
+
+// unhealthyDependency returns the first of names which is reported
+// unhealthy or whose health check is down.
+func (svr *Server) unhealthyDependency(names []string) (string, bool) {
+	svr.dependenciesMtx.Lock()
+	defer svr.dependenciesMtx.Unlock()
+
+	for _, name := range names {
+		if check, ok := svr.healthChecks[name]; ok && check.down() {
+			return name, true
+		}
+		dep, ok := svr.dependencies[name]
+		if !ok {
+			continue
+		}
+		dep.mtx.Lock()
+		unhealthy := dep.unhealthy
+		dep.mtx.Unlock()
+		if unhealthy {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// shedDependencies reports whether the request should be shed because one
+// of the handler's dependencies is unhealthy, waiting for it to recover
+// first if its policy has a Delay.
+func (svr *Server) shedDependencies(ctx context.Context, handler Handler, entry Entry, w http.ResponseWriter) bool {
+	if len(handler.Dependencies) == 0 || handler.Priority == PriorityCritical {
+		return false
+	}
+	name, unhealthy := svr.unhealthyDependency(handler.Dependencies)
+	if !unhealthy {
+		return false
+	}
+
+	if delay := svr.DependencyPolicies[name].Delay; delay > 0 && handler.Priority == PriorityNormal {
+		start := time.Now()
+		recovered := svr.waitDependencies(ctx, handler.Dependencies, delay)
+		entry.AddField("dependency_delay_ms", durationMs(time.Since(start)))
+		if recovered {
+			return false
+		}
+	}
+
+	entry.AddField("shed_reason", "dependency")
+	entry.AddField("shed_dependency", name)
+	shedRequestsTotal.WithLabelValues(handler.Name, "dependency").Inc()
+	hdr := RetryAfter(time.Second)
+	w.Header().Set(hdr.Name, hdr.Value)
+	return true
+}
+
+// waitDependencies waits up to delay for all of names to be healthy.
+func (svr *Server) waitDependencies(ctx context.Context, names []string, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	ticker := time.NewTicker(dependencyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case <-ticker.C:
+			if _, unhealthy := svr.unhealthyDependency(names); !unhealthy {
+				return true
+			}
+		}
+	}
+}
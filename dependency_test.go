@@ -0,0 +1,65 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDependencyShedding(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.DependencyPolicies = map[string]DependencyPolicy{
+		"db": {MaxLatency: 100 * time.Millisecond, Delay: 50 * time.Millisecond},
+	}
+	newHandler := func(priority Priority) func(http.ResponseWriter, *http.Request) {
+		return s.Handle(Handler{Name: "test", Priority: priority, Dependencies: []string{"db"}, Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{}, nil
+		}})
+	}
+	serve := func(priority Priority) int {
+		w := httptest.NewRecorder()
+		newHandler(priority)(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	// act
+	healthy := serve(PriorityLow)
+	s.ReportDependency("db", DependencyReport{Latency: 500 * time.Millisecond})
+	low := serve(PriorityLow)
+	normal := serve(PriorityNormal)
+	critical := serve(PriorityCritical)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 20; i++ {
+			s.ReportDependency("db", DependencyReport{Latency: time.Millisecond})
+		}
+	}()
+	recovered := serve(PriorityNormal)
+	s.Shutdown()
+
+	// assert
+	expected := []int{200, 503, 503, 200, 200}
+	for i, got := range []int{healthy, low, normal, critical, recovered} {
+		if got != expected[i] {
+			t.Errorf("request %d status want: %d got: %d", i, expected[i], got)
+		}
+	}
+	var shed, delayed int
+	for _, rec := range sink.records {
+		if rec.Fields["shed_dependency"] == "db" {
+			shed++
+		}
+		if ms, ok := rec.Fields["dependency_delay_ms"].(float64); ok && ms >= 50 {
+			delayed++
+		}
+	}
+	if shed != 2 || delayed != 1 {
+		t.Errorf("shed, delayed want: 2, 1 got: %d, %d", shed, delayed)
+	}
+}
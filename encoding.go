@@ -0,0 +1,118 @@
+package httplog
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// supportedEncodings lists the content codings the server can produce, in
+// order of preference when the client rates them equally.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// acceptedEncodings holds the qualities parsed from an Accept-Encoding
+// header.
+type acceptedEncodings struct {
+	present  bool
+	q        map[string]float64
+	wildcard float64
+}
+
+func parseAcceptEncoding(h http.Header) acceptedEncodings {
+	values, present := h["Accept-Encoding"]
+	a := acceptedEncodings{
+		present:  present,
+		q:        make(map[string]float64),
+		wildcard: -1,
+	}
+
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			q := 1.0
+			coding := part
+			if i := strings.Index(part, ";"); i != -1 {
+				coding = strings.TrimSpace(part[:i])
+				for _, param := range strings.Split(part[i+1:], ";") {
+					param = strings.TrimSpace(param)
+					if !strings.HasPrefix(param, "q=") {
+						continue
+					}
+					if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+
+			coding = strings.ToLower(coding)
+			if coding == "*" {
+				a.wildcard = q
+			} else {
+				a.q[coding] = q
+			}
+		}
+	}
+
+	return a
+}
+
+// quality returns the client's quality value for coding. Codings not listed
+// take the wildcard's quality. Identity is acceptable unless explicitly
+// excluded; other codings are not.
+func (a acceptedEncodings) quality(coding string) float64 {
+	if q, ok := a.q[coding]; ok {
+		return q
+	}
+	if a.wildcard >= 0 {
+		return a.wildcard
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
+}
+
+// negotiate returns the preferred supported coding, "identity" if no
+// supported coding is acceptable, or false if identity isn't acceptable
+// either. A request without an Accept-Encoding header gets identity.
+func (a acceptedEncodings) negotiate() (string, bool) {
+	if !a.present {
+		return "identity", true
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supportedEncodings {
+		if q := a.quality(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	if best != "" {
+		return best, true
+	}
+
+	if a.quality("identity") > 0 {
+		return "identity", true
+	}
+	return "", false
+}
+
+// newEncoder returns a writer which compresses to w using coding.
+func newEncoder(coding string, w io.Writer) (io.WriteCloser, error) {
+	switch coding {
+	case "gzip":
+		return gzip.NewWriterLevel(w, gzipCompLevel)
+	case "deflate":
+		// HTTP's "deflate" coding is the zlib format.
+		return zlib.NewWriterLevel(w, gzipCompLevel)
+	default:
+		return nil, fmt.Errorf("unsupported content coding %q", coding)
+	}
+}
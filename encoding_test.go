@@ -0,0 +1,46 @@
+package httplog
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		AcceptEncoding []string
+		Expected       string
+		ExpectedOK     bool
+	}{
+		{nil, "identity", true},
+		{[]string{""}, "identity", true},
+		{[]string{"gzip"}, "gzip", true},
+		{[]string{"gzip;q=0"}, "identity", true},
+		{[]string{"deflate"}, "deflate", true},
+		{[]string{"gzip, deflate"}, "gzip", true},
+		{[]string{"gzip;q=0.5, deflate"}, "deflate", true},
+		{[]string{"GZIP; q=0.8"}, "gzip", true},
+		{[]string{"br"}, "identity", true},
+		{[]string{"*"}, "gzip", true},
+		{[]string{"*;q=0, deflate"}, "deflate", true},
+		{[]string{"br", "deflate"}, "deflate", true},
+		{[]string{"identity;q=0"}, "", false},
+		{[]string{"br, *;q=0"}, "", false},
+		{[]string{"gzip;q=0, identity;q=0"}, "", false},
+	}
+
+	for _, c := range cases {
+		// arrange
+		h := http.Header{}
+		if c.AcceptEncoding != nil {
+			h["Accept-Encoding"] = c.AcceptEncoding
+		}
+
+		// act
+		coding, ok := parseAcceptEncoding(h).negotiate()
+
+		// assert
+		if coding != c.Expected || ok != c.ExpectedOK {
+			t.Errorf("Accept-Encoding %q: want: %q,%v got: %q,%v", c.AcceptEncoding, c.Expected, c.ExpectedOK, coding, ok)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package httplog
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsWindow = 5 * time.Minute
+	statsBucketWidth   = time.Minute
+)
+
+// EndpointSort selects the order of Server.TopEndpoints.
+type EndpointSort string
+
+const (
+	// SortByRequests orders endpoints by request count.
+	SortByRequests EndpointSort = "requests"
+	// SortByErrorRate orders endpoints by the fraction of requests logged
+	// at warn or error level.
+	SortByErrorRate EndpointSort = "error_rate"
+	// SortByLatency orders endpoints by average latency.
+	SortByLatency EndpointSort = "latency"
+)
+
+// EndpointStats summarizes requests to one handler over the Server's
+// StatsWindow.
+type EndpointStats struct {
+	Handler      string  `json:"handler"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+}
+
+type endpointCounts struct {
+	requests   int64
+	errors     int64
+	totalNanos int64
+	maxNanos   int64
+}
+
+type statsBucket struct {
+	start     time.Time
+	endpoints map[string]*endpointCounts
+}
+
+// endpointStats aggregates per-handler counts in a ring of minute buckets.
+type endpointStats struct {
+	mtx     sync.Mutex
+	buckets []statsBucket
+}
+
+func newEndpointStats(window time.Duration) *endpointStats {
+	n := int(window / statsBucketWidth)
+	if n < 1 {
+		n = 1
+	}
+	return &endpointStats{buckets: make([]statsBucket, n)}
+}
+
+func (es *endpointStats) observe(handlerName string, isError bool, duration time.Duration, now time.Time) {
+	start := now.Truncate(statsBucketWidth)
+	idx := int(start.Unix()/int64(statsBucketWidth/time.Second)) % len(es.buckets)
+
+	es.mtx.Lock()
+	defer es.mtx.Unlock()
+
+	b := &es.buckets[idx]
+	if b.start.After(start) {
+		// the slot holds a later period, so start is outside the window;
+		// requests are observed when they finish, so a long one can
+		// arrive after its slot has been reused
+		return
+	}
+	if !b.start.Equal(start) {
+		b.start = start
+		b.endpoints = make(map[string]*endpointCounts)
+	}
+
+	c, ok := b.endpoints[handlerName]
+	if !ok {
+		c = &endpointCounts{}
+		b.endpoints[handlerName] = c
+	}
+	c.requests++
+	if isError {
+		c.errors++
+	}
+	c.totalNanos += int64(duration)
+	if int64(duration) > c.maxNanos {
+		c.maxNanos = int64(duration)
+	}
+}
+
+func (es *endpointStats) snapshot(now time.Time) []EndpointStats {
+	oldest := now.Truncate(statsBucketWidth).Add(-statsBucketWidth * time.Duration(len(es.buckets)-1))
+
+	totals := make(map[string]*endpointCounts)
+
+	es.mtx.Lock()
+	for _, b := range es.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		for name, c := range b.endpoints {
+			t, ok := totals[name]
+			if !ok {
+				t = &endpointCounts{}
+				totals[name] = t
+			}
+			t.requests += c.requests
+			t.errors += c.errors
+			t.totalNanos += c.totalNanos
+			if c.maxNanos > t.maxNanos {
+				t.maxNanos = c.maxNanos
+			}
+		}
+	}
+	es.mtx.Unlock()
+
+	stats := make([]EndpointStats, 0, len(totals))
+	for name, t := range totals {
+		stats = append(stats, EndpointStats{
+			Handler:      name,
+			Requests:     t.requests,
+			Errors:       t.errors,
+			ErrorRate:    float64(t.errors) / float64(t.requests),
+			AvgLatencyMs: float64(t.totalNanos) / float64(t.requests) / 1e6,
+			MaxLatencyMs: float64(t.maxNanos) / 1e6,
+		})
+	}
+	return stats
+}
+
+func (svr *Server) endpointStats() *endpointStats {
+	svr.endpointStatsOnce.Do(func() {
+		window := svr.StatsWindow
+		if window <= 0 {
+			window = defaultStatsWindow
+		}
+		svr.stats = newEndpointStats(window)
+	})
+	return svr.stats
+}
+
+// TopEndpoints returns up to n handlers from the last StatsWindow, ordered by
+// the given sort in descending order. n <= 0 returns all handlers.
+func (svr *Server) TopEndpoints(n int, by EndpointSort) []EndpointStats {
+	stats := svr.endpointStats().snapshot(time.Now())
+
+	less := func(i, j int) bool { return stats[i].Requests > stats[j].Requests }
+	switch by {
+	case SortByErrorRate:
+		less = func(i, j int) bool { return stats[i].ErrorRate > stats[j].ErrorRate }
+	case SortByLatency:
+		less = func(i, j int) bool { return stats[i].AvgLatencyMs > stats[j].AvgLatencyMs }
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if less(i, j) != less(j, i) {
+			return less(i, j)
+		}
+		return stats[i].Handler < stats[j].Handler
+	})
+
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// TopEndpointsHandler returns a Handler which serves TopEndpoints as JSON.
+// The query parameters n (default 10) and by ("requests", "error_rate", or
+// "latency") select the report.
+func (svr *Server) TopEndpointsHandler() Handler {
+	return Handler{
+		Name: "httplog_top_endpoints",
+		Func: func(r *http.Request, _ Entry) (Response, error) {
+			q := r.URL.Query()
+			n, err := strconv.Atoi(q.Get("n"))
+			if err != nil {
+				n = 10
+			}
+			by := EndpointSort(q.Get("by"))
+			if by == "" {
+				by = SortByRequests
+			}
+			return Response{Body: svr.TopEndpoints(n, by)}, nil
+		},
+	}
+}
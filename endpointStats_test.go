@@ -0,0 +1,132 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEndpointStatsWindow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 30, 0, time.UTC)
+
+	cases := []struct {
+		name         string
+		window       time.Duration
+		ago          []time.Duration
+		wantRequests int64
+	}{
+		{name: "current bucket", window: 5 * time.Minute, ago: []time.Duration{0, time.Second}, wantRequests: 2},
+		{name: "within window", window: 5 * time.Minute, ago: []time.Duration{0, 4 * time.Minute}, wantRequests: 2},
+		{name: "outside window", window: 5 * time.Minute, ago: []time.Duration{0, 5 * time.Minute, 10 * time.Minute}, wantRequests: 1},
+		{name: "reused bucket reset", window: 2 * time.Minute, ago: []time.Duration{2 * time.Minute, 0}, wantRequests: 1},
+		{name: "window below a minute", window: time.Second, ago: []time.Duration{0, time.Minute}, wantRequests: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			es := newEndpointStats(c.window)
+			for _, ago := range c.ago {
+				es.observe("test", false, time.Millisecond, now.Add(-ago))
+			}
+
+			// act
+			stats := es.snapshot(now)
+
+			// assert
+			if len(stats) != 1 || stats[0].Requests != c.wantRequests {
+				t.Errorf("requests want: %d got: %+v", c.wantRequests, stats)
+			}
+		})
+	}
+}
+
+func TestTopEndpoints(t *testing.T) {
+	type observation struct {
+		handler  string
+		isError  bool
+		duration time.Duration
+	}
+	observations := []observation{
+		{"a", false, 10 * time.Millisecond},
+		{"a", false, 10 * time.Millisecond},
+		{"a", true, 10 * time.Millisecond},
+		{"b", true, 50 * time.Millisecond},
+		{"c", false, 20 * time.Millisecond},
+		{"c", false, 40 * time.Millisecond},
+		{"d", false, 30 * time.Millisecond},
+		{"d", false, 30 * time.Millisecond},
+	}
+
+	cases := []struct {
+		name string
+		n    int
+		by   EndpointSort
+		want []string
+	}{
+		{name: "requests", by: SortByRequests, want: []string{"a", "c", "d", "b"}},
+		{name: "error rate", by: SortByErrorRate, want: []string{"b", "a", "c", "d"}},
+		{name: "latency", by: SortByLatency, want: []string{"b", "c", "d", "a"}},
+		{name: "limited", n: 2, by: SortByRequests, want: []string{"a", "c"}},
+		{name: "unknown sort", by: "other", want: []string{"a", "c", "d", "b"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			now := time.Now()
+			for _, o := range observations {
+				s.endpointStats().observe(o.handler, o.isError, o.duration, now)
+			}
+
+			// act
+			stats := s.TopEndpoints(c.n, c.by)
+
+			// assert
+			var got []string
+			for _, st := range stats {
+				got = append(got, st.Handler)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestTopEndpointsStats(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	statuses := []int{200, 200, 404, 500}
+	handler := s.Handle(Handler{
+		Name:         "test",
+		StatusLevels: map[int]Level{404: LevelInfo},
+		Func: func(r *http.Request, _ Entry) (Response, error) {
+			return Response{Status: statuses[len(r.URL.Path)-1]}, nil
+		},
+	})
+	paths := []string{"/", "//", "///", "////"}
+
+	// act
+	for _, path := range paths {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	s.Shutdown()
+	stats := s.TopEndpoints(0, SortByRequests)
+
+	// assert
+	if len(stats) != 1 {
+		t.Fatalf("want 1 endpoint got: %+v", stats)
+	}
+	got := stats[0]
+	if got.Handler != "test" || got.Requests != 4 || got.Errors != 1 || got.ErrorRate != 0.25 {
+		t.Errorf("want 4 requests, 1 error (404 demoted to info) got: %+v", got)
+	}
+	if got.MaxLatencyMs < got.AvgLatencyMs {
+		t.Errorf("max latency want >= avg got: %+v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultEnricherTimeout = 100 * time.Millisecond
+
+// Enricher adds fields to access log entries, for example a cost center or
+// deployment ring. Only fields added to the entry are used; its log methods
+// are ignored. See Server.AddEnricher.
+type Enricher interface {
+	Enrich(ctx context.Context, info *RequestInfo, entry Entry)
+}
+
+// EnricherFunc adapts a function to the Enricher interface.
+type EnricherFunc func(ctx context.Context, info *RequestInfo, entry Entry)
+
+// Enrich calls fn(ctx, info, entry).
+func (fn EnricherFunc) Enrich(ctx context.Context, info *RequestInfo, entry Entry) {
+	fn(ctx, info, entry)
+}
+
+type namedEnricher struct {
+	name     string
+	timeout  time.Duration
+	enricher Enricher
+}
+
+// AddEnricher registers e to add fields to every access log entry. It may be
+// called while the Server is running. Enrichers run in registration order
+// when the access log is written, each with its own timeout; the default,
+// when timeout is 0, is 100ms. Fields added by an enricher that times out or
+// panics are discarded and the failure is logged in enricher_errors, keyed
+// by name. An enricher's fields replace fields of the same name.
+//
+// The context passed to e carries the request's values but isn't cancelled
+// when the request finishes.
+func (svr *Server) AddEnricher(name string, timeout time.Duration, e Enricher) {
+	if timeout <= 0 {
+		timeout = defaultEnricherTimeout
+	}
+	svr.enrichersMtx.Lock()
+	svr.enrichers = append(svr.enrichers, namedEnricher{name: name, timeout: timeout, enricher: e})
+	svr.enrichersMtx.Unlock()
+}
+
+// enrich runs the registered enrichers, adding their fields to fields.
+func (svr *Server) enrich(ctx context.Context, info *RequestInfo, fields map[string]interface{}) {
+	svr.enrichersMtx.RLock()
+	enrichers := svr.enrichers
+	svr.enrichersMtx.RUnlock()
+	if len(enrichers) == 0 {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	errs := make(map[string]string)
+	for _, e := range enrichers {
+		added, err := runEnricher(ctx, e, info)
+		if err != nil {
+			errs[e.name] = err.Error()
+			continue
+		}
+		for k, v := range added {
+			fields[k] = v
+		}
+	}
+	if len(errs) > 0 {
+		fields["enricher_errors"] = errs
+	}
+}
+
+// runEnricher runs e in its own goroutine so a slow enricher can be
+// abandoned when its timeout expires.
+func runEnricher(ctx context.Context, e namedEnricher, info *RequestInfo) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	entry := &enricherEntry{fields: make(map[string]interface{})}
+	done := make(chan error, 1)
+	go func() {
+		_, err := callRecover(func() error {
+			e.enricher.Enrich(ctx, info, entry)
+			return nil
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return entry.close(), nil
+	case <-ctx.Done():
+		entry.close()
+		return nil, fmt.Errorf("timed out after %v", e.timeout)
+	}
+}
+
+// enricherEntry collects the fields added by an Enricher. Fields added
+// after close are dropped.
+type enricherEntry struct {
+	mtx    sync.Mutex
+	closed bool
+	fields map[string]interface{}
+}
+
+func (e *enricherEntry) AddField(key string, value interface{}) {
+	e.mtx.Lock()
+	if !e.closed {
+		e.fields[key] = value
+	}
+	e.mtx.Unlock()
+}
+
+func (e *enricherEntry) AddFields(fields map[string]interface{}) {
+	e.mtx.Lock()
+	if !e.closed {
+		for k, v := range fields {
+			e.fields[k] = v
+		}
+	}
+	e.mtx.Unlock()
+}
+
+func (e *enricherEntry) AddError(err error) {
+	e.AddField("error", err.Error())
+}
+
+func (e *enricherEntry) close() map[string]interface{} {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.closed = true
+	return e.fields
+}
+
+func (e *enricherEntry) Info(args ...interface{})                  {}
+func (e *enricherEntry) Infof(format string, args ...interface{})  {}
+func (e *enricherEntry) Warn(args ...interface{})                  {}
+func (e *enricherEntry) Warnf(format string, args ...interface{})  {}
+func (e *enricherEntry) Error(args ...interface{})                 {}
+func (e *enricherEntry) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,46 @@
+package httplog
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrich(t *testing.T) {
+	// arrange
+	svr := &Server{}
+	svr.AddEnricher("ring", 0, EnricherFunc(func(ctx context.Context, info *RequestInfo, entry Entry) {
+		entry.AddFields(map[string]interface{}{"ring": "canary", "cost_center": info.Handler})
+	}))
+	svr.AddEnricher("panics", 0, EnricherFunc(func(ctx context.Context, info *RequestInfo, entry Entry) {
+		entry.AddField("partial", true)
+		panic("boom")
+	}))
+	svr.AddEnricher("slow", 10*time.Millisecond, EnricherFunc(func(ctx context.Context, info *RequestInfo, entry Entry) {
+		<-ctx.Done()
+		entry.AddField("late", true)
+	}))
+	svr.AddEnricher("override", 0, EnricherFunc(func(ctx context.Context, info *RequestInfo, entry Entry) {
+		entry.AddField("ring", "stable")
+	}))
+	fields := make(map[string]interface{})
+
+	// act
+	svr.enrich(context.Background(), &RequestInfo{Handler: "orders"}, fields)
+
+	// assert
+	errs, _ := fields["enricher_errors"].(map[string]string)
+	delete(fields, "enricher_errors")
+	expected := map[string]interface{}{"ring": "stable", "cost_center": "orders"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("fields want: %v got: %v", expected, fields)
+	}
+	if !strings.Contains(errs["panics"], "boom") {
+		t.Errorf("enricher_errors[panics] want: boom got: %q", errs["panics"])
+	}
+	if !strings.Contains(errs["slow"], "timed out") {
+		t.Errorf("enricher_errors[slow] want: timed out got: %q", errs["slow"])
+	}
+}
@@ -0,0 +1,54 @@
+package httplog
+
+import "fmt"
+
+// ErrorCause is one error in an error chain. See ErrorChain.
+type ErrorCause struct {
+	Message string       `json:"msg"`
+	Type    string       `json:"type"`
+	Frames  []StackFrame `json:"frames,omitempty"`
+}
+
+// ErrorChain returns err and the errors it wraps, outermost first, following
+// Unwrap. Errors wrapping several errors, such as those from errors.Join or
+// ErrorCode.Wrap, are followed depth first. The stack captured by Server is
+// attached to the error it was captured for rather than listed separately,
+// so the last cause is the root cause. Server logs the chain as error_chain,
+// with paths rendered as set by StackPaths, when LogErrorChain is set.
+func ErrorChain(err error) []ErrorCause {
+	return errorChain(err, StackPathTrimmed)
+}
+
+func errorChain(err error, format StackPathFormat) []ErrorCause {
+	var chain []ErrorCause
+	appendErrorChain(&chain, err, nil, format)
+	return chain
+}
+
+func appendErrorChain(chain *[]ErrorCause, err error, frames []StackFrame, format StackPathFormat) {
+	if err == nil {
+		return
+	}
+	if errStack, ok := err.(*errorStack); ok {
+		if errStack.orig != nil {
+			appendErrorChain(chain, errStack.orig, ErrorStackPaths(err, format), format)
+			return
+		}
+		frames = ErrorStackPaths(err, format)
+	}
+
+	*chain = append(*chain, ErrorCause{
+		Message: err.Error(),
+		Type:    fmt.Sprintf("%T", err),
+		Frames:  frames,
+	})
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		appendErrorChain(chain, u.Unwrap(), nil, format)
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			appendErrorChain(chain, e, nil, format)
+		}
+	}
+}
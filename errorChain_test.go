@@ -0,0 +1,64 @@
+package httplog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorChain(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogErrorChain = true
+	cause := errors.New("connection refused")
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, fmt.Errorf("load order: %w", cause)
+	}}
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	chain, ok := sink.records[0].Fields["error_chain"].([]ErrorCause)
+	if !ok || len(chain) != 2 {
+		t.Fatalf("error_chain want 2 causes got: %#v", sink.records[0].Fields["error_chain"])
+	}
+	if chain[0].Message != "load order: connection refused" || chain[0].Type != "*fmt.wrapError" {
+		t.Errorf("chain[0] want: load order: connection refused, *fmt.wrapError got: %s, %s", chain[0].Message, chain[0].Type)
+	}
+	if len(chain[0].Frames) == 0 {
+		t.Error("chain[0] want frames")
+	}
+	if chain[1].Message != "connection refused" || chain[1].Type != "*errors.errorString" || chain[1].Frames != nil {
+		t.Errorf("chain[1] want: connection refused, *errors.errorString, no frames got: %+v", chain[1])
+	}
+}
+
+func TestErrorChainMultiple(t *testing.T) {
+	// arrange
+	cause := errors.New("sql: no rows in result set")
+	err := errTestOrderNotFound.Wrap(cause)
+
+	// act
+	chain := ErrorChain(err)
+
+	// assert
+	var types []string
+	for _, c := range chain {
+		types = append(types, c.Type)
+	}
+	want := "[*httplog.codedError *httplog.ErrorCode *errors.errorString]"
+	if got := fmt.Sprint(types); got != want {
+		t.Errorf("types want: %s got: %s", want, got)
+	}
+	if ErrorChain(nil) != nil {
+		t.Error("want nil chain for nil error")
+	}
+}
@@ -0,0 +1,114 @@
+package httplog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ErrorCode is a stable, machine-readable error identifier with the status
+// and client-facing message it responds with. Create codes with
+// NewErrorCode, usually as package variables:
+//
+//	var ErrOrderNotFound = httplog.NewErrorCode("ORD-404", http.StatusNotFound, "order not found")
+//
+// When a handler returns an error carrying an ErrorCode, directly or through
+// Wrap, the error_code field is logged. If the handler's Response is empty
+// the response is the code's status with an ErrorBody, its message
+// translated by Server.Messages.
+type ErrorCode struct {
+	Code    string `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// ErrorBody is the JSON response body for an ErrorCode.
+type ErrorBody struct {
+	ErrorCode string `json:"error_code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+var (
+	errorCodesMtx sync.RWMutex
+	errorCodes    = make(map[string]*ErrorCode)
+)
+
+// NewErrorCode registers an error code in the catalog. It panics if code is
+// already registered or status isn't 4xx or 5xx.
+func NewErrorCode(code string, status int, message string) *ErrorCode {
+	if status < 400 || status > 599 {
+		panic(fmt.Sprintf("httplog: error code %s: invalid status %d", code, status))
+	}
+
+	errorCodesMtx.Lock()
+	defer errorCodesMtx.Unlock()
+
+	if _, ok := errorCodes[code]; ok {
+		panic(fmt.Sprintf("httplog: error code %s already registered", code))
+	}
+	c := &ErrorCode{Code: code, Status: status, Message: message}
+	errorCodes[code] = c
+	return c
+}
+
+// ErrorCatalog returns the registered error codes sorted by code.
+func ErrorCatalog() []*ErrorCode {
+	errorCodesMtx.RLock()
+	defer errorCodesMtx.RUnlock()
+
+	catalog := make([]*ErrorCode, 0, len(errorCodes))
+	for _, c := range errorCodes {
+		catalog = append(catalog, c)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Code < catalog[j].Code })
+	return catalog
+}
+
+// ErrorCatalogHandler returns a Handler which serves ErrorCatalog as JSON,
+// for client teams.
+func ErrorCatalogHandler() Handler {
+	return Handler{
+		Name: "httplog_error_catalog",
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			return Response{Body: ErrorCatalog()}, nil
+		},
+	}
+}
+
+func (c *ErrorCode) Error() string {
+	return c.Code + ": " + c.Message
+}
+
+// Wrap returns an error carrying c and the internal cause err, which is
+// logged but not sent to the client. errors.Is and errors.As match both.
+func (c *ErrorCode) Wrap(err error) error {
+	if err == nil {
+		return c
+	}
+	return &codedError{code: c, err: err}
+}
+
+type codedError struct {
+	code *ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.code.Error() + ": " + e.err.Error()
+}
+
+func (e *codedError) Unwrap() []error {
+	return []error{e.code, e.err}
+}
+
+// errorCodeOf returns the ErrorCode carried by err, if any.
+func errorCodeOf(err error) (*ErrorCode, bool) {
+	var code *ErrorCode
+	if errors.As(err, &code) {
+		return code, true
+	}
+	return nil, false
+}
@@ -0,0 +1,54 @@
+package httplog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errTestOrderNotFound = NewErrorCode("TEST-ORD-404", http.StatusNotFound, "order not found")
+
+func TestErrorCode(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	cause := errors.New("sql: no rows in result set")
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, errTestOrderNotFound.Wrap(cause)
+	}}
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status want: %d got: %d", http.StatusNotFound, w.Code)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ErrorCode != "TEST-ORD-404" || body.Message != "order not found" || body.RequestID == "" {
+		t.Errorf("body want: TEST-ORD-404, order not found, request ID got: %+v", body)
+	}
+	if got := sink.records[0].Fields["error_code"]; got != "TEST-ORD-404" {
+		t.Errorf("error_code want: TEST-ORD-404 got: %v", got)
+	}
+	if err := sink.records[0].Err; !errors.Is(err, cause) || !errors.Is(err, errTestOrderNotFound) {
+		t.Errorf("logged error want cause and code got: %v", err)
+	}
+
+	found := false
+	for _, c := range ErrorCatalog() {
+		found = found || c == errTestOrderNotFound
+	}
+	if !found {
+		t.Error("want code in ErrorCatalog")
+	}
+}
@@ -0,0 +1,73 @@
+package httplog
+
+import (
+	"log"
+	"strings"
+)
+
+// ErrorLog returns a *log.Logger for http.Server's ErrorLog which writes
+// net/http's errors, such as failed TLS handshakes, malformed requests, and
+// panics outside Handle, to the Server's Entry. Each message is logged as
+// "http server error" with the original message in error_log and, where
+// known, remote_addr and reason. Failed TLS handshakes are counted in
+// httplog_rejected_requests_total with reason tls_handshake.
+// Server.NewHTTPServer sets it.
+func (svr *Server) ErrorLog() *log.Logger {
+	return log.New(errorLogWriter{svr: svr}, "", 0)
+}
+
+type errorLogWriter struct {
+	svr *Server
+}
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+
+	fields := map[string]interface{}{"error_log": msg}
+	if reason, remoteAddr, ok := parseErrorLog(msg); ok {
+		fields["reason"] = reason
+		if remoteAddr != "" {
+			fields["remote_addr"] = w.svr.logAddr(remoteAddr)
+		}
+		if reason == "tls_handshake" {
+			rejectedRequestsTotal.WithLabelValues(reason).Inc()
+		}
+	}
+
+	entry := w.svr.newEntry()
+	entry.AddFields(fields)
+	entry.Warn("http server error")
+	return len(p), nil
+}
+
+// errorLogPrefixes maps the prefixes of net/http's error log messages to
+// reasons. Messages which name the client continue with its address.
+var errorLogPrefixes = []struct {
+	prefix string
+	reason string
+}{
+	{"http: TLS handshake error from ", "tls_handshake"},
+	{"http: panic serving ", "panic"},
+	{"http: Accept error: ", "accept"},
+	{"http: superfluous response.WriteHeader call", "superfluous_write_header"},
+	{"http2: ", "http2"},
+}
+
+// parseErrorLog returns the reason for a net/http error log message and the
+// client address it names, if any.
+func parseErrorLog(msg string) (reason, remoteAddr string, ok bool) {
+	for _, p := range errorLogPrefixes {
+		if !strings.HasPrefix(msg, p.prefix) {
+			continue
+		}
+		if p.reason == "tls_handshake" || p.reason == "panic" {
+			rest := msg[len(p.prefix):]
+			// the address is followed by ": <error>"
+			if i := strings.Index(rest, ": "); i != -1 {
+				remoteAddr = rest[:i]
+			}
+		}
+		return p.reason, remoteAddr, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,29 @@
+package httplog
+
+import (
+	"testing"
+)
+
+func TestErrorLog(t *testing.T) {
+	// arrange
+	var entry *fieldEntry
+	var s Server
+	s.NewLogEntry = func() Entry {
+		entry = newFieldEntry(&nullLogger{})
+		return entry
+	}
+
+	// act
+	s.ErrorLog().Printf("http: TLS handshake error from 10.0.0.1:52114: remote error: tls: bad certificate")
+
+	// assert
+	if got := entry.fields["reason"]; got != "tls_handshake" {
+		t.Errorf("reason want: tls_handshake got: %v", got)
+	}
+	if got := entry.fields["remote_addr"]; got != "10.0.0.1:52114" {
+		t.Errorf("remote_addr want: 10.0.0.1:52114 got: %v", got)
+	}
+	if got := entry.fields["error_log"]; got != "http: TLS handshake error from 10.0.0.1:52114: remote error: tls: bad certificate" {
+		t.Errorf("error_log got: %v", got)
+	}
+}
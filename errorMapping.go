@@ -0,0 +1,36 @@
+package httplog
+
+// MapError registers fn to translate errors returned by handlers into
+// responses. When a handler returns a non-nil error the registered functions
+// are called in registration order; the Response of the first to return
+// true replaces the handler's Response. The error is still logged.
+//
+// fn receives the error returned by the handler, before its stack is
+// captured, so errors.Is and errors.As can be used, for example:
+//
+//	svr.MapError(func(err error) (httplog.Response, bool) {
+//		if errors.Is(err, sql.ErrNoRows) {
+//			return httplog.Response{Status: http.StatusNotFound}, true
+//		}
+//		return httplog.Response{}, false
+//	})
+func (svr *Server) MapError(fn func(err error) (Response, bool)) {
+	svr.errorMappersMtx.Lock()
+	svr.errorMappers = append(svr.errorMappers, fn)
+	svr.errorMappersMtx.Unlock()
+}
+
+// mapError returns the Response for err from the first matching function
+// registered with MapError.
+func (svr *Server) mapError(err error) (Response, bool) {
+	svr.errorMappersMtx.RLock()
+	mappers := svr.errorMappers
+	svr.errorMappersMtx.RUnlock()
+
+	for _, fn := range mappers {
+		if resp, ok := fn(err); ok {
+			return resp, true
+		}
+	}
+	return Response{}, false
+}
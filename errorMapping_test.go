@@ -0,0 +1,96 @@
+package httplog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errTestNotFound = errors.New("not found")
+
+func TestMapError(t *testing.T) {
+	notFound := func(err error) (Response, bool) {
+		if errors.Is(err, errTestNotFound) {
+			return Response{Status: http.StatusNotFound, Body: "missing"}, true
+		}
+		return Response{}, false
+	}
+	conflict := func(err error) (Response, bool) {
+		return Response{Status: http.StatusConflict}, true
+	}
+
+	cases := []struct {
+		name       string
+		mappers    []func(error) (Response, bool)
+		resp       Response
+		err        error
+		wantStatus int
+		wantBody   string
+		wantErr    bool
+	}{
+		{name: "no mappers", err: errTestNotFound, resp: Response{Status: http.StatusTeapot}, wantStatus: http.StatusTeapot, wantErr: true},
+		{name: "matched", mappers: []func(error) (Response, bool){notFound}, err: errTestNotFound, wantStatus: http.StatusNotFound, wantBody: "missing", wantErr: true},
+		{name: "matched wrapped", mappers: []func(error) (Response, bool){notFound}, err: fmt.Errorf("loading: %w", errTestNotFound), wantStatus: http.StatusNotFound, wantBody: "missing", wantErr: true},
+		{name: "replaces handler response", mappers: []func(error) (Response, bool){notFound}, err: errTestNotFound, resp: Response{Status: http.StatusInternalServerError, Body: "oops"}, wantStatus: http.StatusNotFound, wantBody: "missing", wantErr: true},
+		{name: "unmatched", mappers: []func(error) (Response, bool){notFound}, err: errors.New("boom"), resp: Response{Status: http.StatusInternalServerError}, wantStatus: http.StatusInternalServerError, wantErr: true},
+		{name: "first match wins", mappers: []func(error) (Response, bool){notFound, conflict}, err: errTestNotFound, wantStatus: http.StatusNotFound, wantBody: "missing", wantErr: true},
+		{name: "later mapper", mappers: []func(error) (Response, bool){notFound, conflict}, err: errors.New("boom"), wantStatus: http.StatusConflict, wantErr: true},
+		{name: "no error", mappers: []func(error) (Response, bool){conflict}, resp: Response{Status: http.StatusOK}, wantStatus: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			for _, fn := range c.mappers {
+				s.MapError(fn)
+			}
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return c.resp, c.err
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.wantStatus {
+				t.Errorf("status want: %d got: %d", c.wantStatus, w.Code)
+			}
+			if c.wantBody != "" && w.Body.String() != c.wantBody {
+				t.Errorf("body want: %s got: %s", c.wantBody, w.Body.String())
+			}
+			if got := sink.records[0].Err != nil; got != c.wantErr {
+				t.Errorf("error logged want: %v got: %v", c.wantErr, got)
+			}
+		})
+	}
+}
+
+func TestMapErrorReceivesUnwrappedError(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	var got error
+	s.MapError(func(err error) (Response, bool) {
+		got = err
+		return Response{}, false
+	})
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, errTestNotFound
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// assert
+	if got != errTestNotFound {
+		t.Errorf("want the handler's error before its stack is captured got: %#v", got)
+	}
+}
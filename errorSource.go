@@ -0,0 +1,92 @@
+package httplog
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// sourceContextLines is the number of lines shown either side of the line
+// in a SourceSnippet.
+const sourceContextLines = 2
+
+// libraryDir is the directory of httplog's own source files, whose frames
+// aren't application frames.
+var libraryDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return path.Dir(file)
+}()
+
+// SourceSnippet is the source around the top application frame of an
+// error's stack. See Server.LogErrorSource.
+type SourceSnippet struct {
+	Path      string   `json:"path"`
+	Line      int      `json:"line"`
+	StartLine int      `json:"start_line"`
+	Lines     []string `json:"lines"`
+}
+
+// errorSource returns the source around the first frame of err's stack
+// outside the standard library and httplog, with its path rendered in
+// format. It returns false if err doesn't
+// carry a stack or the source file can't be read.
+func errorSource(err error, format StackPathFormat) (SourceSnippet, bool) {
+	errStack, ok := err.(*errorStack)
+	if !ok {
+		return SourceSnippet{}, false
+	}
+	for _, f := range errStack.StackTrace() {
+		file, line := f.file()
+		if !applicationFile(file) {
+			continue
+		}
+		snippet, err := readSourceSnippet(file, line)
+		if err != nil {
+			return SourceSnippet{}, false
+		}
+		snippet.Path = f.stackFrame(format).Path
+		return snippet, true
+	}
+	return SourceSnippet{}, false
+}
+
+func applicationFile(file string) bool {
+	if file == "" {
+		return false
+	}
+	if goroot := runtime.GOROOT(); goroot != "" && strings.HasPrefix(file, goroot+"/") {
+		return false
+	}
+	return path.Dir(file) != libraryDir || strings.HasSuffix(file, "_test.go")
+}
+
+func readSourceSnippet(file string, line int) (SourceSnippet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return SourceSnippet{}, err
+	}
+	defer f.Close()
+
+	snippet := SourceSnippet{Line: line, StartLine: line - sourceContextLines}
+	if snippet.StartLine < 1 {
+		snippet.StartLine = 1
+	}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; n <= line+sourceContextLines && scanner.Scan(); n++ {
+		if n >= snippet.StartLine {
+			snippet.Lines = append(snippet.Lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return SourceSnippet{}, err
+	}
+	if snippet.StartLine+len(snippet.Lines) <= line {
+		// the file changed since the binary was built
+		return SourceSnippet{}, io.ErrUnexpectedEOF
+	}
+	return snippet, nil
+}
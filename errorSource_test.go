@@ -0,0 +1,40 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorSource(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogErrorSource = true
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		panic("source snippet test")
+	}}
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	snippet, ok := sink.records[0].Fields["error_source"].(SourceSnippet)
+	if !ok {
+		t.Fatalf("error_source want SourceSnippet got: %#v", sink.records[0].Fields["error_source"])
+	}
+	if !strings.HasSuffix(snippet.Path, "errorSource_test.go") {
+		t.Errorf("path want: errorSource_test.go got: %s", snippet.Path)
+	}
+	if len(snippet.Lines) != 5 || snippet.StartLine != snippet.Line-2 {
+		t.Fatalf("want 5 lines from line %d got: %d from %d", snippet.Line-2, len(snippet.Lines), snippet.StartLine)
+	}
+	if got := snippet.Lines[2]; !strings.Contains(got, `panic("source snippet test")`) {
+		t.Errorf("line want: panic got: %s", got)
+	}
+}
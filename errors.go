@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 // FilterStackTrace is called by the stackTrace function to filter frames.
@@ -15,6 +16,23 @@ var FilterStackTrace = func(path string) bool {
 		path == "testing/testing.go"
 }
 
+// StackFrame describes a single frame of a captured stack trace.
+type StackFrame struct {
+	Path     string `json:"path"`
+	Filename string `json:"filename"`
+	Func     string `json:"func"`
+	Line     int    `json:"line"`
+	// Module is the module containing the file, set by StackPathModule.
+	Module string `json:"module,omitempty"`
+}
+
+// ErrorStack returns the stack trace captured with err, innermost frame
+// first. It returns nil if err doesn't carry a stack trace. Errors returned
+// by handlers and recovered panics have their stack captured by Server.
+func ErrorStack(err error) []StackFrame {
+	return ErrorStackPaths(err, StackPathTrimmed)
+}
+
 // The code in this file is heavily based on http://github.com/pkg/errors, with modifications.
 
 // Persuant to the BSD 2-clause "Simplified" License of pkg/errors the license is replicated here:
@@ -45,45 +63,68 @@ var FilterStackTrace = func(path string) bool {
 
 // stackTrace returns the current frames in the program's stack.
 func stackTrace() []frame {
-	stackTrace := callers().StackTrace()
-	filtered := make([]frame, 0, len(stackTrace))
-	for _, frame := range stackTrace {
-		if !FilterStackTrace(frame.Path()) {
-			filtered = append(filtered, frame)
+	return filterFrames(capturePCs(1, 0))
+}
+
+// filterFrames resolves pcs, removing the frames FilterStackTrace matches.
+func filterFrames(pcs []uintptr) []frame {
+	filtered := make([]frame, 0, len(pcs))
+	for _, pc := range pcs {
+		f := frame(pc)
+		if !FilterStackTrace(f.Path()) {
+			filtered = append(filtered, f)
 		}
 	}
 	return filtered
 }
 
 func withStack(err error) error {
-	return withStackSkip(err, 2)
+	return withStackSkip(err, 2, 0)
+}
+
+// withStack captures the stack with err, a handler error logged at level,
+// as configured by StackDepth and StackCaptureLevel.
+func (svr *Server) withStack(err error, level Level) error {
+	if err == nil || levelRank(level) < levelRank(svr.StackCaptureLevel) {
+		return err
+	}
+	return withStackSkip(err, 2, svr.StackDepth)
 }
 
-func withStackSkip(err error, skip int) error {
+// withStackSkip captures up to depth frames of the stack with err, or all of
+// them if depth is 0, skipping skip frames above its caller. Only the program counters are captured; they're resolved and
+// filtered when the stack is first read, so errors which are never logged
+// don't pay for symbolization.
+func withStackSkip(err error, skip, depth int) error {
 	if err == nil {
 		return nil
 	}
+	pcs := capturePCs(skip, depth)
 	if e, ok := err.(*errorStack); ok {
-		stackTrace := stackTrace()[skip:]
-		first := stackTrace[0]
-		firstPath, firstLine := first.Path(), first.Line()
-		for _, st := range e.stackTrace {
-			if st.Path() == firstPath && st.Line() == firstLine {
-				return err
+		e.mtx.Lock()
+		defer e.mtx.Unlock()
+		if len(pcs) > 0 {
+			// keep the stack if it was captured lower in this one
+			firstFile, firstLine := frame(pcs[0]).file()
+			for _, pc := range e.pcs {
+				if file, line := frame(pc).file(); file == firstFile && line == firstLine {
+					return err
+				}
 			}
 		}
-		e.stackTrace = stackTrace
+		e.pcs = pcs
+		e.stackTrace = nil
 		return e
 	}
-	e := errorStack{}
-	e.message = err.Error()
-	e.orig = err
-	e.stackTrace = stackTrace()[skip:]
-	return &e
+	return &errorStack{
+		message: err.Error(),
+		orig:    err,
+		pcs:     pcs,
+	}
 }
 
 func wrap(err error, message string) error {
-	e := withStackSkip(err, 2)
+	e := withStackSkip(err, 2, 0)
 	if e == nil {
 		return nil
 	}
@@ -97,9 +138,13 @@ func wrap(err error, message string) error {
 }
 
 type errorStack struct {
-	message    string
+	message string
+	orig    error
+
+	mtx sync.Mutex
+	pcs []uintptr
+	// stackTrace holds pcs resolved and filtered by StackTrace.
 	stackTrace []frame
-	orig       error
 }
 
 func (e *errorStack) Error() string {
@@ -107,6 +152,11 @@ func (e *errorStack) Error() string {
 }
 
 func (e *errorStack) StackTrace() []frame {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.stackTrace == nil {
+		e.stackTrace = filterFrames(e.pcs)
+	}
 	return e.stackTrace
 }
 
@@ -114,6 +164,10 @@ func (e *errorStack) Orig() error {
 	return e.orig
 }
 
+func (e *errorStack) Unwrap() error {
+	return e.orig
+}
+
 // frame represents a program counter inside a stack frame.
 type frame uintptr
 
@@ -132,9 +186,24 @@ func (f frame) Path() string {
 	return trimGOPATH(fn.Name(), file)
 }
 
+// file returns the full path to the file that contains the function for this
+// frame's pc, and the line number.
+func (f frame) file() (string, int) {
+	fn := runtime.FuncForPC(f.pc())
+	if fn == nil {
+		return "", 0
+	}
+	return fn.FileLine(f.pc())
+}
+
 // Func returns the function name.
 func (f frame) Func() string {
-	name := runtime.FuncForPC(f.pc()).Name()
+	return funcName(runtime.FuncForPC(f.pc()).Name())
+}
+
+// funcName returns a function name as returned by runtime.Func.Name without
+// its package.
+func funcName(name string) string {
 	// remove the path prefix component of a function's name
 	i := strings.LastIndex(name, "/")
 	name = name[i+1:]
@@ -164,22 +233,48 @@ func (f frame) Line() int {
 	return line
 }
 
-type stack []uintptr
+// pcBufferSize is the size of the pooled buffers program counters are
+// captured into. Deeper stacks are captured into larger buffers.
+const pcBufferSize = 32
 
-func callers() *stack {
-	const depth = 32
-	var pcs [depth]uintptr
-	n := runtime.Callers(3, pcs[:])
-	var st stack = pcs[0:n]
-	return &st
+// pcPool holds the buffers program counters are captured into before being
+// copied to a slice of their actual length.
+var pcPool = sync.Pool{
+	New: func() interface{} { return new([pcBufferSize]uintptr) },
 }
 
-func (s *stack) StackTrace() []frame {
-	f := make([]frame, len(*s))
-	for i := 0; i < len(f); i++ {
-		f[i] = frame((*s)[i])
+// capturePCs returns the program counters of up to depth frames of the
+// current stack, or all of them if depth is 0, skipping skip frames above
+// the caller of capturePCs.
+func capturePCs(skip, depth int) []uintptr {
+	limit := pcBufferSize
+	if depth > 0 && depth < limit {
+		limit = depth
+	}
+	buf := pcPool.Get().(*[pcBufferSize]uintptr)
+	n := runtime.Callers(skip+2, buf[:limit])
+	if n == pcBufferSize && depth != pcBufferSize {
+		// the stack may be deeper than the buffer
+		pcPool.Put(buf)
+		return captureDeepPCs(skip+1, depth)
+	}
+	pcs := make([]uintptr, n)
+	copy(pcs, buf[:n])
+	pcPool.Put(buf)
+	return pcs
+}
+
+func captureDeepPCs(skip, depth int) []uintptr {
+	for size := pcBufferSize * 2; ; size *= 2 {
+		if depth > 0 && size >= depth {
+			size = depth
+		}
+		pcs := make([]uintptr, size)
+		n := runtime.Callers(skip+2, pcs)
+		if n < size || size == depth {
+			return pcs[:n]
+		}
 	}
-	return f
 }
 
 func trimGOPATH(name, file string) string {
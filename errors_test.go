@@ -0,0 +1,100 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithStackLazy(t *testing.T) {
+	// arrange
+	filter := FilterStackTrace
+	defer func() { FilterStackTrace = filter }()
+	var filtered int
+	FilterStackTrace = func(path string) bool {
+		filtered++
+		return filter(path)
+	}
+
+	// act
+	err := withStack(errors.New("test"))
+	captured := filtered
+	frames := ErrorStack(err)
+
+	// assert
+	if captured != 0 {
+		t.Errorf("frames resolved at capture want: 0 got: %d", captured)
+	}
+	if len(frames) == 0 || frames[0].Func != "TestWithStackLazy" {
+		t.Fatalf("top frame want: TestWithStackLazy got: %+v", frames)
+	}
+	if got := ErrorStack(withStack(err))[0].Line; got == frames[0].Line {
+		t.Errorf("want stack replaced when captured again elsewhere got line: %d", got)
+	}
+}
+
+func BenchmarkWithStack(b *testing.B) {
+	cause := errors.New("test")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = withStack(cause)
+	}
+}
+
+func TestStackCapture(t *testing.T) {
+	cases := []struct {
+		name       string
+		depth      int
+		level      Level
+		status     int
+		wantFrames func(int) bool
+	}{
+		{"unlimited", 0, "", http.StatusBadRequest, func(n int) bool { return n > 1 }},
+		{"depth", 1, "", http.StatusBadRequest, func(n int) bool { return n == 1 }},
+		{"below level", 0, LevelError, http.StatusBadRequest, func(n int) bool { return n == 0 }},
+		{"at level", 0, LevelError, http.StatusInternalServerError, func(n int) bool { return n > 1 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.StackDepth = c.depth
+			s.StackCaptureLevel = c.level
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{Status: c.status}, errors.New("test")
+			}}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			err := sink.records[0].Err
+			if err == nil || err.Error() != "test" {
+				t.Fatalf("logged error want: test got: %v", err)
+			}
+			if n := len(ErrorStack(err)); !c.wantFrames(n) {
+				t.Errorf("unexpected frame count %d", n)
+			}
+		})
+	}
+}
+
+func TestCaptureDeepPCs(t *testing.T) {
+	var recurse func(n int) []uintptr
+	recurse = func(n int) []uintptr {
+		if n == 0 {
+			return capturePCs(0, 0)
+		}
+		return recurse(n - 1)
+	}
+
+	if n := len(recurse(pcBufferSize * 3)); n <= pcBufferSize*3 {
+		t.Errorf("frames want > %d got: %d", pcBufferSize*3, n)
+	}
+}
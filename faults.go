@@ -0,0 +1,147 @@
+package httplog
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// FaultKind is the kind of fault a FaultRule injects.
+type FaultKind int
+
+const (
+	// FaultLatency delays the request by Latency before the handler runs.
+	FaultLatency FaultKind = iota
+	// FaultError responds with Status without calling the handler.
+	FaultError
+	// FaultAbort closes the connection without responding. Connections
+	// which can't be hijacked, such as HTTP/2, get StatusServiceUnavailable
+	// (503) instead.
+	FaultAbort
+)
+
+// FaultRule injects a fault into a percentage of matching requests.
+type FaultRule struct {
+	Name string
+	// Handlers limits the rule to handlers with these names. Optional; by
+	// default all handlers match.
+	Handlers []string
+	// Path limits the rule to matching URL paths. Optional.
+	Path *regexp.Regexp
+	// Percent is the percentage of matching requests, 0-100, to inject the
+	// fault into.
+	Percent float64
+	Kind    FaultKind
+	// Latency is the delay added by FaultLatency.
+	Latency time.Duration
+	// Status is the status returned by FaultError. The default is
+	// StatusServiceUnavailable (503).
+	Status int
+}
+
+// FaultInjector injects latency, errors, and dropped connections into
+// requests for resilience testing. It does nothing unless set as
+// Server.FaultInjector; don't enable it in production.
+//
+// Requests with an injected fault are logged with fault_injected naming the
+// rule and fault, for example "slow_db:latency=200ms". At most one rule is
+// applied to a request, the first which matches and is selected.
+type FaultInjector struct {
+	Rules []FaultRule
+
+	mtx sync.Mutex
+	rnd *rand.Rand
+}
+
+// pick returns the rule to apply to a request, or nil.
+func (f *FaultInjector) pick(handlerName string, r *http.Request) *FaultRule {
+	if f == nil {
+		return nil
+	}
+
+	for i := range f.Rules {
+		rule := &f.Rules[i]
+		if !rule.matches(handlerName, r) {
+			continue
+		}
+		if f.roll() < rule.Percent {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (f *FaultInjector) roll() float64 {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.rnd == nil {
+		f.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return f.rnd.Float64() * 100
+}
+
+func (rule *FaultRule) matches(handlerName string, r *http.Request) bool {
+	if rule.Path != nil && !rule.Path.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rule.Handlers) == 0 {
+		return true
+	}
+	for _, name := range rule.Handlers {
+		if name == handlerName {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *FaultRule) status() int {
+	if rule.Status == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return rule.Status
+}
+
+// String returns the value logged as fault_injected.
+func (rule *FaultRule) String() string {
+	switch rule.Kind {
+	case FaultLatency:
+		return fmt.Sprintf("%s:latency=%v", rule.Name, rule.Latency)
+	case FaultError:
+		return fmt.Sprintf("%s:error=%d", rule.Name, rule.status())
+	default:
+		return rule.Name + ":abort"
+	}
+}
+
+// injectFault applies rule to the request. handled is true if the handler
+// mustn't run; status is the status written, or 0 if the connection was
+// closed.
+func injectFault(rule *FaultRule, w http.ResponseWriter, r *http.Request, writeHeader func(int)) (status int, handled bool) {
+	switch rule.Kind {
+	case FaultLatency:
+		timer := time.NewTimer(rule.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		return 0, false
+	case FaultError:
+		writeHeader(rule.status())
+		return rule.status(), true
+	default:
+		if h, ok := w.(http.Hijacker); ok {
+			if conn, _, err := h.Hijack(); err == nil {
+				_ = conn.Close()
+				return 0, true
+			}
+		}
+		// HTTP/2 connections can't be hijacked
+		writeHeader(http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable, true
+	}
+}
@@ -0,0 +1,169 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFaultInjector(t *testing.T) {
+	cases := []struct {
+		name        string
+		rules       []FaultRule
+		path        string
+		wantStatus  int
+		wantCalled  bool
+		wantField   interface{}
+		wantLatency time.Duration
+	}{
+		{name: "no rules", path: "/", wantStatus: http.StatusOK, wantCalled: true},
+		{
+			name:       "error",
+			rules:      []FaultRule{{Name: "down", Percent: 100, Kind: FaultError}},
+			path:       "/",
+			wantStatus: http.StatusServiceUnavailable,
+			wantField:  "down:error=503",
+		},
+		{
+			name:       "error status",
+			rules:      []FaultRule{{Name: "teapot", Percent: 100, Kind: FaultError, Status: http.StatusTeapot}},
+			path:       "/",
+			wantStatus: http.StatusTeapot,
+			wantField:  "teapot:error=418",
+		},
+		{
+			name:        "latency",
+			rules:       []FaultRule{{Name: "slow", Percent: 100, Kind: FaultLatency, Latency: 20 * time.Millisecond}},
+			path:        "/",
+			wantStatus:  http.StatusOK,
+			wantCalled:  true,
+			wantField:   "slow:latency=20ms",
+			wantLatency: 20 * time.Millisecond,
+		},
+		{
+			name:       "abort without hijacker",
+			rules:      []FaultRule{{Name: "drop", Percent: 100, Kind: FaultAbort}},
+			path:       "/",
+			wantStatus: http.StatusServiceUnavailable,
+			wantField:  "drop:abort",
+		},
+		{
+			name:       "zero percent",
+			rules:      []FaultRule{{Name: "never", Percent: 0, Kind: FaultError}},
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantCalled: true,
+		},
+		{
+			name:       "other handler",
+			rules:      []FaultRule{{Name: "other", Handlers: []string{"other"}, Percent: 100, Kind: FaultError}},
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantCalled: true,
+		},
+		{
+			name:       "matching handler",
+			rules:      []FaultRule{{Name: "mine", Handlers: []string{"other", "test"}, Percent: 100, Kind: FaultError}},
+			path:       "/",
+			wantStatus: http.StatusServiceUnavailable,
+			wantField:  "mine:error=503",
+		},
+		{
+			name:       "path mismatch",
+			rules:      []FaultRule{{Name: "orders", Path: regexp.MustCompile(`^/orders`), Percent: 100, Kind: FaultError}},
+			path:       "/users",
+			wantStatus: http.StatusOK,
+			wantCalled: true,
+		},
+		{
+			name: "first matching rule wins",
+			rules: []FaultRule{
+				{Name: "orders", Path: regexp.MustCompile(`^/orders`), Percent: 100, Kind: FaultError, Status: http.StatusBadGateway},
+				{Name: "all", Percent: 100, Kind: FaultError},
+			},
+			path:       "/orders/1",
+			wantStatus: http.StatusBadGateway,
+			wantField:  "orders:error=502",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			if c.rules != nil {
+				s.FaultInjector = &FaultInjector{Rules: c.rules}
+			}
+			var called bool
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				called = true
+				return Response{}, nil
+			}}
+			w := httptest.NewRecorder()
+			start := time.Now()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", c.path, nil))
+			elapsed := time.Since(start)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.wantStatus {
+				t.Errorf("status want: %d got: %d", c.wantStatus, w.Code)
+			}
+			if called != c.wantCalled {
+				t.Errorf("handler called want: %v got: %v", c.wantCalled, called)
+			}
+			if got := sink.records[0].Fields["fault_injected"]; got != c.wantField {
+				t.Errorf("fault_injected want: %v got: %v", c.wantField, got)
+			}
+			if elapsed < c.wantLatency {
+				t.Errorf("latency want >= %v got: %v", c.wantLatency, elapsed)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorPercent(t *testing.T) {
+	// arrange
+	f := &FaultInjector{Rules: []FaultRule{{Name: "half", Percent: 50, Kind: FaultError}}}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	// act
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		if f.pick("test", r) != nil {
+			picked++
+		}
+	}
+
+	// assert
+	if picked < 400 || picked > 600 {
+		t.Errorf("want about 500 of 1000 picked got: %d", picked)
+	}
+}
+
+func TestFaultAbortHijacked(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.FaultInjector = &FaultInjector{Rules: []FaultRule{{Name: "drop", Percent: 100, Kind: FaultAbort}}}
+	ts := httptest.NewServer(http.HandlerFunc(s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, nil
+	}})))
+	defer ts.Close()
+
+	// act
+	resp, err := ts.Client().Get(ts.URL)
+
+	// assert
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("want connection closed got: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,106 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldTree is a set of JSON field paths, keyed by the first segment. A
+// leaf, with no children, selects the whole value.
+type fieldTree map[string]fieldTree
+
+// requestedFields parses the request's fields query parameter, a comma
+// separated list of dotted JSON field paths such as "id,address.city". It
+// returns the paths not permitted by allowed, where "address" permits
+// "address" and any path below it.
+func requestedFields(r *http.Request, allowed []string) (fields []string, invalid []string) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if fieldAllowed(field, allowed) {
+			fields = append(fields, field)
+		} else {
+			invalid = append(invalid, field)
+		}
+	}
+	return fields, invalid
+}
+
+func fieldAllowed(field string, allowed []string) bool {
+	for _, a := range allowed {
+		if field == a || strings.HasPrefix(field, a+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func newFieldTree(fields []string) fieldTree {
+	tree := make(fieldTree)
+	for _, field := range fields {
+		node := tree
+		segments := strings.Split(field, ".")
+		for i, segment := range segments {
+			child, ok := node[segment]
+			if ok && child == nil {
+				break // a shorter path already selects the whole value
+			}
+			if i == len(segments)-1 {
+				node[segment] = nil
+				break
+			}
+			if !ok {
+				child = make(fieldTree)
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// prune removes the fields of v not in t. Arrays are pruned element by
+// element.
+func (t fieldTree) prune(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			child, ok := t[name]
+			if !ok {
+				delete(v, name)
+			} else if child != nil {
+				v[name] = child.prune(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = t.prune(value)
+		}
+	}
+	return v
+}
+
+// pruneJSON returns body with only the given fields.
+func pruneJSON(body []byte, fields []string, indent bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	v = newFieldTree(fields).prune(v)
+	if indent {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
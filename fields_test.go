@@ -0,0 +1,116 @@
+package httplog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerFields(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+
+	type address struct {
+		City    string `json:"city"`
+		Country string `json:"country"`
+	}
+	type order struct {
+		ID      int     `json:"id"`
+		Name    string  `json:"name"`
+		Address address `json:"address"`
+		Secret  string  `json:"secret"`
+	}
+	handler := s.Handle(Handler{
+		Name:   "test",
+		Fields: []string{"id", "name", "address"},
+		Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{Body: []order{
+				{ID: 1, Name: "a", Address: address{City: "Austin", Country: "US"}, Secret: "x"},
+				{ID: 2, Name: "b", Address: address{City: "Lyon", Country: "FR"}, Secret: "y"},
+			}}, nil
+		},
+	})
+
+	cases := []struct {
+		Query          string
+		ExpectedStatus int
+		ExpectedBody   string
+	}{
+		{"", 200, `[{"id":1,"name":"a","address":{"city":"Austin","country":"US"},"secret":"x"},{"id":2,"name":"b","address":{"city":"Lyon","country":"FR"},"secret":"y"}]`},
+		{"?fields=id", 200, `[{"id":1},{"id":2}]`},
+		{"?fields=id,address.city", 200, `[{"address":{"city":"Austin"},"id":1},{"address":{"city":"Lyon"},"id":2}]`},
+		{"?fields=address.city,address", 200, `[{"address":{"city":"Austin","country":"US"}},{"address":{"city":"Lyon","country":"FR"}}]`},
+		{"?fields=id,secret", 400, ``},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+
+		// act
+		handler(w, httptest.NewRequest("GET", "/"+c.Query, nil))
+
+		// assert
+		if w.Code != c.ExpectedStatus {
+			t.Errorf("%q: status want: %d got: %d", c.Query, c.ExpectedStatus, w.Code)
+		}
+		if got := w.Body.String(); got != c.ExpectedBody {
+			t.Errorf("%q: body\nwant: %s\ngot:  %s", c.Query, c.ExpectedBody, got)
+		}
+	}
+	s.Shutdown()
+}
+
+func TestHandlerFieldsCompressionCache(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	items := make([]item, 500)
+	for i := range items {
+		items[i] = item{ID: i, Name: "widget"}
+	}
+	handler := s.Handle(Handler{
+		Name:            "test",
+		Fields:          []string{"id", "name"},
+		CacheCompressed: true,
+		Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{Body: items, Version: "v1"}, nil
+		},
+	})
+
+	get := func(query string) []map[string]interface{} {
+		r := httptest.NewRequest("GET", "/"+query, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body []map[string]interface{}
+		if err := json.NewDecoder(gz).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	// act
+	pruned := get("?fields=id")
+	full := get("")
+	s.Shutdown()
+
+	// assert
+	if _, ok := pruned[0]["name"]; ok {
+		t.Error("pruned body want name removed")
+	}
+	if _, ok := full[0]["name"]; !ok {
+		t.Error("full body was served the cached pruned body")
+	}
+}
@@ -0,0 +1,193 @@
+package httplog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// fingerprintFrames is the number of stack frames included in an error
+// fingerprint.
+const fingerprintFrames = 3
+
+// ErrorFingerprint returns a short hash identifying err by its underlying
+// type and the top frames of its captured stack. Errors raised from the same
+// place share a fingerprint regardless of their message. Server logs the
+// fingerprint as error_fingerprint.
+func ErrorFingerprint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%T", rootCause(err))
+
+	frames := ErrorStack(err)
+	if len(frames) > fingerprintFrames {
+		frames = frames[:fingerprintFrames]
+	}
+	for _, f := range frames {
+		fmt.Fprintf(h, "|%s:%s:%d", f.Path, f.Func, f.Line)
+	}
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// rootCause follows the Unwrap chain of err to the innermost error.
+func rootCause(err error) error {
+	for {
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return err
+		}
+		next := u.Unwrap()
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// errorDeduper tracks recently logged error fingerprints so duplicates can be
+// logged without their stack trace. See Server.SuppressDuplicateErrors.
+type errorDeduper struct {
+	window time.Duration
+
+	mtx  sync.Mutex
+	seen map[string]*dupState
+}
+
+type dupState struct {
+	first      time.Time
+	message    string
+	suppressed int
+}
+
+type dupSummary struct {
+	fingerprint string
+	message     string
+	suppressed  int
+}
+
+func newErrorDeduper(window time.Duration) *errorDeduper {
+	return &errorDeduper{
+		window: window,
+		seen:   make(map[string]*dupState),
+	}
+}
+
+// check records an occurrence of fingerprint and reports whether it should be
+// suppressed. It also returns summaries for fingerprints whose window has
+// ended with suppressed duplicates.
+func (d *errorDeduper) check(fingerprint, message string, now time.Time) (bool, []dupSummary) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	summaries := d.expire(now, false)
+
+	if state, ok := d.seen[fingerprint]; ok {
+		state.suppressed++
+		return true, summaries
+	}
+
+	d.seen[fingerprint] = &dupState{first: now, message: message}
+	return false, summaries
+}
+
+// expired returns summaries for fingerprints whose window ended before now
+// with suppressed duplicates.
+func (d *errorDeduper) expired(now time.Time) []dupSummary {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.expire(now, false)
+}
+
+// flush returns summaries for all fingerprints with suppressed duplicates.
+func (d *errorDeduper) flush() []dupSummary {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.expire(time.Now(), true)
+}
+
+func (d *errorDeduper) expire(now time.Time, all bool) []dupSummary {
+	var summaries []dupSummary
+	for fp, state := range d.seen {
+		if !all && now.Sub(state.first) < d.window {
+			continue
+		}
+		if state.suppressed > 0 {
+			summaries = append(summaries, dupSummary{
+				fingerprint: fp,
+				message:     state.message,
+				suppressed:  state.suppressed,
+			})
+		}
+		delete(d.seen, fp)
+	}
+	return summaries
+}
+
+// suppressDuplicate strips the error from rec if an error with the same
+// fingerprint was logged within Server.SuppressDuplicateErrors. The error
+// message is kept in the err field.
+func (svr *Server) suppressDuplicate(rec *AccessRecord) {
+	window := svr.SuppressDuplicateErrors
+	if window <= 0 || rec.Err == nil {
+		return
+	}
+
+	svr.dedupOnce.Do(func() {
+		svr.dedup = newErrorDeduper(window)
+		go svr.expireSuppressed(window)
+	})
+
+	fp, _ := rec.Fields["error_fingerprint"].(string)
+	suppress, summaries := svr.dedup.check(fp, rec.Err.Error(), time.Now())
+	svr.logSuppressed(summaries)
+
+	if suppress {
+		rec.Fields["err"] = rec.Err.Error()
+		rec.Fields["error_suppressed"] = true
+		rec.Err = nil
+	}
+}
+
+// expireSuppressed logs the summaries of windows which have ended, checking
+// four times per window, until Shutdown starts.
+func (svr *Server) expireSuppressed(window time.Duration) {
+	interval := window / 4
+	if interval <= 0 {
+		interval = window
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			svr.logSuppressed(svr.dedup.expired(now))
+		case <-svr.stopping():
+			return
+		}
+	}
+}
+
+func (svr *Server) flushSuppressed() {
+	if svr.dedup != nil {
+		svr.logSuppressed(svr.dedup.flush())
+	}
+}
+
+func (svr *Server) logSuppressed(summaries []dupSummary) {
+	for _, s := range summaries {
+		entry := svr.newEntry()
+		entry.AddFields(map[string]interface{}{
+			"error_fingerprint": s.fingerprint,
+			"err":               s.message,
+			"suppressed_count":  s.suppressed,
+		})
+		entry.Warnf("%d duplicate errors suppressed in the last %v", s.suppressed, svr.SuppressDuplicateErrors)
+	}
+}
@@ -0,0 +1,147 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fingerprintError struct{ msg string }
+
+func (e *fingerprintError) Error() string { return e.msg }
+
+func fingerprintErrorAt(msg string) error {
+	return withStack(&fingerprintError{msg: msg})
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	var sameLine []error
+	for _, msg := range []string{"user 1 not found", "user 2 not found"} {
+		sameLine = append(sameLine, fingerprintErrorAt(msg))
+	}
+	otherLine := fingerprintErrorAt("user 1 not found")
+	otherType := withStack(errors.New("user 1 not found"))
+
+	cases := []struct {
+		name  string
+		a, b  error
+		equal bool
+	}{
+		{"same place, different messages", sameLine[0], sameLine[1], true},
+		{"different place", sameLine[0], otherLine, false},
+		{"different type", otherLine, otherType, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			a, b := ErrorFingerprint(c.a), ErrorFingerprint(c.b)
+
+			// assert
+			if len(a) != 16 {
+				t.Errorf("fingerprint want 16 hex digits got: %q", a)
+			}
+			if (a == b) != c.equal {
+				t.Errorf("fingerprints %s %s equal want: %v", a, b, c.equal)
+			}
+		})
+	}
+
+	if got := ErrorFingerprint(nil); got != "" {
+		t.Errorf("nil want: empty got: %q", got)
+	}
+}
+
+func TestErrorDeduper(t *testing.T) {
+	// arrange
+	d := newErrorDeduper(time.Minute)
+	start := time.Now()
+
+	// act
+	first, _ := d.check("fp", "boom", start)
+	second, _ := d.check("fp", "boom", start.Add(time.Second))
+	third, _ := d.check("fp", "boom", start.Add(2*time.Second))
+	other, _ := d.check("other", "bang", start.Add(2*time.Second))
+	early := d.expired(start.Add(30 * time.Second))
+	ended := d.expired(start.Add(time.Minute))
+	afterWindow, _ := d.check("fp", "boom", start.Add(time.Minute+time.Second))
+
+	// assert
+	if first || !second || !third || other {
+		t.Errorf("suppressed want: false true true false got: %v %v %v %v", first, second, third, other)
+	}
+	if len(early) != 0 {
+		t.Errorf("want no summaries before the window ends got: %v", early)
+	}
+	if len(ended) != 1 || ended[0].fingerprint != "fp" || ended[0].suppressed != 2 {
+		t.Errorf("want one summary of 2 suppressed got: %v", ended)
+	}
+	if afterWindow {
+		t.Error("want the first error after the window logged")
+	}
+}
+
+func TestSuppressDuplicateErrors(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var mtx sync.Mutex
+	var entries []*fieldEntry
+	var s Server
+	s.NewLogEntry = func() Entry {
+		mtx.Lock()
+		defer mtx.Unlock()
+		entry := newFieldEntry(&nullLogger{})
+		entries = append(entries, entry)
+		return entry
+	}
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+	s.SuppressDuplicateErrors = 40 * time.Millisecond
+	h := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Status: http.StatusInternalServerError}, fingerprintErrorAt("boom")
+	}})
+
+	summaryLogged := func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, entry := range entries {
+			entry.mtx.Lock()
+			count := entry.fields["suppressed_count"]
+			entry.mtx.Unlock()
+			if count == 2 {
+				return true
+			}
+		}
+		return false
+	}
+
+	// act
+	for i := 0; i < 3; i++ {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !summaryLogged() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	logged := summaryLogged()
+	s.Shutdown()
+
+	// assert
+	if !logged {
+		t.Error("want summary logged by the timer before Shutdown")
+	}
+	if len(sink.records) != 3 {
+		t.Fatalf("records want: 3 got: %d", len(sink.records))
+	}
+	if sink.records[0].Err == nil || sink.records[0].Fields["error_suppressed"] != nil {
+		t.Error("want first error logged")
+	}
+	for _, rec := range sink.records[1:] {
+		if rec.Err != nil || rec.Fields["error_suppressed"] != true || rec.Fields["err"] != "boom" {
+			t.Errorf("want duplicate suppressed got: %v %v", rec.Err, rec.Fields)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package httplog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// entropySampleBytes is the number of body bytes read to compute entropy.
+const entropySampleBytes = 8 << 10
+
+// RuleAction is what a Firewall does with a request matching a Rule.
+type RuleAction int
+
+const (
+	// RuleTag logs the rule name in waf_rules and lets the request through.
+	RuleTag RuleAction = iota
+	// RuleRateLimit rejects requests from a client IP over the rule's
+	// RateLimit with StatusTooManyRequests (429) and a Retry-After header
+	// with the time until the client's limit resets. The client IP is the
+	// connection's address unless Server.TrustedProxies is set, so clients
+	// can't evade the limit by rotating forwarding headers.
+	RuleRateLimit
+	// RuleBlock rejects the request with StatusForbidden (403).
+	RuleBlock
+)
+
+func (a RuleAction) String() string {
+	switch a {
+	case RuleRateLimit:
+		return "rate_limit"
+	case RuleBlock:
+		return "block"
+	default:
+		return "tag"
+	}
+}
+
+// Rule matches suspicious requests. A request matches when it satisfies
+// every condition which is set; a Rule without conditions matches nothing.
+type Rule struct {
+	Name string
+	// Path is matched against the URL path.
+	Path *regexp.Regexp
+	// Headers maps header names to patterns their value must match.
+	Headers map[string]*regexp.Regexp
+	// MaxBodyBytes matches requests with a Content-Length over this.
+	MaxBodyBytes int64
+	// MinBodyEntropy matches bodies whose Shannon entropy, in bits per
+	// byte (0-8), is at least this, which can indicate encoded or encrypted
+	// payloads. The first 8 KiB of the body are sampled.
+	MinBodyEntropy float64
+	Action         RuleAction
+	// RateLimit is the number of matching requests per minute allowed from
+	// each client IP for RuleRateLimit.
+	RateLimit int
+}
+
+// Firewall evaluates Rules against requests before handlers run. Set it as
+// Server.Firewall. Matching rule names are logged as waf_rules and counted
+// in httplog_waf_rule_hits_total. Rules can be replaced at runtime with
+// SetRules.
+type Firewall struct {
+	mtx      sync.RWMutex
+	rules    []Rule
+	limiters map[string]*rateLimiter
+}
+
+// NewFirewall returns a Firewall evaluating rules.
+func NewFirewall(rules []Rule) *Firewall {
+	f := &Firewall{}
+	f.SetRules(rules)
+	return f
+}
+
+// SetRules replaces the Firewall's rules. Rate limit state is kept for rules
+// whose name and limit are unchanged.
+func (f *Firewall) SetRules(rules []Rule) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	limiters := make(map[string]*rateLimiter)
+	for _, rule := range rules {
+		if rule.Action != RuleRateLimit {
+			continue
+		}
+		if l, ok := f.limiters[rule.Name]; ok && l.limit == rule.RateLimit {
+			limiters[rule.Name] = l
+		} else {
+			limiters[rule.Name] = newRateLimiter(rule.RateLimit, time.Minute)
+		}
+	}
+
+	f.rules = append([]Rule(nil), rules...)
+	f.limiters = limiters
+}
+
+// firewallClientIP returns the client IP rate limits are keyed on.
+// Forwarding headers are trusted from any client while TrustedProxies is
+// empty, so the connection's address is used instead.
+func (svr *Server) firewallClientIP(r *http.Request, info *RequestInfo) string {
+	if len(svr.TrustedProxies) > 0 {
+		return info.ClientIP
+	}
+	return remoteIP(r)
+}
+
+// evaluate returns the names of the rules r matches and the status to reject
+// it with, or 0 to let it through. Requests over a rate limit are rejected
+// with the time until the limit resets.
+func (f *Firewall) evaluate(r *http.Request, clientIP string) (matched []string, status int, retryAfter time.Duration) {
+	if f == nil {
+		return nil, 0, 0
+	}
+
+	f.mtx.RLock()
+	rules, limiters := f.rules, f.limiters
+	f.mtx.RUnlock()
+
+	entropy := -1.0
+	for _, rule := range rules {
+		if rule.MinBodyEntropy > 0 && entropy < 0 {
+			entropy = sampleBodyEntropy(r)
+		}
+		if !rule.matches(r, entropy) {
+			continue
+		}
+
+		matched = append(matched, rule.Name)
+		wafRuleHitsTotal.WithLabelValues(rule.Name, rule.Action.String()).Inc()
+
+		switch rule.Action {
+		case RuleBlock:
+			status = http.StatusForbidden
+		case RuleRateLimit:
+			now := time.Now()
+			if !limiters[rule.Name].allow(clientIP, now) && status == 0 {
+				status = http.StatusTooManyRequests
+				retryAfter = limiters[rule.Name].retryAfter(clientIP, now)
+			}
+		}
+	}
+	return matched, status, retryAfter
+}
+
+func (rule *Rule) matches(r *http.Request, entropy float64) bool {
+	conditions := 0
+
+	if rule.Path != nil {
+		conditions++
+		if !rule.Path.MatchString(r.URL.Path) {
+			return false
+		}
+	}
+	for name, pattern := range rule.Headers {
+		conditions++
+		if !pattern.MatchString(r.Header.Get(name)) {
+			return false
+		}
+	}
+	if rule.MaxBodyBytes > 0 {
+		conditions++
+		if r.ContentLength <= rule.MaxBodyBytes {
+			return false
+		}
+	}
+	if rule.MinBodyEntropy > 0 {
+		conditions++
+		if entropy < rule.MinBodyEntropy {
+			return false
+		}
+	}
+
+	return conditions > 0
+}
+
+// sampleBodyEntropy reads the start of r's body, computes its Shannon
+// entropy, and restores the body for the handler.
+func sampleBodyEntropy(r *http.Request) float64 {
+	if r.Body == nil || r.Body == http.NoBody {
+		return 0
+	}
+
+	sample, err := ioutil.ReadAll(io.LimitReader(r.Body, entropySampleBytes))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(sample), r.Body), r.Body}
+	if err != nil || len(sample) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+
+	var entropy float64
+	n := float64(len(sample))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// readCloser combines a Reader with the Closer of the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
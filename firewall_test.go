@@ -0,0 +1,165 @@
+package httplog
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFirewallRules(t *testing.T) {
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+
+	rules := []Rule{
+		{Name: "admin", Path: regexp.MustCompile("^/admin"), Action: RuleBlock},
+		{Name: "scanner", Headers: map[string]*regexp.Regexp{"User-Agent": regexp.MustCompile("(?i)sqlmap")}, Action: RuleBlock},
+		{Name: "large", MaxBodyBytes: 100, Action: RuleTag},
+		{Name: "encrypted", Path: regexp.MustCompile("^/upload"), MinBodyEntropy: 7, Action: RuleTag},
+		{Name: "empty", Action: RuleBlock},
+	}
+
+	cases := []struct {
+		name           string
+		path           string
+		userAgent      string
+		body           []byte
+		expectedStatus int
+		expectedRules  []string
+	}{
+		{"no match", "/", "", nil, 200, nil},
+		{"path block", "/admin/users", "", nil, 403, []string{"admin"}},
+		{"header block", "/", "sqlmap/1.5", nil, 403, []string{"scanner"}},
+		{"body size tag", "/", "", bytes.Repeat([]byte("a"), 101), 200, []string{"large"}},
+		{"low entropy", "/upload", "", bytes.Repeat([]byte("a"), 50), 200, nil},
+		{"high entropy tag", "/upload", "", random, 200, []string{"large", "encrypted"}},
+		{"block and tag", "/admin", "", bytes.Repeat([]byte("a"), 101), 403, []string{"admin", "large"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.Firewall = NewFirewall(rules)
+			var received []byte
+			h := s.Handle(Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+				received, _ = ioutil.ReadAll(r.Body)
+				return Response{}, nil
+			}})
+			method := "GET"
+			if c.body != nil {
+				method = "POST"
+			}
+			r := httptest.NewRequest(method, c.path, bytes.NewReader(c.body))
+			if c.userAgent != "" {
+				r.Header.Set("User-Agent", c.userAgent)
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			h(w, r)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.expectedStatus {
+				t.Errorf("status want: %d got: %d", c.expectedStatus, w.Code)
+			}
+			if got, _ := sink.records[0].Fields["waf_rules"].([]string); !reflect.DeepEqual(got, c.expectedRules) {
+				t.Errorf("waf_rules want: %v got: %v", c.expectedRules, got)
+			}
+			if c.expectedStatus == 200 && !bytes.Equal(received, c.body) {
+				t.Errorf("body not restored after sampling, got %d bytes want %d", len(received), len(c.body))
+			}
+		})
+	}
+}
+
+func TestFirewallRateLimitClientIP(t *testing.T) {
+	cases := []struct {
+		name           string
+		trustedProxies string
+		remoteAddrs    []string
+		forwardedFor   []string
+		expectedCodes  []int
+	}{
+		{
+			"rotated X-Forwarded-For ignored",
+			"",
+			[]string{"203.0.113.1:1", "203.0.113.1:2"},
+			[]string{"198.51.100.1", "198.51.100.2"},
+			[]int{200, 429},
+		},
+		{
+			"separate connections",
+			"",
+			[]string{"203.0.113.1:1", "203.0.113.2:1"},
+			[]string{"", ""},
+			[]int{200, 200},
+		},
+		{
+			"X-Forwarded-For from trusted proxy",
+			"10.0.0.0/8",
+			[]string{"10.0.0.1:1", "10.0.0.1:2", "10.0.0.1:3"},
+			[]string{"198.51.100.1", "198.51.100.2", "198.51.100.1"},
+			[]int{200, 200, 429},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			if c.trustedProxies != "" {
+				s.TrustedProxies, _ = ParseNetworks(c.trustedProxies)
+			}
+			s.Firewall = NewFirewall([]Rule{{Name: "login", Path: regexp.MustCompile("^/login"), Action: RuleRateLimit, RateLimit: 1}})
+			h := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{}, nil
+			}})
+
+			// act
+			var codes []int
+			for i, addr := range c.remoteAddrs {
+				r := httptest.NewRequest("GET", "/login", nil)
+				r.RemoteAddr = addr
+				if c.forwardedFor[i] != "" {
+					r.Header.Set("X-Forwarded-For", c.forwardedFor[i])
+				}
+				w := httptest.NewRecorder()
+				h(w, r)
+				codes = append(codes, w.Code)
+			}
+			s.Shutdown()
+
+			// assert
+			if !reflect.DeepEqual(codes, c.expectedCodes) {
+				t.Errorf("status codes want: %v got: %v", c.expectedCodes, codes)
+			}
+		})
+	}
+}
+
+func TestFirewallSetRules(t *testing.T) {
+	// arrange
+	f := NewFirewall([]Rule{{Name: "admin", Path: regexp.MustCompile("^/admin"), Action: RuleBlock}})
+	r := httptest.NewRequest("GET", "/admin", strings.NewReader(""))
+
+	// act
+	_, before, _ := f.evaluate(r, "203.0.113.1")
+	f.SetRules(nil)
+	_, after, _ := f.evaluate(r, "203.0.113.1")
+
+	// assert
+	if before != http.StatusForbidden || after != 0 {
+		t.Errorf("status want: 403 then 0 got: %d then %d", before, after)
+	}
+}
@@ -0,0 +1,55 @@
+package httplog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// GzipBytes compresses b with gzip at the level Server uses for responses.
+// Handlers can store the result and return it as a []byte Body: Server sends
+// it as-is to clients accepting gzip and decompresses it for those that
+// don't.
+func GzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&buf, gzipCompLevel)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = gzipWriter.Write(b); err != nil {
+		return nil, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GunzipBytes decompresses gzipped b.
+func GunzipBytes(b []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return decompressed, reader.Close()
+}
+
+// IsGzip reports whether b starts with the gzip magic header. Server treats
+// such bodies as already compressed.
+func IsGzip(b []byte) bool {
+	return len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// gzipSize returns the uncompressed size recorded in the trailer of a single
+// member gzip body, or 0 if b isn't gzip. The size is modulo 2^32.
+func gzipSize(b []byte) int {
+	if !IsGzip(b) || len(b) < 18 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+}
@@ -0,0 +1,85 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// HandlerNamePolicy sets how Handle treats a Handler whose Name is empty or
+// registered for a different Func. Names label metrics and log lines, so
+// handlers sharing a name can't be told apart. See Server.HandlerNames.
+//
+// Funcs are compared by their code pointer, so a Handler registered again
+// on a second route isn't a duplicate. Closures created by the same
+// function share a code pointer too: two handlers returned by one factory
+// under the same Name aren't detected as duplicates.
+type HandlerNamePolicy int
+
+const (
+	// HandlerNamesReject panics, as http.ServeMux does when a pattern is
+	// registered twice.
+	HandlerNamesReject HandlerNamePolicy = iota
+	// HandlerNamesWarn logs a warning and registers the handler.
+	HandlerNamesWarn
+	// HandlerNamesAllow registers the handler.
+	HandlerNamesAllow
+)
+
+// checkHandlerName applies svr.HandlerNames to a handler being registered.
+// Names starting with "__" are used by handlers Handle registers itself,
+// such as "__method_not_allowed__", and aren't checked.
+func (svr *Server) checkHandlerName(handler Handler) {
+	name := handler.Name
+	if svr.HandlerNames == HandlerNamesAllow || strings.HasPrefix(name, "__") {
+		return
+	}
+
+	// registering the same handler again, for example on a second route,
+	// isn't a duplicate. Closures from the same function literal share a
+	// code pointer, so they can't be told apart here.
+	fn := reflect.ValueOf(handler.Func).Pointer()
+	svr.handlerNamesMtx.Lock()
+	registered, ok := svr.handlerNames[name]
+	if svr.handlerNames == nil {
+		svr.handlerNames = make(map[string]uintptr)
+	}
+	svr.handlerNames[name] = fn
+	svr.handlerNamesMtx.Unlock()
+	duplicate := ok && registered != fn
+
+	var problem string
+	switch {
+	case name == "":
+		problem = "handler registered without a Name"
+	case duplicate:
+		problem = fmt.Sprintf("handler %q registered more than once", name)
+	default:
+		return
+	}
+
+	if svr.HandlerNames == HandlerNamesReject {
+		panic("httplog: " + problem)
+	}
+	entry := svr.newEntry()
+	entry.AddField("handler", name)
+	entry.Warn(problem)
+}
+
+// connWriterKey holds the *responseWriter HTTPServer passes to its handler,
+// so Handle can detect writes which bypass its own.
+type connWriterKeyType struct{}
+
+var connWriterKey connWriterKeyType
+
+func withConnWriter(ctx context.Context, w *responseWriter) context.Context {
+	return context.WithValue(ctx, connWriterKey, w)
+}
+
+// connWriter returns the *responseWriter HTTPServer passed to its handler,
+// or nil if the request wasn't served by an HTTPServer.
+func connWriter(ctx context.Context) *responseWriter {
+	w, _ := ctx.Value(connWriterKey).(*responseWriter)
+	return w
+}
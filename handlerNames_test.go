@@ -0,0 +1,78 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckHandlerName(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	ok := func(_ *http.Request, _ Entry) (Response, error) { return Response{}, nil }
+	other := func(_ *http.Request, _ Entry) (Response, error) { return Response{Status: http.StatusTeapot}, nil }
+
+	register := func(handler Handler) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		s.Handle(handler)
+		return false
+	}
+
+	// act
+	first := register(Handler{Name: "orders", Func: ok})
+	again := register(Handler{Name: "orders", Func: ok})
+	duplicate := register(Handler{Name: "orders", Func: other})
+	unnamed := register(Handler{Func: ok})
+	s.HandlerNames = HandlerNamesWarn
+	warned := register(Handler{Name: "orders", Func: other})
+
+	// assert
+	if first || again {
+		t.Error("registering a handler again want no panic")
+	}
+	if !duplicate || !unnamed {
+		t.Errorf("duplicate and unnamed want panic got: %v %v", duplicate, unnamed)
+	}
+	if warned {
+		t.Error("HandlerNamesWarn want no panic")
+	}
+}
+
+func TestDirectWrite(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+			// bypasses the Response
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("streamed"))
+			return Response{}, nil
+		}})(w, r)
+	})
+	srv := s.NewHTTPServer("", mux, ServerLimits{})
+	rec := httptest.NewRecorder()
+
+	// act
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "streamed" {
+		t.Errorf("response want: 202 streamed got: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	fields := sink.records[0].Fields
+	if fields["direct_write"] != true || fields["bytes_sent"] != 8 || fields["http_status"] != http.StatusAccepted {
+		t.Errorf("want direct_write, 8 bytes, and 202 got: %v %v %v", fields["direct_write"], fields["bytes_sent"], fields["http_status"])
+	}
+	if sink.records[0].Level != LevelWarn {
+		t.Errorf("level want: %v got: %v", LevelWarn, sink.records[0].Level)
+	}
+}
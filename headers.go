@@ -0,0 +1,34 @@
+package httplog
+
+import "net/http"
+
+// HeaderPolicy describes response headers to set or remove. Policies are
+// applied after the handler's headers, immediately before the status is
+// written, so they take precedence. See Server.HeaderPolicy.
+type HeaderPolicy struct {
+	// Set replaces any existing values of each header.
+	Set []Header
+	// Remove deletes each named header, for example "X-Powered-By".
+	Remove []string
+}
+
+func (p HeaderPolicy) apply(h http.Header) {
+	for _, name := range p.Remove {
+		h.Del(name)
+	}
+	for _, hdr := range p.Set {
+		h.Set(hdr.Name, hdr.Value)
+	}
+}
+
+// applyHeaderPolicies applies the Server's HeaderPolicy followed by the
+// policy for group, if any.
+func (svr *Server) applyHeaderPolicies(group string, h http.Header) {
+	svr.HeaderPolicy.apply(h)
+	if group == "" {
+		return
+	}
+	if p, ok := svr.GroupHeaderPolicies[group]; ok {
+		p.apply(h)
+	}
+}
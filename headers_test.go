@@ -0,0 +1,86 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	cases := []struct {
+		name           string
+		policy         HeaderPolicy
+		groupPolicies  map[string]HeaderPolicy
+		group          string
+		handlerHeaders []Header
+		want           map[string][]string
+	}{
+		{
+			name:           "no policy",
+			handlerHeaders: []Header{{"X-Powered-By", "go"}},
+			want:           map[string][]string{"X-Powered-By": {"go"}},
+		},
+		{
+			name:           "remove",
+			policy:         HeaderPolicy{Remove: []string{"x-powered-by"}},
+			handlerHeaders: []Header{{"X-Powered-By", "go"}},
+			want:           map[string][]string{"X-Powered-By": nil},
+		},
+		{
+			name:           "set replaces handler values",
+			policy:         HeaderPolicy{Set: []Header{{"Cache-Control", "no-store"}}},
+			handlerHeaders: []Header{{"Cache-Control", "max-age=60"}, {"Cache-Control", "public"}},
+			want:           map[string][]string{"Cache-Control": {"no-store"}},
+		},
+		{
+			name:   "group applied after server",
+			policy: HeaderPolicy{Set: []Header{{"Cache-Control", "no-store"}, {"X-Frame-Options", "DENY"}}},
+			groupPolicies: map[string]HeaderPolicy{
+				"static": {Set: []Header{{"Cache-Control", "max-age=3600"}}, Remove: []string{"X-Frame-Options"}},
+			},
+			group: "static",
+			want:  map[string][]string{"Cache-Control": {"max-age=3600"}, "X-Frame-Options": nil},
+		},
+		{
+			name:   "other group ignored",
+			policy: HeaderPolicy{Set: []Header{{"Cache-Control", "no-store"}}},
+			groupPolicies: map[string]HeaderPolicy{
+				"static": {Set: []Header{{"Cache-Control", "max-age=3600"}}},
+			},
+			group: "api",
+			want:  map[string][]string{"Cache-Control": {"no-store"}},
+		},
+		{
+			name: "ungrouped handler ignores group policies",
+			groupPolicies: map[string]HeaderPolicy{
+				"": {Set: []Header{{"X-Test", "1"}}},
+			},
+			want: map[string][]string{"X-Test": nil},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.HeaderPolicy = c.policy
+			s.GroupHeaderPolicies = c.groupPolicies
+			handler := Handler{Name: "test", Group: c.group, Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{Headers: c.handlerHeaders}, nil
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+
+			// assert
+			for name, want := range c.want {
+				if got := w.Header().Values(name); !reflect.DeepEqual(want, got) {
+					t.Errorf("%s want: %v got: %v", name, want, got)
+				}
+			}
+		})
+	}
+}
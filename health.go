@@ -0,0 +1,234 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthCheck periodically checks a dependency. See Server.AddHealthCheck.
+type HealthCheck struct {
+	// Name identifies the dependency, as listed in Handler.Dependencies.
+	Name string
+	// Check returns an error when the dependency is down.
+	Check func(ctx context.Context) error
+	// Interval is how often Check is called. The default is 10s.
+	Interval time.Duration
+	// Timeout limits each call to Check. The default is 5s.
+	Timeout time.Duration
+	// Critical makes the server unavailable while the dependency is down.
+	// Other dependencies only degrade it.
+	Critical bool
+}
+
+type healthCheckState struct {
+	critical bool
+
+	mtx       sync.Mutex
+	checked   bool
+	err       error
+	checkedAt time.Time
+	latency   time.Duration
+}
+
+// HealthStatus is the state of a dependency served by ReadinessHandler.
+type HealthStatus struct {
+	// Status is "up" or "down", or "unknown" before the first check.
+	Status    string     `json:"status"`
+	Critical  bool       `json:"critical"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	LatencyMs float64    `json:"latency_ms"`
+}
+
+// Readiness is the body served by ReadinessHandler.
+type Readiness struct {
+	// Status is "ok", "degraded" when a non-critical dependency is down or
+	// a reported dependency is unhealthy, or "unavailable" when a critical
+	// dependency is down. Dependencies not yet checked count as down, so
+	// the server isn't ready until its critical checks pass.
+	Status string                  `json:"status"`
+	Checks map[string]HealthStatus `json:"checks,omitempty"`
+	// UnavailableHandlers lists the handlers currently failing fast
+	// because a dependency is down or unhealthy.
+	UnavailableHandlers []string `json:"unavailable_handlers,omitempty"`
+}
+
+// AddHealthCheck runs check in the background every Interval until
+// Shutdown. While the check fails, requests to handlers listing it in
+// Handler.Dependencies fail fast with StatusServiceUnavailable (503), as
+// with dependencies reported unhealthy by ReportDependency, and
+// ReadinessHandler reports the server degraded or unavailable. Changes in
+// the check's result are logged with dependency and exported in
+// httplog_dependency_healthy.
+func (svr *Server) AddHealthCheck(check HealthCheck) {
+	interval := check.Interval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	state := &healthCheckState{critical: check.Critical}
+	svr.dependenciesMtx.Lock()
+	if svr.healthChecks == nil {
+		svr.healthChecks = make(map[string]*healthCheckState)
+	}
+	svr.healthChecks[check.Name] = state
+	svr.dependenciesMtx.Unlock()
+
+	svr.goTask(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			svr.runHealthCheck(ctx, check, timeout, state)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (svr *Server) runHealthCheck(ctx context.Context, check HealthCheck, timeout time.Duration, state *healthCheckState) {
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	start := time.Now()
+	_, err := callRecover(func() error { return check.Check(checkCtx) })
+	latency := time.Since(start)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	state.mtx.Lock()
+	changed := !state.checked || (err == nil) != (state.err == nil)
+	state.checked = true
+	state.err = err
+	state.checkedAt = start
+	state.latency = latency
+	state.mtx.Unlock()
+
+	if !changed {
+		return
+	}
+
+	entry := svr.newEntry()
+	entry.AddFields(map[string]interface{}{
+		"dependency": check.Name,
+		"latency_ms": durationMs(latency),
+	})
+	if err != nil {
+		dependencyHealthy.WithLabelValues(check.Name).Set(0)
+		entry.AddError(err)
+		entry.Warn("dependency down")
+	} else {
+		dependencyHealthy.WithLabelValues(check.Name).Set(1)
+		entry.Info("dependency up")
+	}
+}
+
+func (state *healthCheckState) down() bool {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return state.err != nil
+}
+
+func (state *healthCheckState) status() HealthStatus {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	status := HealthStatus{Status: "unknown", Critical: state.critical}
+	if !state.checked {
+		return status
+	}
+	status.Status = "up"
+	if state.err != nil {
+		status.Status = "down"
+		status.Error = state.err.Error()
+	}
+	checkedAt := state.checkedAt
+	status.CheckedAt = &checkedAt
+	status.LatencyMs = durationMs(state.latency)
+	return status
+}
+
+// registerDependencies records the dependencies of a handler for
+// ReadinessHandler.
+func (svr *Server) registerDependencies(handler Handler) {
+	if len(handler.Dependencies) == 0 || handler.Priority == PriorityCritical {
+		return
+	}
+	svr.dependenciesMtx.Lock()
+	defer svr.dependenciesMtx.Unlock()
+	if svr.handlerDependencies == nil {
+		svr.handlerDependencies = make(map[string][]string)
+	}
+	svr.handlerDependencies[handler.Name] = handler.Dependencies
+}
+
+// Readiness returns the state of the Server's health checks and the
+// handlers failing fast because of them.
+func (svr *Server) Readiness() Readiness {
+	svr.dependenciesMtx.Lock()
+	checks := make(map[string]*healthCheckState, len(svr.healthChecks))
+	for name, state := range svr.healthChecks {
+		checks[name] = state
+	}
+	handlers := make(map[string][]string, len(svr.handlerDependencies))
+	for name, deps := range svr.handlerDependencies {
+		handlers[name] = deps
+	}
+	svr.dependenciesMtx.Unlock()
+
+	readiness := Readiness{Status: "ok", Checks: make(map[string]HealthStatus, len(checks))}
+	for name, state := range checks {
+		status := state.status()
+		readiness.Checks[name] = status
+		if status.Status != "up" {
+			if status.Critical {
+				readiness.Status = "unavailable"
+			} else if readiness.Status == "ok" {
+				readiness.Status = "degraded"
+			}
+		}
+	}
+
+	for name, deps := range handlers {
+		if _, unhealthy := svr.unhealthyDependency(deps); unhealthy {
+			readiness.UnavailableHandlers = append(readiness.UnavailableHandlers, name)
+		}
+	}
+	sort.Strings(readiness.UnavailableHandlers)
+	if len(readiness.UnavailableHandlers) > 0 && readiness.Status == "ok" {
+		readiness.Status = "degraded"
+	}
+	return readiness
+}
+
+// ReadinessHandler returns a Handler which serves Readiness as JSON, with
+// StatusServiceUnavailable (503) when a critical dependency is down and
+// StatusOK (200) otherwise, including when degraded.
+func (svr *Server) ReadinessHandler() Handler {
+	return Handler{
+		Name:     "httplog_readiness",
+		Priority: PriorityCritical,
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			readiness := svr.Readiness()
+			status := http.StatusOK
+			if readiness.Status == "unavailable" {
+				status = http.StatusServiceUnavailable
+			}
+			return Response{Status: status, Body: readiness}, nil
+		},
+	}
+}
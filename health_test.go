@@ -0,0 +1,83 @@
+package httplog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+
+	var cacheDown, dbDown int32
+	check := func(down *int32) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			if atomic.LoadInt32(down) == 1 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+	}
+	s.AddHealthCheck(HealthCheck{Name: "cache", Check: check(&cacheDown), Interval: 5 * time.Millisecond})
+	s.AddHealthCheck(HealthCheck{Name: "db", Check: check(&dbDown), Interval: 5 * time.Millisecond, Critical: true})
+
+	okFunc := func(_ *http.Request, _ Entry) (Response, error) { return Response{}, nil }
+	search := s.Handle(Handler{Name: "search", Dependencies: []string{"cache"}, Func: okFunc})
+	orders := s.Handle(Handler{Name: "orders", Dependencies: []string{"db"}, Func: okFunc})
+	readiness := s.Handle(s.ReadinessHandler())
+
+	serve := func(h func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+	waitFor := func(status string) Readiness {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			var body Readiness
+			_ = json.Unmarshal(serve(readiness).Body.Bytes(), &body)
+			if body.Status == status || time.Now().After(deadline) {
+				return body
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// act & assert
+	if got := waitFor("ok"); got.Checks["db"].Status != "up" || got.Checks["cache"].Status != "up" {
+		t.Fatalf("want all checks up got: %+v", got)
+	}
+	if code := serve(search).Code; code != 200 {
+		t.Errorf("search status want: 200 got: %d", code)
+	}
+
+	atomic.StoreInt32(&cacheDown, 1)
+	got := waitFor("degraded")
+	if got.Status != "degraded" || len(got.UnavailableHandlers) != 1 || got.UnavailableHandlers[0] != "search" {
+		t.Errorf("want degraded with search unavailable got: %+v", got)
+	}
+	if code := serve(search).Code; code != 503 {
+		t.Errorf("search status want: 503 got: %d", code)
+	}
+	if code := serve(orders).Code; code != 200 {
+		t.Errorf("orders status want: 200 got: %d", code)
+	}
+
+	atomic.StoreInt32(&dbDown, 1)
+	waitFor("unavailable")
+	if code := serve(readiness).Code; code != 503 {
+		t.Errorf("readiness status want: 503 got: %d", code)
+	}
+	if code := serve(orders).Code; code != 503 {
+		t.Errorf("orders status want: 503 got: %d", code)
+	}
+
+	s.Shutdown()
+}
@@ -0,0 +1,274 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// ServerLimits configures the limits net/http enforces before a request
+// reaches a handler.
+type ServerLimits struct {
+	// MaxHeaderBytes is the largest request line and headers accepted.
+	// Larger requests are rejected with StatusRequestHeaderFieldsTooLarge
+	// (431). The default is http.DefaultMaxHeaderBytes, 1 MiB.
+	MaxHeaderBytes int
+	// ReadHeaderTimeout limits the time to read request headers. The
+	// default is 10s.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits the time to read the whole request, including the
+	// body. The default, 0, is no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout limits the time to write the response. The default, 0,
+	// is no limit.
+	WriteTimeout time.Duration
+	// IdleTimeout limits how long a keep-alive connection waits for its
+	// next request. The default is 2m.
+	IdleTimeout time.Duration
+}
+
+// HTTPServer is an http.Server created by Server.NewHTTPServer. Requests
+// net/http rejects before calling the handler are logged with the Server's
+// Entry as "request rejected" and counted in
+// httplog_rejected_requests_total with a reason:
+//
+//	header_too_large      The request exceeded MaxHeaderBytes (431).
+//	expectation_failed    The Expect header wasn't 100-continue (417).
+//	bad_request           The request couldn't be parsed (400).
+//	status_<code>         Another status written by net/http.
+//	read_header_timeout   The headers weren't received within ReadHeaderTimeout.
+//	idle_timeout          A keep-alive connection was idle for IdleTimeout.
+//
+// Rejection statuses are only seen on plaintext connections; on TLS
+// connections only timeouts are detected. Failed TLS handshakes and other
+// net/http errors are logged through ErrorLog. Start the server with its
+// ListenAndServe, ListenAndServeTLS, Serve, or ServeTLS methods.
+type HTTPServer struct {
+	*http.Server
+	svr *Server
+}
+
+// NewHTTPServer returns an HTTPServer serving handler on addr with limits.
+func (svr *Server) NewHTTPServer(addr string, handler http.Handler, limits ServerLimits) *HTTPServer {
+	if limits.MaxHeaderBytes <= 0 {
+		limits.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+	if limits.ReadHeaderTimeout <= 0 {
+		limits.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if limits.IdleTimeout <= 0 {
+		limits.IdleTimeout = defaultIdleTimeout
+	}
+
+	s := &HTTPServer{svr: svr}
+	s.Server = &http.Server{
+		Addr:              addr,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		ErrorLog:          svr.ErrorLog(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, _ := r.Context().Value(trackedConnKey).(*trackedConn)
+			if c != nil {
+				atomic.AddInt32(&c.dispatched, 1)
+				atomic.AddInt32(&c.active, 1)
+				defer atomic.AddInt32(&c.active, -1)
+			}
+			cw := &responseWriter{ResponseWriter: w}
+			handler.ServeHTTP(cw, r.WithContext(withConnWriter(r.Context(), cw)))
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if tc, ok := c.(*tls.Conn); ok {
+				c = tc.NetConn()
+			}
+			if tracked, ok := c.(*trackedConn); ok {
+				return context.WithValue(ctx, trackedConnKey, tracked)
+			}
+			return ctx
+		},
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address Addr and calls Serve.
+func (s *HTTPServer) ListenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// ListenAndServeTLS listens on the TCP address Addr and calls ServeTLS.
+func (s *HTTPServer) ListenAndServeTLS(certFile, keyFile string) error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(ln, certFile, keyFile)
+}
+
+// Serve accepts connections on ln. See http.Server.Serve.
+func (s *HTTPServer) Serve(ln net.Listener) error {
+	return s.Server.Serve(&trackedListener{Listener: ln, svr: s.svr})
+}
+
+// ServeTLS accepts TLS connections on ln. See http.Server.ServeTLS.
+func (s *HTTPServer) ServeTLS(ln net.Listener, certFile, keyFile string) error {
+	return s.Server.ServeTLS(&trackedListener{Listener: ln, svr: s.svr}, certFile, keyFile)
+}
+
+func (s *HTTPServer) listen() (net.Listener, error) {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	return net.Listen("tcp", addr)
+}
+
+type trackedConnKeyType struct{}
+
+var trackedConnKey trackedConnKeyType
+
+type trackedListener struct {
+	net.Listener
+	svr *Server
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: c, svr: l.svr}, nil
+}
+
+// trackedConn watches a connection for responses net/http writes without
+// calling the handler, and for read timeouts.
+type trackedConn struct {
+	net.Conn
+	svr *Server
+
+	// dispatched counts requests passed to the handler, active those being
+	// served.
+	dispatched int32
+	active     int32
+
+	mtx       sync.Mutex
+	responses int32
+	// readSince is the number of bytes read since the last response.
+	readSince int
+	timedOut  bool
+	// aborted is set while net/http cancels its background read with a
+	// deadline in the past, which isn't a timeout.
+	aborted bool
+}
+
+func (c *trackedConn) SetReadDeadline(t time.Time) error {
+	c.setAborted(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *trackedConn) SetDeadline(t time.Time) error {
+	c.setAborted(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *trackedConn) setAborted(deadline time.Time) {
+	c.mtx.Lock()
+	c.aborted = !deadline.IsZero() && deadline.Before(time.Unix(1<<30, 0))
+	c.mtx.Unlock()
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.mtx.Lock()
+	c.readSince += n
+	var reason string
+	var netErr net.Error
+	if err != nil && !c.timedOut && !c.aborted && errors.As(err, &netErr) && netErr.Timeout() && atomic.LoadInt32(&c.active) == 0 {
+		// body read timeouts are reported to the handler
+		c.timedOut = true
+		if c.readSince == 0 && atomic.LoadInt32(&c.dispatched) > 0 {
+			reason = "idle_timeout"
+		} else {
+			reason = "read_header_timeout"
+		}
+	}
+	c.mtx.Unlock()
+
+	if reason != "" {
+		c.reject(reason, 0)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	// net/http writes each response's status line at the start of a write
+	if status, ok := responseStatus(p); ok && status >= 200 {
+		c.mtx.Lock()
+		c.responses++
+		rejected := c.responses > atomic.LoadInt32(&c.dispatched)
+		if rejected {
+			// don't count the rejected request against later ones
+			c.responses--
+		}
+		c.readSince = 0
+		c.mtx.Unlock()
+
+		if rejected {
+			c.reject(rejectReason(status), status)
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func responseStatus(p []byte) (int, bool) {
+	if len(p) < 12 || !bytes.HasPrefix(p, []byte("HTTP/1.")) || p[8] != ' ' {
+		return 0, false
+	}
+	status, err := strconv.Atoi(string(p[9:12]))
+	return status, err == nil
+}
+
+func rejectReason(status int) string {
+	switch status {
+	case http.StatusRequestHeaderFieldsTooLarge:
+		return "header_too_large"
+	case http.StatusExpectationFailed:
+		return "expectation_failed"
+	case http.StatusBadRequest:
+		return "bad_request"
+	}
+	return "status_" + strconv.Itoa(status)
+}
+
+func (c *trackedConn) reject(reason string, status int) {
+	rejectedRequestsTotal.WithLabelValues(reason).Inc()
+
+	entry := c.svr.newEntry()
+	fields := map[string]interface{}{
+		"reason":      reason,
+		"remote_addr": c.svr.logAddr(c.RemoteAddr().String()),
+	}
+	if status != 0 {
+		fields["http_status"] = status
+	}
+	entry.AddFields(fields)
+	entry.Warn("request rejected")
+}
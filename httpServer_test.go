@@ -0,0 +1,88 @@
+package httplog
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerRejected(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var entries []*fieldEntry
+	var s Server
+	s.NewLogEntry = func() Entry {
+		entry := newFieldEntry(&nullLogger{})
+		mtx.Lock()
+		entries = append(entries, entry)
+		mtx.Unlock()
+		return entry
+	}
+
+	srv := s.NewHTTPServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}), ServerLimits{MaxHeaderBytes: 1024, ReadHeaderTimeout: 100 * time.Millisecond})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	send := func(request string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	// act
+	statuses := []string{
+		send("GET / HTTP/1.1\r\nHost: a\r\n\r\n"),
+		send("GET / HTTP/1.1\r\nHost: a\r\nX-Big: " + strings.Repeat("a", 8<<10) + "\r\n\r\n"),
+		send("GET / HTTP/1.1\r\nHost: a\r\nExpect: teapot\r\n\r\n"),
+		send("GET / HTTP/1.1\r\nHost: a\r\n"),
+	}
+
+	// assert
+	expectedStatuses := []string{
+		"HTTP/1.1 200 OK",
+		"HTTP/1.1 431 Request Header Fields Too Large",
+		"HTTP/1.1 417 Expectation Failed",
+		"",
+	}
+	for i := range expectedStatuses {
+		if statuses[i] != expectedStatuses[i] {
+			t.Errorf("request %d want: %q got: %q", i, expectedStatuses[i], statuses[i])
+		}
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	var reasons []string
+	for _, entry := range entries {
+		entry.mtx.Lock()
+		if reason, ok := entry.fields["reason"].(string); ok {
+			reasons = append(reasons, reason)
+		}
+		entry.mtx.Unlock()
+	}
+	sort.Strings(reasons)
+	expected := []string{"expectation_failed", "header_too_large", "read_header_timeout"}
+	if strings.Join(reasons, ",") != strings.Join(expected, ",") {
+		t.Errorf("reasons want: %v got: %v", expected, reasons)
+	}
+}
@@ -0,0 +1,185 @@
+// Package httplogtest provides test doubles for handlers served by
+// httplog.Server: a recording log Entry and a scripted upstream Transport.
+//
+//	svr, logs := httplogtest.NewServer()
+//	upstream := &httplogtest.MockTransport{}
+//	upstream.Respond("GET", "https://api.example.com/users/1", 200, `{"id":1}`)
+//	client := upstream.Client()
+//
+//	// ... serve a handler using client with svr.Handle and httptest ...
+//
+//	svr.Shutdown() // flush access logs
+//	calls := logs.WithMessage("outbound request")
+package httplogtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/judwhite/httplog"
+)
+
+// Entry is an httplog.Entry which records what's written to it.
+type Entry struct {
+	mtx     sync.Mutex
+	fields  map[string]interface{}
+	errors  []error
+	level   string
+	message string
+}
+
+// AddField implements httplog.Entry.
+func (e *Entry) AddField(key string, value interface{}) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.fields == nil {
+		e.fields = make(map[string]interface{})
+	}
+	e.fields[key] = value
+}
+
+// AddFields implements httplog.Entry.
+func (e *Entry) AddFields(fields map[string]interface{}) {
+	for k, v := range fields {
+		e.AddField(k, v)
+	}
+}
+
+// AddError implements httplog.Entry.
+func (e *Entry) AddError(err error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.errors = append(e.errors, err)
+}
+
+// Info implements httplog.Entry.
+func (e *Entry) Info(args ...interface{}) { e.write("info", fmt.Sprint(args...)) }
+
+// Infof implements httplog.Entry.
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.write("info", fmt.Sprintf(format, args...))
+}
+
+// Warn implements httplog.Entry.
+func (e *Entry) Warn(args ...interface{}) { e.write("warn", fmt.Sprint(args...)) }
+
+// Warnf implements httplog.Entry.
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.write("warn", fmt.Sprintf(format, args...))
+}
+
+// Error implements httplog.Entry.
+func (e *Entry) Error(args ...interface{}) { e.write("error", fmt.Sprint(args...)) }
+
+// Errorf implements httplog.Entry.
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.write("error", fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) write(level, message string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.level = level
+	e.message = message
+}
+
+// Field returns the value of a field and whether it was set.
+func (e *Entry) Field(key string) (interface{}, bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	v, ok := e.fields[key]
+	return v, ok
+}
+
+// Fields returns a copy of the entry's fields.
+func (e *Entry) Fields() map[string]interface{} {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	fields := make(map[string]interface{}, len(e.fields))
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	return fields
+}
+
+// Errors returns the errors added to the entry.
+func (e *Entry) Errors() []error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]error(nil), e.errors...)
+}
+
+// Level returns "info", "warn", or "error", or "" if the entry hasn't been
+// written.
+func (e *Entry) Level() string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.level
+}
+
+// Message returns the message the entry was written with.
+func (e *Entry) Message() string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.message
+}
+
+// Logs records every Entry created by NewEntry.
+type Logs struct {
+	mtx     sync.Mutex
+	entries []*Entry
+}
+
+// NewEntry returns a new recorded Entry. Use it as Server.NewLogEntry or
+// Transport.NewLogEntry.
+func (l *Logs) NewEntry() httplog.Entry {
+	e := &Entry{}
+	l.mtx.Lock()
+	l.entries = append(l.entries, e)
+	l.mtx.Unlock()
+	return e
+}
+
+// Entries returns the entries which have been written, in the order they
+// were created.
+func (l *Logs) Entries() []*Entry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	var written []*Entry
+	for _, e := range l.entries {
+		if e.Level() != "" {
+			written = append(written, e)
+		}
+	}
+	return written
+}
+
+// WithMessage returns the written entries with message.
+func (l *Logs) WithMessage(message string) []*Entry {
+	var matched []*Entry
+	for _, e := range l.Entries() {
+		if e.Message() == message {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// WithField returns the written entries with a field equal to value.
+func (l *Logs) WithField(key string, value interface{}) []*Entry {
+	var matched []*Entry
+	for _, e := range l.Entries() {
+		if v, ok := e.Field(key); ok && v == value {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// NewServer returns a Server whose log entries are recorded in Logs. Access
+// logs are written in the background; call the Server's Shutdown method to
+// flush them before asserting on them.
+func NewServer() (*httplog.Server, *Logs) {
+	logs := &Logs{}
+	return &httplog.Server{NewLogEntry: logs.NewEntry}, logs
+}
@@ -0,0 +1,67 @@
+package httplogtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/judwhite/httplog"
+)
+
+func TestMockTransport(t *testing.T) {
+	// arrange
+	svr, logs := NewServer()
+
+	upstream := &MockTransport{}
+	upstream.Respond("GET", "https://api.example.com/users/1", 200, `{"id":1}`)
+	client := upstream.Client()
+
+	handler := httplog.Handler{Name: "get_user", Func: func(r *http.Request, _ httplog.Entry) (httplog.Response, error) {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", "https://api.example.com/users/1", nil)
+		if err != nil {
+			return httplog.Response{}, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return httplog.Response{}, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return httplog.Response{Body: body}, err
+	}}
+
+	ts := httptest.NewServer(http.HandlerFunc(svr.Handle(handler)))
+	defer ts.Close()
+
+	// act
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	svr.Shutdown()
+
+	// assert
+	requests := upstream.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("upstream requests want: 1 got: %d", len(requests))
+	}
+	requestID := requests[0].Header.Get("X-Parent-Request-ID")
+	if requestID == "" {
+		t.Error("want X-Parent-Request-ID sent upstream")
+	}
+
+	calls := logs.WithMessage("outbound request")
+	if len(calls) != 1 {
+		t.Fatalf("outbound logs want: 1 got: %d", len(calls))
+	}
+	if v, _ := calls[0].Field("parent_request_id"); v != requestID {
+		t.Errorf("parent_request_id want: %s got: %v", requestID, v)
+	}
+
+	access := logs.WithField("request_id", requestID)
+	if len(access) != 1 || access[0].Level() != "info" {
+		t.Errorf("want one info access log for request %s, got %d", requestID, len(access))
+	}
+}
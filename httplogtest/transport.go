@@ -0,0 +1,123 @@
+package httplogtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/judwhite/httplog"
+)
+
+// RecordedRequest is an outbound request received by a MockTransport.
+type RecordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+type mockRoute struct {
+	method string
+	url    string
+	fn     func(r *http.Request) (*http.Response, error)
+}
+
+// MockTransport is an http.RoundTripper which records requests and returns
+// scripted responses instead of making network calls. Use it as the Base of
+// an httplog.Transport, or call Client, so outbound calls are still logged
+// and linked to the request being served.
+//
+// Requests without a scripted response fail with an error.
+type MockTransport struct {
+	mtx      sync.Mutex
+	routes   []mockRoute
+	requests []*RecordedRequest
+}
+
+// Respond scripts a response to requests with method and url. url is the
+// full request URL, including the query string.
+func (m *MockTransport) Respond(method, url string, status int, body string, headers ...httplog.Header) {
+	m.RespondFunc(method, url, func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{
+			StatusCode:    status,
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       r,
+		}
+		for _, h := range headers {
+			resp.Header.Add(h.Name, h.Value)
+		}
+		return resp, nil
+	})
+}
+
+// RespondError scripts an error, such as a connection failure, for requests
+// with method and url.
+func (m *MockTransport) RespondError(method, url string, err error) {
+	m.RespondFunc(method, url, func(*http.Request) (*http.Response, error) {
+		return nil, err
+	})
+}
+
+// RespondFunc scripts fn to produce the response to requests with method
+// and url. Later scripts for the same request replace earlier ones.
+func (m *MockTransport) RespondFunc(method, url string, fn func(r *http.Request) (*http.Response, error)) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.routes = append(m.routes, mockRoute{method: method, url: url, fn: fn})
+}
+
+// RoundTrip implements http.RoundTripper.
+func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		rec.Body = body
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	m.mtx.Lock()
+	m.requests = append(m.requests, rec)
+	var fn func(r *http.Request) (*http.Response, error)
+	for i := len(m.routes) - 1; i >= 0; i-- {
+		if m.routes[i].method == req.Method && m.routes[i].url == rec.URL {
+			fn = m.routes[i].fn
+			break
+		}
+	}
+	m.mtx.Unlock()
+
+	if fn == nil {
+		return nil, fmt.Errorf("httplogtest: no response scripted for %s %s", req.Method, rec.URL)
+	}
+	return fn(req)
+}
+
+// Requests returns the requests received, in order.
+func (m *MockTransport) Requests() []*RecordedRequest {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]*RecordedRequest(nil), m.requests...)
+}
+
+// Client returns an http.Client sending requests through an httplog
+// Transport backed by m.
+func (m *MockTransport) Client() *http.Client {
+	return &http.Client{Transport: &httplog.Transport{Base: m}}
+}
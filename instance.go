@@ -0,0 +1,42 @@
+package httplog
+
+import "os"
+
+// Instance identifies the process serving requests in a multi-instance
+// deployment. See Server.Instance.
+type Instance struct {
+	Host   string
+	ID     string
+	Region string
+}
+
+// DetectInstance returns an Instance populated from the environment: Host
+// from os.Hostname, ID from INSTANCE_ID, and Region from REGION or
+// AWS_REGION. Values which can't be found are left empty.
+func DetectInstance() Instance {
+	var inst Instance
+	inst.Host, _ = os.Hostname()
+	inst.ID = os.Getenv("INSTANCE_ID")
+	inst.Region = os.Getenv("REGION")
+	if inst.Region == "" {
+		inst.Region = os.Getenv("AWS_REGION")
+	}
+	return inst
+}
+
+// addFields adds the non-empty server_host, instance_id, and region fields
+// to fields.
+func (inst *Instance) addFields(fields map[string]interface{}) {
+	if inst == nil {
+		return
+	}
+	if inst.Host != "" {
+		fields["server_host"] = inst.Host
+	}
+	if inst.ID != "" {
+		fields["instance_id"] = inst.ID
+	}
+	if inst.Region != "" {
+		fields["region"] = inst.Region
+	}
+}
@@ -0,0 +1,87 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDetectInstance(t *testing.T) {
+	host, _ := os.Hostname()
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		want Instance
+	}{
+		{name: "empty", want: Instance{Host: host}},
+		{name: "id and region", env: map[string]string{"INSTANCE_ID": "i-1", "REGION": "eu-west-1"}, want: Instance{Host: host, ID: "i-1", Region: "eu-west-1"}},
+		{name: "aws region", env: map[string]string{"AWS_REGION": "us-east-2"}, want: Instance{Host: host, Region: "us-east-2"}},
+		{name: "region wins over aws region", env: map[string]string{"REGION": "eu-west-1", "AWS_REGION": "us-east-2"}, want: Instance{Host: host, Region: "eu-west-1"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			for _, name := range []string{"INSTANCE_ID", "REGION", "AWS_REGION"} {
+				t.Setenv(name, c.env[name])
+			}
+
+			// act
+			got := DetectInstance()
+
+			// assert
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("want: %+v got: %+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestInstanceFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		instance *Instance
+		want     map[string]interface{}
+	}{
+		{name: "nil", want: map[string]interface{}{}},
+		{name: "empty", instance: &Instance{}, want: map[string]interface{}{}},
+		{
+			name:     "all",
+			instance: &Instance{Host: "web-1", ID: "i-1", Region: "eu-west-1"},
+			want:     map[string]interface{}{"server_host": "web-1", "instance_id": "i-1", "region": "eu-west-1"},
+		},
+		{name: "host only", instance: &Instance{Host: "web-1"}, want: map[string]interface{}{"server_host": "web-1"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.Instance = c.instance
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{}, nil
+			}}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			got := make(map[string]interface{})
+			for _, key := range []string{"server_host", "instance_id", "region"} {
+				if v, ok := sink.records[0].Fields[key]; ok {
+					got[key] = v
+				}
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Interceptor inspects or modifies a handler's Response before it's
+// written, for example to add headers, wrap the Body, or remove fields the
+// caller may not see. It receives the Response after errors have been
+// mapped by MapError; a Status of 0 means the handler's default. The
+// returned Response replaces it. A returned error is logged with the
+// handler's and doesn't change the Response. See Server.Intercept and
+// Handler.Interceptors.
+type Interceptor func(r *http.Request, entry Entry, resp Response) (Response, error)
+
+// Intercept registers fn to run on every handler's Response. It may be
+// called while the Server is running. A Handler's own Interceptors run
+// first, in order, followed by the Server's in registration order, so
+// server-wide policies see the final Response.
+func (svr *Server) Intercept(fn Interceptor) {
+	svr.interceptorsMtx.Lock()
+	svr.interceptors = append(svr.interceptors, fn)
+	svr.interceptorsMtx.Unlock()
+}
+
+// intercept runs handler's and the Server's interceptors on resp, joining
+// their errors with err.
+func (svr *Server) intercept(handler Handler, r *http.Request, entry Entry, resp Response, err error) (Response, error) {
+	svr.interceptorsMtx.RLock()
+	interceptors := svr.interceptors
+	svr.interceptorsMtx.RUnlock()
+	if len(handler.Interceptors) == 0 && len(interceptors) == 0 {
+		return resp, err
+	}
+
+	run := func(fn Interceptor) {
+		var interceptErr error
+		resp, interceptErr = fn(r, entry, resp)
+		if interceptErr == nil {
+			return
+		}
+		if err == nil {
+			err = interceptErr
+		} else {
+			err = errors.Join(err, interceptErr)
+		}
+	}
+	for _, fn := range handler.Interceptors {
+		run(fn)
+	}
+	for _, fn := range interceptors {
+		run(fn)
+	}
+	return resp, err
+}
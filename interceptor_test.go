@@ -0,0 +1,51 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIntercept(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	var order []string
+	s.Intercept(func(_ *http.Request, _ Entry, resp Response) (Response, error) {
+		order = append(order, "server")
+		resp.Headers = append(resp.Headers, Header{Name: "X-Policy", Value: "applied"})
+		return resp, errors.New("audit unavailable")
+	})
+	handler := Handler{
+		Name: "test",
+		Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{Body: "hello"}, nil
+		},
+		Interceptors: []Interceptor{func(_ *http.Request, _ Entry, resp Response) (Response, error) {
+			order = append(order, "handler")
+			resp.Body = strings.ToUpper(resp.Body.(string))
+			return resp, nil
+		}},
+	}
+	rec := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(rec, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if strings.Join(order, ",") != "handler,server" {
+		t.Errorf("order want: handler,server got: %v", order)
+	}
+	if rec.Body.String() != "HELLO" || rec.Header().Get("X-Policy") != "applied" {
+		t.Errorf("unexpected response %s %v", rec.Body.String(), rec.Header())
+	}
+	if len(sink.records) != 1 || sink.records[0].Err == nil {
+		t.Fatal("want interceptor error logged")
+	}
+}
@@ -0,0 +1,126 @@
+package httplog
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// IPAnonymization determines how client IP addresses are written to logs.
+// See Server.IPAnonymization.
+type IPAnonymization int
+
+const (
+	// IPAnonymizeNone logs addresses as received. This is the default.
+	IPAnonymizeNone IPAnonymization = iota
+	// IPAnonymizeTruncate zeroes the last octet of IPv4 addresses and the
+	// last 80 bits of IPv6 addresses, for example 192.0.2.0 and
+	// 2001:db8:cafe::.
+	IPAnonymizeTruncate
+	// IPAnonymizeHash replaces addresses with a keyed hash, salted with
+	// Server.IPHashSalt and rotated every IPHashRotation. The same address
+	// hashes to the same value within a rotation period, so requests can be
+	// correlated for abuse analysis without logging the address.
+	IPAnonymizeHash
+)
+
+// ipHashLength is the number of hex characters of a hashed address logged.
+const ipHashLength = 16
+
+// normalizeIP returns the canonical text form of an address, such as
+// "2001:db8::1" for "[2001:DB8:0::1]:443" or "192.0.2.1" for the
+// IPv4-mapped "::ffff:192.0.2.1". Brackets and a port are removed. It
+// returns "" if addr isn't an IP address.
+func normalizeIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	} else if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		addr = addr[1 : len(addr)-1]
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	return ip.Unmap().String()
+}
+
+// anonymizeIP returns ip with its host bits zeroed as described by
+// IPAnonymizeTruncate, or ip unchanged if it isn't an IP address.
+func anonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.Addr().String()
+}
+
+// logIP returns ip as written to logs, anonymized as set by
+// IPAnonymization.
+func (svr *Server) logIP(ip string) string {
+	switch svr.IPAnonymization {
+	case IPAnonymizeTruncate:
+		return anonymizeIP(ip)
+	case IPAnonymizeHash:
+		return svr.hashIP(ip, time.Now())
+	}
+	return ip
+}
+
+// hashIP returns the HMAC-SHA256 of ip keyed with IPHashSalt and the
+// rotation period containing now.
+func (svr *Server) hashIP(ip string, now time.Time) string {
+	var period int64
+	if svr.IPHashRotation > 0 {
+		period = now.UnixNano() / int64(svr.IPHashRotation)
+	}
+	var periodBytes [8]byte
+	binary.BigEndian.PutUint64(periodBytes[:], uint64(period))
+
+	mac := hmac.New(sha256.New, svr.hashSalt())
+	mac.Write(periodBytes[:])
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))[:ipHashLength]
+}
+
+// hashSalt returns IPHashSalt, or a random salt generated on first use if
+// it's empty.
+func (svr *Server) hashSalt() []byte {
+	svr.ipHashSaltOnce.Do(func() {
+		svr.ipHashSalt = svr.IPHashSalt
+		if len(svr.ipHashSalt) == 0 {
+			svr.ipHashSalt = make([]byte, 32)
+			if _, err := rand.Read(svr.ipHashSalt); err != nil {
+				panic(err)
+			}
+		}
+	})
+	return svr.ipHashSalt
+}
+
+// logAddr returns the host:port address addr as written to logs. The port
+// is dropped when the IP is anonymized or hashed.
+func (svr *Server) logAddr(addr string) string {
+	if svr.IPAnonymization == IPAnonymizeNone {
+		return addr
+	}
+	if ip := normalizeIP(addr); ip != "" {
+		return svr.logIP(ip)
+	}
+	return addr
+}
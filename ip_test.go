@@ -0,0 +1,91 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.123":             "192.0.2.0",
+		"::ffff:192.0.2.123":      "192.0.2.0",
+		"2001:db8:cafe:1:2:3:4:5": "2001:db8:cafe::",
+		"fe80::1:2:3:4%eth0":      "fe80::",
+		"not an ip":               "not an ip",
+	}
+	for ip, want := range cases {
+		if got := anonymizeIP(ip); got != want {
+			t.Errorf("%s: want: %s got: %s", ip, want, got)
+		}
+	}
+}
+
+func TestIPAnonymization(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.IPAnonymization = IPAnonymizeTruncate
+	var handlerIP string
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		handlerIP = RequestInfoFromContext(r.Context()).ClientIP
+		return Response{}, nil
+	}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[2001:db8:cafe:1:2:3:4:5]:443"
+	var lookups []string
+	defer func(lookup func(string) ([]string, error)) { lookupAddr = lookup }(lookupAddr)
+	lookupAddr = func(addr string) ([]string, error) {
+		lookups = append(lookups, addr)
+		return nil, nil
+	}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), r)
+	s.Shutdown()
+
+	// assert
+	if handlerIP != "2001:db8:cafe:1:2:3:4:5" {
+		t.Errorf("handler ip want: 2001:db8:cafe:1:2:3:4:5 got: %s", handlerIP)
+	}
+	fields := sink.records[0].Fields
+	if fields["ip"] != "2001:db8:cafe::" || fields["host"] != "2001:db8:cafe::" {
+		t.Errorf("ip and host want: 2001:db8:cafe:: got: %v %v", fields["ip"], fields["host"])
+	}
+	if len(lookups) != 0 {
+		t.Errorf("want no reverse DNS lookups got: %v", lookups)
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	// arrange
+	s := Server{
+		IPAnonymization: IPAnonymizeHash,
+		IPHashSalt:      []byte("test salt"),
+		IPHashRotation:  24 * time.Hour,
+	}
+	day := time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC)
+
+	// act
+	first := s.hashIP("192.0.2.1", day)
+	sameDay := s.hashIP("192.0.2.1", day.Add(12*time.Hour))
+	other := s.hashIP("192.0.2.2", day)
+	nextDay := s.hashIP("192.0.2.1", day.Add(24*time.Hour))
+
+	// assert
+	if len(first) != ipHashLength {
+		t.Errorf("length want: %d got: %d", ipHashLength, len(first))
+	}
+	if first != sameDay {
+		t.Errorf("want same hash within a period got: %s %s", first, sameDay)
+	}
+	if first == other || first == nextDay {
+		t.Errorf("want different hashes for other addresses and periods got: %s %s %s", first, other, nextDay)
+	}
+	if got := s.logAddr("[::ffff:192.0.2.1]:443"); got != s.hashIP("192.0.2.1", time.Now()) {
+		t.Errorf("remote_addr want hashed ip got: %s", got)
+	}
+}
@@ -0,0 +1,247 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobsPath     = "/jobs/"
+	defaultJobRetention = time.Hour
+)
+
+// JobState is the state of a Job.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobFunc does the work of a job started with Server.StartJob. The returned
+// value is served as the job's result.
+type JobFunc func(ctx context.Context, job *Job) (interface{}, error)
+
+// Job is a long-running task started by a handler. Its status is served by
+// Server.JobStatusHandler.
+type Job struct {
+	ID   string
+	Name string
+
+	entry     func() Entry
+	requestID string
+	started   time.Time
+
+	mtx      sync.Mutex
+	state    JobState
+	progress float64
+	message  string
+	result   interface{}
+	err      error
+	finished time.Time
+}
+
+// JobStatus is the JSON representation of a Job.
+type JobStatus struct {
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	State    JobState    `json:"state"`
+	Progress float64     `json:"progress"`
+	Message  string      `json:"message,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished,omitempty"`
+}
+
+// Progress records the job's progress, from 0 to 1, with an optional
+// message. Each call is logged.
+func (job *Job) Progress(progress float64, message string) {
+	job.mtx.Lock()
+	job.progress = progress
+	job.message = message
+	job.mtx.Unlock()
+
+	entry := job.entry()
+	entry.AddFields(job.fields())
+	entry.AddField("progress", progress)
+	entry.Info(message)
+}
+
+// Status returns a snapshot of the job.
+func (job *Job) Status() JobStatus {
+	job.mtx.Lock()
+	defer job.mtx.Unlock()
+
+	st := JobStatus{
+		ID:       job.ID,
+		Name:     job.Name,
+		State:    job.state,
+		Progress: job.progress,
+		Message:  job.message,
+		Result:   job.result,
+		Started:  job.started,
+	}
+	if job.err != nil {
+		st.Error = job.err.Error()
+	}
+	if !job.finished.IsZero() {
+		finished := job.finished
+		st.Finished = &finished
+	}
+	return st
+}
+
+func (job *Job) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"job_id":   job.ID,
+		"job_name": job.Name,
+	}
+	if job.requestID != "" {
+		fields["parent_request_id"] = job.requestID
+	}
+	return fields
+}
+
+// JobAccepted is the body of a response returned by Accepted.
+type JobAccepted struct {
+	JobID     string `json:"job_id"`
+	StatusURL string `json:"status_url"`
+}
+
+// Accepted returns a StatusAccepted (202) response for a job started with
+// Server.StartJob. The response's Location header and status_url point to
+// the job's status under Server.JobsPath, and the job ID is logged as
+// job_id.
+func Accepted(jobID string) Response {
+	return Response{Status: http.StatusAccepted, Body: &JobAccepted{JobID: jobID}}
+}
+
+// StartJob runs fn in the background and returns its Job. The job is linked
+// to the request in ctx, if any, by parent_request_id. Like tasks started
+// with Go, the job's context is cancelled on Shutdown. Finished jobs are
+// kept for Server.JobRetention.
+//
+//	job := svr.StartJob(r.Context(), "export", export)
+//	return httplog.Accepted(job.ID), nil
+func (svr *Server) StartJob(ctx context.Context, name string, fn JobFunc) *Job {
+	job := &Job{
+		ID:        newRequestID(),
+		Name:      name,
+		entry:     svr.newEntry,
+		requestID: RequestIDFromContext(ctx),
+		started:   time.Now(),
+		state:     JobRunning,
+	}
+
+	svr.jobsMtx.Lock()
+	if svr.jobs == nil {
+		svr.jobs = make(map[string]*Job)
+	}
+	svr.expireJobs(job.started)
+	svr.jobs[job.ID] = job
+	svr.jobsMtx.Unlock()
+
+	svr.goTask(func(ctx context.Context) { svr.runJob(ctx, job, fn) })
+	return job
+}
+
+func (svr *Server) runJob(ctx context.Context, job *Job, fn JobFunc) {
+	var result interface{}
+	_, err := callRecover(func() error {
+		var err error
+		result, err = fn(ctx, job)
+		return err
+	})
+
+	job.mtx.Lock()
+	job.finished = time.Now()
+	job.progress = 1
+	if err != nil {
+		job.state = JobFailed
+		job.err = err
+	} else {
+		job.state = JobSucceeded
+		job.result = result
+	}
+	duration := job.finished.Sub(job.started)
+	job.mtx.Unlock()
+
+	entry := job.entry()
+	entry.AddFields(job.fields())
+	entry.AddField("time_taken", int64(duration/time.Millisecond))
+	if err != nil {
+		entry.AddError(err)
+		entry.Error("job failed")
+	} else {
+		entry.Info("job succeeded")
+	}
+}
+
+// Job returns the job with id, or nil if it doesn't exist or has expired.
+func (svr *Server) Job(id string) *Job {
+	svr.jobsMtx.Lock()
+	defer svr.jobsMtx.Unlock()
+
+	job := svr.jobs[id]
+	if job != nil && job.expired(time.Now(), svr.jobRetention()) {
+		delete(svr.jobs, id)
+		return nil
+	}
+	return job
+}
+
+// expireJobs removes finished jobs older than JobRetention. The caller must
+// hold jobsMtx.
+func (svr *Server) expireJobs(now time.Time) {
+	retention := svr.jobRetention()
+	for id, job := range svr.jobs {
+		if job.expired(now, retention) {
+			delete(svr.jobs, id)
+		}
+	}
+}
+
+func (svr *Server) jobRetention() time.Duration {
+	if svr.JobRetention <= 0 {
+		return defaultJobRetention
+	}
+	return svr.JobRetention
+}
+
+// expired reports whether job finished more than retention before now.
+func (job *Job) expired(now time.Time, retention time.Duration) bool {
+	job.mtx.Lock()
+	defer job.mtx.Unlock()
+	return !job.finished.IsZero() && now.Sub(job.finished) > retention
+}
+
+func (svr *Server) jobsPath() string {
+	if svr.JobsPath == "" {
+		return defaultJobsPath
+	}
+	return svr.JobsPath
+}
+
+// JobStatusHandler returns a Handler which serves the JobStatus of the job
+// whose ID follows JobsPath in the request path, for example /jobs/{id}.
+// Unknown and expired jobs respond with StatusNotFound (404).
+func (svr *Server) JobStatusHandler() Handler {
+	return Handler{
+		Name: "httplog_job_status",
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			id := strings.TrimPrefix(r.URL.Path, svr.jobsPath())
+			entry.AddField("job_id", id)
+
+			job := svr.Job(id)
+			if job == nil {
+				return Response{Status: http.StatusNotFound}, nil
+			}
+			return Response{Body: job.Status()}, nil
+		},
+	}
+}
@@ -0,0 +1,244 @@
+package httplog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartJob(t *testing.T) {
+	jobErr := errors.New("export failed")
+
+	cases := []struct {
+		name       string
+		fn         JobFunc
+		wantState  JobState
+		wantResult interface{}
+		wantErr    string
+	}{
+		{
+			name:       "succeeded",
+			fn:         func(ctx context.Context, job *Job) (interface{}, error) { return "done", nil },
+			wantState:  JobSucceeded,
+			wantResult: "done",
+		},
+		{
+			name:      "failed",
+			fn:        func(ctx context.Context, job *Job) (interface{}, error) { return "partial", jobErr },
+			wantState: JobFailed,
+			wantErr:   "export failed",
+		},
+		{
+			name:      "panicked",
+			fn:        func(ctx context.Context, job *Job) (interface{}, error) { panic("boom") },
+			wantState: JobFailed,
+			wantErr:   "boom",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.ShutdownTimeout = 5 * time.Second
+			var job *Job
+			handler := Handler{Name: "start", Func: func(r *http.Request, _ Entry) (Response, error) {
+				job = s.StartJob(r.Context(), "export", c.fn)
+				return Accepted(job.ID), nil
+			}}
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("X-Request-ID", "parent-1")
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), r)
+			s.Shutdown()
+
+			// assert
+			st := job.Status()
+			if st.State != c.wantState || st.Progress != 1 || st.Finished == nil {
+				t.Errorf("want finished %s got: %+v", c.wantState, st)
+			}
+			if st.Result != c.wantResult {
+				t.Errorf("result want: %v got: %v", c.wantResult, st.Result)
+			}
+			if c.wantErr != "" && !strings.Contains(st.Error, c.wantErr) {
+				t.Errorf("error want: %q got: %q", c.wantErr, st.Error)
+			}
+			if st.Name != "export" || s.Job(job.ID) != job {
+				t.Errorf("want job export registered got: %+v", st)
+			}
+			if got := job.fields()["parent_request_id"]; got != "parent-1" {
+				t.Errorf("parent_request_id want: parent-1 got: %v", got)
+			}
+		})
+	}
+}
+
+func TestAcceptedLocation(t *testing.T) {
+	cases := []struct {
+		name         string
+		jobsPath     string
+		statusURL    string
+		wantLocation string
+	}{
+		{name: "default path", wantLocation: "/jobs/abc"},
+		{name: "custom path", jobsPath: "/api/jobs/", wantLocation: "/api/jobs/abc"},
+		{name: "status url kept", statusURL: "https://status.example.com/abc", wantLocation: "https://status.example.com/abc"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.JobsPath = c.jobsPath
+			handler := Handler{Name: "start", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				resp := Accepted("abc")
+				resp.Body.(*JobAccepted).StatusURL = c.statusURL
+				return resp, nil
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("POST", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if w.Code != http.StatusAccepted {
+				t.Errorf("status want: 202 got: %d", w.Code)
+			}
+			if got := w.Header().Get("Location"); got != c.wantLocation {
+				t.Errorf("Location want: %s got: %s", c.wantLocation, got)
+			}
+			var body JobAccepted
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.JobID != "abc" || body.StatusURL != c.wantLocation {
+				t.Errorf("body want: abc %s got: %+v", c.wantLocation, body)
+			}
+			if got := sink.records[0].Fields["job_id"]; got != "abc" {
+				t.Errorf("job_id want: abc got: %v", got)
+			}
+		})
+	}
+}
+
+func TestJobStatusHandler(t *testing.T) {
+	cases := []struct {
+		name       string
+		jobsPath   string
+		path       func(job *Job) string
+		wantStatus int
+	}{
+		{name: "running", path: func(job *Job) string { return "/jobs/" + job.ID }, wantStatus: http.StatusOK},
+		{name: "custom path", jobsPath: "/api/jobs/", path: func(job *Job) string { return "/api/jobs/" + job.ID }, wantStatus: http.StatusOK},
+		{name: "unknown", path: func(job *Job) string { return "/jobs/missing" }, wantStatus: http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.JobsPath = c.jobsPath
+			release := make(chan struct{})
+			progressed := make(chan struct{})
+			job := s.StartJob(context.Background(), "export", func(ctx context.Context, job *Job) (interface{}, error) {
+				job.Progress(0.5, "halfway")
+				close(progressed)
+				<-release
+				return nil, nil
+			})
+			<-progressed
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(s.JobStatusHandler())(w, httptest.NewRequest("GET", c.path(job), nil))
+			close(release)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.wantStatus {
+				t.Fatalf("status want: %d got: %d", c.wantStatus, w.Code)
+			}
+			if c.wantStatus != http.StatusOK {
+				return
+			}
+			var st JobStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+			if st.ID != job.ID || st.State != JobRunning || st.Progress != 0.5 || st.Message != "halfway" || st.Finished != nil {
+				t.Errorf("want running at 0.5 got: %+v", st)
+			}
+		})
+	}
+}
+
+func TestExpireJobs(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.JobRetention = time.Minute
+	now := time.Now()
+	s.jobs = map[string]*Job{
+		"old":     {ID: "old", finished: now.Add(-2 * time.Minute)},
+		"recent":  {ID: "recent", finished: now.Add(-30 * time.Second)},
+		"running": {ID: "running"},
+	}
+
+	// act
+	s.jobsMtx.Lock()
+	s.expireJobs(now)
+	s.jobsMtx.Unlock()
+
+	// assert
+	if s.Job("old") != nil {
+		t.Error("want old job expired")
+	}
+	if s.Job("recent") == nil || s.Job("running") == nil {
+		t.Error("want recent and running jobs kept")
+	}
+}
+
+func TestJobExpiresWithoutNewJobs(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.JobRetention = 50 * time.Millisecond
+	job := s.StartJob(context.Background(), "export", func(ctx context.Context, job *Job) (interface{}, error) {
+		return nil, nil
+	})
+	for job.Status().Finished == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if s.Job(job.ID) != job {
+		t.Fatal("want finished job kept within retention")
+	}
+	time.Sleep(100 * time.Millisecond)
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(s.JobStatusHandler())(w, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status want: %d got: %d", http.StatusNotFound, w.Code)
+	}
+	if s.Job(job.ID) != nil {
+		t.Error("want expired job removed")
+	}
+	if _, ok := s.jobs[job.ID]; ok {
+		t.Error("want expired job deleted")
+	}
+}
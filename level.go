@@ -0,0 +1,66 @@
+package httplog
+
+import "context"
+
+// Level is the level an access log record is written at.
+type Level string
+
+const (
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+// statusLevel returns the default Level for an HTTP status code.
+func statusLevel(status int) Level {
+	if status >= 500 {
+		return LevelError
+	}
+	if status >= 400 {
+		return LevelWarn
+	}
+	return LevelInfo
+}
+
+// statusLevel returns the Level the access log of a response with status is
+// written at, before escalation.
+func (handler Handler) statusLevel(status int) Level {
+	if level, ok := handler.StatusLevels[status]; ok {
+		return level
+	}
+	return statusLevel(status)
+}
+
+// levelRank orders levels from Info to Error. The empty Level ranks lowest.
+func levelRank(level Level) int {
+	switch level {
+	case LevelInfo:
+		return 1
+	case LevelWarn:
+		return 2
+	case LevelError:
+		return 3
+	}
+	return 0
+}
+
+// EscalateToError marks the request being served with ctx so its access log
+// is written at Error level regardless of the HTTP status, with reason logged
+// as escalation_reason. Code deep in a handler can use it to flag requests
+// which succeeded for the client but need attention. It does nothing if ctx
+// doesn't belong to a request served by Handle.
+func EscalateToError(ctx context.Context, reason string) {
+	state := getRequestState(ctx)
+	if state == nil {
+		return
+	}
+	state.mtx.Lock()
+	state.escalation = reason
+	state.mtx.Unlock()
+}
+
+func (state *requestState) escalationReason() string {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return state.escalation
+}
@@ -0,0 +1,113 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerStatusLevels(t *testing.T) {
+	cases := []struct {
+		name         string
+		statusLevels map[int]Level
+		status       int
+		escalate     bool
+		want         Level
+	}{
+		{name: "default 2xx", status: http.StatusOK, want: LevelInfo},
+		{name: "default 4xx", status: http.StatusUnauthorized, want: LevelWarn},
+		{name: "default 5xx", status: http.StatusBadGateway, want: LevelError},
+		{name: "demoted 4xx", statusLevels: map[int]Level{401: LevelInfo}, status: http.StatusUnauthorized, want: LevelInfo},
+		{name: "promoted 4xx", statusLevels: map[int]Level{409: LevelError}, status: http.StatusConflict, want: LevelError},
+		{name: "other status unaffected", statusLevels: map[int]Level{401: LevelInfo}, status: http.StatusForbidden, want: LevelWarn},
+		{name: "demoted 5xx", statusLevels: map[int]Level{503: LevelWarn}, status: http.StatusServiceUnavailable, want: LevelWarn},
+		{name: "escalation wins", statusLevels: map[int]Level{401: LevelInfo}, status: http.StatusUnauthorized, escalate: true, want: LevelError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", StatusLevels: c.statusLevels, Func: func(r *http.Request, _ Entry) (Response, error) {
+				if c.escalate {
+					EscalateToError(r.Context(), "test")
+				}
+				return Response{Status: c.status}, nil
+			}}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if got := handler.statusLevel(c.status); !c.escalate && got != c.want {
+				t.Errorf("statusLevel want: %s got: %s", c.want, got)
+			}
+			if len(sink.records) != 1 {
+				t.Fatalf("records want: 1 got: %d", len(sink.records))
+			}
+			if got := sink.records[0].Level; got != c.want {
+				t.Errorf("level want: %s got: %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestEscalateToError(t *testing.T) {
+	cases := []struct {
+		name       string
+		status     int
+		reasons    []string
+		wantLevel  Level
+		wantReason interface{}
+	}{
+		{name: "not escalated", status: http.StatusOK, wantLevel: LevelInfo},
+		{name: "2xx escalated", status: http.StatusOK, reasons: []string{"stale cache"}, wantLevel: LevelError, wantReason: "stale cache"},
+		{name: "4xx escalated", status: http.StatusNotFound, reasons: []string{"missing fixture"}, wantLevel: LevelError, wantReason: "missing fixture"},
+		{name: "already error", status: http.StatusBadGateway, reasons: []string{"upstream"}, wantLevel: LevelError, wantReason: "upstream"},
+		{name: "last reason wins", status: http.StatusOK, reasons: []string{"first", "second"}, wantLevel: LevelError, wantReason: "second"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+				for _, reason := range c.reasons {
+					EscalateToError(r.Context(), reason)
+				}
+				return Response{Status: c.status}, nil
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.status {
+				t.Errorf("status want: %d got: %d", c.status, w.Code)
+			}
+			rec := sink.records[0]
+			if rec.Level != c.wantLevel {
+				t.Errorf("level want: %s got: %s", c.wantLevel, rec.Level)
+			}
+			if got := rec.Fields["escalation_reason"]; got != c.wantReason {
+				t.Errorf("escalation_reason want: %v got: %v", c.wantReason, got)
+			}
+		})
+	}
+}
+
+func TestEscalateToErrorOutsideRequest(t *testing.T) {
+	// act
+	// ctx has no request state; EscalateToError must not panic
+	EscalateToError(context.Background(), "reason")
+}
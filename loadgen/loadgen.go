@@ -0,0 +1,396 @@
+// Package loadgen sends traffic to an httplog.Server for load and soak
+// tests. Requests are replayed from recorded access logs or generated from
+// route definitions:
+//
+//	f, err := os.Open("access.log")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	res, err := loadgen.Run(ctx, loadgen.Config{
+//		BaseURL:     "http://localhost:8080",
+//		Concurrency: 16,
+//		Rate:        200,
+//	}, loadgen.ReadAccessLog(f))
+//
+// Request latency is exported in httplog_loadgen_request_duration_seconds
+// alongside the Transport's outbound metrics, and summarized in the Result.
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/judwhite/httplog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "httplog_loadgen_request_duration_seconds",
+		Help: "The time taken by load generator requests in seconds.",
+	},
+	[]string{"method", "code"},
+)
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+}
+
+// Request is a request to send.
+type Request struct {
+	Method string
+	// URI is the path and query, relative to Config.BaseURL.
+	URI    string
+	Header http.Header
+	Body   []byte
+}
+
+// Source produces requests. Next returns io.EOF when there are no more.
+type Source interface {
+	Next() (*Request, error)
+}
+
+type accessLogSource struct {
+	mtx     sync.Mutex
+	scanner *bufio.Scanner
+}
+
+// ReadAccessLog returns a Source replaying access logs written as JSON
+// lines, such as by httplog.NewJSONLinesWriter. Each record's method and uri
+// fields are replayed; lines without them are skipped.
+func ReadAccessLog(r io.Reader) Source {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	return &accessLogSource{scanner: scanner}
+}
+
+func (s *accessLogSource) Next() (*Request, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for s.scanner.Scan() {
+		var rec struct {
+			Method string `json:"method"`
+			URI    string `json:"uri"`
+		}
+		if err := json.Unmarshal(s.scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Method == "" || rec.URI == "" {
+			continue
+		}
+		return &Request{Method: rec.Method, URI: rec.URI}, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Route describes synthetic requests for a route.
+type Route struct {
+	Method string
+	// Template is the route's path, for example "/orders/{id}". Each
+	// {param} is replaced by the value of Params[param], or a random
+	// integer if it's not in Params.
+	Template string
+	Params   map[string]func() string
+	Header   http.Header
+	Body     func() []byte
+	// Weight is the route's share of the traffic relative to other routes.
+	// The default is 1.
+	Weight int
+}
+
+type syntheticSource struct {
+	mtx    sync.Mutex
+	routes []Route
+	total  int
+	remain int
+	rnd    *rand.Rand
+}
+
+// Synthetic returns a Source generating n requests from routes, chosen at
+// random by weight. An n <= 0 generates requests indefinitely; bound the
+// run with Config.Duration or the context.
+func Synthetic(routes []Route, n int) Source {
+	s := &syntheticSource{
+		routes: routes,
+		remain: n,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if n <= 0 {
+		s.remain = -1
+	}
+	for _, route := range routes {
+		s.total += weight(route)
+	}
+	return s
+}
+
+func weight(route Route) int {
+	if route.Weight <= 0 {
+		return 1
+	}
+	return route.Weight
+}
+
+func (s *syntheticSource) Next() (*Request, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.remain == 0 || len(s.routes) == 0 {
+		return nil, io.EOF
+	}
+	if s.remain > 0 {
+		s.remain--
+	}
+
+	pick := s.rnd.Intn(s.total)
+	route := s.routes[0]
+	for _, r := range s.routes {
+		if pick < weight(r) {
+			route = r
+			break
+		}
+		pick -= weight(r)
+	}
+
+	req := &Request{
+		Method: route.Method,
+		URI:    s.expand(route),
+		Header: route.Header,
+	}
+	if route.Body != nil {
+		req.Body = route.Body()
+	}
+	return req, nil
+}
+
+func (s *syntheticSource) expand(route Route) string {
+	var b strings.Builder
+	tmpl := route.Template
+	for {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(tmpl[:open])
+		name := tmpl[open+1 : open+end]
+		if fn, ok := route.Params[name]; ok {
+			b.WriteString(fn())
+		} else {
+			b.WriteString(strconv.Itoa(s.rnd.Intn(1000000)))
+		}
+		tmpl = tmpl[open+end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// Config configures a run.
+type Config struct {
+	// BaseURL is the scheme and host requests are sent to.
+	BaseURL string
+	// Concurrency is the number of requests in flight. The default is 1.
+	Concurrency int
+	// Rate limits requests per second. Optional.
+	Rate float64
+	// Duration stops the run after this long. Optional.
+	Duration time.Duration
+	// Client sends requests. The default client uses httplog.Transport so
+	// the Transport's outbound metrics are recorded too.
+	Client *http.Client
+}
+
+// Result summarizes a run.
+type Result struct {
+	Requests int
+	// Errors counts requests which failed without a response.
+	Errors   int
+	Statuses map[int]int
+	Elapsed  time.Duration
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// String formats the result for display.
+func (res *Result) String() string {
+	codes := make([]int, 0, len(res.Statuses))
+	for code := range res.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "requests=%d errors=%d elapsed=%v p50=%v p90=%v p99=%v max=%v",
+		res.Requests, res.Errors, res.Elapsed, res.P50, res.P90, res.P99, res.Max)
+	for _, code := range codes {
+		fmt.Fprintf(&b, " %d=%d", code, res.Statuses[code])
+	}
+	return b.String()
+}
+
+// Run sends requests from src until it's exhausted, Duration elapses, or ctx
+// is done.
+func Run(ctx context.Context, cfg Config, src Source) (*Result, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("loadgen: BaseURL is required")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{
+			Transport: &httplog.Transport{NewLogEntry: func() httplog.Entry { return nopEntry{} }},
+			Timeout:   30 * time.Second,
+		}
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		mtx       sync.Mutex
+		res       = &Result{Statuses: make(map[int]int)}
+		latencies []time.Duration
+		srcErr    error
+		wg        sync.WaitGroup
+	)
+
+	start := time.Now()
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+
+	wg.Add(cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-ctx.Done():
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				req, err := src.Next()
+				if err != nil {
+					if err != io.EOF {
+						mtx.Lock()
+						srcErr = err
+						mtx.Unlock()
+					}
+					return
+				}
+
+				status, latency, err := send(ctx, cfg.Client, baseURL, req)
+				if err != nil && ctx.Err() != nil {
+					return
+				}
+
+				mtx.Lock()
+				res.Requests++
+				if err != nil {
+					res.Errors++
+				} else {
+					res.Statuses[status]++
+					latencies = append(latencies, latency)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	res.Elapsed = time.Since(start)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50 = percentile(latencies, 0.50)
+	res.P90 = percentile(latencies, 0.90)
+	res.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		res.Max = latencies[len(latencies)-1]
+	}
+	return res, srcErr
+}
+
+func send(ctx context.Context, client *http.Client, baseURL string, r *Request) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, baseURL+r.URI, bytes.NewReader(r.Body))
+	if err != nil {
+		return 0, 0, err
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	latency := time.Since(start)
+
+	requestDuration.WithLabelValues(r.Method, strconv.Itoa(resp.StatusCode)).Observe(latency.Seconds())
+	return resp.StatusCode, latency, nil
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// nopEntry discards the Transport's per-request logs, which would drown out
+// the run's results.
+type nopEntry struct{}
+
+func (nopEntry) AddField(key string, value interface{})    {}
+func (nopEntry) AddFields(fields map[string]interface{})   {}
+func (nopEntry) AddError(err error)                        {}
+func (nopEntry) Info(args ...interface{})                  {}
+func (nopEntry) Infof(format string, args ...interface{})  {}
+func (nopEntry) Warn(args ...interface{})                  {}
+func (nopEntry) Warnf(format string, args ...interface{})  {}
+func (nopEntry) Error(args ...interface{})                 {}
+func (nopEntry) Errorf(format string, args ...interface{}) {}
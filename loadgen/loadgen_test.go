@@ -0,0 +1,70 @@
+package loadgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunReplaysAccessLog(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var uris []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		uris = append(uris, r.Method+" "+r.RequestURI)
+		mtx.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	log := strings.Join([]string{
+		`{"method":"GET","uri":"/orders/1?expand=items","http_status":200}`,
+		`not json`,
+		`{"msg":"no request fields"}`,
+		`{"method":"DELETE","uri":"/missing","http_status":404}`,
+	}, "\n")
+
+	// act
+	res, err := Run(context.Background(), Config{BaseURL: ts.URL}, ReadAccessLog(strings.NewReader(log)))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests != 2 || res.Statuses[200] != 1 || res.Statuses[404] != 1 {
+		t.Errorf("unexpected result: %v", res)
+	}
+	expected := []string{"GET /orders/1?expand=items", "DELETE /missing"}
+	if strings.Join(uris, ",") != strings.Join(expected, ",") {
+		t.Errorf("requests want: %v got: %v", expected, uris)
+	}
+}
+
+func TestSyntheticExpandsTemplates(t *testing.T) {
+	// arrange
+	src := Synthetic([]Route{{
+		Method:   "GET",
+		Template: "/users/{user}/orders/{id}",
+		Params:   map[string]func() string{"user": func() string { return "u1" }},
+	}}, 1)
+
+	// act
+	req, err := src.Next()
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(req.URI, "/users/u1/orders/") || strings.Contains(req.URI, "{") {
+		t.Errorf("unexpected URI: %s", req.URI)
+	}
+	if _, err := src.Next(); err == nil {
+		t.Error("want io.EOF after n requests")
+	}
+}
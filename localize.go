@@ -0,0 +1,93 @@
+package httplog
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MessageCatalog translates the client-facing messages of error codes.
+type MessageCatalog interface {
+	// Message returns the message for code in lang, a lower case language
+	// tag such as "fr" or "pt-br".
+	Message(code, lang string) (string, bool)
+}
+
+// MessageMap is a MessageCatalog of messages by language tag, then code.
+type MessageMap map[string]map[string]string
+
+// Message implements MessageCatalog.
+func (m MessageMap) Message(code, lang string) (string, bool) {
+	msg, ok := m[lang][code]
+	return msg, ok
+}
+
+// localizedErrorBody returns the ErrorBody for code, its message translated
+// by the Server's Messages to the first language in the request's
+// Accept-Language header with a translation, and the language used. Region
+// subtags fall back to their base language: "fr-CA" tries "fr-ca", then
+// "fr". Without a translation the code's Message is used and lang is empty.
+func (svr *Server) localizedErrorBody(r *http.Request, code *ErrorCode, requestID string) (body ErrorBody, lang string) {
+	body = ErrorBody{ErrorCode: code.Code, Message: code.Message, RequestID: requestID}
+	if svr.Messages == nil {
+		return body, ""
+	}
+
+	for _, tag := range parseAcceptLanguage(r.Header) {
+		for {
+			if msg, ok := svr.Messages.Message(code.Code, tag); ok {
+				body.Message = msg
+				return body, tag
+			}
+			i := strings.LastIndex(tag, "-")
+			if i == -1 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return body, ""
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language
+// header in order of preference, lower cased, without the wildcard or tags
+// with a quality of 0.
+func parseAcceptLanguage(h http.Header) []string {
+	type language struct {
+		tag string
+		q   float64
+	}
+
+	var languages []language
+	for _, value := range h["Accept-Language"] {
+		for _, part := range strings.Split(value, ",") {
+			tag, params, _ := strings.Cut(part, ";")
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || tag == "*" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+			if q > 0 {
+				languages = append(languages, language{tag: tag, q: q})
+			}
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].q > languages[j].q })
+	tags := make([]string, len(languages))
+	for i, l := range languages {
+		tags[i] = l.tag
+	}
+	return tags
+}
@@ -0,0 +1,62 @@
+package httplog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errTestLocalized = NewErrorCode("TEST-LOC-404", http.StatusNotFound, "order not found")
+
+func TestLocalizedErrorBody(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Messages = MessageMap{
+		"fr": {"TEST-LOC-404": "commande introuvable"},
+		"de": {"TEST-LOC-404": "Bestellung nicht gefunden"},
+	}
+	handler := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, errTestLocalized
+	}})
+
+	cases := []struct {
+		AcceptLanguage  string
+		ExpectedMessage string
+		ExpectedLang    string
+	}{
+		{"", "order not found", ""},
+		{"fr-CA, en;q=0.8", "commande introuvable", "fr"},
+		{"es, de;q=0.5, fr;q=0.9", "commande introuvable", "fr"},
+		{"fr;q=0, de", "Bestellung nicht gefunden", "de"},
+		{"ja, *", "order not found", ""},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.AcceptLanguage != "" {
+			r.Header.Set("Accept-Language", c.AcceptLanguage)
+		}
+		w := httptest.NewRecorder()
+
+		// act
+		handler(w, r)
+
+		// assert
+		var body ErrorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Message != c.ExpectedMessage {
+			t.Errorf("Accept-Language %q: message want: %q got: %q", c.AcceptLanguage, c.ExpectedMessage, body.Message)
+		}
+		if got := w.Header().Get("Content-Language"); got != c.ExpectedLang {
+			t.Errorf("Accept-Language %q: Content-Language want: %q got: %q", c.AcceptLanguage, c.ExpectedLang, got)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Language" {
+			t.Errorf("Vary want: Accept-Language got: %q", got)
+		}
+	}
+	s.Shutdown()
+}
@@ -0,0 +1,152 @@
+package httplog
+
+import (
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority ranks handlers for load shedding. See Handler.Priority.
+type Priority int
+
+const (
+	// PriorityNormal handlers are shed only by explicit limits.
+	PriorityNormal Priority = iota
+	// PriorityLow handlers are shed first under pressure, for example
+	// reports and exports.
+	PriorityLow
+	// PriorityCritical handlers, such as health checks, are never shed.
+	PriorityCritical
+)
+
+const defaultMemoryGuardInterval = time.Second
+
+const (
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+	gcPausesMetric    = "/sched/pauses/total/gc:seconds"
+)
+
+// MemoryGuard sheds PriorityLow requests while heap usage or GC pause times
+// are past their thresholds, to avoid being OOM killed during traffic
+// spikes. See Server.MemoryGuard.
+//
+// Runtime metrics are sampled at most once per Interval as requests arrive.
+// The start and end of each pressure period are logged with pressure,
+// heap_bytes, and gc_pause_ms, and exported in httplog_memory_pressure. Shed
+// requests respond with StatusServiceUnavailable (503) and are logged with
+// shed_reason.
+type MemoryGuard struct {
+	// MaxHeapBytes is the heap size at which pressure starts. Zero disables
+	// the heap check.
+	MaxHeapBytes uint64
+	// MaxGCPause is the GC pause at which pressure starts; the longest
+	// pause since the previous sample is compared. Zero disables the pause
+	// check.
+	MaxGCPause time.Duration
+	// Interval is how often runtime metrics are sampled. The default is 1s.
+	Interval time.Duration
+
+	sampling   int32
+	lastSample int64 // unix nanos
+	pressure   int32
+
+	mtx        sync.Mutex
+	samples    []metrics.Sample
+	lastPauses []uint64
+}
+
+// underPressure reports whether the guard is past a threshold, sampling the
+// runtime metrics first if Interval has elapsed.
+func (g *MemoryGuard) underPressure(svr *Server) bool {
+	if g == nil {
+		return false
+	}
+
+	interval := g.Interval
+	if interval <= 0 {
+		interval = defaultMemoryGuardInterval
+	}
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&g.lastSample) >= int64(interval) && atomic.CompareAndSwapInt32(&g.sampling, 0, 1) {
+		atomic.StoreInt64(&g.lastSample, now)
+		g.sample(svr)
+		atomic.StoreInt32(&g.sampling, 0)
+	}
+	return atomic.LoadInt32(&g.pressure) == 1
+}
+
+func (g *MemoryGuard) sample(svr *Server) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.samples == nil {
+		g.samples = []metrics.Sample{{Name: heapObjectsMetric}, {Name: gcPausesMetric}}
+	}
+	metrics.Read(g.samples)
+
+	var heapBytes uint64
+	if g.samples[0].Value.Kind() == metrics.KindUint64 {
+		heapBytes = g.samples[0].Value.Uint64()
+	}
+	var maxPause time.Duration
+	if g.samples[1].Value.Kind() == metrics.KindFloat64Histogram {
+		maxPause = g.maxPauseSince(g.samples[1].Value.Float64Histogram())
+	}
+
+	pressure := (g.MaxHeapBytes > 0 && heapBytes >= g.MaxHeapBytes) ||
+		(g.MaxGCPause > 0 && maxPause >= g.MaxGCPause)
+
+	var value int32
+	if pressure {
+		value = 1
+	}
+	if atomic.SwapInt32(&g.pressure, value) == value {
+		return
+	}
+
+	memoryPressure.Set(float64(value))
+	entry := svr.newEntry()
+	entry.AddFields(map[string]interface{}{
+		"pressure":    pressure,
+		"heap_bytes":  heapBytes,
+		"gc_pause_ms": durationMs(maxPause),
+	})
+	if pressure {
+		entry.Warn("memory pressure started; shedding low priority requests")
+	} else {
+		entry.Info("memory pressure ended")
+	}
+}
+
+// maxPauseSince returns the lower bound of the highest histogram bucket
+// whose count increased since the previous sample.
+func (g *MemoryGuard) maxPauseSince(h *metrics.Float64Histogram) time.Duration {
+	var maxPause time.Duration
+	if len(g.lastPauses) == len(h.Counts) {
+		for i := len(h.Counts) - 1; i >= 0; i-- {
+			if h.Counts[i] > g.lastPauses[i] {
+				if lower := h.Buckets[i]; !math.IsInf(lower, -1) {
+					maxPause = time.Duration(lower * float64(time.Second))
+				}
+				break
+			}
+		}
+	}
+	g.lastPauses = append(g.lastPauses[:0], h.Counts...)
+	return maxPause
+}
+
+// shed reports whether a request to handler should be shed, logging why.
+func (svr *Server) shed(handler Handler, entry Entry, w http.ResponseWriter) bool {
+	if handler.Priority != PriorityLow || !svr.MemoryGuard.underPressure(svr) {
+		return false
+	}
+	entry.AddField("shed_reason", "memory_pressure")
+	shedRequestsTotal.WithLabelValues(handler.Name, "memory_pressure").Inc()
+	hdr := RetryAfter(time.Second)
+	w.Header().Set(hdr.Name, hdr.Value)
+	return true
+}
@@ -0,0 +1,34 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemoryGuard(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.MemoryGuard = &MemoryGuard{MaxHeapBytes: 1}
+
+	handle := func(priority Priority) int {
+		w := httptest.NewRecorder()
+		s.Handle(Handler{Name: "test", Priority: priority, Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{}, nil
+		}})(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	// act
+	low, normal, critical := handle(PriorityLow), handle(PriorityNormal), handle(PriorityCritical)
+	s.Shutdown()
+
+	// assert
+	if low != http.StatusServiceUnavailable {
+		t.Errorf("low priority status want: %d got: %d", http.StatusServiceUnavailable, low)
+	}
+	if normal != http.StatusOK || critical != http.StatusOK {
+		t.Errorf("normal and critical status want: 200 200 got: %d %d", normal, critical)
+	}
+}
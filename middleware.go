@@ -0,0 +1,49 @@
+package httplog
+
+import "net/http"
+
+// Middleware wraps a handler's Func with a cross-cutting concern such as
+// authentication or rate limiting. It receives the same request and Entry
+// as the handler, can return early without calling next, and its fields are
+// logged on the request's access log:
+//
+//	func requireToken(next func(*http.Request, httplog.Entry) (httplog.Response, error)) func(*http.Request, httplog.Entry) (httplog.Response, error) {
+//		return func(r *http.Request, entry httplog.Entry) (httplog.Response, error) {
+//			if r.Header.Get("Authorization") == "" {
+//				entry.AddField("auth_missing", true)
+//				return httplog.Response{Status: http.StatusUnauthorized}, nil
+//			}
+//			return next(r, entry)
+//		}
+//	}
+//
+// Time spent in middleware is included in handler_time.
+type Middleware func(next func(r *http.Request, entry Entry) (Response, error)) func(r *http.Request, entry Entry) (Response, error)
+
+// Use appends mw to the middleware wrapping every handler passed to Handle
+// afterwards. Server middleware runs before a Handler's own, and each list
+// runs in order, so the first registered sees the request first.
+func (svr *Server) Use(mw ...Middleware) {
+	svr.middlewareMtx.Lock()
+	svr.middleware = append(svr.middleware, mw...)
+	svr.middlewareMtx.Unlock()
+}
+
+// Use returns a copy of h with mw appended to its Middleware.
+func (h Handler) Use(mw ...Middleware) Handler {
+	h.Middleware = append(h.Middleware[:len(h.Middleware):len(h.Middleware)], mw...)
+	return h
+}
+
+// chain wraps handler's Func with the Server's and handler's middleware.
+func (svr *Server) chain(handler Handler) loggedHandler {
+	svr.middlewareMtx.Lock()
+	middleware := append(svr.middleware[:len(svr.middleware):len(svr.middleware)], handler.Middleware...)
+	svr.middlewareMtx.Unlock()
+
+	fn := handler.Func
+	for i := len(middleware) - 1; i >= 0; i-- {
+		fn = middleware[i](fn)
+	}
+	return fn
+}
@@ -0,0 +1,62 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next func(*http.Request, Entry) (Response, error)) func(*http.Request, Entry) (Response, error) {
+			return func(r *http.Request, entry Entry) (Response, error) {
+				order = append(order, name)
+				return next(r, entry)
+			}
+		}
+	}
+	requireToken := func(next func(*http.Request, Entry) (Response, error)) func(*http.Request, Entry) (Response, error) {
+		return func(r *http.Request, entry Entry) (Response, error) {
+			if r.Header.Get("Authorization") == "" {
+				entry.AddField("auth_missing", true)
+				return Response{Status: http.StatusUnauthorized}, nil
+			}
+			return next(r, entry)
+		}
+	}
+	s.Use(trace("server"))
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		order = append(order, "handler")
+		return Response{}, nil
+	}}.Use(requireToken, trace("handler middleware"))
+	h := s.Handle(handler)
+
+	authorized := httptest.NewRequest("GET", "/", nil)
+	authorized.Header.Set("Authorization", "Bearer token")
+
+	// act
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	h(httptest.NewRecorder(), authorized)
+	s.Shutdown()
+
+	// assert
+	if got := strings.Join(order, ","); got != "server,server,handler middleware,handler" {
+		t.Errorf("order want: server,server,handler middleware,handler got: %s", got)
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("records want: 2 got: %d", len(sink.records))
+	}
+	if sink.records[0].Fields["auth_missing"] != true || sink.records[0].Fields["http_status"] != http.StatusUnauthorized {
+		t.Errorf("unexpected fields %v", sink.records[0].Fields)
+	}
+}
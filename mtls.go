@@ -0,0 +1,61 @@
+package httplog
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+)
+
+// ClientCert is the identity from a verified TLS client certificate. See
+// RequestInfo.ClientCert.
+type ClientCert struct {
+	// Subject is the certificate's distinguished name.
+	Subject    string
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	Emails     []string
+	// Fingerprint is the hex encoded SHA-256 of the certificate.
+	Fingerprint string
+}
+
+// MutualTLSConfig returns a tls.Config which verifies client certificates
+// against clientCAs. When require is false connections without a client
+// certificate are accepted; use Handler.RequireClientCert to require one on
+// specific handlers. Set Certificates or GetCertificate on the result, for
+// example with CertReloader.GetCertificate.
+func MutualTLSConfig(clientCAs *x509.CertPool, require bool) *tls.Config {
+	clientAuth := tls.VerifyClientCertIfGiven
+	if require {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  clientCAs,
+		ClientAuth: clientAuth,
+	}
+}
+
+// clientCert returns the identity of r's verified client certificate, or
+// nil if it has none. Unverified peer certificates are ignored.
+func clientCert(r *http.Request) *ClientCert {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	sum := sha256.Sum256(cert.Raw)
+	cc := &ClientCert{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	for _, uri := range cert.URIs {
+		cc.URIs = append(cc.URIs, uri.String())
+	}
+	return cc
+}
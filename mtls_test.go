@@ -0,0 +1,60 @@
+package httplog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireClientCert(t *testing.T) {
+	der, _ := newTestCert(t, "orders-service")
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name     string
+		TLS      *tls.ConnectionState
+		Expected int
+		Subject  interface{}
+	}{
+		{"plaintext", nil, http.StatusForbidden, nil},
+		{"unverified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, http.StatusForbidden, nil},
+		{"verified", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}, http.StatusOK, "CN=orders-service"},
+	}
+
+	for _, c := range cases {
+		// arrange
+		sink := &recordingSink{}
+		var s Server
+		s.NewLogEntry = func() Entry { return &nullLogger{} }
+		s.Sinks = []Sink{sink}
+
+		var identity *ClientCert
+		handler := Handler{Name: "test", RequireClientCert: true, Func: func(r *http.Request, _ Entry) (Response, error) {
+			identity = RequestInfoFromContext(r.Context()).ClientCert
+			return Response{}, nil
+		}}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = c.TLS
+		w := httptest.NewRecorder()
+
+		// act
+		s.Handle(handler)(w, r)
+		s.Shutdown()
+
+		// assert
+		if w.Code != c.Expected {
+			t.Errorf("%s: status want: %d got: %d", c.Name, c.Expected, w.Code)
+		}
+		if got := sink.records[0].Fields["client_cert_subject"]; got != c.Subject {
+			t.Errorf("%s: client_cert_subject want: %v got: %v", c.Name, c.Subject, got)
+		}
+		if c.Subject != nil && (identity == nil || identity.CommonName != "orders-service") {
+			t.Errorf("%s: ClientCert.CommonName want: orders-service got: %+v", c.Name, identity)
+		}
+	}
+}
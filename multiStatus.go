@@ -0,0 +1,78 @@
+package httplog
+
+import "net/http"
+
+// ItemResult is the outcome of one item of a batch operation. See
+// MultiStatusResponse.
+type ItemResult struct {
+	// ID identifies the item so clients can match results. Optional.
+	ID     string      `json:"id,omitempty"`
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
+	Error  *ErrorBody  `json:"error,omitempty"`
+
+	// err is the error passed to ItemError, logged but not sent.
+	err error
+}
+
+// MultiStatus is the body of a MultiStatusResponse.
+type MultiStatus struct {
+	Results []ItemResult `json:"results"`
+}
+
+// ItemOK returns a successful ItemResult with status and body.
+func ItemOK(id string, status int, body interface{}) ItemResult {
+	return ItemResult{ID: id, Status: status, Body: body}
+}
+
+// ItemError returns a failed ItemResult for err. An err carrying an
+// ErrorCode responds with the code's status and message; other errors
+// respond with StatusInternalServerError (500) without revealing err. In
+// both cases err is logged under multi_status_errors.
+func ItemError(id string, err error) ItemResult {
+	if code, ok := errorCodeOf(err); ok {
+		return ItemResult{ID: id, Status: code.Status, Error: &ErrorBody{ErrorCode: code.Code, Message: code.Message}, err: err}
+	}
+	return ItemResult{
+		ID:     id,
+		Status: http.StatusInternalServerError,
+		Error:  &ErrorBody{Message: http.StatusText(http.StatusInternalServerError)},
+		err:    err,
+	}
+}
+
+// MultiStatusResponse returns a StatusMultiStatus (207) response for a batch
+// operation whose items may succeed or fail independently. Handle logs the
+// number of items as multi_status_items, and those with a status below 400
+// and those without as multi_status_succeeded and multi_status_failed. The
+// errors of items created with ItemError are logged as multi_status_errors,
+// a list of their IDs, statuses and error messages.
+func MultiStatusResponse(results []ItemResult) Response {
+	return Response{Status: http.StatusMultiStatus, Body: &MultiStatus{Results: results}}
+}
+
+func (ms *MultiStatus) logFields() map[string]interface{} {
+	failed := 0
+	var errs []map[string]interface{}
+	for _, result := range ms.Results {
+		if result.Status >= 400 {
+			failed++
+		}
+		if result.err != nil {
+			errs = append(errs, map[string]interface{}{
+				"id":     result.ID,
+				"status": result.Status,
+				"error":  result.err.Error(),
+			})
+		}
+	}
+	fields := map[string]interface{}{
+		"multi_status_items":     len(ms.Results),
+		"multi_status_succeeded": len(ms.Results) - failed,
+		"multi_status_failed":    failed,
+	}
+	if len(errs) > 0 {
+		fields["multi_status_errors"] = errs
+	}
+	return fields
+}
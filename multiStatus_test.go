@@ -0,0 +1,93 @@
+package httplog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var errTestItemNotFound = NewErrorCode("TEST-ITEM-404", http.StatusNotFound, "item not found")
+
+func TestMultiStatusResponse(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return MultiStatusResponse([]ItemResult{
+			ItemOK("1", http.StatusCreated, map[string]string{"id": "1"}),
+			ItemError("2", errTestItemNotFound.Wrap(errors.New("no rows"))),
+			ItemError("3", errors.New("connection reset")),
+		}), nil
+	}}
+	rec := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(rec, httptest.NewRequest("POST", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("status want: 207 got: %d", rec.Code)
+	}
+	var body MultiStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Results) != 3 || body.Results[1].Status != http.StatusNotFound || body.Results[1].Error.ErrorCode != "TEST-ITEM-404" {
+		t.Errorf("unexpected results %+v", body.Results)
+	}
+	if body.Results[2].Error.Message != "Internal Server Error" {
+		t.Errorf("want internal error hidden got: %+v", body.Results[2].Error)
+	}
+	fields := sink.records[0].Fields
+	if fields["multi_status_items"] != 3 || fields["multi_status_succeeded"] != 1 || fields["multi_status_failed"] != 2 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	wantErrors := []map[string]interface{}{
+		{"id": "2", "status": http.StatusNotFound, "error": errTestItemNotFound.Wrap(errors.New("no rows")).Error()},
+		{"id": "3", "status": http.StatusInternalServerError, "error": "connection reset"},
+	}
+	if !reflect.DeepEqual(wantErrors, fields["multi_status_errors"]) {
+		t.Errorf("multi_status_errors want: %v got: %v", wantErrors, fields["multi_status_errors"])
+	}
+}
+
+func TestMultiStatusLogFields(t *testing.T) {
+	cases := []struct {
+		name          string
+		results       []ItemResult
+		wantSucceeded int
+		wantFailed    int
+		wantErrors    bool
+	}{
+		{name: "empty", results: nil},
+		{name: "2xx and 3xx succeed", results: []ItemResult{ItemOK("1", 200, nil), ItemOK("2", 304, nil)}, wantSucceeded: 2},
+		{name: "1xx succeeds", results: []ItemResult{ItemOK("1", 102, nil)}, wantSucceeded: 1},
+		{name: "4xx and 5xx fail", results: []ItemResult{ItemOK("1", 409, nil), {ID: "2", Status: 503}}, wantFailed: 2},
+		{name: "item error logged", results: []ItemResult{ItemError("1", errors.New("boom"))}, wantFailed: 1, wantErrors: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			ms := &MultiStatus{Results: c.results}
+
+			// act
+			fields := ms.logFields()
+
+			// assert
+			if fields["multi_status_items"] != len(c.results) || fields["multi_status_succeeded"] != c.wantSucceeded || fields["multi_status_failed"] != c.wantFailed {
+				t.Errorf("want: %d/%d/%d got: %v", len(c.results), c.wantSucceeded, c.wantFailed, fields)
+			}
+			if _, ok := fields["multi_status_errors"]; ok != c.wantErrors {
+				t.Errorf("multi_status_errors present want: %v got: %v", c.wantErrors, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package httplog
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Handler names unmatched requests are logged under.
+const (
+	notFoundHandlerName         = "__not_found__"
+	methodNotAllowedHandlerName = "__method_not_allowed__"
+)
+
+// NotFoundHandler returns a Handler which responds with StatusNotFound
+// (404), for requests which match no route. Register it as the catch-all so
+// unmatched requests are logged and measured under the handler name
+// "__not_found__":
+//
+//	mux.HandleFunc("/", svr.Handle(httplog.NotFoundHandler()))
+//
+// See Server.NotFoundResponse to customize the response.
+func NotFoundHandler() Handler {
+	return Handler{
+		Name: notFoundHandlerName,
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			return unmatchedResponse(r, http.StatusNotFound), nil
+		},
+	}
+}
+
+// methodNotAllowedHandler returns the Handler serving requests whose method
+// isn't one of methods, logged under "__method_not_allowed__".
+func methodNotAllowedHandler(methods []string) Handler {
+	allow := strings.Join(allowedMethods(methods), ", ")
+	return Handler{
+		Name: methodNotAllowedHandlerName,
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			resp := unmatchedResponse(r, http.StatusMethodNotAllowed)
+			resp.Headers = append(resp.Headers, Header{Name: "Allow", Value: allow})
+			return resp, nil
+		},
+	}
+}
+
+// unmatchedResponse returns the response for a 404 or 405 from the Server's
+// NotFoundResponse or MethodNotAllowedResponse, with status unless the hook
+// sets another 4xx status.
+func unmatchedResponse(r *http.Request, status int) Response {
+	state := getRequestState(r.Context())
+	if state == nil {
+		return Response{Status: status}
+	}
+	fn := state.svr.NotFoundResponse
+	if status == http.StatusMethodNotAllowed {
+		fn = state.svr.MethodNotAllowedResponse
+	}
+	if fn == nil {
+		return Response{Status: status}
+	}
+	resp := fn(r, state.requestID)
+	if resp.Status < 400 || resp.Status > 499 {
+		resp.Status = status
+	}
+	return resp
+}
+
+// allowedMethods returns methods, upper cased, with HEAD when GET is
+// allowed.
+func allowedMethods(methods []string) []string {
+	allowed := make([]string, 0, len(methods)+1)
+	hasGet, hasHead := false, false
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		hasGet = hasGet || m == http.MethodGet
+		hasHead = hasHead || m == http.MethodHead
+		allowed = append(allowed, m)
+	}
+	if hasGet && !hasHead {
+		allowed = append(allowed, http.MethodHead)
+	}
+	return allowed
+}
+
+func methodAllowed(allowed []string, method string) bool {
+	for _, m := range allowed {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
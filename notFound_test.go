@@ -0,0 +1,86 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotFoundHandler(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.Handle(NotFoundHandler()))
+	w := httptest.NewRecorder()
+
+	// act
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status want: %d got: %d", http.StatusNotFound, w.Code)
+	}
+	if len(sink.records) != 1 || sink.records[0].Handler != "__not_found__" {
+		t.Errorf("want one record for __not_found__ got: %+v", sink.records)
+	}
+}
+
+func TestHandlerMethods(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1 // keep records in request order
+	s.MethodNotAllowedResponse = func(_ *http.Request, requestID string) Response {
+		return Response{Body: map[string]string{"error": "method not allowed", "request_id": requestID}}
+	}
+	handler := s.Handle(Handler{Name: "orders", Methods: []string{"get", "POST"}, Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{}, nil
+	}})
+
+	cases := []struct {
+		Method          string
+		ExpectedStatus  int
+		ExpectedHandler string
+	}{
+		{"GET", 200, "orders"},
+		{"HEAD", 200, "orders"},
+		{"POST", 200, "orders"},
+		{"DELETE", 405, "__method_not_allowed__"},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+
+		// act
+		handler(w, httptest.NewRequest(c.Method, "/", nil))
+
+		// assert
+		if w.Code != c.ExpectedStatus {
+			t.Errorf("%s: status want: %d got: %d", c.Method, c.ExpectedStatus, w.Code)
+		}
+		if c.ExpectedStatus == 405 {
+			if got := w.Header().Get("Allow"); got != "GET, POST, HEAD" {
+				t.Errorf("%s: Allow want: %q got: %q", c.Method, "GET, POST, HEAD", got)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("%s: Content-Type want: application/json got: %q", c.Method, got)
+			}
+		}
+	}
+	s.Shutdown()
+
+	if len(sink.records) != len(cases) {
+		t.Fatalf("records want: %d got: %d", len(cases), len(sink.records))
+	}
+	for i, c := range cases {
+		if got := sink.records[i].Handler; got != c.ExpectedHandler {
+			t.Errorf("%s: handler want: %s got: %s", c.Method, c.ExpectedHandler, got)
+		}
+	}
+}
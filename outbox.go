@@ -0,0 +1,351 @@
+package httplog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboxInterval  = 5 * time.Second
+	defaultOutboxBatchSize = 100
+)
+
+// ErrOutboxDisabled is returned by Server.Emit when Server.Outbox isn't set.
+var ErrOutboxDisabled = errors.New("httplog: Server.Outbox not set")
+
+// OutboxEvent is a domain event emitted with Server.Emit.
+type OutboxEvent struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// RequestID and Handler identify the request which emitted the event,
+	// if any.
+	RequestID string    `json:"request_id,omitempty"`
+	Handler   string    `json:"handler,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// OutboxStore persists events until they're published. Implementations
+// must be safe for concurrent use. See NewMemoryOutboxStore.
+type OutboxStore interface {
+	// Save persists ev.
+	Save(ctx context.Context, ev OutboxEvent) error
+	// Pending returns up to limit saved events, oldest first.
+	Pending(ctx context.Context, limit int) ([]OutboxEvent, error)
+	// Delete removes an event once it's published or discarded.
+	Delete(ctx context.Context, id string) error
+}
+
+// Outbox publishes events emitted with Server.Emit. Events are saved to
+// Store when emitted and published after the response to the request which
+// emitted them is written, so consumers never see an event for a change
+// the client wasn't told about. Events emitted by requests which panic or
+// fail with a 5xx status are discarded. Events which fail to publish, or
+// which were saved before a crash, are retried every Interval until they
+// succeed, so delivery is at least once. Shutdown publishes pending events
+// before returning.
+//
+// Each publish is logged with event_id, event_type, request_id, attempt,
+// and time_taken, and counted in httplog_outbox_events_total by type and
+// outcome (emitted, published, failed, or discarded).
+type Outbox struct {
+	// Store persists events. Required.
+	Store OutboxStore
+	// Publish delivers an event, for example to a message broker.
+	// Required.
+	Publish func(ctx context.Context, ev OutboxEvent) error
+	// Interval is how often pending events are retried. The default is 5s.
+	Interval time.Duration
+	// BatchSize is the number of pending events read from Store at once.
+	// The default is 100.
+	BatchSize int
+
+	startOnce sync.Once
+	notify    chan struct{}
+	// publishMtx serializes publishing so an event isn't published twice.
+	publishMtx sync.Mutex
+
+	mtx sync.Mutex
+	// held are events whose request hasn't finished.
+	held     map[string]bool
+	attempts map[string]int
+}
+
+// Emit saves a domain event with payload encoded as JSON to the Outbox. If
+// ctx belongs to a request served by Handle the event is held until the
+// response is written, then published; otherwise it's published right
+// away. It returns the event's ID, which consumers can use to discard
+// duplicates.
+func (svr *Server) Emit(ctx context.Context, eventType string, payload interface{}) (string, error) {
+	o := svr.Outbox
+	if o == nil {
+		return "", ErrOutboxDisabled
+	}
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return "", err
+		}
+		raw = b
+	}
+
+	ev := OutboxEvent{
+		ID:      newRequestID(),
+		Type:    eventType,
+		Payload: raw,
+		Time:    time.Now(),
+	}
+	state := getRequestState(ctx)
+	if state != nil && state.svr == svr {
+		ev.RequestID = state.requestID
+		ev.Handler = state.handlerName
+		o.hold(ev.ID)
+	}
+
+	svr.startOutbox()
+	if err := o.Store.Save(context.WithoutCancel(ctx), ev); err != nil {
+		o.release(ev.ID)
+		return "", err
+	}
+	outboxEventsTotal.WithLabelValues(eventType, "emitted").Inc()
+
+	if state != nil && state.svr == svr {
+		state.mtx.Lock()
+		state.outboxEvents = append(state.outboxEvents, ev)
+		state.mtx.Unlock()
+	} else {
+		o.wake()
+	}
+	return ev.ID, nil
+}
+
+// startOutbox starts publishing pending events in the background.
+func (svr *Server) startOutbox() {
+	o := svr.Outbox
+	if o == nil {
+		return
+	}
+	o.startOnce.Do(func() {
+		o.notify = make(chan struct{}, 1)
+		interval := o.Interval
+		if interval <= 0 {
+			interval = defaultOutboxInterval
+		}
+		svr.goTask(func(ctx context.Context) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				svr.publishOutbox(ctx)
+				select {
+				case <-ctx.Done():
+					return
+				case <-o.notify:
+				case <-ticker.C:
+				}
+			}
+		})
+	})
+}
+
+// finishOutbox releases the events emitted while serving a request, to be
+// published, or discards them if the request failed.
+func (svr *Server) finishOutbox(state *requestState, status int, panicked bool) {
+	state.mtx.Lock()
+	events := state.outboxEvents
+	state.mtx.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	o := svr.Outbox
+	if !panicked && status < 500 {
+		for _, ev := range events {
+			o.release(ev.ID)
+		}
+		o.wake()
+		return
+	}
+
+	for _, ev := range events {
+		err := o.Store.Delete(context.Background(), ev.ID)
+		o.release(ev.ID)
+		outboxEventsTotal.WithLabelValues(ev.Type, "discarded").Inc()
+
+		entry := svr.newEntry()
+		entry.AddFields(ev.logFields())
+		entry.AddField("http_status", status)
+		if err != nil {
+			entry.AddError(err)
+		}
+		entry.Warn("outbox event discarded")
+	}
+}
+
+// publishOutbox publishes the pending events which aren't held.
+func (svr *Server) publishOutbox(ctx context.Context) {
+	o := svr.Outbox
+	o.publishMtx.Lock()
+	defer o.publishMtx.Unlock()
+
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultOutboxBatchSize
+	}
+
+	for ctx.Err() == nil {
+		events, err := o.Store.Pending(ctx, batchSize)
+		if err != nil {
+			entry := svr.newEntry()
+			entry.AddError(err)
+			entry.Warn("outbox store failed")
+			return
+		}
+		published := 0
+		for _, ev := range events {
+			if o.isHeld(ev.ID) {
+				continue
+			}
+			if svr.publishEvent(ctx, ev) {
+				published++
+			}
+		}
+		// stop when the store is empty or events are failing
+		if len(events) < batchSize || published == 0 {
+			return
+		}
+	}
+}
+
+func (svr *Server) publishEvent(ctx context.Context, ev OutboxEvent) bool {
+	o := svr.Outbox
+	o.mtx.Lock()
+	if o.attempts == nil {
+		o.attempts = make(map[string]int)
+	}
+	o.attempts[ev.ID]++
+	attempt := o.attempts[ev.ID]
+	o.mtx.Unlock()
+
+	start := time.Now()
+	_, err := callRecover(func() error { return o.Publish(ctx, ev) })
+	if err == nil {
+		err = o.Store.Delete(ctx, ev.ID)
+	}
+	duration := time.Since(start)
+
+	entry := svr.newEntry()
+	entry.AddFields(ev.logFields())
+	entry.AddFields(map[string]interface{}{
+		"attempt":    attempt,
+		"time_taken": int64(duration / time.Millisecond),
+	})
+	if err != nil {
+		outboxEventsTotal.WithLabelValues(ev.Type, "failed").Inc()
+		entry.AddError(err)
+		entry.Warn("outbox event publish failed")
+		return false
+	}
+
+	o.mtx.Lock()
+	delete(o.attempts, ev.ID)
+	o.mtx.Unlock()
+	outboxEventsTotal.WithLabelValues(ev.Type, "published").Inc()
+	entry.Info("outbox event published")
+	return true
+}
+
+// drainOutbox publishes pending events during Shutdown, giving up after
+// timeout.
+func (svr *Server) drainOutbox(timeout time.Duration) {
+	if svr.Outbox == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	svr.publishOutbox(ctx)
+}
+
+func (ev OutboxEvent) logFields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"event_id":   ev.ID,
+		"event_type": ev.Type,
+	}
+	if ev.RequestID != "" {
+		fields["request_id"] = ev.RequestID
+	}
+	return fields
+}
+
+func (o *Outbox) hold(id string) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.held == nil {
+		o.held = make(map[string]bool)
+	}
+	o.held[id] = true
+}
+
+func (o *Outbox) release(id string) {
+	o.mtx.Lock()
+	delete(o.held, id)
+	o.mtx.Unlock()
+}
+
+func (o *Outbox) isHeld(id string) bool {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.held[id]
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+type memoryOutboxStore struct {
+	mtx    sync.Mutex
+	events map[string]OutboxEvent
+}
+
+// NewMemoryOutboxStore returns an OutboxStore which keeps events in memory.
+// Events are lost if the process exits, so it's meant for tests and
+// development.
+func NewMemoryOutboxStore() OutboxStore {
+	return &memoryOutboxStore{events: make(map[string]OutboxEvent)}
+}
+
+func (s *memoryOutboxStore) Save(_ context.Context, ev OutboxEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.events[ev.ID] = ev
+	return nil
+}
+
+func (s *memoryOutboxStore) Pending(_ context.Context, limit int) ([]OutboxEvent, error) {
+	s.mtx.Lock()
+	events := make([]OutboxEvent, 0, len(s.events))
+	for _, ev := range s.events {
+		events = append(events, ev)
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *memoryOutboxStore) Delete(_ context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.events, id)
+	return nil
+}
@@ -0,0 +1,88 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var published []OutboxEvent
+	var inHandler bool
+	fail := true
+	store := NewMemoryOutboxStore()
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Outbox = &Outbox{
+		Store:    store,
+		Interval: 10 * time.Millisecond,
+		Publish: func(_ context.Context, ev OutboxEvent) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if inHandler {
+				t.Error("event published before the response was written")
+			}
+			if ev.Type == "order.retried" && fail {
+				fail = false
+				return errors.New("broker unavailable")
+			}
+			published = append(published, ev)
+			return nil
+		},
+	}
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		mtx.Lock()
+		inHandler = true
+		mtx.Unlock()
+		defer func() {
+			mtx.Lock()
+			inHandler = false
+			mtx.Unlock()
+		}()
+
+		if _, err := s.Emit(r.Context(), "order.created", map[string]int{"id": 1}); err != nil {
+			return Response{}, err
+		}
+		if _, err := s.Emit(r.Context(), "order.retried", map[string]int{"id": 1}); err != nil {
+			return Response{}, err
+		}
+		// give the relay a chance to publish held events
+		time.Sleep(30 * time.Millisecond)
+		if r.URL.Query().Get("fail") != "" {
+			return Response{Status: http.StatusInternalServerError}, nil
+		}
+		return Response{}, nil
+	}}
+	h := s.Handle(handler)
+
+	// act
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/?fail=1", nil))
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if len(published) != 2 {
+		t.Fatalf("published want: 2 got: %d", len(published))
+	}
+	for _, ev := range published {
+		if ev.RequestID == "" || ev.Handler != "test" || string(ev.Payload) != `{"id":1}` {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	}
+	if pending, _ := store.Pending(context.Background(), 10); len(pending) != 0 {
+		t.Errorf("pending want: 0 got: %d", len(pending))
+	}
+}
+
+func TestEmitWithoutOutbox(t *testing.T) {
+	var s Server
+	if _, err := s.Emit(context.Background(), "test", nil); err != ErrOutboxDisabled {
+		t.Errorf("want ErrOutboxDisabled got: %v", err)
+	}
+}
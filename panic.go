@@ -0,0 +1,80 @@
+package httplog
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSONPanicResponse is a Server.PanicResponse which returns a JSON body with
+// a generic message and the request ID, for example:
+//
+//	{"error":"internal server error","request_id":"4b1d0a5e6f6c2a90"}
+func JSONPanicResponse(_ *http.Request, requestID string) Response {
+	return Response{
+		Body: map[string]string{
+			"error":      "internal server error",
+			"request_id": requestID,
+		},
+	}
+}
+
+// writePanicResponse writes the response for a recovered panic, using
+// Server.PanicResponse when set. The panic itself is never included. It
+// returns the status and number of body bytes written.
+func (svr *Server) writePanicResponse(w http.ResponseWriter, r *http.Request, requestID string, writeHeader func(int)) (status, bytesSent int) {
+	status = http.StatusInternalServerError
+
+	panicResponse := svr.PanicResponse
+	if panicResponse == nil {
+		writeHeader(status)
+		return status, 0
+	}
+
+	var body []byte
+	ok := func() (ok bool) {
+		// a panicking hook falls back to an empty response
+		defer func() {
+			if recover() != nil {
+				ok = false
+			}
+		}()
+
+		resp := panicResponse(r, requestID)
+		if resp.Status >= 500 && resp.Status <= 599 {
+			status = resp.Status
+		}
+		for _, hdr := range resp.Headers {
+			w.Header().Add(hdr.Name, hdr.Value)
+		}
+
+		switch b := resp.Body.(type) {
+		case nil:
+		case string:
+			body = []byte(b)
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "text/plain")
+			}
+		case []byte:
+			body = b
+		default:
+			var err error
+			if body, err = json.Marshal(b); err != nil {
+				return false
+			}
+			w.Header().Set("Content-Type", "application/json")
+		}
+		return true
+	}()
+
+	if !ok {
+		status = http.StatusInternalServerError
+		body = nil
+	}
+
+	writeHeader(status)
+	if len(body) == 0 {
+		return status, 0
+	}
+	n, _ := w.Write(body)
+	return status, n
+}
@@ -0,0 +1,440 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Patch media types accepted by ApplyPatch.
+const (
+	MergePatchMediaType = "application/merge-patch+json"
+	JSONPatchMediaType  = "application/json-patch+json"
+)
+
+// Error codes returned by ApplyPatch.
+var (
+	ErrPatchUnsupported   = NewErrorCode("PATCH-415", http.StatusUnsupportedMediaType, "unsupported patch media type")
+	ErrPatchMalformed     = NewErrorCode("PATCH-400", http.StatusBadRequest, "malformed patch document")
+	ErrPatchUnprocessable = NewErrorCode("PATCH-422", http.StatusUnprocessableEntity, "patch can't be applied")
+)
+
+// ApplyPatch applies the request body to v, a pointer to the entity being
+// patched, as a JSON Merge Patch (RFC 7386) or JSON Patch (RFC 6902)
+// document depending on the request's Content-Type:
+//
+//	order, err := loadOrder(id)
+//	...
+//	if err := httplog.ApplyPatch(r, entry, &order); err != nil {
+//		return httplog.Response{}, err
+//	}
+//
+// The patched document must decode into v's type without unknown fields.
+// Errors carry ErrPatchUnsupported, ErrPatchMalformed, or
+// ErrPatchUnprocessable, so returning them from a handler responds with 415,
+// 400, or 422. v is only modified when the patch applies. The operations are
+// logged for audit as patch_ops, for example "replace /name", without their
+// values.
+func ApplyPatch(r *http.Request, entry Entry, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("httplog: ApplyPatch requires a non-nil pointer, got %T", v)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != MergePatchMediaType && mediaType != JSONPatchMediaType {
+		return ErrPatchUnsupported.Wrap(fmt.Errorf("content type %q", mediaType))
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	patch, err := decodeJSON(body)
+	if err != nil {
+		return ErrPatchMalformed.Wrap(err)
+	}
+
+	current, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSON(current)
+	if err != nil {
+		return err
+	}
+
+	var ops []string
+	if mediaType == MergePatchMediaType {
+		entry.AddField("patch_type", "merge")
+		doc = mergePatch(doc, patch, "", &ops)
+		sort.Strings(ops)
+	} else {
+		entry.AddField("patch_type", "json")
+		doc, ops, err = jsonPatch(doc, patch)
+	}
+	entry.AddField("patch_ops", ops)
+	if err != nil {
+		return err
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// decode into a new value so removed fields are zeroed
+	target := reflect.New(rv.Elem().Type())
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(target.Interface()); err != nil {
+		return ErrPatchUnprocessable.Wrap(err)
+	}
+	rv.Elem().Set(target.Elem())
+	return nil
+}
+
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// mergePatch applies patch to target (RFC 7386 section 2), appending the
+// changed paths to ops.
+func mergePatch(target, patch interface{}, path string, ops *[]string) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		*ops = append(*ops, "replace "+path)
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for name, value := range p {
+		childPath := path + "/" + escapePointerToken(name)
+		if value == nil {
+			if _, ok := t[name]; ok {
+				delete(t, name)
+				*ops = append(*ops, "remove "+childPath)
+			}
+			continue
+		}
+		t[name] = mergePatch(t[name], value, childPath, ops)
+	}
+	return t
+}
+
+// patchOperation is an operation of a JSON Patch document.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies the JSON Patch document patch to doc (RFC 6902),
+// returning the patched document and the operations applied.
+func jsonPatch(doc interface{}, patch interface{}) (interface{}, []string, error) {
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return nil, nil, err
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal(b, &operations); err != nil {
+		return nil, nil, ErrPatchMalformed.Wrap(err)
+	}
+
+	ops := make([]string, 0, len(operations))
+	for i, op := range operations {
+		if op.Path == nil {
+			return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: missing path", i))
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: %w", i, err))
+		}
+
+		var from []string
+		switch op.Op {
+		case "move", "copy":
+			if op.From == nil {
+				return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: missing from", i))
+			}
+			if from, err = parsePointer(*op.From); err != nil {
+				return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: %w", i, err))
+			}
+			ops = append(ops, op.Op+" "+*op.From+" "+*op.Path)
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: missing value", i))
+			}
+			fallthrough
+		case "remove":
+			ops = append(ops, op.Op+" "+*op.Path)
+		default:
+			return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: unknown op %q", i, op.Op))
+		}
+
+		var value interface{}
+		if op.Value != nil {
+			if value, err = decodeJSON(op.Value); err != nil {
+				return nil, ops, ErrPatchMalformed.Wrap(fmt.Errorf("operation %d: %w", i, err))
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, path, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, path)
+		case "replace":
+			if _, err = pointerGet(doc, path); err == nil {
+				doc, err = pointerReplace(doc, path, value)
+			}
+		case "move":
+			if strings.HasPrefix(*op.Path, *op.From+"/") {
+				err = errors.New("can't move a value into itself")
+				break
+			}
+			if doc, value, err = pointerRemove(doc, from); err == nil {
+				doc, err = pointerAdd(doc, path, value)
+			}
+		case "copy":
+			if value, err = pointerGet(doc, from); err == nil {
+				if value, err = deepCopyJSON(value); err == nil {
+					doc, err = pointerAdd(doc, path, value)
+				}
+			}
+		case "test":
+			var current interface{}
+			if current, err = pointerGet(doc, path); err == nil && !jsonEqual(current, value) {
+				err = fmt.Errorf("test %s failed", *op.Path)
+			}
+		}
+		if err != nil {
+			return nil, ops, ErrPatchUnprocessable.Wrap(fmt.Errorf("operation %d: %w", i, err))
+		}
+	}
+	return doc, ops, nil
+}
+
+// parsePointer returns the reference tokens of a JSON Pointer (RFC 6901).
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// arrayIndex parses token as an index into an array of length n. max is
+// the largest index allowed.
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+// pointerUpdate walks doc to the container of the value at path and
+// replaces it with the result of leaf, returning the updated document.
+func pointerUpdate(doc interface{}, path []string, leaf func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return leaf(doc, path[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("path %q not found", path[0])
+		}
+		child, err := pointerUpdate(child, path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := pointerUpdate(node[i], path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("path %q not found", path[0])
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", token)
+			}
+			doc = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path %q not found", token)
+		}
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			i, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path %q not found", token)
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("can't remove the whole document")
+	}
+	var removed interface{}
+	doc, err := pointerUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", token)
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("path %q not found", token)
+	})
+	return doc, removed, err
+}
+
+func pointerReplace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("path %q not found", token)
+	})
+}
+
+func deepCopyJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(b)
+}
+
+// jsonEqual reports whether two decoded JSON values are equal, comparing
+// numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aErr := a.Float64()
+		bf, bErr := b.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,146 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type patchTestOrder struct {
+	Name    string            `json:"name"`
+	Tags    []string          `json:"tags"`
+	Address map[string]string `json:"address,omitempty"`
+	Note    *string           `json:"note"`
+}
+
+func TestApplyPatch(t *testing.T) {
+	note := "leave at door"
+	original := patchTestOrder{
+		Name:    "a",
+		Tags:    []string{"x", "y"},
+		Address: map[string]string{"city": "Austin", "zip": "78701"},
+		Note:    &note,
+	}
+
+	cases := []struct {
+		Name          string
+		ContentType   string
+		Patch         string
+		Expected      patchTestOrder
+		ExpectedError *ErrorCode
+		ExpectedOps   []string
+	}{
+		{
+			Name:        "merge",
+			ContentType: MergePatchMediaType,
+			Patch:       `{"name":"b","address":{"zip":null},"note":null}`,
+			Expected:    patchTestOrder{Name: "b", Tags: []string{"x", "y"}, Address: map[string]string{"city": "Austin"}},
+			ExpectedOps: []string{"remove /address/zip", "remove /note", "replace /name"},
+		},
+		{
+			Name:        "invalid index",
+			ContentType: JSONPatchMediaType,
+			Patch: `[
+				{"op":"test","path":"/name","value":"a"},
+				{"op":"add","path":"/tags/1","value":"z"},
+				{"op":"remove","path":"/tags/0"},
+				{"op":"move","from":"/address/city","path":"/name"},
+				{"op":"copy","from":"/tags/-1","path":"/tags/-"}
+			]`,
+			ExpectedError: ErrPatchUnprocessable,
+			ExpectedOps:   []string{"test /name", "add /tags/1", "remove /tags/0", "move /address/city /name", "copy /tags/-1 /tags/-"},
+		},
+		{
+			Name:        "json patch",
+			ContentType: JSONPatchMediaType + "; charset=utf-8",
+			Patch: `[
+				{"op":"add","path":"/tags/1","value":"z"},
+				{"op":"remove","path":"/tags/0"},
+				{"op":"move","from":"/address/city","path":"/name"},
+				{"op":"copy","from":"/tags/1","path":"/tags/-"},
+				{"op":"replace","path":"/note","value":null}
+			]`,
+			Expected:    patchTestOrder{Name: "Austin", Tags: []string{"z", "y", "y"}, Address: map[string]string{"zip": "78701"}},
+			ExpectedOps: []string{"add /tags/1", "remove /tags/0", "move /address/city /name", "copy /tags/1 /tags/-", "replace /note"},
+		},
+		{
+			Name:          "failed test",
+			ContentType:   JSONPatchMediaType,
+			Patch:         `[{"op":"test","path":"/name","value":"b"},{"op":"remove","path":"/name"}]`,
+			ExpectedError: ErrPatchUnprocessable,
+			ExpectedOps:   []string{"test /name"},
+		},
+		{
+			Name:          "unknown field",
+			ContentType:   MergePatchMediaType,
+			Patch:         `{"price":10}`,
+			ExpectedError: ErrPatchUnprocessable,
+			ExpectedOps:   []string{"replace /price"},
+		},
+		{
+			Name:          "malformed",
+			ContentType:   JSONPatchMediaType,
+			Patch:         `[{"op":"add","path":"/name"}]`,
+			ExpectedError: ErrPatchMalformed,
+			ExpectedOps:   []string{},
+		},
+		{
+			Name:          "unsupported",
+			ContentType:   "application/json",
+			Patch:         `{}`,
+			ExpectedError: ErrPatchUnsupported,
+		},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("PATCH", "/", strings.NewReader(c.Patch))
+		r.Header.Set("Content-Type", c.ContentType)
+		entry := newFieldEntry(&nullLogger{})
+		order := original
+		order.Tags = append([]string(nil), original.Tags...)
+		order.Address = map[string]string{"city": "Austin", "zip": "78701"}
+
+		// act
+		err := ApplyPatch(r, entry, &order)
+
+		// assert
+		if c.ExpectedError != nil {
+			if !errors.Is(err, c.ExpectedError) {
+				t.Errorf("%s: error want: %v got: %v", c.Name, c.ExpectedError, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		} else if !reflect.DeepEqual(order, c.Expected) {
+			t.Errorf("%s:\nwant: %+v\ngot:  %+v", c.Name, c.Expected, order)
+		}
+
+		if got := entry.fields["patch_ops"]; c.ExpectedOps != nil && !reflect.DeepEqual(got, c.ExpectedOps) {
+			t.Errorf("%s: patch_ops want: %q got: %q", c.Name, c.ExpectedOps, got)
+		}
+	}
+}
+
+func TestHandlerPatchStatus(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	handler := s.Handle(Handler{Name: "test", Func: func(r *http.Request, entry Entry) (Response, error) {
+		var order patchTestOrder
+		return Response{}, ApplyPatch(r, entry, &order)
+	}})
+	r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":`))
+	r.Header.Set("Content-Type", MergePatchMediaType)
+	w := httptest.NewRecorder()
+
+	// act
+	handler(w, r)
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status want: %d got: %d", http.StatusBadRequest, w.Code)
+	}
+}
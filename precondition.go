@@ -0,0 +1,54 @@
+package httplog
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned by IfMatch when the request's If-Match
+// header doesn't match the entity's current version. When a handler returns
+// it, precondition_failed is logged and, if the handler's Response is
+// empty, the response is StatusPreconditionFailed (412).
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// IfMatch checks the request's If-Match header against version, the current
+// version of the entity a PUT, PATCH, or DELETE modifies, for optimistic
+// locking:
+//
+//	if err := httplog.IfMatch(r, order.Revision); err != nil {
+//		return httplog.Response{}, err
+//	}
+//
+// Entity tags are compared strongly (RFC 7232 section 3.1), so weak tags
+// never match. "*" matches any existing entity; pass an empty version when
+// the entity doesn't exist. Requests without If-Match always pass.
+func IfMatch(r *http.Request, version string) error {
+	values, ok := r.Header["If-Match"]
+	if !ok {
+		return nil
+	}
+
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" && version != "" {
+				return nil
+			}
+			if version != "" && tag == quoteETag(version) {
+				return nil
+			}
+		}
+	}
+	return ErrPreconditionFailed
+}
+
+// ETag returns the ETag header for version, to send the entity's current
+// version with a response.
+func ETag(version string) Header {
+	return Header{Name: "ETag", Value: quoteETag(version)}
+}
+
+func quoteETag(version string) string {
+	return `"` + version + `"`
+}
@@ -0,0 +1,62 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	cases := []struct {
+		IfMatch  []string
+		Version  string
+		Expected error
+	}{
+		{nil, "v1", nil},
+		{[]string{`"v1"`}, "v1", nil},
+		{[]string{`"v0", "v1"`}, "v1", nil},
+		{[]string{`"v0"`}, "v1", ErrPreconditionFailed},
+		{[]string{`W/"v1"`}, "v1", ErrPreconditionFailed},
+		{[]string{`*`}, "v1", nil},
+		{[]string{`*`}, "", ErrPreconditionFailed},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/", nil)
+		if c.IfMatch != nil {
+			r.Header["If-Match"] = c.IfMatch
+		}
+		if got := IfMatch(r, c.Version); got != c.Expected {
+			t.Errorf("If-Match %q version %q: want: %v got: %v", c.IfMatch, c.Version, c.Expected, got)
+		}
+	}
+}
+
+func TestHandlerPreconditionFailed(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		if err := IfMatch(r, "v2"); err != nil {
+			return Response{}, err
+		}
+		return Response{Headers: []Header{ETag("v3")}}, nil
+	}}
+	r := httptest.NewRequest("PUT", "/", nil)
+	r.Header.Set("If-Match", `"v1"`)
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(w, r)
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("status want: %d got: %d", http.StatusPreconditionFailed, w.Code)
+	}
+	if got := sink.records[0].Fields["precondition_failed"]; got != true {
+		t.Errorf("precondition_failed want: true got: %v", got)
+	}
+}
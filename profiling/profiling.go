@@ -0,0 +1,227 @@
+// Package profiling connects an httplog.Server to continuous profilers.
+//
+// For pull-based profilers such as Parca, register the pprof endpoints on an
+// admin mux:
+//
+//	profiling.Register(adminMux)
+//
+// For push-based profilers such as Pyroscope, start a Pusher, which runs
+// until the Server shuts down:
+//
+//	pusher, err := profiling.New(profiling.Options{
+//		URL:     "http://pyroscope:4040",
+//		Service: "orders",
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	pusher.Start(svr)
+//
+// Profiles are tagged with the service and the version and revision
+// reported by httplog.BuildInfo, the same labels as httplog_build_info.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/judwhite/httplog"
+)
+
+// Register adds the net/http/pprof handlers under /debug/pprof/ to mux, for
+// profilers which scrape them. Register them only on an admin listener.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// Options configures a Pusher.
+type Options struct {
+	// URL is the profiler's base URL, for example "http://pyroscope:4040".
+	URL string
+	// Service is the application name profiles are stored under.
+	Service string
+	// Labels are added to every profile, along with service, version, and
+	// revision. Optional.
+	Labels map[string]string
+	// Interval is the length of each CPU profile and the period between
+	// uploads. The default is 15s.
+	Interval time.Duration
+	// Client is the HTTP client used to upload profiles. The default client
+	// has a 10s timeout.
+	Client *http.Client
+}
+
+// Pusher records CPU and heap profiles and uploads them to a Pyroscope
+// compatible ingest endpoint.
+type Pusher struct {
+	opts      Options
+	ingestURL string
+	appName   string
+}
+
+// New creates a Pusher from opts. An error is returned if the URL can't be
+// parsed or Service is empty.
+func New(opts Options) (*Pusher, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("profiling: invalid URL %q", opts.URL)
+	}
+	if opts.Service == "" {
+		return nil, fmt.Errorf("profiling: Service is required")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	version, revision := httplog.BuildInfo()
+	labels := map[string]string{"version": version, "revision": revision}
+	if host, err := os.Hostname(); err == nil {
+		labels["host"] = host
+	}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+
+	return &Pusher{
+		opts:      opts,
+		ingestURL: strings.TrimRight(opts.URL, "/") + "/ingest",
+		appName:   appName(opts.Service, labels),
+	}, nil
+}
+
+// appName formats the Pyroscope application name, "service{k=v,...}".
+func appName(service string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return service + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Start records and uploads profiles in a background task of svr until
+// Shutdown. Upload failures are logged and retried with the next profile.
+func (p *Pusher) Start(svr *httplog.Server) {
+	svr.Go("continuous_profiler", func(ctx context.Context) error {
+		for ctx.Err() == nil {
+			if err := p.pushOnce(ctx); err != nil && ctx.Err() == nil {
+				logError(svr, err)
+				// wait out the interval so a failing profiler isn't hammered
+				select {
+				case <-ctx.Done():
+				case <-time.After(p.opts.Interval):
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// pushOnce records a CPU profile for one interval, then uploads it and a
+// heap profile.
+func (p *Pusher) pushOnce(ctx context.Context) error {
+	from := time.Now()
+	var cpu bytes.Buffer
+	if err := runtimepprof.StartCPUProfile(&cpu); err != nil {
+		return fmt.Errorf("profiling: start CPU profile: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(p.opts.Interval):
+	}
+	runtimepprof.StopCPUProfile()
+	until := time.Now()
+
+	if err := p.upload(ctx, "cpu", from, until, &cpu); err != nil {
+		return err
+	}
+
+	var heap bytes.Buffer
+	if err := runtimepprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return fmt.Errorf("profiling: heap profile: %v", err)
+	}
+	return p.upload(ctx, "memory", from, until, &heap)
+}
+
+func (p *Pusher) upload(ctx context.Context, profileType string, from, until time.Time, profile io.Reader) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, profile); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	name := p.appName
+	if profileType != "cpu" {
+		// Pyroscope stores each profile type under its own application
+		name = strings.Replace(name, "{", "."+profileType+"{", 1)
+	}
+	q := url.Values{
+		"name":       {name},
+		"from":       {strconv.FormatInt(from.Unix(), 10)},
+		"until":      {strconv.FormatInt(until.Unix(), 10)},
+		"format":     {"pprof"},
+		"spyName":    {"gospy"},
+		"sampleRate": {"100"},
+	}
+
+	// the last profile is still uploaded during shutdown; the Client's
+	// timeout bounds it
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "POST", p.ingestURL+"?"+q.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := p.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("profiling: upload %s profile: %v", profileType, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("profiling: upload %s profile: %s", profileType, resp.Status)
+	}
+	return nil
+}
+
+func logError(svr *httplog.Server, err error) {
+	if svr.NewLogEntry == nil {
+		return
+	}
+	entry := svr.NewLogEntry()
+	entry.AddField("task", "continuous_profiler")
+	entry.AddError(err)
+	entry.Warn("profile upload failed")
+}
@@ -0,0 +1,48 @@
+package profiling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPusher(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := r.FormFile("profile"); err != nil {
+			t.Errorf("profile form file: %v", err)
+		}
+		mtx.Lock()
+		names = append(names, r.URL.Query().Get("name"))
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+
+	p, err := New(Options{URL: srv.URL, Service: "orders", Labels: map[string]string{"region": "eu"}, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = p.pushOnce(context.Background())
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("uploads want: 2 got: %d", len(names))
+	}
+	if !strings.HasPrefix(names[0], "orders{") || !strings.Contains(names[0], "region=eu") || !strings.Contains(names[0], "version=") {
+		t.Errorf("cpu name want: orders{...region=eu...version=...} got: %s", names[0])
+	}
+	if !strings.HasPrefix(names[1], "orders.memory{") {
+		t.Errorf("heap name want: orders.memory{...} got: %s", names[1])
+	}
+}
@@ -1,6 +1,10 @@
 package httplog
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
 	httpRequestDurationCounter = prometheus.NewHistogramVec(
@@ -17,9 +21,349 @@ var (
 		},
 		[]string{"code", "handler", "method"},
 	)
+	requestQueueDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_request_queue_duration_seconds",
+			Help: "The time from a request's arrival until its handler was called, in seconds.",
+		},
+		[]string{"handler"},
+	)
+	handlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_handler_duration_seconds",
+			Help: "The time handlers took to return, excluding writing the response, in seconds.",
+		},
+		[]string{"handler"},
+	)
+	responseWriteDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_response_write_duration_seconds",
+			Help: "The time taken to write responses after the handler returned, in seconds.",
+		},
+		[]string{"handler"},
+	)
+	httpRequestSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_size_bytes",
+			Help:    "The HTTP request body sizes in bytes.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		},
+		[]string{"handler", "method"},
+	)
+	httpResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "The HTTP response body sizes in bytes, as sent.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		},
+		[]string{"handler", "method"},
+	)
+	apiVersionRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_api_version_requests_total",
+			Help: "Total number of requests to versioned handlers by version.",
+		},
+		[]string{"handler", "version"},
+	)
+	deprecatedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_deprecated_requests_total",
+			Help: "Total number of requests to deprecated handlers by route.",
+		},
+		[]string{"handler", "route"},
+	)
+	memoryPressure = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "httplog_memory_pressure",
+			Help: "1 while the MemoryGuard is shedding low priority requests, otherwise 0.",
+		},
+	)
+	shedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_shed_requests_total",
+			Help: "Total number of requests shed by reason.",
+		},
+		[]string{"handler", "reason"},
+	)
+	dependencyHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_dependency_healthy",
+			Help: "Whether a dependency reported with ReportDependency is healthy (1) or not (0).",
+		},
+		[]string{"dependency"},
+	)
+	rejectedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_rejected_requests_total",
+			Help: "Total number of requests rejected by net/http before reaching a handler, by reason.",
+		},
+		[]string{"reason"},
+	)
+	cdnRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_cdn_requests_total",
+			Help: "Total number of requests forwarded by a CDN, by the CDN's cache status and whether the response was cacheable. See Server.LogCDN.",
+		},
+		[]string{"cache_status", "cacheable"},
+	)
+	validationFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_validation_failures_total",
+			Help: "Total number of request validation failures by field and reason.",
+		},
+		[]string{"handler", "field", "reason"},
+	)
+	wafRuleHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_waf_rule_hits_total",
+			Help: "Total number of requests matching each firewall rule.",
+		},
+		[]string{"rule", "action"},
+	)
+	droppedLogsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "httplog_dropped_logs_total",
+			Help: "Total number of access log entries dropped by a full log queue.",
+		},
+	)
+	compressionCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_compression_cache_requests_total",
+			Help: "Total number of compressed body cache lookups by result.",
+		},
+		[]string{"result"},
+	)
+	compressionRatio = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "httplog_response_compression_ratio",
+			Help:    "The ratio of uncompressed to compressed response body sizes.",
+			Buckets: []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16},
+		},
+		[]string{"handler"},
+	)
+	compressionCacheBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "httplog_compression_cache_bytes",
+			Help: "The number of bytes held by the compressed body cache.",
+		},
+	)
+	sinkDroppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "httplog_sink_dropped_records_total",
+			Help: "Total number of access log records dropped by full or closed sinks.",
+		},
+	)
+	sinkErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "httplog_sink_errors_total",
+			Help: "Total number of access log records sinks failed to write.",
+		},
+	)
+	sinkBatchSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "httplog_sink_batch_size",
+			Help:    "The number of access log records per flushed batch.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+	)
+	sinkFlushDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "httplog_sink_flush_duration_seconds",
+			Help: "The time taken to flush a batch of access log records in seconds.",
+		},
+	)
+	outboundPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_outbound_phase_duration_seconds",
+			Help: "The duration of outbound request phases (dns, connect, tls, ttfb) in seconds.",
+		},
+		[]string{"host", "phase"},
+	)
+	outboundConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_outbound_connections_total",
+			Help: "Total number of connections used by outbound requests, by whether they were reused.",
+		},
+		[]string{"host", "reused"},
+	)
+	outboundInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_outbound_in_flight",
+			Help: "The number of outbound requests in flight.",
+		},
+		[]string{"host"},
+	)
+	outboundPoolSaturation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_outbound_pool_saturation",
+			Help: "Outbound requests in flight as a fraction of the per-host connection limit.",
+		},
+		[]string{"host"},
+	)
+	outboundCacheRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_outbound_cache_requests_total",
+			Help: "Total number of outbound requests by response cache result.",
+		},
+		[]string{"host", "result"},
+	)
+	scheduledTaskRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_scheduled_task_runs_total",
+			Help: "Total number of scheduled task runs by result.",
+		},
+		[]string{"task", "result"},
+	)
+	scheduledTaskDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_scheduled_task_duration_seconds",
+			Help: "The time taken by scheduled task runs in seconds.",
+		},
+		[]string{"task"},
+	)
+	outboxEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_outbox_events_total",
+			Help: "Total number of outbox events by type and outcome.",
+		},
+		[]string{"type", "outcome"},
+	)
+	dbQueryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_db_query_duration_seconds",
+			Help: "The time taken by database queries in seconds.",
+		},
+		[]string{"handler", "op"},
+	)
+	cacheCallsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_cache_calls_total",
+			Help: "Total number of cache calls by operation and result.",
+		},
+		[]string{"cache", "op", "result"},
+	)
+	cacheCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_cache_call_duration_seconds",
+			Help: "The time taken by cache calls in seconds.",
+		},
+		[]string{"cache", "op"},
+	)
+	costTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_cost_total",
+			Help: "Total cost added with AddCost by handler and resource.",
+		},
+		[]string{"handler", "resource"},
+	)
+	clientErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_client_errors_total",
+			Help: "Total number of client error reports by handler and kind.",
+		},
+		[]string{"handler", "kind"},
+	)
+	anomaliesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_anomalies_total",
+			Help: "Total number of anomalies detected by handler and signal.",
+		},
+		[]string{"handler", "signal"},
+	)
+	webhookDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httplog_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts by outcome.",
+		},
+		[]string{"host", "outcome"},
+	)
+	certExpiryDays = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_tls_cert_expiry_days",
+			Help: "The number of days until the served TLS certificate expires.",
+		},
+		[]string{"subject"},
+	)
+	webhookDeliveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "httplog_webhook_delivery_duration_seconds",
+			Help: "The time taken to deliver a webhook in seconds.",
+		},
+		[]string{"host"},
+	)
+	startTimeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "httplog_start_time_seconds",
+			Help: "The time the process started serving, in seconds since the Unix epoch.",
+		},
+	)
+	uptimeGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "httplog_uptime_seconds",
+			Help: "The time since the process started, in seconds.",
+		},
+		func() float64 { return time.Since(startTime).Seconds() },
+	)
+	buildInfoGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_build_info",
+			Help: "Always 1; labelled with the version and revision of the running build.",
+		},
+		[]string{"version", "revision", "go_version"},
+	)
+	configGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "httplog_config",
+			Help: "The value of Server settings; durations are in seconds and 0 means the default or unlimited.",
+		},
+		[]string{"setting"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(httpRequestDurationCounter)
 	prometheus.MustRegister(httpRequestsTotal)
+	prometheus.MustRegister(requestQueueDuration)
+	prometheus.MustRegister(handlerDuration)
+	prometheus.MustRegister(responseWriteDuration)
+	prometheus.MustRegister(httpRequestSize)
+	prometheus.MustRegister(httpResponseSize)
+	prometheus.MustRegister(validationFailuresTotal)
+	prometheus.MustRegister(apiVersionRequestsTotal)
+	prometheus.MustRegister(deprecatedRequestsTotal)
+	prometheus.MustRegister(memoryPressure)
+	prometheus.MustRegister(shedRequestsTotal)
+	prometheus.MustRegister(rejectedRequestsTotal)
+	prometheus.MustRegister(dependencyHealthy)
+	prometheus.MustRegister(cdnRequestsTotal)
+	prometheus.MustRegister(outboxEventsTotal)
+	prometheus.MustRegister(dbQueryDuration)
+	prometheus.MustRegister(cacheCallsTotal)
+	prometheus.MustRegister(cacheCallDuration)
+	prometheus.MustRegister(costTotal)
+	prometheus.MustRegister(clientErrorsTotal)
+	prometheus.MustRegister(anomaliesTotal)
+	prometheus.MustRegister(wafRuleHitsTotal)
+	prometheus.MustRegister(droppedLogsTotal)
+	prometheus.MustRegister(compressionCacheRequests)
+	prometheus.MustRegister(compressionCacheBytes)
+	prometheus.MustRegister(compressionRatio)
+	prometheus.MustRegister(sinkDroppedTotal)
+	prometheus.MustRegister(sinkErrorsTotal)
+	prometheus.MustRegister(sinkBatchSize)
+	prometheus.MustRegister(sinkFlushDuration)
+	prometheus.MustRegister(outboundPhaseDuration)
+	prometheus.MustRegister(outboundConnectionsTotal)
+	prometheus.MustRegister(outboundInFlight)
+	prometheus.MustRegister(outboundPoolSaturation)
+	prometheus.MustRegister(outboundCacheRequestsTotal)
+	prometheus.MustRegister(scheduledTaskRunsTotal)
+	prometheus.MustRegister(scheduledTaskDuration)
+	prometheus.MustRegister(webhookDeliveriesTotal)
+	prometheus.MustRegister(webhookDeliveryDuration)
+	prometheus.MustRegister(certExpiryDays)
+	prometheus.MustRegister(startTimeGauge)
+	prometheus.MustRegister(uptimeGauge)
+	prometheus.MustRegister(buildInfoGauge)
+	prometheus.MustRegister(configGauge)
 }
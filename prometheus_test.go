@@ -0,0 +1,32 @@
+package httplog
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// readMetric returns the current value of a counter, gauge or histogram.
+func readMetric(t *testing.T, m prometheus.Metric) *dto.Metric {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	return &out
+}
+
+// counterValue returns the value of a counter from a CounterVec.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	return readMetric(t, c).GetCounter().GetValue()
+}
+
+// histogramValue returns the sample count and sum of a histogram from a
+// HistogramVec.
+func histogramValue(t *testing.T, o prometheus.Observer) (count uint64, sum float64) {
+	t.Helper()
+	h := readMetric(t, o.(prometheus.Metric)).GetHistogram()
+	return h.GetSampleCount(), h.GetSampleSum()
+}
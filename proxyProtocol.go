@@ -0,0 +1,239 @@
+package httplog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener accepts connections with a PROXY protocol v1 or v2
+// header (as sent by HAProxy, AWS NLB, and others) and reports the client
+// address from the header as the connection's RemoteAddr, so logged IPs are
+// the real clients' even without forwarding headers. Wrap it with
+// tls.NewListener when serving TLS.
+//
+// The header is read on the connection's goroutine when it's first used, so
+// a slow client doesn't block Accept. Connections from trusted proxies
+// without a valid header are closed.
+type ProxyProtocolListener struct {
+	net.Listener
+	// TrustedProxies lists the networks allowed to send a PROXY header.
+	// Connections from other addresses are served as-is, with their
+	// header, if any, treated as request data. When empty all connections
+	// must send a header. See ParseNetworks.
+	TrustedProxies []*net.IPNet
+	// ReadHeaderTimeout limits the time to read the header. The default is
+	// 5s.
+	ReadHeaderTimeout time.Duration
+}
+
+// ParseNetworks parses CIDR blocks, such as "10.0.0.0/8", and IP addresses
+// into networks.
+func ParseNetworks(cidrs ...string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether ip is in one of networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept implements net.Listener.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.TrustedProxies) > 0 {
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil || !containsIP(l.TrustedProxies, net.ParseIP(host)) {
+			return conn, nil
+		}
+	}
+
+	timeout := l.ReadHeaderTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyConn{Conn: conn, br: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// proxyConn reads the PROXY header before the connection's first use.
+type proxyConn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.local, c.err = readProxyHeader(c.br)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// readProxyHeader reads a v1 or v2 PROXY header from br. The addresses are
+// nil for the v1 UNKNOWN and v2 LOCAL commands, meaning the connection's own
+// addresses apply.
+func readProxyHeader(br *bufio.Reader) (remote, local net.Addr, err error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(br)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(br)
+	}
+	return nil, nil, errProxyHeader
+}
+
+func readProxyHeaderV1(br *bufio.Reader) (remote, local net.Addr, err error) {
+	// the longest v1 header is 107 bytes including CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errProxyHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readProxyHeaderV2(br *bufio.Reader) (remote, local net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, errProxyHeader
+	}
+	command, family := hdr[12]&0x0f, hdr[13]>>4
+	length := int(binary.BigEndian.Uint16(hdr[14:]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections are health checks from the proxy itself
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, errProxyHeader
+	}
+
+	switch family {
+	case 1: // AF_INET
+		if length < 12 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:]))}, nil
+	case 2: // AF_INET6
+		if length < 36 {
+			return nil, nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:]))}, nil
+	}
+	// AF_UNIX and AF_UNSPEC carry no usable client address
+	return nil, nil, nil
+}
@@ -0,0 +1,98 @@
+package httplog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 203, 0, 113, 7, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 51000)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+
+	cases := []struct {
+		Name     string
+		Header   string
+		Expected string
+		Err      bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 198.51.100.22 10.0.0.1 35646 80\r\n", "198.51.100.22:35646", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 35646 443\r\n", "[2001:db8::1]:35646", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v2 inet", string(v2), "203.0.113.7:51000", false},
+		{"v2 local", string(proxyV2Signature) + "\x20\x00\x00\x00", "", false},
+		{"missing", "GET / HTTP/1.1\r\n", "", true},
+		{"v1 malformed", "PROXY TCP4 nope 10.0.0.1 1 2\r\n", "", true},
+	}
+
+	for _, c := range cases {
+		// arrange
+		br := bufio.NewReader(strings.NewReader(c.Header + "GET / HTTP/1.1\r\n"))
+
+		// act
+		remote, _, err := readProxyHeader(br)
+
+		// assert
+		if (err != nil) != c.Err {
+			t.Errorf("%s: err want: %v got: %v", c.Name, c.Err, err)
+			continue
+		}
+		if c.Err {
+			continue
+		}
+		got := ""
+		if remote != nil {
+			got = remote.String()
+		}
+		if got != c.Expected {
+			t.Errorf("%s: remote want: %q got: %q", c.Name, c.Expected, got)
+		}
+		if rest, _ := br.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" {
+			t.Errorf("%s: want header consumed, next line: %q", c.Name, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	// arrange
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, _ := ParseNetworks("127.0.0.1")
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(requestInfo(r).ClientIP))
+	})}
+	go func() { _ = srv.Serve(&ProxyProtocolListener{Listener: l, TrustedProxies: trusted}) }()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// act
+	_, err = conn.Write([]byte("PROXY TCP4 198.51.100.22 10.0.0.1 35646 80\r\nGET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// assert
+	if !bytes.Equal(body, []byte("198.51.100.22")) {
+		t.Errorf("client IP want: 198.51.100.22 got: %s", body)
+	}
+}
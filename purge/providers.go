@@ -0,0 +1,148 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	cloudflareBaseURL = "https://api.cloudflare.com/client/v4"
+	fastlyBaseURL     = "https://api.fastly.com"
+
+	// cloudflareBatchSize is the number of URLs or tags Cloudflare accepts
+	// in one purge request.
+	cloudflareBatchSize = 30
+	// fastlyBatchSize is the number of surrogate keys Fastly accepts in one
+	// purge request.
+	fastlyBatchSize = 256
+)
+
+// Cloudflare purges URLs and cache tags from a Cloudflare zone.
+type Cloudflare struct {
+	// ZoneID is the zone's identifier.
+	ZoneID string
+	// Token is an API token with the Cache Purge permission.
+	Token string
+	// BaseURL is the API's base URL. The default is
+	// "https://api.cloudflare.com/client/v4".
+	BaseURL string
+}
+
+// Name returns "cloudflare".
+func (p *Cloudflare) Name() string {
+	return "cloudflare"
+}
+
+// Requests returns the purge_cache requests for target, in batches of 30
+// URLs and 30 tags.
+func (p *Cloudflare) Requests(ctx context.Context, target Target) ([]*http.Request, error) {
+	endpoint := baseURL(p.BaseURL, cloudflareBaseURL) + "/zones/" + url.PathEscape(p.ZoneID) + "/purge_cache"
+
+	type purgeBody struct {
+		Files []string `json:"files,omitempty"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+	var bodies []purgeBody
+	for _, files := range batches(target.URLs, cloudflareBatchSize) {
+		bodies = append(bodies, purgeBody{Files: files})
+	}
+	for _, tags := range batches(target.Tags, cloudflareBatchSize) {
+		bodies = append(bodies, purgeBody{Tags: tags})
+	}
+
+	reqs := make([]*http.Request, 0, len(bodies))
+	for _, body := range bodies {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+		req.Header.Set("Content-Type", "application/json")
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// Fastly purges URLs and surrogate keys from a Fastly service.
+type Fastly struct {
+	// ServiceID is the service's identifier, required to purge surrogate
+	// keys.
+	ServiceID string
+	// Token is an API token with the purge_select scope.
+	Token string
+	// Soft marks content stale instead of removing it, so it can still be
+	// served while revalidating or if the origin is down.
+	Soft bool
+	// BaseURL is the API's base URL. The default is
+	// "https://api.fastly.com".
+	BaseURL string
+}
+
+// Name returns "fastly".
+func (p *Fastly) Name() string {
+	return "fastly"
+}
+
+// Requests returns a purge request for each URL and one for each batch of
+// 256 surrogate keys.
+func (p *Fastly) Requests(ctx context.Context, target Target) ([]*http.Request, error) {
+	base := baseURL(p.BaseURL, fastlyBaseURL)
+
+	var reqs []*http.Request
+	for _, u := range target.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", base+"/purge/"+parsed.Host+parsed.RequestURI(), nil)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, p.authorize(req))
+	}
+	for _, keys := range batches(target.Tags, fastlyBatchSize) {
+		req, err := http.NewRequestWithContext(ctx, "POST", base+"/service/"+url.PathEscape(p.ServiceID)+"/purge", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+		reqs = append(reqs, p.authorize(req))
+	}
+	return reqs, nil
+}
+
+func (p *Fastly) authorize(req *http.Request) *http.Request {
+	req.Header.Set("Fastly-Key", p.Token)
+	if p.Soft {
+		req.Header.Set("Fastly-Soft-Purge", "1")
+	}
+	return req
+}
+
+func baseURL(configured, def string) string {
+	if configured == "" {
+		return def
+	}
+	return strings.TrimRight(configured, "/")
+}
+
+// batches splits values into slices of at most size.
+func batches(values []string, size int) [][]string {
+	var out [][]string
+	for len(values) > size {
+		out = append(out, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		out = append(out, values)
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+// Package purge purges CDN caches when handlers change cacheable resources.
+//
+// Create a Client for the CDN's API and purge the URLs or cache tags a
+// handler changed:
+//
+//	purger, err := purge.New(purge.Options{
+//		Provider: &purge.Cloudflare{ZoneID: zoneID, Token: os.Getenv("CF_TOKEN")},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	// in a handler, after the change is stored
+//	if err := purger.Purge(r.Context(), purge.Target{Tags: []string{"product-" + id}}); err != nil {
+//		entry.AddError(err)
+//	}
+//
+// API calls are made through an httplog.Transport, so each is logged and
+// linked to the request being served. Failed calls are retried, and the
+// outcome is added to the request's log entry.
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/judwhite/httplog"
+)
+
+// Target lists what to purge.
+type Target struct {
+	// URLs are absolute URLs to purge.
+	URLs []string
+	// Tags are cache tags or surrogate keys to purge.
+	Tags []string
+}
+
+// Provider builds the API requests purging a Target from a CDN.
+type Provider interface {
+	// Name identifies the CDN in logs, for example "cloudflare".
+	Name() string
+	// Requests returns the API requests which purge target. Requests with
+	// a body must be replayable, as those created by http.NewRequest with a
+	// *bytes.Reader are, so they can be retried.
+	Requests(ctx context.Context, target Target) ([]*http.Request, error)
+}
+
+// Options configures a Client.
+type Options struct {
+	// Provider is the CDN's API. Required.
+	Provider Provider
+	// Client is the HTTP client used to call the API. The default client
+	// has a 10s timeout and an httplog.Transport.
+	Client *http.Client
+	// MaxAttempts is the number of times each API request is tried. The
+	// default is 3.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling with each
+	// retry. A Retry-After response header takes precedence. The default
+	// is 500ms.
+	Backoff time.Duration
+	// NewLogEntry creates the log entry for purges made outside a request
+	// served by httplog.Server.Handle, for example from Server.Go. If nil
+	// they're only logged by the Transport.
+	NewLogEntry func() httplog.Entry
+}
+
+// Client purges CDN caches.
+type Client struct {
+	opts Options
+}
+
+// New creates a Client from opts. An error is returned if Provider is nil.
+func New(opts Options) (*Client, error) {
+	if opts.Provider == nil {
+		return nil, fmt.Errorf("purge: Provider is required")
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Transport: &httplog.Transport{}, Timeout: 10 * time.Second}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	return &Client{opts: opts}, nil
+}
+
+// Purge purges target, retrying API requests which fail with a network
+// error, StatusTooManyRequests (429), or a 5xx status. The outcome is
+// logged with purge_provider, purge_urls and purge_tags (the number of
+// each), purge_attempts, and purge_error if it failed, on the entry of the
+// request ctx belongs to, or on a new entry from NewLogEntry.
+func (c *Client) Purge(ctx context.Context, target Target) error {
+	if len(target.URLs) == 0 && len(target.Tags) == 0 {
+		return nil
+	}
+
+	attempts := 0
+	reqs, err := c.opts.Provider.Requests(ctx, target)
+	if err == nil {
+		for _, req := range reqs {
+			var n int
+			n, err = c.do(ctx, req)
+			attempts += n
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	c.log(ctx, target, attempts, err)
+	return err
+}
+
+// do sends req until it succeeds or MaxAttempts is reached, returning the
+// number of attempts.
+func (c *Client) do(ctx context.Context, req *http.Request) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.send(req)
+		if err == nil {
+			return attempt, nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt == c.opts.MaxAttempts {
+			return attempt, err
+		}
+
+		wait := c.opts.Backoff << uint(attempt-1)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return attempt, bodyErr
+			}
+			req.Body = body
+		}
+	}
+}
+
+// permanentError is an API response which isn't retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Client) send(req *http.Request) (time.Duration, error) {
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("purge: %s: %v", c.opts.Provider.Name(), err)
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("purge: %s: %s: %s", c.opts.Provider.Name(), resp.Status, msg)
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return 0, &permanentError{err: err}
+	}
+	var retryAfter time.Duration
+	if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return retryAfter, err
+}
+
+func (c *Client) log(ctx context.Context, target Target, attempts int, err error) {
+	fields := map[string]interface{}{
+		"purge_provider": c.opts.Provider.Name(),
+		"purge_urls":     len(target.URLs),
+		"purge_tags":     len(target.Tags),
+		"purge_attempts": attempts,
+	}
+	if err != nil {
+		fields["purge_error"] = err.Error()
+	}
+
+	if entry := httplog.EntryFromContext(ctx); entry != nil {
+		entry.AddFields(fields)
+		return
+	}
+	if c.opts.NewLogEntry == nil {
+		return
+	}
+	entry := c.opts.NewLogEntry()
+	entry.AddFields(fields)
+	if err != nil {
+		entry.AddError(err)
+		entry.Warn("cdn purge failed")
+		return
+	}
+	entry.Info("cdn purge")
+}
@@ -0,0 +1,128 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/judwhite/httplog"
+)
+
+func TestCloudflarePurge(t *testing.T) {
+	// arrange
+	var mtx sync.Mutex
+	var bodies []map[string][]string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		calls++
+		if r.URL.Path != "/zones/zone1/purge_cache" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		var body map[string][]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if calls == 1 {
+			// the first attempt fails and is retried with the same body
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+
+	var entries []*testEntry
+	c, err := New(Options{
+		Provider: &Cloudflare{ZoneID: "zone1", Token: "secret", BaseURL: srv.URL},
+		Client:   srv.Client(),
+		Backoff:  time.Millisecond,
+		NewLogEntry: func() httplog.Entry {
+			e := &testEntry{fields: make(map[string]interface{})}
+			entries = append(entries, e)
+			return e
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = c.Purge(context.Background(), Target{URLs: []string{"https://example.com/a"}, Tags: []string{"product-1"}})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 || bodies[0]["files"][0] != "https://example.com/a" || bodies[1]["tags"][0] != "product-1" {
+		t.Errorf("bodies want files then tags got: %v", bodies)
+	}
+	if len(entries) != 1 || entries[0].fields["purge_attempts"] != 3 || entries[0].msg != "cdn purge" {
+		t.Errorf("log want 3 attempts got: %+v", entries)
+	}
+}
+
+func TestFastlyPurgePermanentError(t *testing.T) {
+	// arrange
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/service/svc1/purge" || r.Header.Get("Surrogate-Key") != "a b" || r.Header.Get("Fastly-Soft-Purge") != "1" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c, err := New(Options{
+		Provider: &Fastly{ServiceID: "svc1", Token: "secret", Soft: true, BaseURL: srv.URL},
+		Client:   srv.Client(),
+		Backoff:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = c.Purge(context.Background(), Target{Tags: []string{"a", "b"}})
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("error want 401 got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls want: 1 got: %d", calls)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	got := batches([]string{"a", "b", "c", "d", "e"}, 2)
+	if len(got) != 3 || len(got[2]) != 1 {
+		t.Errorf("want 3 batches got: %v", got)
+	}
+}
+
+type testEntry struct {
+	fields map[string]interface{}
+	msg    string
+}
+
+func (e *testEntry) AddField(key string, value interface{}) { e.fields[key] = value }
+func (e *testEntry) AddFields(fields map[string]interface{}) {
+	for k, v := range fields {
+		e.fields[k] = v
+	}
+}
+func (e *testEntry) AddError(err error)                        { e.fields["err"] = err }
+func (e *testEntry) Info(args ...interface{})                  { e.msg = args[0].(string) }
+func (e *testEntry) Infof(format string, args ...interface{})  {}
+func (e *testEntry) Warn(args ...interface{})                  { e.msg = args[0].(string) }
+func (e *testEntry) Warnf(format string, args ...interface{})  {}
+func (e *testEntry) Error(args ...interface{})                 { e.msg = args[0].(string) }
+func (e *testEntry) Errorf(format string, args ...interface{}) {}
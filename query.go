@@ -0,0 +1,75 @@
+package httplog
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+// DefaultRedactedQueryParams are the query parameters redacted when
+// Server.LogQuery is set and RedactQueryParams is nil.
+var DefaultRedactedQueryParams = []string{
+	"access_token", "api_key", "apikey", "code", "key", "password",
+	"secret", "signature", "token",
+}
+
+// addQueryFields adds the request's query string as the query field when
+// LogQuery is set, redacting the values of sensitive parameters in both
+// query and uri.
+func (svr *Server) addQueryFields(fields map[string]interface{}, r *http.Request) {
+	if !svr.LogQuery || r.URL.RawQuery == "" {
+		return
+	}
+
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil && len(values) == 0 {
+		return
+	}
+
+	names := svr.RedactQueryParams
+	if names == nil {
+		names = DefaultRedactedQueryParams
+	}
+	redact := make(map[string]bool, len(names))
+	for _, name := range names {
+		redact[strings.ToLower(name)] = true
+	}
+
+	query := make(map[string]interface{}, len(values))
+	redactedAny := false
+	for name, vals := range values {
+		if redact[strings.ToLower(name)] {
+			redactedAny = true
+			for i := range vals {
+				vals[i] = redacted
+			}
+		}
+		if len(vals) == 1 {
+			query[name] = vals[0]
+		} else {
+			query[name] = vals
+		}
+	}
+	fields["query"] = query
+
+	if redactedAny {
+		fields["uri"] = r.URL.EscapedPath() + "?" + redactRawQuery(r.URL.RawQuery, redact)
+	}
+}
+
+// redactRawQuery replaces the values of redacted parameters in rawQuery,
+// keeping the order and encoding of the others.
+func redactRawQuery(rawQuery string, redact map[string]bool) string {
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		rawName, _, hasValue := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil || !hasValue || !redact[strings.ToLower(name)] {
+			continue
+		}
+		parts[i] = rawName + "=" + redacted
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,30 @@
+package httplog
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQueryFields(t *testing.T) {
+	// arrange
+	svr := &Server{LogQuery: true}
+	r := httptest.NewRequest("GET", "/search?q=shoes&Token=abc&size=9&size=10", nil)
+	fields := map[string]interface{}{"uri": r.RequestURI}
+
+	// act
+	svr.addQueryFields(fields, r)
+
+	// assert
+	expected := map[string]interface{}{
+		"q":     "shoes",
+		"Token": "[redacted]",
+		"size":  []string{"9", "10"},
+	}
+	if !reflect.DeepEqual(fields["query"], expected) {
+		t.Errorf("query want: %v got: %v", expected, fields["query"])
+	}
+	if uri := "/search?q=shoes&Token=[redacted]&size=9&size=10"; fields["uri"] != uri {
+		t.Errorf("uri want: %s got: %v", uri, fields["uri"])
+	}
+}
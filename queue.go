@@ -0,0 +1,166 @@
+package httplog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy determines what happens when a job is added to a full
+// queue. See Server.LogOverflowPolicy.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for space in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued job to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the job being added.
+	OverflowDropNewest
+)
+
+const (
+	defaultLogQueueSize = 4096
+	defaultLogWorkers   = 4
+)
+
+// jobQueue runs jobs from a bounded queue on a fixed number of workers.
+type jobQueue struct {
+	jobs   chan func()
+	policy OverflowPolicy
+	onDrop func()
+	wg     sync.WaitGroup
+
+	mtx    sync.RWMutex
+	closed bool
+}
+
+func newJobQueue(size, workers int, policy OverflowPolicy, onDrop func()) *jobQueue {
+	q := &jobQueue{
+		jobs:   make(chan func(), size),
+		policy: policy,
+		onDrop: onDrop,
+	}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		job()
+	}
+}
+
+// push adds job to the queue according to the queue's OverflowPolicy. It
+// returns false if the queue is closed.
+func (q *jobQueue) push(job func()) bool {
+	q.mtx.RLock()
+	defer q.mtx.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	switch q.policy {
+	case OverflowDropNewest:
+		select {
+		case q.jobs <- job:
+		default:
+			q.onDrop()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.jobs <- job:
+				return true
+			default:
+			}
+			select {
+			case <-q.jobs:
+				q.onDrop()
+			default:
+			}
+		}
+	default:
+		q.jobs <- job
+	}
+	return true
+}
+
+// tryPush adds job to the queue without blocking, regardless of the queue's
+// OverflowPolicy. It returns false if the queue is full or closed.
+func (q *jobQueue) tryPush(job func()) bool {
+	q.mtx.RLock()
+	defer q.mtx.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting jobs and waits up to timeout for queued jobs to
+// finish. A timeout <= 0 waits indefinitely. It returns false if the timeout
+// elapsed first.
+func (q *jobQueue) close(timeout time.Duration) bool {
+	q.mtx.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mtx.Unlock()
+
+	if timeout <= 0 {
+		q.wg.Wait()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// logQueue returns the Server's access log queue, starting its workers on
+// first use.
+func (svr *Server) logQueue() *jobQueue {
+	svr.logQueueOnce.Do(func() {
+		size := svr.LogQueueSize
+		if size <= 0 {
+			size = defaultLogQueueSize
+		}
+		workers := svr.LogWorkers
+		if workers <= 0 {
+			workers = defaultLogWorkers
+		}
+		svr.logs = newJobQueue(size, workers, svr.LogOverflowPolicy, func() {
+			atomic.AddInt64(&svr.droppedLogs, 1)
+			droppedLogsTotal.Inc()
+		})
+	})
+	return svr.logs
+}
+
+// DroppedLogs returns the number of access log entries dropped because the
+// log queue was full. See LogOverflowPolicy.
+func (svr *Server) DroppedLogs() int64 {
+	return atomic.LoadInt64(&svr.droppedLogs)
+}
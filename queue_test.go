@@ -0,0 +1,59 @@
+package httplog
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestJobQueueOverflow(t *testing.T) {
+	cases := []struct {
+		Policy      OverflowPolicy
+		ExpectedRun []int
+		Dropped     int
+	}{
+		{OverflowDropNewest, []int{0, 1}, 2},
+		{OverflowDropOldest, []int{2, 3}, 2},
+	}
+
+	for _, c := range cases {
+		// arrange
+		var mtx sync.Mutex
+		var ran []int
+		dropped := 0
+
+		q := newJobQueue(2, 1, c.Policy, func() { dropped++ })
+
+		// block the worker so jobs accumulate in the queue
+		release := make(chan struct{})
+		started := make(chan struct{})
+		q.push(func() {
+			close(started)
+			<-release
+		})
+		<-started
+
+		// act
+		for i := 0; i < 4; i++ {
+			i := i
+			q.push(func() {
+				mtx.Lock()
+				ran = append(ran, i)
+				mtx.Unlock()
+			})
+		}
+		close(release)
+		q.close(0)
+
+		// assert
+		if !reflect.DeepEqual(ran, c.ExpectedRun) {
+			t.Errorf("policy %d: ran want: %v got: %v", c.Policy, c.ExpectedRun, ran)
+		}
+		if dropped != c.Dropped {
+			t.Errorf("policy %d: dropped want: %d got: %d", c.Policy, c.Dropped, dropped)
+		}
+		if q.push(func() {}) {
+			t.Errorf("policy %d: push after close want: false got: true", c.Policy)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httplog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseByteRange parses a Range header containing a single byte range for a
+// body of size bytes. valid is false for headers which should be ignored,
+// such as other units, malformed ranges, or multiple ranges; the full body is
+// served in that case. satisfiable is false when the range lies outside the
+// body. end is inclusive.
+func parseByteRange(header string, size int) (start, end int, valid, satisfiable bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, 0, false, false
+	}
+	spec := strings.TrimSpace(header[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+
+	i := strings.Index(spec, "-")
+	if i == -1 {
+		return 0, 0, false, false
+	}
+	startStr, endStr := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if startStr == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.Atoi(endStr)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+
+	end = size - 1
+	if endStr != "" {
+		end, err = strconv.Atoi(endStr)
+		if err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end, true, true
+}
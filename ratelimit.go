@@ -0,0 +1,64 @@
+package httplog
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows up to limit events per key in fixed windows.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mtx       sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow records an event for key and reports whether it's within the limit.
+func (rl *rateLimiter) allow(key string, now time.Time) bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for k, w := range rl.windows {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.windows, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) >= rl.window {
+		w = &rateWindow{start: now}
+		rl.windows[key] = w
+	}
+	w.count++
+	return w.count <= rl.limit
+}
+
+// retryAfter returns the time until key's window resets.
+func (rl *rateLimiter) retryAfter(key string, now time.Time) time.Duration {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	w, ok := rl.windows[key]
+	if !ok {
+		return 0
+	}
+	return w.start.Add(rl.window).Sub(now)
+}
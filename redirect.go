@@ -0,0 +1,139 @@
+package httplog
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redirectHandlerName = "__redirect__"
+
+// RedirectPolicy configures Server.RedirectMiddleware.
+type RedirectPolicy struct {
+	// HTTPS redirects plain HTTP requests to HTTPS.
+	HTTPS bool
+	// Host is the canonical host, for example "example.com". Requests for
+	// any other host, such as "www.example.com", are redirected to it.
+	// Optional.
+	Host string
+	// Status is the redirect status. The default is
+	// StatusPermanentRedirect (308), which keeps the method and body.
+	Status int
+	// HSTSMaxAge sends Strict-Transport-Security with this max-age on HTTPS
+	// responses, including redirects to the canonical host. It's never sent
+	// over plain HTTP (RFC 6797 section 7.2). Optional.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains adds includeSubDomains to
+	// Strict-Transport-Security.
+	HSTSIncludeSubdomains bool
+	// HSTSPreload adds preload to Strict-Transport-Security.
+	HSTSPreload bool
+}
+
+// RedirectMiddleware returns middleware which redirects requests to HTTPS
+// and the canonical host as configured by policy, before they reach next.
+// The scheme and host honor forwarding headers only when TrustedProxies is
+// set and the request comes from one of them; otherwise the request's own
+// Host and TLS state are used, so a client can't choose the host it's
+// redirected to.
+// Redirects are logged under the handler name "__redirect__" with
+// redirect_reason https, canonical_host, or both as "https,canonical_host",
+// and redirect_location.
+func (svr *Server) RedirectMiddleware(policy RedirectPolicy) func(next http.Handler) http.Handler {
+	status := policy.Status
+	if status == 0 {
+		status = http.StatusPermanentRedirect
+	}
+	hsts := policy.hstsHeader()
+
+	redirect := svr.Handle(Handler{
+		Name: redirectHandlerName,
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			scheme, host := svr.redirectFrom(r)
+			location, reasons := policy.redirect(scheme, host, r.URL.RequestURI())
+			entry.AddField("redirect_reason", strings.Join(reasons, ","))
+			entry.AddField("redirect_location", location)
+
+			resp := Response{Status: status, Headers: []Header{{Name: "Location", Value: location}}}
+			if hsts != "" && scheme == "https" {
+				resp.Headers = append(resp.Headers, Header{Name: "Strict-Transport-Security", Value: hsts})
+			}
+			return resp, nil
+		},
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, host := svr.redirectFrom(r)
+			if _, reasons := policy.redirect(scheme, host, r.URL.RequestURI()); len(reasons) > 0 {
+				redirect(w, r)
+				return
+			}
+			if hsts != "" && scheme == "https" {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// redirectFrom returns the scheme and host r was sent to. Forwarding
+// headers are ignored unless TrustedProxies is set, since they're trusted
+// from any client by default and a redirect to a spoofed host can be
+// cached.
+func (svr *Server) redirectFrom(r *http.Request) (scheme, host string) {
+	if len(svr.TrustedProxies) > 0 {
+		info := newRequestInfo(r, svr.TrustedProxies)
+		return info.Scheme, info.Host
+	}
+	if r.TLS != nil {
+		return "https", r.Host
+	}
+	return "http", r.Host
+}
+
+// redirect returns where a request for scheme, host, and requestURI is
+// redirected to and why, or no reasons if it isn't.
+func (p RedirectPolicy) redirect(scheme, host, requestURI string) (string, []string) {
+	var reasons []string
+	if p.HTTPS && scheme != "https" {
+		reasons = append(reasons, "https")
+		scheme = "https"
+		// the plain HTTP port doesn't serve HTTPS
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if p.Host != "" && !strings.EqualFold(hostname(host), hostname(p.Host)) {
+		reasons = append(reasons, "canonical_host")
+		host = p.Host
+	}
+	if len(reasons) == 0 {
+		return "", nil
+	}
+	return scheme + "://" + host + requestURI, reasons
+}
+
+func (p RedirectPolicy) hstsHeader() string {
+	if p.HSTSMaxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.FormatInt(int64(p.HSTSMaxAge/time.Second), 10)
+	if p.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if p.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// hostname returns host without its port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+package httplog
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRedirectMiddleware(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1                                 // keep records in request order
+	s.TrustedProxies, _ = ParseNetworks("192.0.2.1") // httptest's RemoteAddr
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	handler := s.RedirectMiddleware(RedirectPolicy{
+		HTTPS:      true,
+		Host:       "example.com",
+		HSTSMaxAge: 365 * 24 * time.Hour,
+	})(next)
+
+	cases := []struct {
+		URL              string
+		TLS              bool
+		ForwardedProto   string
+		ExpectedStatus   int
+		ExpectedLocation string
+		ExpectedHSTS     string
+	}{
+		{"http://example.com/a?b=c", false, "", 308, "https://example.com/a?b=c", ""},
+		{"http://www.example.com:8080/a", false, "", 308, "https://example.com/a", ""},
+		{"https://www.example.com/a", true, "", 308, "https://example.com/a", "max-age=31536000"},
+		{"https://example.com/a", true, "", 200, "", "max-age=31536000"},
+		{"http://example.com/a", false, "https", 200, "", "max-age=31536000"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.URL, nil)
+		if c.TLS {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if c.ForwardedProto != "" {
+			r.Header.Set("X-Forwarded-Proto", c.ForwardedProto)
+		}
+		w := httptest.NewRecorder()
+
+		// act
+		handler.ServeHTTP(w, r)
+
+		// assert
+		if w.Code != c.ExpectedStatus {
+			t.Errorf("%s: status want: %d got: %d", c.URL, c.ExpectedStatus, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != c.ExpectedLocation {
+			t.Errorf("%s: Location want: %q got: %q", c.URL, c.ExpectedLocation, got)
+		}
+		if got := w.Header().Get("Strict-Transport-Security"); got != c.ExpectedHSTS {
+			t.Errorf("%s: Strict-Transport-Security want: %q got: %q", c.URL, c.ExpectedHSTS, got)
+		}
+	}
+	s.Shutdown()
+
+	if len(sink.records) != 3 {
+		t.Fatalf("records want: 3 got: %d", len(sink.records))
+	}
+	if got := sink.records[0].Fields["redirect_reason"]; got != "https" {
+		t.Errorf("redirect_reason want: https got: %v", got)
+	}
+	if got := sink.records[1].Fields["redirect_reason"]; got != "https,canonical_host" {
+		t.Errorf("redirect_reason want: https,canonical_host got: %v", got)
+	}
+}
+
+func TestRedirectMiddlewareForwardedHost(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	cases := []struct {
+		Name             string
+		TrustedProxies   string
+		RemoteAddr       string
+		ForwardedHost    string
+		ForwardedProto   string
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"spoofed host without trusted proxies", "", "203.0.113.9:1234", "evil.example", "", 308, "https://example.com/a"},
+		{"spoofed proto without trusted proxies", "", "203.0.113.9:1234", "", "https", 308, "https://example.com/a"},
+		{"spoofed host from untrusted address", "10.0.0.0/8", "203.0.113.9:1234", "evil.example", "", 308, "https://example.com/a"},
+		{"forwarded host from trusted proxy", "10.0.0.0/8", "10.0.0.1:1234", "shop.example", "", 308, "https://shop.example/a"},
+		{"forwarded proto from trusted proxy", "10.0.0.0/8", "10.0.0.1:1234", "", "https", 200, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			if c.TrustedProxies != "" {
+				s.TrustedProxies, _ = ParseNetworks(c.TrustedProxies)
+			}
+			handler := s.RedirectMiddleware(RedirectPolicy{HTTPS: true})(next)
+			r := httptest.NewRequest("GET", "http://example.com/a", nil)
+			r.RemoteAddr = c.RemoteAddr
+			if c.ForwardedHost != "" {
+				r.Header.Set("X-Forwarded-Host", c.ForwardedHost)
+			}
+			if c.ForwardedProto != "" {
+				r.Header.Set("X-Forwarded-Proto", c.ForwardedProto)
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(w, r)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.ExpectedStatus {
+				t.Errorf("status want: %d got: %d", c.ExpectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != c.ExpectedLocation {
+				t.Errorf("Location want: %q got: %q", c.ExpectedLocation, got)
+			}
+		})
+	}
+}
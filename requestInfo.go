@@ -0,0 +1,209 @@
+package httplog
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequestInfo holds values parsed from a request. Handle computes it once
+// per request and shares it with logging, metrics, and handlers through the
+// request's context; see RequestInfoFromContext.
+type RequestInfo struct {
+	// ClientIP is the remote IP address, taken from Forwarded, X-Real-IP,
+	// X-Forwarded-For, or the connection's remote address, in that order.
+	// It's in canonical form without brackets or a port, with IPv4-mapped
+	// IPv6 addresses as IPv4. See Server.TrustedProxies.
+	ClientIP string
+	// Scheme is "https" for TLS connections, otherwise the forwarded proto
+	// or "http".
+	Scheme string
+	// Host is the forwarded host when set, otherwise the request's Host.
+	Host string
+	Path string
+	// Route is the Handler's Route, falling back to its Name.
+	Route         string
+	Handler       string
+	RequestID     string
+	ContentLength int64
+	Proto         string
+	TLS           bool
+	TLSVersion    string
+	// ClientCert is the identity from the verified client certificate of a
+	// mutual TLS connection; nil if there's none. See MutualTLSConfig.
+	ClientCert *ClientCert
+	// Forwarded reports whether Scheme or Host were taken from a Forwarded,
+	// X-Forwarded-Proto, or X-Forwarded-Host header.
+	Forwarded bool
+}
+
+// NewRequestInfo parses r into a RequestInfo. Handlers should use
+// RequestInfoFromContext instead to avoid parsing the request again.
+// Forwarding headers are trusted; see Server.TrustedProxies.
+func NewRequestInfo(r *http.Request) *RequestInfo {
+	return newRequestInfo(r, nil)
+}
+
+// newRequestInfo parses r, honoring forwarding headers only from trusted
+// proxies. When trusted is empty every sender is trusted.
+func newRequestInfo(r *http.Request, trusted []*net.IPNet) *RequestInfo {
+	info := &RequestInfo{
+		ClientIP:      remoteIP(r),
+		Scheme:        "http",
+		Host:          r.Host,
+		Path:          r.URL.Path,
+		ContentLength: r.ContentLength,
+		Proto:         r.Proto,
+	}
+
+	if r.TLS != nil {
+		info.Scheme = "https"
+		info.TLS = true
+		info.TLSVersion = tls.VersionName(r.TLS.Version)
+		info.ClientCert = clientCert(r)
+	}
+
+	if len(trusted) > 0 && !containsIP(trusted, net.ParseIP(info.ClientIP)) {
+		return info
+	}
+
+	var proto, host string
+	if elements := parseForwarded(r.Header); len(elements) > 0 {
+		i := clientHop(elements, trusted)
+		if elements[i].ip != "" {
+			info.ClientIP = elements[i].ip
+		}
+		proto, host = elements[i].proto, elements[i].host
+	} else {
+		if ip := normalizeIP(r.Header.Get("X-Real-IP")); ip != "" {
+			info.ClientIP = ip
+		} else if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			var elements []forwardedElement
+			for _, ip := range strings.Split(xff, ",") {
+				elements = append(elements, forwardedElement{ip: normalizeIP(ip)})
+			}
+			if ip := elements[clientHop(elements, trusted)].ip; ip != "" {
+				info.ClientIP = ip
+			}
+		}
+		proto = firstHeaderValue(r.Header.Get("X-Forwarded-Proto"))
+		host = firstHeaderValue(r.Header.Get("X-Forwarded-Host"))
+	}
+
+	if proto != "" && r.TLS == nil {
+		info.Scheme = strings.ToLower(proto)
+		info.Forwarded = true
+	}
+	if host != "" {
+		info.Host = host
+		info.Forwarded = true
+	}
+
+	return info
+}
+
+// RequestInfoFromContext returns the RequestInfo of the request being served
+// by Handle, or nil if ctx doesn't belong to such a request.
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	if state := getRequestState(ctx); state != nil {
+		return state.info
+	}
+	return nil
+}
+
+// requestInfo returns the RequestInfo from r's context, parsing r if it
+// wasn't served by Handle.
+func requestInfo(r *http.Request) *RequestInfo {
+	if info := RequestInfoFromContext(r.Context()); info != nil {
+		return info
+	}
+	return NewRequestInfo(r)
+}
+
+// remoteIP returns the IP address of r's connection, or RemoteAddr if it
+// isn't an IP address, such as for Unix sockets.
+func remoteIP(r *http.Request) string {
+	if ip := normalizeIP(r.RemoteAddr); ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// forwardedElement is one proxy hop from a Forwarded or X-Forwarded-For
+// header.
+type forwardedElement struct {
+	ip    string
+	proto string
+	host  string
+}
+
+// parseForwarded parses the RFC 7239 Forwarded headers of h, in order from
+// the client to the last proxy. Obfuscated and unknown addresses have an
+// empty ip.
+func parseForwarded(h http.Header) []forwardedElement {
+	var elements []forwardedElement
+	for _, value := range h.Values("Forwarded") {
+		for _, element := range splitQuoted(value, ',') {
+			var fe forwardedElement
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(name) {
+				case "for":
+					fe.ip = normalizeIP(value)
+				case "proto":
+					fe.proto = value
+				case "host":
+					fe.host = value
+				}
+			}
+			elements = append(elements, fe)
+		}
+	}
+	return elements
+}
+
+// splitQuoted splits s at sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// clientHop returns the index of the client in elements. Without trusted
+// proxies it's the first element. Otherwise the elements are walked from
+// the last, skipping trusted proxies, since hops before an untrusted one
+// may be forged.
+func clientHop(elements []forwardedElement, trusted []*net.IPNet) int {
+	if len(trusted) == 0 {
+		return 0
+	}
+	for i := len(elements) - 1; i > 0; i-- {
+		ip := net.ParseIP(elements[i].ip)
+		if ip == nil || !containsIP(trusted, ip) {
+			return i
+		}
+	}
+	return 0
+}
+
+// firstHeaderValue returns the first element of a comma separated header.
+func firstHeaderValue(value string) string {
+	return strings.TrimSpace(strings.SplitN(value, ",", 2)[0])
+}
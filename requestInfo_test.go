@@ -0,0 +1,101 @@
+package httplog
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestInfoForwarded(t *testing.T) {
+	trusted, err := ParseNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name       string
+		RemoteAddr string
+		Headers    map[string]string
+		Trusted    bool
+		ClientIP   string
+		Scheme     string
+		Host       string
+	}{
+		{
+			Name:       "forwarded",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https;host=shop.example.com`},
+			ClientIP:   "2001:db8:cafe::17", Scheme: "https", Host: "shop.example.com",
+		},
+		{
+			Name:       "forwarded over x-forwarded-for",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "198.51.100.1"},
+			ClientIP:   "192.0.2.60", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "trusted walks past forged hop",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"Forwarded": "for=1.2.3.4;proto=https, for=192.0.2.60;proto=http, for=10.0.0.9"},
+			Trusted:    true,
+			ClientIP:   "192.0.2.60", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "trusted x-forwarded-for",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 192.0.2.60, 10.1.1.1"},
+			Trusted:    true,
+			ClientIP:   "192.0.2.60", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "ipv6 remote address",
+			RemoteAddr: "[2001:DB8:0::1]:443",
+			ClientIP:   "2001:db8::1", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "ipv4-mapped remote address",
+			RemoteAddr: "[::ffff:192.0.2.1]:443",
+			ClientIP:   "192.0.2.1", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "x-forwarded-for with ports",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"X-Forwarded-For": "[2001:db8::7]:4711, 10.1.1.1:80"},
+			Trusted:    true,
+			ClientIP:   "2001:db8::7", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "invalid x-real-ip ignored",
+			RemoteAddr: "10.0.0.2:1234",
+			Headers:    map[string]string{"X-Real-IP": "unknown", "X-Forwarded-For": "192.0.2.60"},
+			ClientIP:   "192.0.2.60", Scheme: "http", Host: "example.com",
+		},
+		{
+			Name:       "untrusted sender ignored",
+			RemoteAddr: "192.0.2.99:1234",
+			Headers:    map[string]string{"Forwarded": "for=1.2.3.4;host=evil.example", "X-Real-IP": "1.2.3.4"},
+			Trusted:    true,
+			ClientIP:   "192.0.2.99", Scheme: "http", Host: "example.com",
+		},
+	}
+
+	for _, c := range cases {
+		// arrange
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = c.RemoteAddr
+		for k, v := range c.Headers {
+			r.Header.Set(k, v)
+		}
+		networks := trusted
+		if !c.Trusted {
+			networks = nil
+		}
+
+		// act
+		info := newRequestInfo(r, networks)
+
+		// assert
+		if info.ClientIP != c.ClientIP || info.Scheme != c.Scheme || info.Host != c.Host {
+			t.Errorf("%s: want: %s %s %s got: %s %s %s", c.Name, c.ClientIP, c.Scheme, c.Host, info.ClientIP, info.Scheme, info.Host)
+		}
+	}
+}
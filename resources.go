@@ -0,0 +1,39 @@
+package httplog
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+const heapAllocsMetric = "/gc/heap/allocs:bytes"
+
+// resourceUsage is a snapshot of process CPU time and heap allocations.
+type resourceUsage struct {
+	cpu    time.Duration
+	cpuOK  bool
+	allocs uint64
+}
+
+func readResourceUsage() resourceUsage {
+	var ru resourceUsage
+	ru.cpu, ru.cpuOK = processCPUTime()
+
+	sample := []metrics.Sample{{Name: heapAllocsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		ru.allocs = sample[0].Value.Uint64()
+	}
+	return ru
+}
+
+// addResourceFields adds the CPU time and allocations since start. Go
+// doesn't account either per goroutine, so these are process-wide deltas:
+// they're exact for a request served alone and otherwise include
+// concurrent requests, counted in concurrent_requests.
+func addResourceFields(fields map[string]interface{}, start, end resourceUsage, concurrent int32) {
+	if start.cpuOK && end.cpuOK {
+		fields["cpu_ms"] = durationMs(end.cpu - start.cpu)
+	}
+	fields["alloc_bytes"] = end.allocs - start.allocs
+	fields["concurrent_requests"] = concurrent
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package httplog
+
+import "time"
+
+// processCPUTime isn't supported on this platform.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
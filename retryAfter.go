@@ -0,0 +1,60 @@
+package httplog
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfter returns a Retry-After header telling clients to wait d before
+// retrying, in whole seconds rounded up with a minimum of 1.
+func RetryAfter(d time.Duration) Header {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return Header{Name: "Retry-After", Value: strconv.FormatInt(secs, 10)}
+}
+
+// TooManyRequests returns a StatusTooManyRequests (429) response asking the
+// client to retry after retryAfter, for example the time until a rate limit
+// window resets.
+func TooManyRequests(retryAfter time.Duration) Response {
+	return Response{Status: http.StatusTooManyRequests, Headers: []Header{RetryAfter(retryAfter)}}
+}
+
+// ServiceUnavailable returns a StatusServiceUnavailable (503) response
+// asking the client to retry after retryAfter.
+func ServiceUnavailable(retryAfter time.Duration) Response {
+	return Response{Status: http.StatusServiceUnavailable, Headers: []Header{RetryAfter(retryAfter)}}
+}
+
+// MaintenanceWindow is a period when a service is unavailable.
+type MaintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Active reports whether now is within the window.
+func (mw MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(mw.Start) && now.Before(mw.End)
+}
+
+// Response returns a ServiceUnavailable response asking clients to retry
+// when the window ends.
+func (mw MaintenanceWindow) Response(now time.Time) Response {
+	return ServiceUnavailable(mw.End.Sub(now))
+}
+
+// retryAfterField returns the Retry-After response header as logged in
+// retry_after: seconds as an int, or an HTTP date as a string.
+func retryAfterField(h http.Header) (interface{}, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return nil, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return secs, true
+	}
+	return value, true
+}
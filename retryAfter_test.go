@@ -0,0 +1,70 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                       "1",
+		500 * time.Millisecond:  "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	}
+	for d, want := range cases {
+		if got := RetryAfter(d).Value; got != want {
+			t.Errorf("%v want: %s got: %s", d, want, got)
+		}
+	}
+}
+
+func TestRetryAfterLogged(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+	s.Firewall = NewFirewall([]Rule{{Name: "burst", Path: regexp.MustCompile("^/limited"), Action: RuleRateLimit, RateLimit: 1}})
+
+	now := time.Now()
+	window := MaintenanceWindow{Start: now.Add(-time.Minute), End: now.Add(90 * time.Second)}
+	h := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		if window.Active(time.Now()) {
+			return window.Response(time.Now()), nil
+		}
+		return Response{}, nil
+	}})
+
+	// act
+	maintenance := httptest.NewRecorder()
+	h(maintenance, httptest.NewRequest("GET", "/", nil))
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/limited", nil))
+	limited := httptest.NewRecorder()
+	h(limited, httptest.NewRequest("GET", "/limited", nil))
+	s.Shutdown()
+
+	// assert
+	if maintenance.Code != http.StatusServiceUnavailable || maintenance.Header().Get("Retry-After") != "90" {
+		t.Errorf("maintenance want: 503 90 got: %d %s", maintenance.Code, maintenance.Header().Get("Retry-After"))
+	}
+	secs, err := strconv.Atoi(limited.Header().Get("Retry-After"))
+	if limited.Code != http.StatusTooManyRequests || err != nil || secs < 1 || secs > 60 {
+		t.Errorf("rate limited want: 429 1-60 got: %d %s", limited.Code, limited.Header().Get("Retry-After"))
+	}
+	if len(sink.records) != 3 {
+		t.Fatalf("records want: 3 got: %d", len(sink.records))
+	}
+	if got := sink.records[0].Fields["retry_after"]; got != 90 {
+		t.Errorf("retry_after want: 90 got: %v", got)
+	}
+	if got := sink.records[2].Fields["retry_after"]; got != secs {
+		t.Errorf("retry_after want: %d got: %v", secs, got)
+	}
+}
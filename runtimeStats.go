@@ -0,0 +1,22 @@
+package httplog
+
+import (
+	"runtime"
+	"time"
+)
+
+// addRuntimeStats adds goroutine, heap, and GC fields to fields. It's called
+// for slow and panicking requests only since reading memory statistics
+// briefly stops the world.
+func addRuntimeStats(fields map[string]interface{}) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fields["goroutines"] = runtime.NumGoroutine()
+	fields["heap_inuse_bytes"] = ms.HeapInuse
+	fields["gc_count"] = ms.NumGC
+	if ms.NumGC > 0 {
+		lastPause := time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+		fields["last_gc_pause_ms"] = float64(lastPause) / float64(time.Millisecond)
+	}
+}
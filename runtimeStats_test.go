@@ -0,0 +1,81 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAddRuntimeStats(t *testing.T) {
+	// arrange
+	runtime.GC()
+	fields := make(map[string]interface{})
+
+	// act
+	addRuntimeStats(fields)
+
+	// assert
+	if n, ok := fields["goroutines"].(int); !ok || n < 1 {
+		t.Errorf("goroutines want > 0 got: %v", fields["goroutines"])
+	}
+	if n, ok := fields["heap_inuse_bytes"].(uint64); !ok || n == 0 {
+		t.Errorf("heap_inuse_bytes want > 0 got: %v", fields["heap_inuse_bytes"])
+	}
+	if n, ok := fields["gc_count"].(uint32); !ok || n == 0 {
+		t.Errorf("gc_count want > 0 after runtime.GC got: %v", fields["gc_count"])
+	}
+	if ms, ok := fields["last_gc_pause_ms"].(float64); !ok || ms < 0 {
+		t.Errorf("last_gc_pause_ms want >= 0 got: %v", fields["last_gc_pause_ms"])
+	}
+}
+
+func TestRuntimeStatsLogged(t *testing.T) {
+	cases := []struct {
+		name          string
+		slowThreshold time.Duration
+		sleep         time.Duration
+		panics        bool
+		wantSlow      bool
+		wantStats     bool
+	}{
+		{name: "fast", slowThreshold: time.Hour},
+		{name: "no threshold", sleep: 5 * time.Millisecond},
+		{name: "slow", slowThreshold: time.Millisecond, sleep: 5 * time.Millisecond, wantSlow: true, wantStats: true},
+		{name: "panic", slowThreshold: time.Hour, panics: true, wantStats: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.SlowThreshold = c.slowThreshold
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				time.Sleep(c.sleep)
+				if c.panics {
+					panic("boom")
+				}
+				return Response{}, nil
+			}}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			fields := sink.records[0].Fields
+			if got := fields["slow"] == true; got != c.wantSlow {
+				t.Errorf("slow want: %v got: %v", c.wantSlow, fields["slow"])
+			}
+			for _, key := range []string{"goroutines", "heap_inuse_bytes", "gc_count"} {
+				if _, got := fields[key]; got != c.wantStats {
+					t.Errorf("%s present want: %v got: %v", key, c.wantStats, got)
+				}
+			}
+		})
+	}
+}
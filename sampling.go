@@ -0,0 +1,155 @@
+package httplog
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Sampler selects which successful requests have their access log written.
+// Rates can be set per key, such as a tenant or user ID, and changed at
+// runtime, for example to log every request from a customer during an
+// investigation. Set it as Server.Sampler.
+//
+// Requests logged at LevelWarn or LevelError are always written. Sampled
+// records include sample_rate so counts can be re-weighted. The decision is
+// derived from the request ID, so services sharing an ID make the same
+// choice.
+type Sampler struct {
+	// Key returns the sampling key for a request, for example the value of
+	// an X-Tenant-ID header.
+	Key func(r *http.Request) string
+
+	mtx         sync.RWMutex
+	defaultRate float64
+	rates       map[string]float64
+}
+
+// NewSampler returns a Sampler using key to select per-key rates and
+// defaultRate, from 0 to 1, for other keys.
+func NewSampler(key func(r *http.Request) string, defaultRate float64) *Sampler {
+	return &Sampler{Key: key, defaultRate: clampRate(defaultRate), rates: make(map[string]float64)}
+}
+
+// SetDefaultRate sets the rate for keys without their own rate.
+func (s *Sampler) SetDefaultRate(rate float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.defaultRate = clampRate(rate)
+}
+
+// SetRate sets the rate for key, from 0 to 1.
+func (s *Sampler) SetRate(key string, rate float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rates[key] = clampRate(rate)
+}
+
+// ClearRate returns key to the default rate.
+func (s *Sampler) ClearRate(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.rates, key)
+}
+
+// Rate returns the rate applied to key.
+func (s *Sampler) Rate(key string) float64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if rate, ok := s.rates[key]; ok {
+		return rate
+	}
+	return s.defaultRate
+}
+
+// Rates returns the per-key rates.
+func (s *Sampler) Rates() map[string]float64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	rates := make(map[string]float64, len(s.rates))
+	for k, v := range s.rates {
+		rates[k] = v
+	}
+	return rates
+}
+
+// sample returns the rate applied to r and whether its log is written.
+func (s *Sampler) sample(r *http.Request, requestID string) (rate float64, keep bool) {
+	if s == nil {
+		return 1, true
+	}
+
+	key := ""
+	if s.Key != nil {
+		key = s.Key(r)
+	}
+	rate = s.Rate(key)
+	if rate >= 1 {
+		return rate, true
+	}
+	if rate <= 0 {
+		return rate, false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(requestID))
+	return rate, float64(mix64(h.Sum64()))/math.MaxUint64 < rate
+}
+
+// mix64 spreads the bits of x. FNV hashes of IDs differing only in their
+// last bytes are otherwise close together.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Handler returns a Handler which serves the Sampler's rates as JSON. A POST
+// with the query parameters key and rate sets a key's rate; a rate of
+// "default" clears it. A POST with only rate sets the default rate.
+func (s *Sampler) Handler() Handler {
+	return Handler{
+		Name: "httplog_sampling",
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			if r.Method == "POST" {
+				q := r.URL.Query()
+				key, hasKey := q.Get("key"), q.Has("key")
+				if hasKey && q.Get("rate") == "default" {
+					s.ClearRate(key)
+				} else {
+					rate, err := strconv.ParseFloat(q.Get("rate"), 64)
+					if err != nil {
+						return Response{Status: http.StatusBadRequest, Body: "invalid rate"}, nil
+					}
+					if hasKey {
+						s.SetRate(key, rate)
+					} else {
+						s.SetDefaultRate(rate)
+					}
+				}
+				entry.AddFields(map[string]interface{}{
+					"sampling_key":  key,
+					"sampling_rate": q.Get("rate"),
+				})
+			}
+
+			s.mtx.RLock()
+			defaultRate := s.defaultRate
+			s.mtx.RUnlock()
+
+			return Response{Body: map[string]interface{}{
+				"default": defaultRate,
+				"rates":   s.Rates(),
+			}}, nil
+		},
+	}
+}
+
+func clampRate(rate float64) float64 {
+	return math.Max(0, math.Min(1, rate))
+}
@@ -0,0 +1,40 @@
+package httplog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSamplerPerKeyRates(t *testing.T) {
+	// arrange
+	s := NewSampler(func(r *http.Request) string { return r.Header.Get("X-Tenant-ID") }, 0.1)
+	s.SetRate("flagged", 1)
+
+	count := func(tenant string) int {
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Tenant-ID", tenant)
+			if _, keep := s.sample(r, fmt.Sprintf("req-%d", i)); keep {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	// act
+	flagged, other := count("flagged"), count("other")
+
+	// assert
+	if flagged != 1000 {
+		t.Errorf("flagged tenant kept want: 1000 got: %d", flagged)
+	}
+	if other < 50 || other > 150 {
+		t.Errorf("other tenant kept want: ~100 got: %d", other)
+	}
+	if again := count("other"); again != other {
+		t.Errorf("sampling not deterministic: %d then %d", other, again)
+	}
+}
@@ -0,0 +1,236 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule determines when a scheduled task runs. See Server.Schedule.
+type Schedule interface {
+	// Next returns the first run time after t.
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule time.Duration
+
+// Every returns a Schedule which runs at a fixed interval. The interval is
+// measured from the end of the previous run.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic("httplog: Every interval must be positive")
+	}
+	return intervalSchedule(interval)
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronSchedule holds a bit per allowed value of each cron field.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record unrestricted day fields; when both day
+	// fields are restricted a day matching either runs.
+	domStar, dowStar bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard five field cron expression: minute, hour,
+// day of month, month, and day of week (0-6, Sunday is 0 or 7). Fields
+// accept *, values, ranges (1-5), lists (1,3,5), and steps (*/15, 0-30/10).
+// The descriptors @yearly, @monthly, @weekly, @daily, @hourly, and
+// "@every <duration>" are also accepted. Times are evaluated in the
+// location of the time passed to Next.
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("cron %q: invalid duration", expr)
+		}
+		return Every(d), nil
+	}
+	if spec, ok := cronDescriptors[expr]; ok {
+		expr = spec
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron %q: minute: %v", expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron %q: hour: %v", expr, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron %q: day of month: %v", expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron %q: month: %v", expr, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron %q: day of week: %v", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Give up on expressions which never match, such as February 30th.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Schedule runs fn on schedule until Shutdown. Runs of the same task never
+// overlap; the next run time is computed when a run finishes. Shutdown
+// cancels the context of a run in progress and waits for it to return.
+//
+// Each run is logged with task, time_taken, and next_run, along with any
+// error or panic, and counted in httplog_scheduled_task_runs_total.
+func (svr *Server) Schedule(name string, schedule Schedule, fn func(ctx context.Context) error) {
+	svr.goTask(func(ctx context.Context) {
+		next := schedule.Next(time.Now())
+		for !next.IsZero() {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			start := time.Now()
+			panicked, err := callRecover(func() error { return fn(ctx) })
+			duration := time.Since(start)
+			next = schedule.Next(time.Now())
+
+			svr.logScheduledRun(name, duration, next, panicked, err)
+		}
+	})
+}
+
+func (svr *Server) logScheduledRun(name string, duration time.Duration, next time.Time, panicked bool, err error) {
+	scheduledTaskDuration.WithLabelValues(name).Observe(duration.Seconds())
+
+	entry := svr.newEntry()
+	entry.AddFields(map[string]interface{}{
+		"task":       name,
+		"time_taken": int64(duration / time.Millisecond),
+	})
+	if !next.IsZero() {
+		entry.AddField("next_run", next.Format(time.RFC3339))
+	}
+
+	if err == nil {
+		scheduledTaskRunsTotal.WithLabelValues(name, "success").Inc()
+		entry.Info("scheduled task finished")
+		return
+	}
+
+	scheduledTaskRunsTotal.WithLabelValues(name, "error").Inc()
+	entry.AddError(err)
+	if panicked {
+		entry.AddField("panic", true)
+	}
+	entry.Error("scheduled task failed")
+
+	if onError := svr.OnError; onError != nil {
+		onError(ErrorEvent{
+			HandlerName: name,
+			Err:         err,
+			Panic:       panicked,
+			Time:        time.Now().Add(-duration),
+		})
+	}
+}
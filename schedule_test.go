@@ -0,0 +1,48 @@
+package httplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// arrange
+	from := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC) // Wednesday
+
+	cases := []struct {
+		Expr     string
+		Expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 3", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(90 * time.Second)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		// act
+		schedule, err := ParseCron(c.Expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.Expr, err)
+			continue
+		}
+		got := schedule.Next(from)
+
+		// assert
+		if !got.Equal(c.Expected) {
+			t.Errorf("%q: next want: %v got: %v", c.Expr, c.Expected, got)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: want error", expr)
+		}
+	}
+}
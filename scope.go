@@ -0,0 +1,379 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SetScopes records the scopes or roles granted to the caller of the request
+// being served with ctx, typically by authentication Middleware. JSON
+// response fields tagged with scope are only sent to callers with one of
+// the listed scopes:
+//
+//	type Employee struct {
+//		Name   string `json:"name"`
+//		Salary int    `json:"salary" scope:"admin,payroll"`
+//	}
+//
+// Handle logs the caller's scopes as response_scopes when the response has
+// scoped fields, and the JSON paths of the fields it removed as
+// scope_filtered. Values held in interface fields, such as ItemResult.Body
+// or JobStatus.Result, are filtered by the type they hold. Scope tags on
+// embedded structs are ignored; tag the promoted fields instead. Types
+// implementing json.Marshaler or encoding.TextMarshaler encode themselves
+// and aren't filtered. It does nothing if ctx doesn't belong to a request
+// served by Handle.
+func SetScopes(ctx context.Context, scopes ...string) {
+	state := getRequestState(ctx)
+	if state == nil {
+		return
+	}
+	state.mtx.Lock()
+	state.scopes = append([]string{}, scopes...)
+	state.mtx.Unlock()
+}
+
+func (state *requestState) callerScopes() []string {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return append([]string{}, state.scopes...)
+}
+
+// scopeNode describes where scoped fields are in a type's JSON encoding.
+type scopeNode struct {
+	// scopes are the scopes which may see the field, any of which is
+	// enough.
+	scopes []string
+	// fields are the struct's fields which are scoped or contain scoped
+	// fields, by JSON name.
+	fields map[string]*scopeNode
+	// index is the field's index sequence in its struct, for following
+	// the value being encoded.
+	index []int
+	// elem is the element of a slice, array, or map.
+	elem *scopeNode
+	// dynamic marks an interface, whose scoped fields depend on the type of
+	// the value it holds.
+	dynamic bool
+}
+
+var (
+	scopeTrees    sync.Map // reflect.Type -> *scopeNode
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textType      = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// scopeTreeFor returns the scoped fields of v's type if v has any, or nil.
+func scopeTreeFor(v interface{}) *scopeNode {
+	if tree := scopeTreeOf(v); tree.applies(reflect.ValueOf(v)) {
+		return tree
+	}
+	return nil
+}
+
+// scopeTreeOf returns the scoped fields of v's type, or nil if it has none.
+// A type with interface fields has a tree even if the values it holds have
+// no scoped fields; see applies.
+func scopeTreeOf(v interface{}) *scopeNode {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	return scopeTreeOfType(t)
+}
+
+func scopeTreeOfType(t reflect.Type) *scopeNode {
+	if cached, ok := scopeTrees.Load(t); ok {
+		return cached.(*scopeNode)
+	}
+	node := buildScopeTree(t, make(map[reflect.Type]bool))
+	scopeTrees.Store(t, node)
+	return node
+}
+
+func buildScopeTree(t reflect.Type, visiting map[reflect.Type]bool) *scopeNode {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// types which encode themselves aren't inspected
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) ||
+		t.Implements(textType) || reflect.PtrTo(t).Implements(textType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return &scopeNode{dynamic: true}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		elem := buildScopeTree(t.Elem(), visiting)
+		if elem == nil {
+			return nil
+		}
+		return &scopeNode{elem: elem}
+	case reflect.Struct:
+		if visiting[t] {
+			return nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		node := &scopeNode{fields: make(map[string]*scopeNode)}
+		addScopedFields(node, t, nil, visiting)
+		if len(node.fields) == 0 {
+			return nil
+		}
+		return node
+	}
+	return nil
+}
+
+func addScopedFields(node *scopeNode, t reflect.Type, index []int, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// fields of embedded structs are promoted
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addScopedFields(node, ft, fieldIndex, visiting)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		child := buildScopeTree(f.Type, visiting)
+		scopes := splitScopes(f.Tag.Get("scope"))
+		if child == nil && len(scopes) == 0 {
+			continue
+		}
+		if child == nil {
+			child = &scopeNode{}
+		}
+		child.scopes = scopes
+		child.index = fieldIndex
+		node.fields[name] = child
+	}
+}
+
+func splitScopes(tag string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(tag, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// applies reports whether v, a value of n's type, has scoped fields,
+// following interfaces to the values they hold.
+func (n *scopeNode) applies(v reflect.Value) bool {
+	if n == nil {
+		return false
+	}
+	if len(n.scopes) > 0 {
+		return true
+	}
+	if v = indirect(v); !v.IsValid() {
+		return false
+	}
+	if n.dynamic {
+		return scopeTreeOfType(v.Type()).applies(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for _, child := range n.fields {
+			if child.applies(fieldByIndex(v, child.index)) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if n.elem.applies(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if n.elem.applies(iter.Value()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterJSON returns body, the JSON encoding of v, a value of n's type,
+// without the fields the caller's scopes don't permit, and the paths of the
+// removed fields. Elements of arrays and maps share their container's path.
+func (n *scopeNode) filterJSON(body []byte, v interface{}, scopes []string, indent bool) ([]byte, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, nil, err
+	}
+
+	var removed []string
+	n.filter(decoded, reflect.ValueOf(v), scopes, "", &removed)
+	if len(removed) == 0 {
+		return body, nil, nil
+	}
+	var err error
+	if indent {
+		body, err = json.MarshalIndent(decoded, "", "  ")
+	} else {
+		body, err = json.Marshal(decoded)
+	}
+	return body, removed, err
+}
+
+// filter removes fields from decoded, the decoded JSON encoding of v, which
+// the caller's scopes don't permit.
+func (n *scopeNode) filter(decoded interface{}, v reflect.Value, scopes []string, path string, removed *[]string) {
+	if v = indirect(v); !v.IsValid() {
+		return
+	}
+	if n.dynamic {
+		if n = scopeTreeOfType(v.Type()); n == nil {
+			return
+		}
+	}
+
+	switch decoded := decoded.(type) {
+	case map[string]interface{}:
+		switch v.Kind() {
+		case reflect.Struct:
+			for name, child := range n.fields {
+				value, ok := decoded[name]
+				if !ok {
+					continue
+				}
+				childPath := joinPath(path, name)
+				if len(child.scopes) > 0 && !hasAnyScope(scopes, child.scopes) {
+					delete(decoded, name)
+					addUnique(removed, childPath)
+					continue
+				}
+				child.filter(value, fieldByIndex(v, child.index), scopes, childPath, removed)
+			}
+		case reflect.Map:
+			if n.elem == nil {
+				return
+			}
+			iter := v.MapRange()
+			for iter.Next() {
+				key, ok := mapKeyName(iter.Key())
+				if !ok {
+					continue
+				}
+				if value, ok := decoded[key]; ok {
+					n.elem.filter(value, iter.Value(), scopes, path, removed)
+				}
+			}
+		}
+	case []interface{}:
+		if n.elem == nil || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Len() != len(decoded) {
+			return
+		}
+		for i, value := range decoded {
+			n.elem.filter(value, v.Index(i), scopes, path, removed)
+		}
+	}
+}
+
+// indirect follows pointers and interfaces to the value they hold, returning
+// the zero Value for nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, returning the zero Value when
+// it passes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 {
+			if v = indirect(v); !v.IsValid() {
+				return v
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// mapKeyName returns the JSON object key encoding/json uses for a map key.
+func mapKeyName(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", true
+		}
+		b, err := tm.MarshalText()
+		return string(b), err == nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
+
+func hasAnyScope(granted, required []string) bool {
+	for _, r := range required {
+		for _, g := range granted {
+			if g == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func addUnique(list *[]string, value string) {
+	for _, v := range *list {
+		if v == value {
+			return
+		}
+	}
+	*list = append(*list, value)
+}
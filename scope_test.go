@@ -0,0 +1,183 @@
+package httplog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type scopedAddress struct {
+	City   string `json:"city"`
+	Street string `json:"street" scope:"admin"`
+}
+
+type scopedEmployee struct {
+	Name      string          `json:"name"`
+	Salary    int             `json:"salary" scope:"admin,payroll"`
+	Addresses []scopedAddress `json:"addresses"`
+}
+
+func TestScopeFiltering(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.LogWorkers = 1
+	s.Use(func(next func(*http.Request, Entry) (Response, error)) func(*http.Request, Entry) (Response, error) {
+		return func(r *http.Request, entry Entry) (Response, error) {
+			if role := r.Header.Get("X-Role"); role != "" {
+				SetScopes(r.Context(), role)
+			}
+			return next(r, entry)
+		}
+	})
+	h := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Body: []scopedEmployee{{
+			Name:      "Ada",
+			Salary:    100,
+			Addresses: []scopedAddress{{City: "London", Street: "1 Main St"}},
+		}}}, nil
+	}})
+
+	get := func(role string) []map[string]interface{} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Role", role)
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		var body []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	// act
+	payroll := get("payroll")
+	admin := get("admin")
+	s.Shutdown()
+
+	// assert
+	if _, ok := payroll[0]["salary"]; !ok {
+		t.Error("payroll want salary")
+	}
+	if _, ok := payroll[0]["addresses"].([]interface{})[0].(map[string]interface{})["street"]; ok {
+		t.Error("payroll want street removed")
+	}
+	if _, ok := admin[0]["addresses"].([]interface{})[0].(map[string]interface{})["street"]; !ok {
+		t.Error("admin want street")
+	}
+	if got := sink.records[0].Fields["scope_filtered"]; !reflect.DeepEqual(got, []string{"addresses.street"}) {
+		t.Errorf("scope_filtered want: [addresses.street] got: %v", got)
+	}
+	if got := sink.records[1].Fields["response_scopes"]; !reflect.DeepEqual(got, []string{"admin"}) {
+		t.Errorf("response_scopes want: [admin] got: %v", got)
+	}
+}
+
+func TestScopeFilteringCompressionCache(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Use(func(next func(*http.Request, Entry) (Response, error)) func(*http.Request, Entry) (Response, error) {
+		return func(r *http.Request, entry Entry) (Response, error) {
+			if role := r.Header.Get("X-Role"); role != "" {
+				SetScopes(r.Context(), role)
+			}
+			return next(r, entry)
+		}
+	})
+	employees := make([]scopedEmployee, 100)
+	for i := range employees {
+		employees[i] = scopedEmployee{Name: "Ada", Salary: 100}
+	}
+	h := s.Handle(Handler{Name: "test", CacheCompressed: true, Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Body: employees, Version: "v1"}, nil
+	}})
+
+	get := func(role string) []map[string]interface{} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if role != "" {
+			r.Header.Set("X-Role", role)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("want gzip response, got %v", rec.Header())
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body []map[string]interface{}
+		if err := json.NewDecoder(gz).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	// act
+	admin := get("admin")
+	anonymous := get("")
+	s.Shutdown()
+
+	// assert
+	if _, ok := admin[0]["salary"]; !ok {
+		t.Error("admin want salary")
+	}
+	if _, ok := anonymous[0]["salary"]; ok {
+		t.Error("anonymous caller got admin's cached salary")
+	}
+}
+
+func TestScopeFilteringInterfaceValues(t *testing.T) {
+	employee := &scopedEmployee{Name: "Ada", Salary: 100}
+
+	cases := []struct {
+		name     string
+		body     interface{}
+		filtered []string
+	}{
+		{"multi status", MultiStatusResponse([]ItemResult{ItemOK("1", 200, employee)}).Body, []string{"results.body.salary"}},
+		{"map", map[string]interface{}{"employee": employee, "count": 1}, []string{"salary"}},
+		{"no scoped values", map[string]interface{}{"count": 1}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			h := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{Body: c.body}, nil
+			}})
+			rec := httptest.NewRecorder()
+
+			// act
+			h(rec, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if strings.Contains(rec.Body.String(), "salary") {
+				t.Errorf("unscoped caller got salary: %s", rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "count") && !strings.Contains(rec.Body.String(), "Ada") {
+				t.Errorf("unexpected body: %s", rec.Body.String())
+			}
+			fields := sink.records[0].Fields
+			if got, _ := fields["scope_filtered"].([]string); !reflect.DeepEqual(got, c.filtered) {
+				t.Errorf("scope_filtered want: %v got: %v", c.filtered, fields["scope_filtered"])
+			}
+			if _, ok := fields["response_scopes"]; ok != (c.filtered != nil) {
+				t.Errorf("response_scopes logged: %v", ok)
+			}
+		})
+	}
+}
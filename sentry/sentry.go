@@ -0,0 +1,383 @@
+// Package sentry reports recovered panics and 5xx handler errors from an
+// httplog.Server to Sentry.
+//
+// Set the Reporter's Report method as the Server's OnError field:
+//
+//	reporter, err := sentry.New(sentry.Options{DSN: os.Getenv("SENTRY_DSN")})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	svr.OnError = reporter.Report
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/judwhite/httplog"
+)
+
+// Options configures a Reporter.
+type Options struct {
+	// DSN is the Sentry project DSN, for example
+	// "https://<key>@sentry.example.com/<project>".
+	DSN string
+	// Environment and Release are attached to each event when set.
+	Environment string
+	Release     string
+	// ServerName defaults to the host name.
+	ServerName string
+	// MaxEventsPerMinute limits the number of events sent. Events over the
+	// limit are dropped and counted; see Reporter.Dropped. The default is 60.
+	MaxEventsPerMinute int
+	// Client is the HTTP client used to send events. The default client
+	// has a 10s timeout.
+	Client *http.Client
+	// RedactQueryParams lists query parameters, matched
+	// case-insensitively, whose values are redacted in the event's query
+	// string. The default is httplog.DefaultRedactedQueryParams; use the
+	// Server's RedactQueryParams to match the access log.
+	RedactQueryParams []string
+	// RedactHeaders lists additional request headers which aren't sent.
+	// Authorization, Proxy-Authorization, Cookie and headers whose names
+	// suggest a credential, such as X-Api-Key or X-Auth-Token, are never
+	// sent.
+	RedactHeaders []string
+}
+
+// Reporter sends httplog.ErrorEvent values to Sentry.
+type Reporter struct {
+	opts      Options
+	storeURL  string
+	publicKey string
+
+	redactQuery   map[string]bool
+	redactHeaders map[string]bool
+
+	mtx         sync.Mutex
+	windowStart time.Time
+	windowCount int
+
+	dropped int64
+}
+
+// New creates a Reporter from opts. An error is returned if the DSN can't
+// be parsed.
+func New(opts Options) (*Reporter, error) {
+	u, err := url.Parse(opts.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sentry: invalid DSN: %v", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry: DSN missing public key")
+	}
+	projectID := strings.Trim(u.Path, "/")
+	if projectID == "" {
+		return nil, fmt.Errorf("sentry: DSN missing project ID")
+	}
+
+	if opts.MaxEventsPerMinute == 0 {
+		opts.MaxEventsPerMinute = 60
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.ServerName == "" {
+		opts.ServerName, _ = os.Hostname()
+	}
+
+	if opts.RedactQueryParams == nil {
+		opts.RedactQueryParams = httplog.DefaultRedactedQueryParams
+	}
+
+	rep := &Reporter{
+		opts:          opts,
+		storeURL:      fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, projectID),
+		publicKey:     u.User.Username(),
+		redactQuery:   make(map[string]bool, len(opts.RedactQueryParams)),
+		redactHeaders: make(map[string]bool, len(sensitiveHeaders)+len(opts.RedactHeaders)),
+	}
+	for _, name := range opts.RedactQueryParams {
+		rep.redactQuery[strings.ToLower(name)] = true
+	}
+	for name := range sensitiveHeaders {
+		rep.redactHeaders[name] = true
+	}
+	for _, name := range opts.RedactHeaders {
+		rep.redactHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+	return rep, nil
+}
+
+// Report sends ev to Sentry asynchronously. Its signature matches the
+// httplog.Server OnError field.
+func (rep *Reporter) Report(ev httplog.ErrorEvent) {
+	if !rep.allow(ev.Time) {
+		atomic.AddInt64(&rep.dropped, 1)
+		return
+	}
+	go rep.send(rep.newEvent(ev))
+}
+
+// Dropped returns the number of events dropped by the rate limit.
+func (rep *Reporter) Dropped() int64 {
+	return atomic.LoadInt64(&rep.dropped)
+}
+
+func (rep *Reporter) allow(t time.Time) bool {
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	rep.mtx.Lock()
+	defer rep.mtx.Unlock()
+
+	if t.Sub(rep.windowStart) >= time.Minute {
+		rep.windowStart = t
+		rep.windowCount = 0
+	}
+	if rep.windowCount >= rep.opts.MaxEventsPerMinute {
+		return false
+	}
+	rep.windowCount++
+	return true
+}
+
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *request          `json:"request,omitempty"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+}
+
+type request struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []stackFrame `json:"frames"`
+}
+
+type stackFrame struct {
+	Filename string `json:"filename"`
+	Function string `json:"function"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// sensitiveHeaders are never sent to Sentry.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveHeaderWords are parts of header names, such as X-Api-Key or
+// X-Auth-Token, which suggest the header carries a credential. Such headers
+// are never sent to Sentry.
+var sensitiveHeaderWords = []string{
+	"auth", "token", "key", "secret", "session", "password", "signature",
+	"cookie", "csrf", "xsrf",
+}
+
+func (rep *Reporter) newEvent(ev httplog.ErrorEvent) *event {
+	ts := ev.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	e := &event{
+		EventID:     newEventID(),
+		Timestamp:   ts.UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "httplog",
+		ServerName:  rep.opts.ServerName,
+		Environment: rep.opts.Environment,
+		Release:     rep.opts.Release,
+		Transaction: ev.HandlerName,
+		Tags: map[string]string{
+			"handler":     ev.HandlerName,
+			"http_status": fmt.Sprint(ev.Status),
+		},
+	}
+	if ev.RequestID != "" {
+		e.Tags["request_id"] = ev.RequestID
+	}
+	if ev.Panic {
+		e.Level = "fatal"
+		e.Tags["panic"] = "true"
+	}
+
+	if r := ev.Request; r != nil {
+		req := &request{
+			URL:         requestURL(r),
+			Method:      r.Method,
+			QueryString: rep.redactRawQuery(r.URL.RawQuery),
+			Headers:     make(map[string]string),
+		}
+		for name := range r.Header {
+			if !rep.sensitiveHeader(name) {
+				req.Headers[name] = r.Header.Get(name)
+			}
+		}
+		e.Request = req
+	}
+
+	if ev.Err != nil {
+		exc := exception{
+			Type:  errorType(ev),
+			Value: ev.Err.Error(),
+		}
+		// httplog frames are innermost first; Sentry expects the reverse.
+		frames := httplog.ErrorStack(ev.Err)
+		if len(frames) > 0 {
+			st := &stacktrace{Frames: make([]stackFrame, 0, len(frames))}
+			for i := len(frames) - 1; i >= 0; i-- {
+				f := frames[i]
+				st.Frames = append(st.Frames, stackFrame{
+					Filename: f.Path,
+					Function: f.Func,
+					Lineno:   f.Line,
+					InApp:    !isStdlib(f.Path),
+				})
+			}
+			exc.Stacktrace = st
+		}
+		e.Exception = &exceptions{Values: []exception{exc}}
+	}
+
+	return e
+}
+
+func (rep *Reporter) send(e *event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest("POST", rep.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+		"Sentry sentry_version=7, sentry_client=httplog/1.0, sentry_timestamp=%d, sentry_key=%s",
+		time.Now().Unix(), rep.publicKey))
+
+	resp, err := rep.opts.Client.Do(req)
+	if err != nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// sensitiveHeader reports whether the header name may carry a credential.
+func (rep *Reporter) sensitiveHeader(name string) bool {
+	if rep.redactHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactRawQuery replaces the values of RedactQueryParams in rawQuery,
+// keeping the order and encoding of the others.
+func (rep *Reporter) redactRawQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		rawName, _, hasValue := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil || !hasValue || !rep.redactQuery[strings.ToLower(name)] {
+			continue
+		}
+		parts[i] = rawName + "=[redacted]"
+	}
+	return strings.Join(parts, "&")
+}
+
+func errorType(ev httplog.ErrorEvent) string {
+	if ev.Panic {
+		return "panic"
+	}
+	err := ev.Err
+	for {
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok || u.Unwrap() == nil {
+			break
+		}
+		err = u.Unwrap()
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+// isStdlib reports whether path looks like a standard library file. Paths
+// outside of the standard library contain a domain in their first segment,
+// or are left absolute when they can't be trimmed, as for modules checked
+// out at a short path.
+func isStdlib(path string) bool {
+	if strings.HasPrefix(path, "/") || (len(path) > 2 && path[1] == ':' && path[2] == '/') {
+		return false
+	}
+	first := strings.SplitN(path, "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+func newEventID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
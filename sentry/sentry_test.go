@@ -0,0 +1,273 @@
+package sentry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/judwhite/httplog"
+)
+
+type nullLogger struct{}
+
+func (*nullLogger) AddField(key string, value interface{})    {}
+func (*nullLogger) AddFields(fields map[string]interface{})   {}
+func (*nullLogger) AddError(err error)                        {}
+func (*nullLogger) Info(args ...interface{})                  {}
+func (*nullLogger) Infof(format string, args ...interface{})  {}
+func (*nullLogger) Warn(args ...interface{})                  {}
+func (*nullLogger) Warnf(format string, args ...interface{})  {}
+func (*nullLogger) Error(args ...interface{})                 {}
+func (*nullLogger) Errorf(format string, args ...interface{}) {}
+
+func newTestReporter(t *testing.T, opts Options) *Reporter {
+	t.Helper()
+	if opts.DSN == "" {
+		opts.DSN = "https://public@sentry.example.com/42"
+	}
+	rep, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+// captureEvent serves one request with handler, returning the ErrorEvent passed
+// to OnError.
+func captureEvent(t *testing.T, handler httplog.Handler) httplog.ErrorEvent {
+	t.Helper()
+	events := make(chan httplog.ErrorEvent, 1)
+	svr := &httplog.Server{
+		NewLogEntry: func() httplog.Entry { return &nullLogger{} },
+		OnError:     func(ev httplog.ErrorEvent) { events <- ev },
+	}
+	w := httptest.NewRecorder()
+	svr.Handle(handler)(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError wasn't called")
+		return httplog.ErrorEvent{}
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		dsn      string
+		storeURL string
+		err      bool
+	}{
+		{dsn: "https://public@sentry.example.com/42", storeURL: "https://sentry.example.com/api/42/store/"},
+		{dsn: "https://sentry.example.com/42", err: true},
+		{dsn: "https://public@sentry.example.com/", err: true},
+		{dsn: "://bad", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.dsn, func(t *testing.T) {
+			// act
+			rep, err := New(Options{DSN: c.dsn})
+
+			// assert
+			if c.err {
+				if err == nil {
+					t.Errorf("want error got: nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.storeURL != c.storeURL || rep.publicKey != "public" {
+				t.Errorf("want: %s public got: %s %s", c.storeURL, rep.storeURL, rep.publicKey)
+			}
+		})
+	}
+}
+
+func TestNewEventStackFrames(t *testing.T) {
+	// arrange
+	rep := newTestReporter(t, Options{})
+	ev := captureEvent(t, httplog.Handler{Name: "test", Func: func(*http.Request, httplog.Entry) (httplog.Response, error) {
+		return httplog.Response{Status: http.StatusInternalServerError}, errors.New("boom")
+	}})
+	frames := httplog.ErrorStack(ev.Err)
+	if len(frames) == 0 {
+		t.Fatal("want captured stack got none")
+	}
+
+	// act
+	e := rep.newEvent(ev)
+
+	// assert
+	if e.Exception == nil || len(e.Exception.Values) != 1 {
+		t.Fatalf("want one exception got: %+v", e.Exception)
+	}
+	exc := e.Exception.Values[0]
+	if exc.Value != "boom" || exc.Type != "*errors.errorString" {
+		t.Errorf("exception want: *errors.errorString boom got: %s %s", exc.Type, exc.Value)
+	}
+	if exc.Stacktrace == nil || len(exc.Stacktrace.Frames) != len(frames) {
+		t.Fatalf("frames want: %d got: %+v", len(frames), exc.Stacktrace)
+	}
+	// Sentry frames are outermost first
+	last := exc.Stacktrace.Frames[len(frames)-1]
+	if last.Function != frames[0].Func || last.Filename != frames[0].Path || last.Lineno != frames[0].Line {
+		t.Errorf("innermost frame want: %+v got: %+v", frames[0], last)
+	}
+	if !strings.Contains(last.Function, "Handle") || !last.InApp {
+		t.Errorf("innermost frame want in-app Server.Handle got: %+v", last)
+	}
+	if e.Transaction != "test" || e.Tags["http_status"] != "500" || e.Level != "error" {
+		t.Errorf("want transaction test, status 500, level error got: %s %s %s", e.Transaction, e.Tags["http_status"], e.Level)
+	}
+}
+
+func TestIsStdlib(t *testing.T) {
+	cases := map[string]bool{
+		"net/http/server.go":                  true,
+		"runtime/panic.go":                    true,
+		"github.com/judwhite/httplog/main.go": false,
+		"example.com/app/handler.go":          false,
+		"/home/dev/app/handler.go":            false,
+		"C:/dev/app/handler.go":               false,
+	}
+	for path, want := range cases {
+		if got := isStdlib(path); got != want {
+			t.Errorf("%s: want: %v got: %v", path, want, got)
+		}
+	}
+}
+
+func TestNewEventRequest(t *testing.T) {
+	// arrange
+	rep := newTestReporter(t, Options{RedactHeaders: []string{"x-internal"}})
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/path?token=abc&page=2&Password=hunter2", nil)
+	r.Header.Set("Authorization", "Bearer abc")
+	r.Header.Set("Proxy-Authorization", "Basic abc")
+	r.Header.Set("Cookie", "session=abc")
+	r.Header.Set("X-Api-Key", "abc")
+	r.Header.Set("X-Auth-Token", "abc")
+	r.Header.Set("X-Internal", "abc")
+	r.Header.Set("Accept", "text/plain")
+	r.Header.Set("User-Agent", "test")
+
+	// act
+	e := rep.newEvent(httplog.ErrorEvent{Request: r, Status: 500, Err: errors.New("boom")})
+
+	// assert
+	wantHeaders := map[string]string{"Accept": "text/plain", "User-Agent": "test"}
+	if !reflect.DeepEqual(wantHeaders, e.Request.Headers) {
+		t.Errorf("headers want: %v got: %v", wantHeaders, e.Request.Headers)
+	}
+	if want := "token=[redacted]&page=2&Password=[redacted]"; e.Request.QueryString != want {
+		t.Errorf("query_string want: %s got: %s", want, e.Request.QueryString)
+	}
+	if want := "http://example.com/path"; e.Request.URL != want {
+		t.Errorf("url want: %s got: %s", want, e.Request.URL)
+	}
+}
+
+func TestRedactRawQuery(t *testing.T) {
+	cases := []struct {
+		name   string
+		params []string
+		query  string
+		want   string
+	}{
+		{name: "empty", query: "", want: ""},
+		{name: "default", query: "api_key=1&q=go", want: "api_key=[redacted]&q=go"},
+		{name: "escaped name", query: "access%5Ftoken=1", want: "access%5Ftoken=[redacted]"},
+		{name: "no value", query: "token&q=go", want: "token&q=go"},
+		{name: "custom", params: []string{"q"}, query: "token=1&q=go", want: "token=1&q=[redacted]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			rep := newTestReporter(t, Options{RedactQueryParams: c.params})
+
+			// act
+			got := rep.redactRawQuery(c.query)
+
+			// assert
+			if got != c.want {
+				t.Errorf("want: %s got: %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	// arrange
+	rep := newTestReporter(t, Options{MaxEventsPerMinute: 2})
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{at: 0, want: true},
+		{at: time.Second, want: true},
+		{at: 2 * time.Second, want: false},
+		{at: 59 * time.Second, want: false},
+		{at: time.Minute, want: true},
+		{at: time.Minute + time.Second, want: true},
+		{at: time.Minute + 2*time.Second, want: false},
+	}
+
+	for _, c := range cases {
+		// act
+		got := rep.allow(start.Add(c.at))
+
+		// assert
+		if got != c.want {
+			t.Errorf("%v: want: %v got: %v", c.at, c.want, got)
+		}
+	}
+}
+
+func TestReportDropsOverLimit(t *testing.T) {
+	// arrange
+	received := make(chan map[string]interface{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("X-Sentry-Auth missing key: %s", r.Header.Get("X-Sentry-Auth"))
+		}
+		received <- body
+	}))
+	defer ts.Close()
+
+	rep := newTestReporter(t, Options{
+		DSN:                strings.Replace(ts.URL, "http://", "http://public@", 1) + "/42",
+		MaxEventsPerMinute: 1,
+		Client:             ts.Client(),
+	})
+	now := time.Now()
+
+	// act
+	rep.Report(httplog.ErrorEvent{Err: errors.New("first"), Time: now})
+	rep.Report(httplog.ErrorEvent{Err: errors.New("second"), Time: now})
+
+	// assert
+	select {
+	case body := <-received:
+		exc := body["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+		if exc["value"] != "first" {
+			t.Errorf("want first event got: %v", exc["value"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't sent")
+	}
+	if got := rep.Dropped(); got != 1 {
+		t.Errorf("dropped want: 1 got: %d", got)
+	}
+}
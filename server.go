@@ -3,12 +3,18 @@ package httplog
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"runtime/pprof"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +35,60 @@ import (
 type Server struct {
 	stopped         int32
 	openConnections int32
+	droppedLogs     int64
+
+	logQueueOnce sync.Once
+	logs         *jobQueue
+
+	dedupOnce sync.Once
+	dedup     *errorDeduper
+
+	ipHashSaltOnce sync.Once
+	ipHashSalt     []byte
+
+	compressionCacheOnce sync.Once
+	compressionCache     *lruCache
+
+	endpointStatsOnce sync.Once
+	stats             *endpointStats
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+
+	jobsMtx sync.Mutex
+	jobs    map[string]*Job
+
+	tasksOnce   sync.Once
+	tasksCtx    context.Context
+	cancelTasks context.CancelFunc
+	tasks       sync.WaitGroup
+
+	errorMappersMtx sync.RWMutex
+	errorMappers    []func(err error) (Response, bool)
+
+	enrichersMtx sync.RWMutex
+	enrichers    []namedEnricher
+
+	interceptorsMtx sync.RWMutex
+	interceptors    []Interceptor
+
+	middlewareMtx sync.Mutex
+	middleware    []Middleware
+
+	recentRequestsOnce sync.Once
+	recent             *recentRequestLog
+
+	stoppingOnce  sync.Once
+	stoppingCh    chan struct{}
+	closeStopping sync.Once
+
+	handlerNamesMtx sync.Mutex
+	handlerNames    map[string]uintptr
+
+	dependenciesMtx     sync.Mutex
+	dependencies        map[string]*dependencyState
+	healthChecks        map[string]*healthCheckState
+	handlerDependencies map[string][]string
 
 	// ShutdownTimeout defines the duration to wait for outstanding requests
 	// to complete before the Shutdown method returns. The default is 30s.
@@ -41,8 +101,245 @@ type Server struct {
 	// how new log entries are created. This field must be set to integrate
 	// with an outside logging package.
 	NewLogEntry func() Entry
+	// OnError is called after the access log is written for requests which
+	// recovered from a panic or returned an error with a 5xx status. It can
+	// be used to forward errors to a reporting service; see the sentry
+	// sub-package.
+	OnError func(ev ErrorEvent)
+	// Sinks receive a copy of each access log record after it's written to
+	// the request's Entry, including fields the handler added to the Entry.
+	// Sinks implementing io.Closer are closed by Shutdown. See NewAsyncSink,
+	// NewBatchSink, NewPublisherSink, and NewPolicySink.
+	Sinks []Sink
+	// LogQueueSize is the maximum number of access log entries waiting to be
+	// written. The default is 4096.
+	LogQueueSize int
+	// LogWorkers is the number of goroutines writing access log entries.
+	// The default is 4.
+	LogWorkers int
+	// LogOverflowPolicy determines what happens when the log queue is full.
+	// The default, OverflowBlock, makes requests wait for space. See
+	// DroppedLogs.
+	LogOverflowPolicy OverflowPolicy
+	// SuppressDuplicateErrors is the window in which repeated errors with
+	// the same fingerprint are logged without a stack trace. A summary with
+	// the number of suppressed duplicates is logged within a quarter of the
+	// window after it ends, or at Shutdown. The default, 0, disables
+	// suppression. See ErrorFingerprint.
+	SuppressDuplicateErrors time.Duration
+	// LogErrorChain adds an error_chain field to the access log listing the
+	// message, type, and stack of each error in a handler error's Unwrap
+	// chain, so the root cause can be indexed apart from the errors
+	// wrapping it. Suppressed duplicates omit it. The default is false. See
+	// ErrorChain.
+	LogErrorChain bool
+	// LogErrorSource adds an error_source field to the access log with the
+	// lines around the top application frame of an error's stack, such as
+	// the line which panicked, when the source is on disk. It's meant for
+	// development and staging. The default is false. See SourceSnippet.
+	LogErrorSource bool
+	// LogErrorStack adds an error_stack field to the access log with the
+	// frames of an error's stack as an array of StackFrame, for tools which
+	// group or symbolicate by frame. The default is false.
+	LogErrorStack bool
+	// StackPaths sets how file paths are rendered in error_stack,
+	// error_chain, and error_source. The default is StackPathTrimmed.
+	StackPaths StackPathFormat
+	// StackDepth limits the number of frames captured with handler errors
+	// and panics. The default, 0, captures the whole stack.
+	StackDepth int
+	// StackCaptureLevel skips capturing the stack of handler errors whose
+	// access log is written below this level, for example LevelError to
+	// skip errors returned with 4xx statuses by endpoints where they're
+	// expected and frequent. The error is still logged, without a stack.
+	// The default, "", captures every error's stack.
+	StackCaptureLevel Level
+	// LogDownstreamCalls adds a downstream_calls field to the access log
+	// summarizing the host, status, and duration of each outbound request
+	// made through Transport with the request's context. The default is
+	// false.
+	LogDownstreamCalls bool
+	// HeaderPolicy sets and removes headers on every response, for example
+	// to set Server or strip X-Powered-By.
+	HeaderPolicy HeaderPolicy
+	// GroupHeaderPolicies are applied after HeaderPolicy to responses from
+	// handlers whose Group matches the key.
+	GroupHeaderPolicies map[string]HeaderPolicy
+	// StrictAcceptEncoding responds with StatusNotAcceptable (406) when a
+	// request's Accept-Encoding excludes identity (for example
+	// "identity;q=0") and none of the listed codings can be produced. When
+	// false, the default, the response is sent uncompressed anyway.
+	StrictAcceptEncoding bool
+	// CompressionCacheSize is the maximum number of bytes held by the cache
+	// of compressed bodies used by handlers with CacheCompressed set. The
+	// default is 32 MiB.
+	CompressionCacheSize int64
+	// Templates renders TemplateResponse bodies.
+	Templates *Templates
+	// PanicResponse creates the response sent when a handler panics, if the
+	// status hasn't been written yet. The status must be 5xx; any other
+	// value is replaced with StatusInternalServerError (500). The default
+	// is an empty 500. See JSONPanicResponse.
+	PanicResponse func(r *http.Request, requestID string) Response
+	// NotFoundResponse creates the response sent by NotFoundHandler. A
+	// status other than 4xx is replaced with StatusNotFound (404). The
+	// default is an empty 404.
+	NotFoundResponse func(r *http.Request, requestID string) Response
+	// MethodNotAllowedResponse creates the response sent to requests whose
+	// method isn't in Handler.Methods. A status other than 4xx is replaced
+	// with StatusMethodNotAllowed (405). The default is an empty 405.
+	MethodNotAllowedResponse func(r *http.Request, requestID string) Response
+	// Instance, when set, adds server_host, instance_id, and region fields
+	// to every access log entry. See DetectInstance.
+	Instance *Instance
+	// SlowThreshold marks requests taking at least this long as slow. Slow
+	// requests are logged with slow=true and, like panics, with runtime
+	// statistics: goroutines, heap_inuse_bytes, gc_count, and
+	// last_gc_pause_ms. The default, 0, disables slow request detection.
+	SlowThreshold time.Duration
+	// StatsWindow is the period covered by TopEndpoints, rounded down to
+	// whole minutes. The default is 5m.
+	StatsWindow time.Duration
+	// Firewall, when set, evaluates request anomaly rules before handlers
+	// run. See NewFirewall.
+	Firewall *Firewall
+	// LogCookies selects request cookies to log under cookies, by name.
+	// Values are hashed or truncated so credentials aren't stored in logs.
+	LogCookies map[string]CookieLogMode
+	// LogResponseHeaders lists response headers to log under
+	// response_headers. Set-Cookie values are always stripped, leaving only
+	// the cookie names.
+	LogResponseHeaders []string
+	// LogCDN logs how a CDN handled requests it forwarded to the origin:
+	// cdn, the provider from CDN-Loop or its request headers;
+	// cdn_cache_status, from Cache-Status, CF-Cache-Status, or X-Cache;
+	// cdn_age, from Age; cdn_request_no_cache when the client bypassed the
+	// cache; cdn_revalidation for conditional requests; and cdn_cacheable
+	// when the response could have been served from the CDN's cache. They
+	// are counted in httplog_cdn_requests_total, so a surge of misses for
+	// cacheable responses stands out. The default is false.
+	LogCDN bool
+	// JobsPath is the path JobStatusHandler is served under, used to build
+	// the status URL of jobs returned with Accepted. The default is "/jobs/".
+	JobsPath string
+	// JobRetention is how long finished jobs are kept. The default is 1h.
+	JobRetention time.Duration
+	// FaultInjector injects faults into requests for resilience testing.
+	// Optional; never set it in production.
+	FaultInjector *FaultInjector
+	// Sampler, when set, writes only a sample of successful requests'
+	// access logs. See NewSampler.
+	Sampler *Sampler
+	// FieldClasses registers the sensitivity class of log fields by name,
+	// for use by policy sinks. Unregistered fields are ClassPublic. See
+	// NewPolicySink.
+	FieldClasses map[string]FieldClass
+	// LogQuery logs the parsed query string as the query field, a map of
+	// parameter names to values. Values of RedactQueryParams are redacted
+	// in both query and uri.
+	LogQuery bool
+	// RedactQueryParams lists query parameters, matched case-insensitively,
+	// whose values are redacted when LogQuery is set. The default is
+	// DefaultRedactedQueryParams.
+	RedactQueryParams []string
+	// MaxConcurrentRequests limits the number of handlers running at once.
+	// Requests over the limit wait for a slot until their context is done,
+	// then receive StatusServiceUnavailable (503). The default, 0, is
+	// unlimited.
+	MaxConcurrentRequests int
+	// TrustedProxies lists the networks of proxies whose Forwarded,
+	// X-Real-IP, X-Forwarded-For, X-Forwarded-Proto, and X-Forwarded-Host
+	// headers are honored. Requests from other addresses are logged with
+	// their connection's address. Forwarded address lists are walked from
+	// the last hop, skipping trusted proxies, to find the client. When
+	// empty, the default, all forwarding headers are trusted for logging,
+	// but RedirectMiddleware and Firewall rate limits ignore them. See
+	// ParseNetworks.
+	TrustedProxies []*net.IPNet
+	// IPAnonymization anonymizes the client addresses written to logs in
+	// ip and remote_addr. When set, host is logged as the anonymized ip
+	// rather than the reverse DNS name. Handlers and Firewall still see
+	// the full address. The default, IPAnonymizeNone, logs them as
+	// received.
+	IPAnonymization IPAnonymization
+	// IPHashSalt keys the hashes of IPAnonymizeHash and of cookies logged
+	// with CookieHash. Share it between instances so a value hashes the
+	// same on each; keep it secret, as IPv4 addresses are few enough to
+	// hash exhaustively. When empty, a random salt is generated on first
+	// use, so hashes differ between instances and restarts.
+	IPHashSalt []byte
+	// IPHashRotation is how often IPAnonymizeHash hashes change, for
+	// example 24h to correlate a client's requests within a day at most.
+	// The default, 0, never rotates.
+	IPHashRotation time.Duration
+	// NoTracking reports whether a request opted out of tracking, for
+	// example with DoNotTrack or a consent cookie. The access logs of such
+	// requests are written with ip and host truncated as by
+	// IPAnonymizeTruncate, without referrer_domain, UTM parameters,
+	// user_agent, cookies, or fields registered as ClassPII in
+	// FieldClasses, and with tracking_opt_out set. Fields a handler adds to
+	// its Entry are only removed from the records passed to Sinks. The
+	// default, nil, tracks every request.
+	NoTracking func(r *http.Request) bool
+	// Outbox publishes domain events emitted by handlers with Emit after
+	// their responses are written. Optional.
+	Outbox *Outbox
+	// HandlerNames sets how Handle treats a Handler with an empty Name or a
+	// Name already registered with the Server for a different Func. The
+	// default, HandlerNamesReject, panics.
+	HandlerNames HandlerNamePolicy
+	// ClientErrorHistory is the number of recent requests remembered so
+	// client error reports can be linked to the handler which served them.
+	// See ReportClientError. The default, 0, remembers none.
+	ClientErrorHistory int
+	// OnCheckpoint is called by Checkpoint with the time since the request
+	// started, for example to add an event to a trace span. Optional.
+	OnCheckpoint func(ctx context.Context, name string, sinceStart time.Duration)
+	// MemoryGuard sheds PriorityLow requests under memory or GC pressure.
+	// Optional.
+	MemoryGuard *MemoryGuard
+	// Anomalies logs handlers whose latency or error rate departs from
+	// their baseline. Optional.
+	Anomalies *AnomalyDetector
+	// AccountResources logs the approximate CPU time and heap allocations
+	// of slow requests as cpu_ms and alloc_bytes, to tell compute-heavy
+	// requests from those waiting on I/O. Go doesn't account either per
+	// goroutine, so they're process-wide deltas over the request, logged
+	// with concurrent_requests for context. Requires SlowThreshold.
+	AccountResources bool
+	// Messages translates the messages of ErrorBody responses using the
+	// request's Accept-Language header. Logs keep the untranslated
+	// message. Optional; see MessageMap.
+	Messages MessageCatalog
+	// BodyPolicies limits and parses request bodies by media type, for
+	// example a small limit for "application/json" and a large one for
+	// "multipart/form-data". Keys may be a subtype wildcard such as
+	// "image/*", or "*/*" for all other types. Requests whose Content-Length
+	// is over the limit are rejected with StatusRequestEntityTooLarge (413)
+	// before the handler is called, and reading other bodies past the limit
+	// fails with *http.MaxBytesError, which responds with 413 if returned.
+	// Both log body_too_large. See DecodeBody.
+	BodyPolicies map[string]BodyPolicy
+	// DependencyPolicies sets when each named dependency is unhealthy.
+	// Requests to handlers depending on an unhealthy dependency are shed.
+	// See ReportDependency and Handler.Dependencies.
+	DependencyPolicies map[string]DependencyPolicy
+}
+
+// ErrorEvent describes a recovered panic or a 5xx handler error. It's passed
+// to Server.OnError.
+type ErrorEvent struct {
+	HandlerName string
+	Request     *http.Request
+	RequestID   string
+	Status      int
+	Err         error
+	Panic       bool
+	Time        time.Time
 }
 
+const requestIDHeader = "X-Request-ID"
+
 const gzipMinLength = 1000
 const gzipCompLevel = gzip.DefaultCompression
 
@@ -58,6 +355,16 @@ var gzipTypes = map[string]bool{
 	"text/plain":             true,
 }
 
+// isCompressible reports whether contentType, ignoring parameters such as
+// charset, is one of gzipTypes.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return gzipTypes[mediaType]
+}
+
 // Entry is implemented by a log entry.
 type Entry interface {
 	AddField(key string, value interface{})
@@ -75,6 +382,75 @@ type Entry interface {
 type Handler struct {
 	Name string
 	Func loggedHandler
+	// Group selects a policy from Server.GroupHeaderPolicies. Optional.
+	Group string
+	// StatusLevels overrides the level the access log is written at for
+	// specific statuses, for example logging expected 401s from a login
+	// handler at LevelInfo. Requests logged at LevelInfo aren't counted as
+	// errors by TopEndpoints. Optional.
+	StatusLevels map[int]Level
+	// Route is the route template the handler is registered under, for
+	// example "/orders/{id}". It's exposed in RequestInfo. Optional.
+	Route string
+	// DefaultStatus is the status used when Func returns a Response with
+	// Status 0, for example StatusCreated (201) for a handler creating
+	// resources. The default is StatusOK (200).
+	DefaultStatus int
+	// Consumes lists the request body media types the handler accepts, for
+	// example "application/json". Requests with a body of any other type
+	// are rejected with StatusUnsupportedMediaType (415) before Func is
+	// called. Optional; by default all types are accepted.
+	Consumes []string
+	// CacheCompressed caches the compressed form of responses which set
+	// Response.Version, so repeated requests for the same version skip
+	// compression. Use it for handlers returning stable content. Bodies
+	// filtered by caller scope or pruned to the fields query parameter
+	// aren't cached.
+	CacheCompressed bool
+	// Priority ranks the handler for load shedding. PriorityLow handlers
+	// are shed under memory pressure; see Server.MemoryGuard.
+	Priority Priority
+	// Deprecated marks the handler deprecated. Responses include the
+	// Deprecation header and, when Sunset is set, the Sunset header; requests
+	// are logged with deprecated_endpoint and counted per route in
+	// httplog_deprecated_requests_total, so it's known when the handler can
+	// be removed.
+	Deprecated bool
+	// Sunset is when a Deprecated handler will be removed. Optional.
+	Sunset time.Time
+	// RequireClientCert responds with StatusForbidden (403) to requests
+	// without a verified TLS client certificate. See MutualTLSConfig.
+	RequireClientCert bool
+	// Attribution logs the utm_source, utm_medium, utm_campaign, utm_term,
+	// and utm_content query parameters and the Referer header's host as
+	// referrer_domain, for marketing attribution on browser-facing
+	// handlers.
+	Attribution bool
+	// Fields lists the dotted JSON field paths, such as "id" or
+	// "address.city", clients may select with the fields query parameter,
+	// for example "?fields=id,address". Successful JSON responses are pruned
+	// to the selected fields before compression; requests selecting other
+	// fields are rejected with StatusBadRequest (400). Optional; by default
+	// the fields parameter is ignored.
+	Fields []string
+	// Methods lists the HTTP methods the handler serves; HEAD is allowed
+	// with GET. Requests with other methods are responded to with
+	// StatusMethodNotAllowed (405) and an Allow header, and logged under
+	// the handler name "__method_not_allowed__". See
+	// Server.MethodNotAllowedResponse. Optional; by default all methods are
+	// served.
+	Methods []string
+	// Dependencies names the dependencies the handler calls, such as
+	// "orders_db". Requests are shed while one is reported unhealthy or its
+	// health check is down; see Server.ReportDependency and
+	// Server.AddHealthCheck. Optional.
+	Dependencies []string
+	// Interceptors modify the handler's Response before it's written, in
+	// order, before those registered with Server.Intercept. Optional.
+	Interceptors []Interceptor
+	// Middleware wraps Func, in order, after the middleware registered with
+	// Server.Use. See Handler.Use. Optional.
+	Middleware []Middleware
 }
 
 type loggedHandler func(r *http.Request, entry Entry) (Response, error)
@@ -84,6 +460,20 @@ type Response struct {
 	Body    interface{}
 	Status  int
 	Headers []Header
+	// DisableCompression prevents the body from being compressed, for
+	// example when it's an already optimized image or encrypted payload.
+	// Bodies which are already gzipped are still sent as-is or decompressed
+	// depending on the client's Accept-Encoding.
+	DisableCompression bool
+	// Version identifies the entity in Body, for example a content hash or
+	// revision. It's the cache key for Handler.CacheCompressed.
+	Version string
+	// Raw writes the response itself, for protocols the buffered Body
+	// can't express, such as custom streaming or a hijacked connection.
+	// Headers are set before it's called and Body and Status are ignored.
+	// It returns the status and body bytes it wrote, which are logged as
+	// http_status and bytes_sent, and an error to log.
+	Raw func(w http.ResponseWriter) (status, bytes int, err error)
 }
 
 // Header contains the name/value pair of a response HTTP header.
@@ -100,36 +490,173 @@ type Header struct {
 //
 // If the Handler panics it's recovered and the server responds with
 // StatusInternalServerError (500). The callstack is also captured and added
-// to the log.
+// to the log. See the PanicResponse field to customize the response body.
 //
 // If the response from Handler is a type other than string or
-// []byte the object is serialized as JSON. See the FormatJSON field.
+// []byte the object is serialized as JSON. See the FormatJSON field. Fields
+// tagged with scope are removed unless the caller has one of their scopes;
+// see SetScopes.
+//
+// Compressible responses are encoded with gzip or deflate as negotiated
+// from the request's Accept-Encoding. See the StrictAcceptEncoding field.
+// []byte bodies which are already gzipped (see IsGzip and GzipBytes) are sent
+// as-is to clients accepting gzip and decompressed for other clients. A
+// Content-Encoding: gzip header set on any other body is dropped and logged
+// as content_encoding_dropped.
+//
+// A TemplateResponse body is rendered with the Server's Templates and sent
+// as text/html; the render time is logged as template_render_ms. A render
+// error responds with StatusInternalServerError (500).
+//
+// A Response with Raw set writes the response itself; the status and byte
+// count it returns are logged, and raw_response is set.
+//
+// A *CSVResponse body is streamed to the client rather than buffered. An
+// error producing rows after the status has been written is logged.
+//
+// Uncompressed []byte bodies with status 200 support requests for a single
+// byte range, responding with StatusPartialContent (206). The requested range
+// is logged as range.
+//
+// A Response status outside of 100-599 is a handler bug: the server responds
+// with StatusInternalServerError (500) and logs the invalid status as
+// invalid_status.
 //
-// Returning an error from Handler does not modify the status code. The
-// error itself will be written to the log.
+// Middleware registered with Use and Handler.Middleware wraps the Handler's
+// Func.
 //
-// After the response has been written to the client WriteHTTPLog is called.
+// Interceptors registered with Intercept and Handler.Interceptors can modify
+// the Response before it's written.
+//
+// A Retry-After response header, such as one set by TooManyRequests or
+// ServiceUnavailable, is logged as retry_after.
+//
+// Returning an error from Handler does not modify the status code unless
+// the error matches a function registered with MapError, or carries an
+// ErrorCode or ErrPreconditionFailed and the Response is empty. The error
+// itself will be written to the log.
+//
+// Each request is assigned an ID, taken from the X-Request-ID request header
+// when present. The ID is returned in the X-Request-ID response header and
+// logged as request_id. The request's context carries the ID and the log
+// Entry; see EntryFromContext, RequestInfoFromContext, and Transport.
+//
+// time_taken is split into queue_ms, from arrival until the handler is
+// called, handler_time, until the handler returns, and write_time, spent
+// encoding and writing the response, so saturation, latency in application
+// code, and slow clients can be told apart. They're exported in
+// httplog_request_queue_duration_seconds, httplog_handler_duration_seconds,
+// and httplog_response_write_duration_seconds. See MaxConcurrentRequests.
+// For the duration of each request the goroutine's runtime/pprof labels
+// handler and route are set, so CPU profiles can be broken down by endpoint.
+//
+// Request and response body sizes are exported in http_request_size_bytes
+// and http_response_size_bytes.
+//
+// Handle exports the Server's settings in httplog_config. Uptime and build
+// information are exported in httplog_uptime_seconds and httplog_build_info;
+// see SetBuildInfo.
+//
+// Handle panics if handler's Name is empty or already registered for a
+// different Func; see HandlerNames. When served by an HTTPServer, a
+// handler which writes to the connection's ResponseWriter directly instead
+// of returning a Response is logged at Warn level or above with
+// direct_write, and its bytes_sent is taken from the connection's writer.
+//
+// After the response has been written to the client the access log is
+// written; see WriteHTTPLog for its fields.
 func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.Request) {
+	svr.checkHandlerName(handler)
+	svr.exportConfig()
+	svr.registerDependencies(handler)
+	svr.startOutbox()
+	handlerFunc := svr.chain(handler)
+
+	var allowed []string
+	var methodNotAllowed func(w http.ResponseWriter, r *http.Request)
+	if len(handler.Methods) > 0 {
+		allowed = allowedMethods(handler.Methods)
+		methodNotAllowed = svr.Handle(methodNotAllowedHandler(handler.Methods))
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		bytesSent := 0
+		if methodNotAllowed != nil && !methodAllowed(allowed, r.Method) {
+			methodNotAllowed(w, r)
+			return
+		}
+
+		bodyBytes := 0
 		status := 0
 		start := time.Now()
-		logEntry := svr.newEntry()
+		var startUsage resourceUsage
+		if svr.AccountResources && svr.SlowThreshold > 0 {
+			startUsage = readResourceUsage()
+		}
+		logEntry := newFieldEntry(svr.newEntry())
+
+		rw := &responseWriter{ResponseWriter: w}
+		w = rw
+		// writes to the connection's writer while the handler runs bypass
+		// the Response
+		cw := connWriter(r.Context())
+		cwWritten := cw != nil && cw.wroteHeader
+		var directWrite, rawResponse bool
+		var rawBytes int
+
+		requestID := getRequestID(r)
+		logEntry.AddField("request_id", requestID)
+		w.Header().Set(requestIDHeader, requestID)
+		if handler.Deprecated {
+			for _, hdr := range deprecationHeaders(time.Time{}, handler.Sunset) {
+				w.Header().Set(hdr.Name, hdr.Value)
+			}
+		}
+
+		state := newRequestState(svr, handler, r, requestID, logEntry)
+		if state.parentRequestID != "" {
+			logEntry.AddField("parent_request_id", state.parentRequestID)
+		}
+		if state.depth > 0 {
+			logEntry.AddField("request_depth", state.depth)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestStateKey, state))
+
+		// label CPU profile samples with the endpoint; goroutines started
+		// by the handler inherit the labels
+		restoreCtx := r.Context()
+		r = r.WithContext(pprof.WithLabels(r.Context(), pprof.Labels("handler", handler.Name, "route", state.info.Route)))
+		pprof.SetGoroutineLabels(r.Context())
+		defer pprof.SetGoroutineLabels(restoreCtx)
+		if handler.Deprecated {
+			logEntry.AddField("deprecated_endpoint", true)
+			deprecatedRequestsTotal.WithLabelValues(handler.Name, state.info.Route).Inc()
+		}
+		requestBytes := countRequestBody(r)
 
 		var decOpenConnections bool
+		var panicked bool
 		var err error
+		var handlerStart, handlerDone time.Time
+
+		writeHeader := func(code int) {
+			svr.applyHeaderPolicies(handler.Group, w.Header())
+			w.WriteHeader(code)
+		}
 
 		defer func() {
 			if perr := recover(); perr != nil {
+				panicked = true
 				status = http.StatusInternalServerError
-				w.WriteHeader(status)
+				if !rw.wroteHeader {
+					status, bodyBytes = svr.writePanicResponse(w, r, requestID, writeHeader)
+				}
 
 				var ok bool
 				var panicErr error
 				if panicErr, ok = perr.(error); !ok {
 					panicErr = fmt.Errorf("%v", perr)
 				}
-				panicErr = withStack(panicErr)
+				panicErr = svr.withStack(panicErr, LevelError)
 				if err == nil {
 					err = panicErr
 				} else {
@@ -138,8 +665,46 @@ func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.R
 				}
 			}
 
-			duration := time.Since(start)
-			go WriteHTTPLog(handler.Name, logEntry, r, duration, status, bytesSent, err)
+			if retryAfter, ok := retryAfterField(w.Header()); ok {
+				logEntry.AddField("retry_after", retryAfter)
+			}
+
+			rl := requestLog{
+				handler:      handler,
+				entry:        logEntry,
+				r:            r,
+				requestID:    requestID,
+				state:        state,
+				start:        start,
+				duration:     time.Since(start),
+				handlerStart: handlerStart,
+				handlerDone:  handlerDone,
+				status:       status,
+				requestBytes: requestBytes(),
+				bytesSent:    rw.bytes,
+				bodyBytes:    bodyBytes,
+				encoded:      w.Header().Get("Content-Encoding") != "",
+				err:          err,
+				panicked:     panicked,
+				headers:      svr.responseHeaderFields(w.Header()),
+				cdn:          svr.cdnFields(r, status, w.Header()),
+				directWrite:  directWrite,
+			}
+			if directWrite {
+				rl.bytesSent = cw.bytes
+			}
+			if rawResponse {
+				rl.bytesSent = rawBytes
+			}
+			svr.finishOutbox(state, status, panicked)
+			svr.recentRequests().add(requestID, recentRequest{handler: handler.Name, status: status, time: time.Now()})
+			if svr.AccountResources && svr.SlowThreshold > 0 && rl.duration >= svr.SlowThreshold {
+				rl.usage = [2]resourceUsage{startUsage, readResourceUsage()}
+				rl.concurrent = atomic.LoadInt32(&svr.openConnections)
+			}
+			if !svr.logQueue().push(func() { svr.writeLog(rl) }) {
+				svr.writeLog(rl)
+			}
 
 			if decOpenConnections {
 				atomic.AddInt32(&svr.openConnections, -1)
@@ -149,33 +714,231 @@ func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.R
 		// stopped
 		if atomic.LoadInt32(&svr.stopped) == 1 {
 			status = http.StatusServiceUnavailable
+			writeHeader(status)
+			return
+		}
+
+		if svr.shed(handler, logEntry, w) || svr.shedDependencies(r.Context(), handler, logEntry, w) {
+			status = http.StatusServiceUnavailable
+			writeHeader(status)
 			return
 		}
 
 		decOpenConnections = true
 		atomic.AddInt32(&svr.openConnections, 1)
 
-		httpResponse, err := handler.Func(r, logEntry)
-		err = withStack(err)
+		if matched, rejectStatus, retryAfter := svr.Firewall.evaluate(r, svr.firewallClientIP(r, state.info)); len(matched) > 0 {
+			logEntry.AddField("waf_rules", matched)
+			if rejectStatus != 0 {
+				if retryAfter > 0 {
+					hdr := RetryAfter(retryAfter)
+					w.Header().Set(hdr.Name, hdr.Value)
+				}
+				status = rejectStatus
+				writeHeader(status)
+				return
+			}
+		}
+
+		if fault := svr.FaultInjector.pick(handler.Name, r); fault != nil {
+			logEntry.AddField("fault_injected", fault.String())
+			if faultStatus, handled := injectFault(fault, w, r, writeHeader); handled {
+				status = faultStatus
+				return
+			}
+		}
+
+		if handler.RequireClientCert && state.info.ClientCert == nil {
+			logEntry.AddField("client_cert_missing", true)
+			status = http.StatusForbidden
+			writeHeader(status)
+			return
+		}
+
+		if !acceptsContentType(handler.Consumes, r) {
+			logEntry.AddField("unsupported_content_type", r.Header.Get("Content-Type"))
+			status = http.StatusUnsupportedMediaType
+			writeHeader(status)
+			return
+		}
+
+		if !svr.limitBody(w, r) {
+			logEntry.AddField("body_too_large", true)
+			status = http.StatusRequestEntityTooLarge
+			writeHeader(status)
+			return
+		}
+
+		var fields []string
+		if len(handler.Fields) > 0 {
+			var invalidFields []string
+			fields, invalidFields = requestedFields(r, handler.Fields)
+			if len(invalidFields) > 0 {
+				logEntry.AddField("invalid_fields", invalidFields)
+				status = http.StatusBadRequest
+				writeHeader(status)
+				return
+			}
+			if len(fields) > 0 {
+				logEntry.AddField("fields", fields)
+			}
+		}
+
+		release, ok := svr.acquireSlot(r.Context())
+		queued := time.Since(start)
+		logEntry.AddField("queue_ms", int64(queued/time.Millisecond))
+		requestQueueDuration.WithLabelValues(handler.Name).Observe(queued.Seconds())
+		if !ok {
+			hdr := RetryAfter(time.Second)
+			w.Header().Set(hdr.Name, hdr.Value)
+			status = http.StatusServiceUnavailable
+			writeHeader(status)
+			return
+		}
+		defer release()
+
+		handlerStart = time.Now()
+		httpResponse, err := handlerFunc(r, logEntry)
+		handlerDone = time.Now()
+
+		if cw != nil && !cwWritten && cw.wroteHeader {
+			directWrite = true
+			status = cw.status
+			err = svr.withStack(err, handler.statusLevel(status))
+			return
+		}
+
+		if err != nil {
+			code, hasCode := errorCodeOf(err)
+			if hasCode {
+				logEntry.AddField("error_code", code.Code)
+			}
+			preconditionFailed := errors.Is(err, ErrPreconditionFailed)
+			if preconditionFailed {
+				logEntry.AddField("precondition_failed", true)
+			}
+			var maxBytesErr *http.MaxBytesError
+			bodyTooLarge := errors.As(err, &maxBytesErr)
+			if bodyTooLarge {
+				logEntry.AddField("body_too_large", true)
+			}
+			if mapped, ok := svr.mapError(err); ok {
+				httpResponse = mapped
+			} else if hasCode && httpResponse.Status == 0 && httpResponse.Body == nil {
+				body, lang := svr.localizedErrorBody(r, code, requestID)
+				httpResponse = Response{Status: code.Status, Body: body}
+				if svr.Messages != nil {
+					httpResponse.Headers = append(httpResponse.Headers, Header{Name: "Vary", Value: "Accept-Language"})
+				}
+				if lang != "" {
+					httpResponse.Headers = append(httpResponse.Headers, Header{Name: "Content-Language", Value: lang})
+				}
+			} else if preconditionFailed && httpResponse.Status == 0 && httpResponse.Body == nil {
+				httpResponse.Status = http.StatusPreconditionFailed
+			} else if bodyTooLarge && httpResponse.Status == 0 && httpResponse.Body == nil {
+				httpResponse.Status = http.StatusRequestEntityTooLarge
+			}
+		}
+
+		httpResponse, err = svr.intercept(handler, r, logEntry, httpResponse, err)
 
 		resp := httpResponse.Body
 		status = httpResponse.Status
 		headers := httpResponse.Headers
 
 		if status == 0 {
-			status = 200
+			status = handler.DefaultStatus
+			if status == 0 {
+				status = http.StatusOK
+			}
+		}
+		err = svr.withStack(err, handler.statusLevel(status))
+
+		if status < 100 || status > 599 {
+			logEntry.AddField("invalid_status", status)
+			if err == nil {
+				err = svr.withStack(fmt.Errorf("handler %q returned invalid status code %d", handler.Name, status), LevelError)
+			}
+			status = http.StatusInternalServerError
+			writeHeader(status)
+			return
 		}
 
 		for _, hdr := range headers {
 			w.Header().Add(hdr.Name, hdr.Value)
 		}
 
+		if httpResponse.Raw != nil {
+			logEntry.AddField("raw_response", true)
+			svr.applyHeaderPolicies(handler.Group, w.Header())
+			var rawStatus int
+			var rawErr error
+			rawStatus, rawBytes, rawErr = httpResponse.Raw(w)
+			status = rawStatus
+			if status == 0 {
+				status = rw.status
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+			rawResponse = true
+			if err == nil {
+				err = svr.withStack(rawErr, handler.statusLevel(status))
+			}
+			return
+		}
+
+		if ms, ok := resp.(*MultiStatus); ok {
+			logEntry.AddFields(ms.logFields())
+		}
+
+		if accepted, ok := resp.(*JobAccepted); ok {
+			logEntry.AddField("job_id", accepted.JobID)
+			if accepted.StatusURL == "" {
+				accepted.StatusURL = svr.jobsPath() + accepted.JobID
+			}
+			w.Header().Set("Location", accepted.StatusURL)
+		}
+
 		if resp == nil {
-			w.WriteHeader(status)
+			writeHeader(status)
 			return
 		}
 
+		if csvResp, ok := resp.(*CSVResponse); ok {
+			csvResp.setHeaders(w.Header())
+			n, streamErr := writeStream(w, r, !httpResponse.DisableCompression, writeHeader, status, csvResp.stream)
+			bodyBytes = n
+			if err == nil {
+				err = svr.withStack(streamErr, handler.statusLevel(status))
+			}
+			return
+		}
+
+		if tmplResp, ok := resp.(*TemplateResponse); ok {
+			resp = *tmplResp
+		}
+		if tmplResp, ok := resp.(TemplateResponse); ok {
+			renderStart := time.Now()
+			rendered, renderErr := svr.renderTemplate(tmplResp)
+			logEntry.AddField("template_render_ms", int64(time.Since(renderStart)/time.Millisecond))
+			if renderErr != nil {
+				status = http.StatusInternalServerError
+				if err == nil {
+					err = svr.withStack(renderErr, handler.statusLevel(status))
+				}
+				writeHeader(status)
+				return
+			}
+			resp = rendered
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+
 		var body []byte
+		var bodyIsBytes bool
+		// callerSpecific marks bodies filtered for the caller, which can't
+		// be shared through the compression cache
+		var callerSpecific bool
 		if respString, ok := resp.(string); ok {
 			body = []byte(respString)
 			if w.Header().Get("Content-Type") == "" {
@@ -183,6 +946,7 @@ func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.R
 			}
 		} else if respBytes, ok := resp.([]byte); ok {
 			body = respBytes
+			bodyIsBytes = true
 		} else {
 			var marshalErr error
 			if svr.FormatJSON {
@@ -190,6 +954,20 @@ func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.R
 			} else {
 				body, marshalErr = json.Marshal(resp)
 			}
+			if tree := scopeTreeFor(resp); tree != nil && marshalErr == nil {
+				scopes := state.callerScopes()
+				var filtered []string
+				body, filtered, marshalErr = tree.filterJSON(body, resp, scopes, svr.FormatJSON)
+				callerSpecific = true
+				logEntry.AddField("response_scopes", scopes)
+				if len(filtered) > 0 {
+					logEntry.AddField("scope_filtered", filtered)
+				}
+			}
+			if marshalErr == nil && len(fields) > 0 && status >= 200 && status < 300 {
+				body, marshalErr = pruneJSON(body, fields, svr.FormatJSON)
+				callerSpecific = true
+			}
 			if marshalErr != nil {
 				panic(marshalErr)
 			}
@@ -197,59 +975,125 @@ func (svr *Server) Handle(handler Handler) func(w http.ResponseWriter, r *http.R
 		}
 
 		if len(body) == 0 {
-			w.WriteHeader(status)
+			writeHeader(status)
 			return
 		}
 
-		bodyHasGzipMagicHeader := len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b
+		// bodies with the gzip magic header are already compressed; a
+		// Content-Encoding: gzip set on any other body is dropped rather
+		// than mislabeling it
+		bodyIsGzipped := IsGzip(body)
+		if !bodyIsGzipped && strings.EqualFold(w.Header().Get("Content-Encoding"), "gzip") {
+			logEntry.AddField("content_encoding_dropped", "gzip")
+			w.Header().Del("Content-Encoding")
+		}
+
+		compress := !httpResponse.DisableCompression
+
+		// serve partial content for a single byte range of an uncompressed
+		// []byte body
+		if bodyIsBytes && !bodyIsGzipped && status == http.StatusOK {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && (r.Method == "GET" || r.Method == "HEAD") {
+				logEntry.AddField("range", rangeHeader)
+				start, end, valid, satisfiable := parseByteRange(rangeHeader, len(body))
+				if valid && !satisfiable {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+					status = http.StatusRequestedRangeNotSatisfiable
+					writeHeader(status)
+					return
+				}
+				if valid {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+					status = http.StatusPartialContent
+					body = body[start : end+1]
+					compress = false
+				}
+			}
+		}
 
-		writeBody := func() (int, error) {
-			return w.Write(body)
+		bodyBytes = len(body)
+		writeBody := func() error {
+			_, err := w.Write(body)
+			return err
 		}
 
-		gzipOK := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
-		if bodyHasGzipMagicHeader {
-			if !gzipOK {
+		accepted := parseAcceptEncoding(r.Header)
+		if bodyIsGzipped {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if accepted.quality("gzip") > 0 {
+				w.Header().Set("Content-Encoding", "gzip")
+				bodyBytes = gzipSize(body)
+			} else {
+				if svr.StrictAcceptEncoding && accepted.quality("identity") <= 0 {
+					status = http.StatusNotAcceptable
+					writeHeader(status)
+					return
+				}
+
 				w.Header().Del("Content-Encoding")
 
 				buf := bytes.NewBuffer(body)
 				reader, newReaderErr := gzip.NewReader(buf)
 				if newReaderErr != nil {
-					panic(newReaderErr)
+					status = http.StatusInternalServerError
+					if err == nil {
+						err = svr.withStack(newReaderErr, LevelError)
+					}
+					writeHeader(status)
+					return
 				}
-				writeBody = func() (int, error) {
+				writeBody = func() error {
 					n, localErr := io.Copy(w, reader)
+					bodyBytes = int(n)
 					closeErr := reader.Close()
 					if localErr == nil && closeErr != nil {
 						localErr = closeErr
 					}
-					return int(n), localErr
+					return localErr
 				}
-			} else {
-				w.Header().Set("Content-Encoding", "gzip")
 			}
-		} else if gzipOK && len(body) > gzipMinLength && gzipTypes[w.Header().Get("Content-Type")] {
-			w.Header().Set("Content-Encoding", "gzip")
+		} else if compress && len(body) > gzipMinLength && isCompressible(w.Header().Get("Content-Type")) {
+			w.Header().Add("Vary", "Accept-Encoding")
 
-			wc := &writeCounter{writer: w}
-			gzipWriter, newWriterErr := gzip.NewWriterLevel(wc, gzipCompLevel)
-			if newWriterErr != nil {
-				panic(newWriterErr)
+			coding, ok := accepted.negotiate()
+			if !ok && svr.StrictAcceptEncoding {
+				status = http.StatusNotAcceptable
+				writeHeader(status)
+				return
 			}
-			writeBody = func() (int, error) {
-				_, localErr := gzipWriter.Write(body)
-				closeErr := gzipWriter.Close()
-				if localErr == nil && closeErr != nil {
-					localErr = closeErr
+
+			if ok && coding != "identity" && handler.CacheCompressed && httpResponse.Version != "" && !callerSpecific {
+				w.Header().Set("Content-Encoding", coding)
+
+				compressed, compressErr := svr.compressCached(handler.Name, httpResponse.Version, coding, body)
+				if compressErr != nil {
+					panic(compressErr)
+				}
+				writeBody = func() error {
+					_, err := w.Write(compressed)
+					return err
+				}
+			} else if ok && coding != "identity" {
+				w.Header().Set("Content-Encoding", coding)
+
+				encoder, newWriterErr := newEncoder(coding, w)
+				if newWriterErr != nil {
+					panic(newWriterErr)
+				}
+				writeBody = func() error {
+					_, localErr := encoder.Write(body)
+					closeErr := encoder.Close()
+					if localErr == nil && closeErr != nil {
+						localErr = closeErr
+					}
+					return localErr
 				}
-				return wc.count, localErr
 			}
 		}
 
-		w.WriteHeader(status)
-		n, writeBodyErr := writeBody()
-		bytesSent = n
-		if writeBodyErr != nil {
+		writeHeader(status)
+		if writeBodyErr := writeBody(); writeBodyErr != nil {
 			panic(writeBodyErr)
 		}
 	}
@@ -267,9 +1111,12 @@ func (c *writeCounter) Write(p []byte) (int, error) {
 }
 
 // Shutdown attempts a graceful shutdown, waiting for outstanding connections
-// to complete. See ShutdownTimeout.
+// to complete, pending Outbox events to be published, and queued access log
+// entries to be written. Open WebSocket connections are signalled through
+// WebSocketConn.Done. See ShutdownTimeout.
 func (svr *Server) Shutdown() {
 	atomic.StoreInt32(&svr.stopped, 1)
+	svr.closeStopping.Do(func() { close(svr.stopping()) })
 
 	deadlineTimeout := svr.ShutdownTimeout
 	if deadlineTimeout == 0 {
@@ -298,6 +1145,211 @@ loop:
 			break loop
 		}
 	}
+	ticker.Stop()
+
+	svr.drainOutbox(deadlineTimeout)
+
+	if !svr.stopTasks(deadlineTimeout) {
+		svr.newEntry().Errorf("stop deadline %v exceeded; abandoning background tasks", deadlineTimeout)
+	}
+
+	if !svr.logQueue().close(deadlineTimeout) {
+		svr.newEntry().Errorf("stop deadline %v exceeded; abandoning queued log entries", deadlineTimeout)
+	}
+
+	svr.flushSuppressed()
+
+	svr.closeSinks()
+}
+
+// requestLog holds the values collected while serving a request which are
+// needed to write the access log.
+type requestLog struct {
+	handler   Handler
+	entry     Entry
+	r         *http.Request
+	requestID string
+	state     *requestState
+	start     time.Time
+	duration  time.Duration
+	// handlerStart and handlerDone are when the handler was called and
+	// returned; handlerDone is zero if it didn't return.
+	handlerStart time.Time
+	handlerDone  time.Time
+	status       int
+	requestBytes int64
+	bytesSent    int
+	bodyBytes    int
+	encoded      bool
+	err          error
+	panicked     bool
+	headers      map[string]string
+	cdn          map[string]interface{}
+	// directWrite is set when the handler wrote to the connection's
+	// ResponseWriter instead of returning a Response.
+	directWrite bool
+	// usage holds the resource usage at the start and end of slow requests
+	// when AccountResources is set, along with the requests in flight.
+	usage      [2]resourceUsage
+	concurrent int32
+}
+
+func (svr *Server) writeLog(rl requestLog) {
+	observeRequest(rl.handler.Name, rl.r.Method, rl.status, rl.duration)
+	observeSizes(rl.handler.Name, rl.r.Method, rl.requestBytes, rl.bytesSent)
+	noTracking := svr.NoTracking != nil && svr.NoTracking(rl.r)
+	// anonymized addresses and those of requests which opted out of
+	// tracking aren't resolved, which would send the full address to the
+	// resolver and keep it in the host cache
+	resolveHost := svr.IPAnonymization == IPAnonymizeNone && !noTracking
+	rec := newAccessRecord(rl.handler.Name, rl.r, rl.duration, rl.status, rl.bytesSent, rl.err, resolveHost)
+	if !resolveHost {
+		ip := svr.logIP(rl.state.info.ClientIP)
+		rec.Fields["ip"] = ip
+		rec.Fields["host"] = ip
+	}
+	svr.Instance.addFields(rec.Fields)
+	svr.addQueryFields(rec.Fields, rl.r)
+	if rl.handler.Attribution {
+		addAttributionFields(rec.Fields, rl.r)
+	}
+	svr.enrich(rl.r.Context(), rl.state.info, rec.Fields)
+	if !rl.handlerDone.IsZero() {
+		handlerTime := rl.handlerDone.Sub(rl.handlerStart)
+		writeTime := rl.duration - rl.handlerDone.Sub(rl.start)
+		rec.Fields["handler_time"] = int64(handlerTime / time.Millisecond)
+		rec.Fields["write_time"] = int64(writeTime / time.Millisecond)
+		handlerDuration.WithLabelValues(rl.handler.Name).Observe(handlerTime.Seconds())
+		responseWriteDuration.WithLabelValues(rl.handler.Name).Observe(writeTime.Seconds())
+	}
+	if rl.bodyBytes > 0 {
+		rec.Fields["body_bytes_uncompressed"] = rl.bodyBytes
+		if rl.encoded && rl.bytesSent > 0 {
+			compressionRatio.WithLabelValues(rl.handler.Name).Observe(float64(rl.bodyBytes) / float64(rl.bytesSent))
+		}
+	}
+	slow := svr.SlowThreshold > 0 && rl.duration >= svr.SlowThreshold
+	if slow {
+		rec.Fields["slow"] = true
+	}
+	if slow || rl.panicked {
+		addRuntimeStats(rec.Fields)
+	}
+	if slow && svr.AccountResources {
+		addResourceFields(rec.Fields, rl.usage[0], rl.usage[1], rl.concurrent)
+	}
+	rec.Level = rl.handler.statusLevel(rl.status)
+	if reason := rl.state.escalationReason(); reason != "" {
+		rec.Level = LevelError
+		rec.Fields["escalation_reason"] = reason
+	}
+	if rl.directWrite {
+		rec.Fields["direct_write"] = true
+		if levelRank(rec.Level) < levelRank(LevelWarn) {
+			rec.Level = LevelWarn
+		}
+	}
+	observeValidation(rec, rl.err)
+	if cookies := svr.cookieFields(rl.r); len(cookies) > 0 {
+		rec.Fields["cookies"] = cookies
+	}
+	if len(rl.headers) > 0 {
+		rec.Fields["response_headers"] = rl.headers
+	}
+	for k, v := range rl.cdn {
+		rec.Fields[k] = v
+	}
+	if calls := rl.state.downstreamCalls(); len(calls) > 0 {
+		rec.Fields["downstream_calls"] = calls
+	}
+	if checkpoints := rl.state.checkpointTimings(); len(checkpoints) > 0 {
+		rec.Fields["checkpoints"] = checkpoints
+	}
+	rl.state.addDBFields(rec.Fields)
+	rl.state.addCacheFields(rec.Fields)
+	rl.state.addCostFields(rec.Fields)
+	if noTracking {
+		svr.minimizeFields(rec.Fields, rl.state.info)
+	}
+	svr.suppressDuplicate(rec)
+	if svr.LogErrorStack && rec.Err != nil {
+		if frames := ErrorStackPaths(rec.Err, svr.StackPaths); len(frames) > 0 {
+			rec.Fields["error_stack"] = frames
+		}
+	}
+	if svr.LogErrorChain && rec.Err != nil {
+		rec.Fields["error_chain"] = errorChain(rec.Err, svr.StackPaths)
+	}
+	if svr.LogErrorSource && rec.Err != nil {
+		if snippet, ok := errorSource(rec.Err, svr.StackPaths); ok {
+			rec.Fields["error_source"] = snippet
+		}
+	}
+	keep := true
+	if rec.Level == LevelInfo {
+		var rate float64
+		if rate, keep = svr.Sampler.sample(rl.r, rl.requestID); rate < 1 {
+			rec.Fields["sample_rate"] = rate
+		}
+	}
+	if keep {
+		rec.write(rl.entry)
+	}
+	svr.endpointStats().observe(rl.handler.Name, rec.Level != LevelInfo, rl.duration, rl.start)
+	if anomalies := svr.Anomalies.observe(rl.handler.Name, rl.duration, rec.Level == LevelError, time.Now()); len(anomalies) > 0 {
+		svr.reportAnomalies(rl.handler.Name, anomalies)
+	}
+	if keep && len(svr.Sinks) > 0 {
+		// Sinks also receive the fields handlers added to the Entry.
+		if fe, ok := rl.entry.(*fieldEntry); ok {
+			rec.Fields = fe.snapshot()
+			if noTracking {
+				svr.minimizeFields(rec.Fields, rl.state.info)
+			}
+		}
+		rec.Classes = svr.classify(rec.Fields)
+		svr.writeSinks(rec)
+	}
+
+	onError := svr.OnError
+	if onError != nil && rl.err != nil && (rl.panicked || rl.status >= 500) {
+		onError(ErrorEvent{
+			HandlerName: rl.handler.Name,
+			Request:     rl.r,
+			RequestID:   rl.requestID,
+			Status:      rl.status,
+			Err:         rl.err,
+			Panic:       rl.panicked,
+			Time:        rl.start,
+		})
+	}
+}
+
+// getRequestID returns the request's X-Request-ID header, or a new random ID
+// if the header is missing or unreasonably long.
+// maxRequestIDLength is the longest X-Request-ID or X-Parent-Request-ID
+// accepted from clients, so they can't inflate log entries.
+const maxRequestIDLength = 128
+
+func getRequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// validRequestID reports whether a client-supplied request ID can be logged.
+func validRequestID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLength
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
 }
 
 func (svr *Server) newEntry() Entry {
@@ -312,75 +1364,143 @@ func (svr *Server) newEntry() Entry {
 
 // WriteHTTPLog writes the following keys to the log entry:
 //
-//   bytes_sent           The number of bytes sent in the HTTP response body.
-//   host                 The remote host name. If the host name cannot be resolved, IP is repeated here.
-//   http_status          The HTTP status code returned.
-//   ip                   The remote IP address.
-//   method               GET, POST, PUT, DELETE, etc
-//   time_taken           The time taken to complete the request in milliseconds, including writing to the client.
-//   uri                  The request URI.
+//	bytes_sent           The number of bytes sent in the HTTP response body,
+//	                     after compression. Handle also logs
+//	                     body_bytes_uncompressed, the size before
+//	                     compression, when it's known.
+//	error_fingerprint    A hash of the error's type and stack, when an error occurred. See ErrorFingerprint.
+//	host                 The remote host name. If the host name cannot be resolved, IP is repeated here.
+//	http_status          The HTTP status code returned.
+//	ip                   The remote IP address.
+//	method               GET, POST, PUT, DELETE, etc
+//	time_taken           The time taken to complete the request in milliseconds, including writing to the client.
+//	uri                  The request URI.
 //
 // The log level is determined by the status code:
 //
-//   status < 400          Info
-//   400 <= status < 500   Warning
-//   status >= 500         Error
+//	status < 400          Info
+//	400 <= status < 500   Warning
+//	status >= 500         Error
 //
-// This function is invoked by Server's Handle method.
+// Handle writes the access log itself; calling WriteHTTPLog for a request
+// served by Handle logs a warning instead of a second access log.
 func WriteHTTPLog(handlerName string, entry Entry, r *http.Request, duration time.Duration, status int, bytesSent int, err error) {
-	timeTakenSecs := float64(duration) / 1e9
+	if state := getRequestState(r.Context()); state != nil {
+		// Handle writes the access log when the handler returns
+		warning := state.svr.newEntry()
+		warning.AddFields(map[string]interface{}{
+			"handler":    state.handlerName,
+			"request_id": state.requestID,
+		})
+		warning.Warn("WriteHTTPLog called for a request served by Handle")
+		return
+	}
+	observeRequest(handlerName, r.Method, status, duration)
+	rec := newAccessRecord(handlerName, r, duration, status, bytesSent, err, true)
+	rec.write(entry)
+}
 
-	labelValues := []string{strconv.Itoa(status), handlerName, r.Method}
+func observeRequest(handlerName, method string, status int, duration time.Duration) {
+	labelValues := []string{strconv.Itoa(status), handlerName, method}
 	httpRequestsTotal.WithLabelValues(labelValues...).Inc()
-	httpRequestDurationCounter.WithLabelValues(labelValues...).Observe(timeTakenSecs)
+	httpRequestDurationCounter.WithLabelValues(labelValues...).Observe(duration.Seconds())
+}
 
-	var host string
+// AccessRecord is a single access log record. Records are written to the
+// request's Entry and passed to each of the Server's Sinks.
+type AccessRecord struct {
+	Time    time.Time
+	Handler string
+	Level   Level
+	Message string
+	Fields  map[string]interface{}
+	Err     error
+	// Classes holds the class of each field which isn't ClassPublic. See
+	// Server.FieldClasses.
+	Classes map[string]FieldClass
+}
 
-	ip := r.Header.Get("X-Real-IP")
-	if ip == "" {
-		forwardedFor := r.Header.Get("X-Forwarded-For")
-		ip = strings.SplitN(forwardedFor, ",", 2)[0]
-		if ip == "" {
-			var splitErr error
-			ip, _, splitErr = net.SplitHostPort(r.RemoteAddr)
-			if splitErr != nil {
-				ip = r.RemoteAddr
-				host = r.RemoteAddr
-			}
-		}
+// newAccessRecord returns the access log record of a request. host is the
+// reverse DNS name of the client IP if resolveHost is set, or the IP.
+func newAccessRecord(handlerName string, r *http.Request, duration time.Duration, status int, bytesSent int, err error, resolveHost bool) *AccessRecord {
+	timeTakenSecs := float64(duration) / 1e9
+
+	info := requestInfo(r)
+	host := info.ClientIP
+	if resolveHost {
+		host = getHostFromIP(info.ClientIP)
 	}
 
-	if host == "" {
-		host = getHostFromIP(ip)
+	rec := &AccessRecord{
+		Time:    time.Now(),
+		Handler: handlerName,
+		Level:   statusLevel(status),
+		Message: http.StatusText(status),
+		Fields: map[string]interface{}{
+			"bytes_sent":  bytesSent,
+			"host":        host,
+			"http_status": status,
+			"ip":          info.ClientIP,
+			"method":      r.Method,
+			"time_taken":  int64(timeTakenSecs * 1000),
+			"uri":         r.RequestURI,
+		},
+		Err: err,
+	}
+	if info.Forwarded {
+		rec.Fields["scheme"] = info.Scheme
+		rec.Fields["original_host"] = info.Host
+	}
+	if cc := info.ClientCert; cc != nil {
+		rec.Fields["client_cert_subject"] = cc.Subject
+		rec.Fields["client_cert_fingerprint"] = cc.Fingerprint
+	}
+	if err != nil {
+		rec.Fields["error_fingerprint"] = ErrorFingerprint(err)
 	}
+	return rec
+}
 
-	entry.AddFields(map[string]interface{}{
-		"bytes_sent":  bytesSent,
-		"host":        host,
-		"http_status": status,
-		"ip":          ip,
-		"method":      r.Method,
-		"time_taken":  int64(timeTakenSecs * 1000),
-		"uri":         r.RequestURI,
-	})
+func (rec *AccessRecord) write(entry Entry) {
+	entry.AddFields(rec.Fields)
 
-	msg := http.StatusText(status)
-	if err != nil {
-		entry.AddError(err)
+	if rec.Err != nil {
+		entry.AddError(rec.Err)
 	}
 
-	if status >= 400 && status < 500 {
-		entry.Warn(msg)
-	} else if status >= 500 {
-		entry.Error(msg)
-	} else {
-		entry.Info(msg)
+	switch rec.Level {
+	case LevelWarn:
+		entry.Warn(rec.Message)
+	case LevelError:
+		entry.Error(rec.Message)
+	default:
+		entry.Info(rec.Message)
 	}
 }
 
+// MarshalJSON encodes the record as a flat JSON object. Fields are written
+// alongside the time, level, msg, handler, and err keys.
+func (rec *AccessRecord) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(rec.Fields)+5)
+	for k, v := range rec.Fields {
+		m[k] = v
+	}
+	m["time"] = rec.Time.Format(time.RFC3339Nano)
+	m["level"] = rec.Level
+	m["msg"] = rec.Message
+	m["handler"] = rec.Handler
+	if rec.Err != nil {
+		m["err"] = rec.Err.Error()
+	}
+	return json.Marshal(m)
+}
+
 var ipHost map[string]string
 var ipHostMtx sync.RWMutex
 
+// lookupAddr is net.LookupAddr, replaced in tests.
+var lookupAddr = net.LookupAddr
+
 func init() {
 	ipHost = make(map[string]string)
 }
@@ -392,7 +1512,7 @@ func getHostFromIP(ip string) string {
 	ipHostMtx.RUnlock()
 
 	if !ok {
-		names, lookupErr := net.LookupAddr(ip)
+		names, lookupErr := lookupAddr(ip)
 		if lookupErr != nil || len(names) == 0 {
 			entry = ip
 		} else {
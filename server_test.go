@@ -2,14 +2,20 @@ package httplog
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"runtime/pprof"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestHandler(t *testing.T) {
@@ -29,10 +35,10 @@ func TestHandler(t *testing.T) {
 
 	jsonString := string(uncompressedJSONBytes)
 
-	compressedJSONBytes := []byte{0x1f, 0x8b, 0x8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xff, 0xaa, 0x56, 0x4a, 0xcb, 0x4c, 0xcd,
-		0x49, 0x31, 0x54, 0xb2, 0x52, 0x4a, 0x1c, 0x5, 0xa3, 0x60, 0x14, 0xc, 0x7b, 0xa0, 0xa4, 0x3, 0xc9, 0xf3, 0x46,
-		0x4a, 0x56, 0x25, 0x45, 0xa5, 0xa9, 0xb5, 0x80, 0x0, 0x0, 0x0, 0xff, 0xff, 0x60, 0x63, 0x6d, 0xf6, 0x3, 0x4,
-		0x0, 0x0}
+	compressedJSONBytes, err := GzipBytes(uncompressedJSONBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	type clientCase struct {
 		AcceptEncoding          string
@@ -168,3 +174,575 @@ func (*nullLogger) Warnf(format string, args ...interface{})        {}
 func (*nullLogger) Error(args ...interface{})                       {}
 func (*nullLogger) Errorf(format string, args ...interface{})       {}
 func (*nullLogger) Write(level, format string, args ...interface{}) {}
+
+func TestHandlerRange(t *testing.T) {
+	// arrange
+	body := []byte("0123456789")
+
+	cases := []struct {
+		Range                string
+		ExpectedStatus       int
+		ExpectedBody         string
+		ExpectedContentRange string
+	}{
+		{"", 200, "0123456789", ""},
+		{"bytes=2-5", 206, "2345", "bytes 2-5/10"},
+		{"bytes=7-", 206, "789", "bytes 7-9/10"},
+		{"bytes=-3", 206, "789", "bytes 7-9/10"},
+		{"bytes=5-100", 206, "56789", "bytes 5-9/10"},
+		{"bytes=10-", 416, "", "bytes */10"},
+		{"bytes=0-1,4-5", 200, "0123456789", ""},
+		{"items=0-1", 200, "0123456789", ""},
+	}
+
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	defer s.Shutdown()
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Body: body}, nil
+	}}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.Handle(handler)))
+	defer ts.Close()
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Range != "" {
+			req.Header.Set("Range", c.Range)
+		}
+
+		// act
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// assert
+		if resp.StatusCode != c.ExpectedStatus {
+			t.Errorf("Range %q: status want: %d got: %d", c.Range, c.ExpectedStatus, resp.StatusCode)
+		}
+		if string(b) != c.ExpectedBody {
+			t.Errorf("Range %q: body want: %q got: %q", c.Range, c.ExpectedBody, b)
+		}
+		if got := resp.Header.Get("Content-Range"); got != c.ExpectedContentRange {
+			t.Errorf("Range %q: Content-Range want: %q got: %q", c.Range, c.ExpectedContentRange, got)
+		}
+	}
+}
+
+func TestHandlerPanicResponse(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.PanicResponse = JSONPanicResponse
+	defer s.Shutdown()
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		panic("secret detail")
+	}}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.Handle(handler)))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIDHeader, "abc123")
+
+	// act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status want: %d got: %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	expected := `{"error":"internal server error","request_id":"abc123"}`
+	if string(b) != expected {
+		t.Errorf("body want: %s got: %s", expected, b)
+	}
+}
+
+func TestHandlerBytesSent(t *testing.T) {
+	body := []byte(strings.Repeat("compressible ", 200))
+	gzipped, err := GzipBytes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name           string
+		Body           []byte
+		AcceptEncoding string
+		Wire           int
+	}{
+		{"decompress stored gzip", gzipped, "", len(body)},
+		{"stored gzip", gzipped, "gzip", len(gzipped)},
+		{"compress", body, "gzip", -1},
+		{"identity", body, "", len(body)},
+	}
+
+	for _, c := range cases {
+		// arrange
+		sink := &recordingSink{}
+		var s Server
+		s.NewLogEntry = func() Entry { return &nullLogger{} }
+		s.Sinks = []Sink{sink}
+		handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+			return Response{Body: c.Body}, nil
+		}}
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.AcceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", c.AcceptEncoding)
+		}
+		w := httptest.NewRecorder()
+
+		// act
+		s.Handle(handler)(w, r)
+		s.Shutdown()
+
+		// assert
+		fields := sink.records[0].Fields
+		wire := c.Wire
+		if wire == -1 {
+			wire = w.Body.Len()
+		}
+		if fields["bytes_sent"] != w.Body.Len() || w.Body.Len() != wire {
+			t.Errorf("%s: bytes_sent want: %d got: %v (body %d)", c.Name, wire, fields["bytes_sent"], w.Body.Len())
+		}
+		if fields["body_bytes_uncompressed"] != len(body) {
+			t.Errorf("%s: body_bytes_uncompressed want: %d got: %v", c.Name, len(body), fields["body_bytes_uncompressed"])
+		}
+	}
+}
+
+func TestHandlerMaxConcurrentRequests(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.MaxConcurrentRequests = 1
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	handler := s.Handle(Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		started <- struct{}{}
+		<-unblock
+		return Response{}, nil
+	}})
+
+	go handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+
+	// act
+	handler(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	close(unblock)
+	s.Shutdown()
+
+	// assert
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status want: %d got: %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestHandlerProfileLabels(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	var handlerLabel, routeLabel string
+	handler := Handler{Name: "orders", Route: "/orders/{id}", Func: func(r *http.Request, _ Entry) (Response, error) {
+		handlerLabel, _ = pprof.Label(r.Context(), "handler")
+		routeLabel, _ = pprof.Label(r.Context(), "route")
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/1", nil))
+	s.Shutdown()
+
+	// assert
+	if handlerLabel != "orders" || routeLabel != "/orders/{id}" {
+		t.Errorf("labels want: orders /orders/{id} got: %q %q", handlerLabel, routeLabel)
+	}
+}
+
+func TestHandlerAccountResources(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.SlowThreshold = time.Nanosecond
+	s.AccountResources = true
+	var keep [][]byte
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		for i := 0; i < 100; i++ {
+			keep = append(keep, make([]byte, 1024))
+		}
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	fields := sink.records[0].Fields
+	// the runtime flushes per-P allocation counts lazily; allow for some
+	// unflushed bytes
+	if allocs, _ := fields["alloc_bytes"].(uint64); allocs < 50*1024 {
+		t.Errorf("alloc_bytes want: >= %d got: %v", 50*1024, fields["alloc_bytes"])
+	}
+	if _, ok := fields["concurrent_requests"]; !ok {
+		t.Error("want concurrent_requests")
+	}
+	_ = keep
+}
+
+func TestRawResponse(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{
+			Headers: []Header{{Name: "Content-Type", Value: "application/x-custom"}},
+			Raw: func(w http.ResponseWriter) (int, int, error) {
+				w.WriteHeader(http.StatusPartialContent)
+				n, _ := w.Write([]byte("frame"))
+				return http.StatusPartialContent, n, errors.New("client went away")
+			},
+		}, nil
+	}}
+	rec := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(rec, httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "frame" || rec.Header().Get("Content-Type") != "application/x-custom" {
+		t.Errorf("unexpected response %d %s %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	record := sink.records[0]
+	if record.Fields["raw_response"] != true || record.Fields["bytes_sent"] != 5 || record.Fields["http_status"] != http.StatusPartialContent {
+		t.Errorf("unexpected fields %v", record.Fields)
+	}
+	if record.Err == nil {
+		t.Error("want error logged")
+	}
+}
+
+func TestHandlerGzipLabel(t *testing.T) {
+	plain := []byte("not gzip")
+	corrupt := []byte{0x1f, 0x8b, 0xff, 0xff}
+
+	cases := []struct {
+		Name                    string
+		Body                    []byte
+		ContentEncoding         string
+		AcceptEncoding          string
+		ExpectedStatus          int
+		ExpectedContentEncoding string
+		ExpectedBody            []byte
+	}{
+		{"mislabeled, no gzip support", plain, "gzip", "", 200, "", plain},
+		{"mislabeled, gzip support", plain, "gzip", "gzip", 200, "", plain},
+		{"corrupt gzip, no gzip support", corrupt, "", "", 500, "", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				resp := Response{Body: c.Body}
+				if c.ContentEncoding != "" {
+					resp.Headers = []Header{{Name: "Content-Encoding", Value: c.ContentEncoding}}
+				}
+				return resp, nil
+			}}
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.AcceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", c.AcceptEncoding)
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, r)
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.ExpectedStatus {
+				t.Errorf("status want: %d got: %d", c.ExpectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != c.ExpectedContentEncoding {
+				t.Errorf("Content-Encoding want: %q got: %q", c.ExpectedContentEncoding, got)
+			}
+			if !bytes.Equal(w.Body.Bytes(), c.ExpectedBody) {
+				t.Errorf("body want: %q got: %q", c.ExpectedBody, w.Body.Bytes())
+			}
+			if c.ExpectedStatus == 500 && sink.records[0].Err == nil {
+				t.Error("want error logged")
+			}
+		})
+	}
+}
+
+func TestHandlerDisableCompression(t *testing.T) {
+	text := []byte(strings.Repeat("compressible ", 200))
+	gzipped, err := GzipBytes(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := func() func() ([]string, error) {
+		ch := make(chan []string, 200)
+		for i := 0; i < 200; i++ {
+			ch <- []string{"compressible", "row"}
+		}
+		close(ch)
+		return CSVRowsFromChannel(ch)
+	}
+
+	cases := []struct {
+		name               string
+		body               func() interface{}
+		disableCompression bool
+		acceptEncoding     string
+		wantEncoding       string
+		wantBody           []byte
+	}{
+		{name: "compressed", body: func() interface{} { return text }, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "disabled", body: func() interface{} { return text }, disableCompression: true, acceptEncoding: "gzip", wantBody: text},
+		{name: "stored gzip sent as-is", body: func() interface{} { return gzipped }, disableCompression: true, acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: gzipped},
+		{name: "stored gzip decompressed", body: func() interface{} { return gzipped }, disableCompression: true, wantBody: text},
+		{name: "csv compressed", body: func() interface{} { return &CSVResponse{Rows: rows()} }, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "csv disabled", body: func() interface{} { return &CSVResponse{Rows: rows()} }, disableCompression: true, acceptEncoding: "gzip"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{
+					Body:               c.body(),
+					Headers:            []Header{{"Content-Type", "text/plain"}},
+					DisableCompression: c.disableCompression,
+				}, nil
+			}}
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", c.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, r)
+
+			// assert
+			if got := w.Header().Get("Content-Encoding"); got != c.wantEncoding {
+				t.Errorf("Content-Encoding want: %q got: %q", c.wantEncoding, got)
+			}
+			if c.wantBody != nil && !bytes.Equal(c.wantBody, w.Body.Bytes()) {
+				t.Errorf("body want: %d bytes got: %d bytes", len(c.wantBody), w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestHandlerDefaultStatus(t *testing.T) {
+	handlerErr := errors.New("boom")
+
+	cases := []struct {
+		name          string
+		defaultStatus int
+		status        int
+		err           error
+		wantStatus    int
+		wantInvalid   interface{}
+		wantErr       string
+	}{
+		{name: "default ok", wantStatus: http.StatusOK},
+		{name: "handler default", defaultStatus: http.StatusCreated, wantStatus: http.StatusCreated},
+		{name: "explicit status wins", defaultStatus: http.StatusCreated, status: http.StatusAccepted, wantStatus: http.StatusAccepted},
+		{name: "invalid default", defaultStatus: 42, wantStatus: http.StatusInternalServerError, wantInvalid: 42, wantErr: `handler "test" returned invalid status code 42`},
+		{name: "too low", status: 99, wantStatus: http.StatusInternalServerError, wantInvalid: 99, wantErr: `handler "test" returned invalid status code 99`},
+		{name: "too high", status: 600, wantStatus: http.StatusInternalServerError, wantInvalid: 600, wantErr: `handler "test" returned invalid status code 600`},
+		{name: "invalid keeps handler error", status: 600, err: handlerErr, wantStatus: http.StatusInternalServerError, wantInvalid: 600, wantErr: "boom"},
+		{name: "lowest valid", status: 100, wantStatus: 100},
+		{name: "highest valid", status: 599, wantStatus: 599},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			handler := Handler{Name: "test", DefaultStatus: c.defaultStatus, Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{Status: c.status}, c.err
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.wantStatus {
+				t.Errorf("status want: %d got: %d", c.wantStatus, w.Code)
+			}
+			rec := sink.records[0]
+			if got := rec.Fields["invalid_status"]; got != c.wantInvalid {
+				t.Errorf("invalid_status want: %v got: %v", c.wantInvalid, got)
+			}
+			var gotErr string
+			if rec.Err != nil {
+				gotErr = rec.Err.Error()
+			}
+			if gotErr != c.wantErr {
+				t.Errorf("error want: %q got: %q", c.wantErr, gotErr)
+			}
+		})
+	}
+}
+
+// slowWriter delays each write to the client.
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestHandlerTimes(t *testing.T) {
+	cases := []struct {
+		name         string
+		handlerDelay time.Duration
+		writeDelay   time.Duration
+	}{
+		{name: "fast"},
+		{name: "slow handler", handlerDelay: 30 * time.Millisecond},
+		{name: "slow client", writeDelay: 30 * time.Millisecond},
+		{name: "both slow", handlerDelay: 30 * time.Millisecond, writeDelay: 30 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			name := "times " + c.name
+			handler := Handler{Name: name, Func: func(_ *http.Request, _ Entry) (Response, error) {
+				time.Sleep(c.handlerDelay)
+				return Response{Body: "ok"}, nil
+			}}
+			w := &slowWriter{ResponseRecorder: httptest.NewRecorder(), delay: c.writeDelay}
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			fields := sink.records[0].Fields
+			handlerTime, _ := fields["handler_time"].(int64)
+			writeTime, _ := fields["write_time"].(int64)
+			if min := int64(c.handlerDelay / time.Millisecond); handlerTime < min || handlerTime >= min+25 {
+				t.Errorf("handler_time want: ~%d got: %v", min, fields["handler_time"])
+			}
+			if min := int64(c.writeDelay / time.Millisecond); writeTime < min || writeTime >= min+25 {
+				t.Errorf("write_time want: ~%d got: %v", min, fields["write_time"])
+			}
+			if _, ok := fields["queue_ms"]; !ok {
+				t.Error("want queue_ms logged")
+			}
+			for metric, o := range map[string]prometheus.Observer{
+				"httplog_request_queue_duration_seconds":  requestQueueDuration.WithLabelValues(name),
+				"httplog_handler_duration_seconds":        handlerDuration.WithLabelValues(name),
+				"httplog_response_write_duration_seconds": responseWriteDuration.WithLabelValues(name),
+			} {
+				if count, _ := histogramValue(t, o); count != 1 {
+					t.Errorf("%s count want: 1 got: %d", metric, count)
+				}
+			}
+			if _, sum := histogramValue(t, handlerDuration.WithLabelValues(name)); sum < c.handlerDelay.Seconds() {
+				t.Errorf("httplog_handler_duration_seconds sum want >= %v got: %v", c.handlerDelay.Seconds(), sum)
+			}
+			if _, sum := histogramValue(t, responseWriteDuration.WithLabelValues(name)); sum < c.writeDelay.Seconds() {
+				t.Errorf("httplog_response_write_duration_seconds sum want >= %v got: %v", c.writeDelay.Seconds(), sum)
+			}
+		})
+	}
+}
+
+func TestHandlerResponseController(t *testing.T) {
+	// arrange
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	var deadlineErr, duplexErr error
+	handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Raw: func(w http.ResponseWriter) (int, int, error) {
+			rc := http.NewResponseController(w)
+			deadlineErr = rc.SetWriteDeadline(time.Now().Add(time.Minute))
+			duplexErr = rc.EnableFullDuplex()
+			w.WriteHeader(http.StatusNoContent)
+			return http.StatusNoContent, 0, nil
+		}}, nil
+	}}
+	ts := httptest.NewServer(http.HandlerFunc(s.Handle(handler)))
+	defer ts.Close()
+
+	// act
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	s.Shutdown()
+
+	// assert
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status want: %d got: %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if deadlineErr != nil {
+		t.Errorf("SetWriteDeadline want: <nil> got: %v", deadlineErr)
+	}
+	if duplexErr != nil {
+		t.Errorf("EnableFullDuplex want: <nil> got: %v", duplexErr)
+	}
+}
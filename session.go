@@ -0,0 +1,435 @@
+package httplog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSessionMaxAge = 24 * time.Hour
+
+// memorySessionSweepInterval is how often MemorySessionStore.Save removes
+// expired sessions.
+const memorySessionSweepInterval = time.Minute
+
+type sessionContextKey struct{}
+
+// Session holds per-client values across requests. See Sessions.
+type Session struct {
+	// ID identifies the session. It's logged hashed as session_id.
+	ID string
+
+	mtx       sync.Mutex
+	values    map[string]interface{}
+	expires   time.Time
+	changed   bool
+	destroyed bool
+}
+
+// Get returns the value stored under key, or nil.
+func (s *Session) Get(key string) interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.values[key]
+}
+
+// Set stores value under key. Values must be JSON serializable for stores
+// which encode sessions.
+func (s *Session) Set(key string, value interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.values, key)
+	s.changed = true
+}
+
+// Destroy removes the session from its store and expires the client's
+// cookie when the handler returns.
+func (s *Session) Destroy() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.destroyed = true
+}
+
+// Expires returns the time the session expires.
+func (s *Session) Expires() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.expires
+}
+
+func (s *Session) snapshot() sessionData {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	values := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return sessionData{ID: s.ID, Values: values, Expires: s.expires.Unix()}
+}
+
+// sessionData is the serialized form of a Session.
+type sessionData struct {
+	ID      string                 `json:"id"`
+	Values  map[string]interface{} `json:"values"`
+	Expires int64                  `json:"exp"`
+}
+
+func (d *sessionData) session() *Session {
+	if d.Values == nil {
+		d.Values = make(map[string]interface{})
+	}
+	return &Session{ID: d.ID, values: d.Values, expires: time.Unix(d.Expires, 0)}
+}
+
+// SessionFromContext returns the Session for a request handled by a handler
+// wrapped with Sessions.Wrap, or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// SessionStore loads and saves sessions. The cookie value sent to the client
+// is chosen by the store: server-side stores send the session ID, the cookie
+// store sends the session itself.
+type SessionStore interface {
+	// Load returns the session for a cookie value, or nil if it doesn't
+	// exist or has expired.
+	Load(ctx context.Context, cookie string) (*Session, error)
+	// Save persists s until s.Expires() and returns the cookie value to
+	// send to the client.
+	Save(ctx context.Context, s *Session) (cookie string, err error)
+	// Delete removes s.
+	Delete(ctx context.Context, s *Session) error
+}
+
+// Sessions loads a Session before a handler runs and saves it afterward.
+// Use Wrap on each handler which uses sessions, and SessionFromContext to
+// access the Session.
+//
+// The session ID is logged as session_id, hashed so it can be used to follow
+// a user's requests without exposing the ID.
+type Sessions struct {
+	Store SessionStore
+	// CookieName is the name of the session cookie. The default is
+	// "session".
+	CookieName string
+	// MaxAge is how long a session lasts after it's last saved. The default
+	// is 24h.
+	MaxAge time.Duration
+	// Secure restricts the cookie to HTTPS.
+	Secure bool
+}
+
+// Wrap returns a copy of h which loads the request's Session before calling
+// h.Func and saves it afterward if it was changed. Load errors are logged as
+// session_error and a new Session is started; save errors are returned.
+func (ss *Sessions) Wrap(h Handler) Handler {
+	next := h.Func
+	h.Func = func(r *http.Request, entry Entry) (Response, error) {
+		var sess *Session
+		if c, err := r.Cookie(ss.cookieName()); err == nil {
+			sess, err = ss.Store.Load(r.Context(), c.Value)
+			if err != nil {
+				entry.AddField("session_error", err.Error())
+			}
+		}
+
+		isNew := sess == nil
+		if isNew {
+			sess = &Session{ID: newSessionID(), values: make(map[string]interface{})}
+		}
+		entry.AddField("session_id", hashValue(sess.ID))
+
+		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sess))
+		resp, err := next(r, entry)
+
+		cookie, saveErr := ss.save(r.Context(), sess, isNew)
+		if cookie != nil {
+			resp.Headers = append(resp.Headers, Header{"Set-Cookie", cookie.String()})
+		}
+		if saveErr != nil && err == nil {
+			err = saveErr
+		}
+		return resp, err
+	}
+	return h
+}
+
+func (ss *Sessions) save(ctx context.Context, sess *Session, isNew bool) (*http.Cookie, error) {
+	sess.mtx.Lock()
+	destroyed, changed := sess.destroyed, sess.changed
+	sess.mtx.Unlock()
+
+	cookie := &http.Cookie{
+		Name:     ss.cookieName(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   ss.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if destroyed {
+		if isNew {
+			return nil, nil
+		}
+		cookie.MaxAge = -1
+		if err := ss.Store.Delete(ctx, sess); err != nil {
+			return cookie, fmt.Errorf("deleting session: %v", err)
+		}
+		return cookie, nil
+	}
+
+	// new sessions are only saved once they hold a value
+	if !changed {
+		return nil, nil
+	}
+
+	maxAge := ss.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSessionMaxAge
+	}
+
+	sess.mtx.Lock()
+	sess.expires = time.Now().Add(maxAge)
+	sess.changed = false
+	sess.mtx.Unlock()
+
+	value, err := ss.Store.Save(ctx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("saving session: %v", err)
+	}
+	cookie.Value = value
+	cookie.MaxAge = int(maxAge / time.Second)
+	return cookie, nil
+}
+
+func (ss *Sessions) cookieName() string {
+	if ss.CookieName == "" {
+		return "session"
+	}
+	return ss.CookieName
+}
+
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// hashValue returns a short, stable hash of value for logging secrets such
+// as session IDs.
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// MemorySessionStore keeps sessions in memory. Sessions are lost when the
+// process exits and aren't shared between instances.
+type MemorySessionStore struct {
+	mtx       sync.Mutex
+	sessions  map[string]sessionData
+	nextSweep int64
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]sessionData)}
+}
+
+// Load implements SessionStore.
+func (m *MemorySessionStore) Load(ctx context.Context, cookie string) (*Session, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	d, ok := m.sessions[cookie]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().Unix() >= d.Expires {
+		delete(m.sessions, cookie)
+		return nil, nil
+	}
+	// Copy values so concurrent requests don't share a map.
+	values := make(map[string]interface{}, len(d.Values))
+	for k, v := range d.Values {
+		values[k] = v
+	}
+	d.Values = values
+	return d.session(), nil
+}
+
+// Save implements SessionStore. Expired sessions are removed at most once a
+// minute, so the cost of the sweep is spread across saves.
+func (m *MemorySessionStore) Save(ctx context.Context, s *Session) (string, error) {
+	d := s.snapshot()
+	now := time.Now().Unix()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if now >= m.nextSweep {
+		m.sweep(now)
+		m.nextSweep = now + int64(memorySessionSweepInterval/time.Second)
+	}
+	m.sessions[d.ID] = d
+	return d.ID, nil
+}
+
+// sweep removes sessions expired at now. The caller must hold m.mtx.
+func (m *MemorySessionStore) sweep(now int64) {
+	for id, other := range m.sessions {
+		if now >= other.Expires {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// Delete implements SessionStore.
+func (m *MemorySessionStore) Delete(ctx context.Context, s *Session) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.sessions, s.ID)
+	return nil
+}
+
+// CookieSessionStore keeps sessions in the cookie itself, signed with
+// HMAC-SHA256 so clients can't modify them. Values are visible to the
+// client; don't store secrets. Browsers limit cookies to about 4 KiB.
+type CookieSessionStore struct {
+	key []byte
+}
+
+// NewCookieSessionStore returns a CookieSessionStore which signs cookies
+// with key. The key should be at least 32 random bytes.
+func NewCookieSessionStore(key []byte) *CookieSessionStore {
+	return &CookieSessionStore{key: key}
+}
+
+// Load implements SessionStore. A cookie with an invalid signature returns
+// an error.
+func (c *CookieSessionStore) Load(ctx context.Context, cookie string) (*Session, error) {
+	i := strings.LastIndexByte(cookie, '.')
+	if i < 0 {
+		return nil, errors.New("malformed session cookie")
+	}
+	payload, sig := cookie[:i], cookie[i+1:]
+
+	want := c.sign(payload)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return nil, errors.New("invalid session cookie signature")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var d sessionData
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= d.Expires {
+		return nil, nil
+	}
+	return d.session(), nil
+}
+
+// Save implements SessionStore.
+func (c *CookieSessionStore) Save(ctx context.Context, s *Session) (string, error) {
+	b, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + c.sign(payload), nil
+}
+
+// Delete implements SessionStore. Cookie sessions are removed by expiring
+// the cookie, so Delete does nothing.
+func (c *CookieSessionStore) Delete(ctx context.Context, s *Session) error {
+	return nil
+}
+
+func (c *CookieSessionStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RedisClient is the subset of a Redis client used by RedisSessionStore.
+// Adapt a client library to it; Get should return a nil slice and no error
+// when the key doesn't exist.
+type RedisClient interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+}
+
+// RedisSessionStore keeps sessions in Redis as JSON, keyed by session ID
+// with a TTL matching the session's expiry.
+type RedisSessionStore struct {
+	Client RedisClient
+	// Prefix is prepended to session IDs to form keys. The default is
+	// "session:".
+	Prefix string
+}
+
+// Load implements SessionStore.
+func (rs *RedisSessionStore) Load(ctx context.Context, cookie string) (*Session, error) {
+	b, err := rs.Client.Get(ctx, rs.key(cookie))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var d sessionData
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	if d.ID != cookie || time.Now().Unix() >= d.Expires {
+		return nil, nil
+	}
+	return d.session(), nil
+}
+
+// Save implements SessionStore.
+func (rs *RedisSessionStore) Save(ctx context.Context, s *Session) (string, error) {
+	d := s.snapshot()
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	if err := rs.Client.Set(ctx, rs.key(d.ID), b, time.Until(s.Expires())); err != nil {
+		return "", err
+	}
+	return d.ID, nil
+}
+
+// Delete implements SessionStore.
+func (rs *RedisSessionStore) Delete(ctx context.Context, s *Session) error {
+	return rs.Client.Del(ctx, rs.key(s.ID))
+}
+
+func (rs *RedisSessionStore) key(id string) string {
+	if rs.Prefix == "" {
+		return "session:" + id
+	}
+	return rs.Prefix + id
+}
@@ -0,0 +1,357 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieSessionStore(t *testing.T) {
+	// arrange
+	store := NewCookieSessionStore([]byte("0123456789abcdef0123456789abcdef"))
+	sess := &Session{ID: "abc", values: map[string]interface{}{"user": "u1"}, expires: time.Now().Add(time.Hour)}
+
+	cookie, err := store.Save(context.Background(), sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	loaded, err := store.Load(context.Background(), cookie)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || loaded.ID != "abc" || loaded.Get("user") != "u1" {
+		t.Errorf("loaded session want: id abc user u1 got: %+v", loaded)
+	}
+
+	tampered := "x" + cookie[1:]
+	if _, err := store.Load(context.Background(), tampered); err == nil {
+		t.Error("want error loading tampered cookie")
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	// arrange
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+	sess := &Session{ID: "abc", values: map[string]interface{}{"user": "u1"}, expires: time.Now().Add(time.Hour)}
+
+	// act
+	cookie, err := store.Save(ctx, sess)
+	loaded, loadErr := store.Load(ctx, cookie)
+	missing, _ := store.Load(ctx, "other")
+
+	// assert
+	if err != nil || loadErr != nil {
+		t.Fatal(err, loadErr)
+	}
+	if cookie != "abc" {
+		t.Errorf("cookie want: abc got: %s", cookie)
+	}
+	if loaded == nil || loaded.Get("user") != "u1" {
+		t.Fatalf("loaded session want: user u1 got: %+v", loaded)
+	}
+	if missing != nil {
+		t.Errorf("want nil for unknown session got: %+v", missing)
+	}
+
+	// changes to a loaded session aren't visible until saved
+	loaded.Set("user", "u2")
+	again, _ := store.Load(ctx, cookie)
+	if again.Get("user") != "u1" {
+		t.Errorf("want stored value unchanged got: %v", again.Get("user"))
+	}
+
+	if err := store.Delete(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := store.Load(ctx, cookie); deleted != nil {
+		t.Errorf("want nil after Delete got: %+v", deleted)
+	}
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	now := time.Now().Unix()
+
+	cases := []struct {
+		name      string
+		nextSweep int64
+		wantSwept bool
+	}{
+		{name: "sweep due", nextSweep: now, wantSwept: true},
+		{name: "sweep not due", nextSweep: now + 60, wantSwept: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			store := NewMemorySessionStore()
+			store.sessions["expired"] = sessionData{ID: "expired", Expires: now - 1}
+			store.nextSweep = c.nextSweep
+			sess := &Session{ID: "live", values: map[string]interface{}{}, expires: time.Now().Add(time.Hour)}
+
+			// act
+			_, err := store.Save(context.Background(), sess)
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, kept := store.sessions["expired"]
+			if kept == c.wantSwept {
+				t.Errorf("expired session swept want: %v got: %v", c.wantSwept, !kept)
+			}
+			if c.wantSwept && store.nextSweep <= now {
+				t.Errorf("want next sweep scheduled got: %d", store.nextSweep)
+			}
+			if loaded, _ := store.Load(context.Background(), "expired"); loaded != nil {
+				t.Errorf("want expired session not loaded got: %+v", loaded)
+			}
+		})
+	}
+}
+
+type fakeRedis struct {
+	data map[string][]byte
+	ttl  map[string]time.Duration
+	err  error
+}
+
+func (f *fakeRedis) Get(ctx context.Context, key string) ([]byte, error) {
+	return f.data[key], f.err
+}
+
+func (f *fakeRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.data[key] = value
+	f.ttl[key] = ttl
+	return nil
+}
+
+func (f *fakeRedis) Del(ctx context.Context, key string) error {
+	delete(f.data, key)
+	return f.err
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	cases := []struct {
+		name    string
+		prefix  string
+		wantKey string
+	}{
+		{name: "default prefix", wantKey: "session:abc"},
+		{name: "custom prefix", prefix: "app:sess:", wantKey: "app:sess:abc"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			client := &fakeRedis{data: map[string][]byte{}, ttl: map[string]time.Duration{}}
+			store := &RedisSessionStore{Client: client, Prefix: c.prefix}
+			ctx := context.Background()
+			sess := &Session{ID: "abc", values: map[string]interface{}{"user": "u1"}, expires: time.Now().Add(time.Hour)}
+
+			// act
+			cookie, err := store.Save(ctx, sess)
+			loaded, loadErr := store.Load(ctx, cookie)
+
+			// assert
+			if err != nil || loadErr != nil {
+				t.Fatal(err, loadErr)
+			}
+			if _, ok := client.data[c.wantKey]; !ok {
+				t.Fatalf("key want: %s got: %v", c.wantKey, client.data)
+			}
+			if ttl := client.ttl[c.wantKey]; ttl <= 59*time.Minute || ttl > time.Hour {
+				t.Errorf("ttl want: ~1h got: %v", ttl)
+			}
+			if loaded == nil || loaded.ID != "abc" || loaded.Get("user") != "u1" {
+				t.Errorf("loaded session want: id abc user u1 got: %+v", loaded)
+			}
+
+			if err := store.Delete(ctx, sess); err != nil {
+				t.Fatal(err)
+			}
+			if deleted, _ := store.Load(ctx, cookie); deleted != nil {
+				t.Errorf("want nil after Delete got: %+v", deleted)
+			}
+		})
+	}
+}
+
+func TestRedisSessionStoreLoad(t *testing.T) {
+	redisErr := errors.New("connection refused")
+
+	cases := []struct {
+		name    string
+		stored  string
+		err     error
+		wantErr error
+	}{
+		{name: "expired", stored: `{"id":"abc","exp":1}`},
+		{name: "id mismatch", stored: `{"id":"other","exp":9999999999}`},
+		{name: "missing"},
+		{name: "client error", err: redisErr, wantErr: redisErr},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			client := &fakeRedis{data: map[string][]byte{}, err: c.err}
+			if c.stored != "" {
+				client.data["session:abc"] = []byte(c.stored)
+			}
+			store := &RedisSessionStore{Client: client}
+
+			// act
+			loaded, err := store.Load(context.Background(), "abc")
+
+			// assert
+			if loaded != nil {
+				t.Errorf("want nil session got: %+v", loaded)
+			}
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("error want: %v got: %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+// failingStore returns err from every SessionStore method.
+type failingStore struct {
+	err error
+}
+
+func (f *failingStore) Load(ctx context.Context, cookie string) (*Session, error) {
+	return nil, f.err
+}
+
+func (f *failingStore) Save(ctx context.Context, s *Session) (string, error) {
+	return "", f.err
+}
+
+func (f *failingStore) Delete(ctx context.Context, s *Session) error {
+	return f.err
+}
+
+func TestSessionsWrap(t *testing.T) {
+	cases := []struct {
+		name        string
+		stored      map[string]interface{}
+		handler     func(s *Session)
+		wantCookie  string
+		wantUser    interface{}
+		wantDeleted bool
+	}{
+		{
+			name:       "new session unchanged",
+			handler:    func(s *Session) {},
+			wantCookie: "",
+		},
+		{
+			name:       "new session set",
+			handler:    func(s *Session) { s.Set("user", "u1") },
+			wantCookie: "sid=",
+			wantUser:   "u1",
+		},
+		{
+			name:       "existing session read",
+			stored:     map[string]interface{}{"user": "u1"},
+			handler:    func(s *Session) {},
+			wantCookie: "",
+			wantUser:   "u1",
+		},
+		{
+			name:        "existing session destroyed",
+			stored:      map[string]interface{}{"user": "u1"},
+			handler:     func(s *Session) { s.Destroy() },
+			wantCookie:  "sid=; Path=/; Max-Age=0",
+			wantDeleted: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			store := NewMemorySessionStore()
+			ss := &Sessions{Store: store, CookieName: "sid"}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.stored != nil {
+				_, _ = store.Save(context.Background(), &Session{ID: "existing", values: c.stored, expires: time.Now().Add(time.Hour)})
+				r.AddCookie(&http.Cookie{Name: "sid", Value: "existing"})
+			}
+			var sessID string
+			h := ss.Wrap(Handler{Func: func(r *http.Request, entry Entry) (Response, error) {
+				sess := SessionFromContext(r.Context())
+				sessID = sess.ID
+				c.handler(sess)
+				return Response{}, nil
+			}})
+			entry := newFieldEntry(&nullLogger{})
+
+			// act
+			resp, err := h.Func(r, entry)
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cookie string
+			for _, hdr := range resp.Headers {
+				if hdr.Name == "Set-Cookie" {
+					cookie = hdr.Value
+				}
+			}
+			if c.wantCookie == "" && cookie != "" || !strings.HasPrefix(cookie, c.wantCookie) {
+				t.Errorf("Set-Cookie want prefix: %q got: %q", c.wantCookie, cookie)
+			}
+			if got := entry.fields["session_id"]; got != hashValue(sessID) {
+				t.Errorf("session_id want: %s got: %v", hashValue(sessID), got)
+			}
+			if c.stored != nil && sessID != "existing" {
+				t.Errorf("want existing session loaded got: %s", sessID)
+			}
+			loaded, _ := store.Load(context.Background(), sessID)
+			if c.wantDeleted {
+				if loaded != nil {
+					t.Errorf("want session deleted got: %+v", loaded)
+				}
+			} else if c.wantUser != nil && (loaded == nil || loaded.Get("user") != c.wantUser) {
+				t.Errorf("stored user want: %v got: %+v", c.wantUser, loaded)
+			}
+		})
+	}
+}
+
+func TestSessionsWrapStoreErrors(t *testing.T) {
+	// arrange
+	storeErr := errors.New("store down")
+	ss := &Sessions{Store: &failingStore{err: storeErr}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	h := ss.Wrap(Handler{Func: func(r *http.Request, entry Entry) (Response, error) {
+		SessionFromContext(r.Context()).Set("user", "u1")
+		return Response{}, nil
+	}})
+	entry := newFieldEntry(&nullLogger{})
+
+	// act
+	_, err := h.Func(r, entry)
+
+	// assert
+	if got := entry.fields["session_error"]; got != "store down" {
+		t.Errorf("session_error want: store down got: %v", got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "saving session: store down") {
+		t.Errorf("want save error got: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package httplog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	// arrange
+	key := []byte("secret")
+	v := &SignatureVerifier{Key: func(keyID string) ([]byte, error) {
+		if keyID == "k1" {
+			return key, nil
+		}
+		return nil, nil
+	}}
+
+	body := []byte(`{"event":"created"}`)
+	newRequest := func(keyID string, sent []byte) *http.Request {
+		r := httptest.NewRequest("POST", "/hooks?x=1", bytes.NewReader(sent))
+		if keyID != "" {
+			SignRequest(r, keyID, key, body)
+		}
+		return r
+	}
+
+	signed := newRequest("k1", body)
+	replayed := newRequest("", body)
+	replayed.Header = signed.Header.Clone()
+
+	cases := []struct {
+		Name     string
+		Request  *http.Request
+		Expected string
+	}{
+		{"valid", signed, "valid"},
+		{"replayed", replayed, "replayed"},
+		{"tampered", newRequest("k1", []byte(`{}`)), "invalid"},
+		{"unknown key", newRequest("k2", body), "unknown_key"},
+		{"missing", newRequest("", body), "missing"},
+	}
+
+	for _, c := range cases {
+		// act
+		result, err := v.verify(c.Request, c.Request.Header.Get(SignatureKeyIDHeader), time.Now())
+
+		// assert
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		}
+		if result != c.Expected {
+			t.Errorf("%s: result want: %s got: %s", c.Name, c.Expected, result)
+		}
+	}
+
+	// the body is restored for the handler
+	b, err := ioutil.ReadAll(signed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Errorf("body want: %s got: %s", body, b)
+	}
+}
+
+func TestSignatureVerifierRemember(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	window := 5 * time.Minute
+	later := now.Add(time.Minute)
+
+	cases := []struct {
+		name          string
+		seen          map[string]time.Time
+		nextSweep     time.Time
+		sig           string
+		want          bool
+		wantSeen      int
+		wantNextSweep time.Time
+	}{
+		{name: "new", sig: "a", want: true, wantSeen: 1, wantNextSweep: now.Add(window)},
+		{name: "replayed", seen: map[string]time.Time{"a": now.Add(-time.Minute)}, nextSweep: later, sig: "a", want: false, wantSeen: 1, wantNextSweep: later},
+		{name: "expired before sweep", seen: map[string]time.Time{"a": now.Add(-11 * time.Minute)}, nextSweep: later, sig: "a", want: true, wantSeen: 1, wantNextSweep: later},
+		{name: "sweep not due", seen: map[string]time.Time{"old": now.Add(-11 * time.Minute)}, nextSweep: later, sig: "a", want: true, wantSeen: 2, wantNextSweep: later},
+		{name: "sweep due", seen: map[string]time.Time{"old": now.Add(-11 * time.Minute), "recent": now.Add(-time.Minute)}, nextSweep: now, sig: "a", want: true, wantSeen: 2, wantNextSweep: now.Add(window)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			v := &SignatureVerifier{seen: c.seen, nextSweep: c.nextSweep}
+
+			// act
+			got := v.remember(c.sig, now, window)
+
+			// assert
+			if got != c.want {
+				t.Errorf("remember want: %v got: %v", c.want, got)
+			}
+			if len(v.seen) != c.wantSeen {
+				t.Errorf("seen want: %d got: %d", c.wantSeen, len(v.seen))
+			}
+			if !v.nextSweep.Equal(c.wantNextSweep) {
+				t.Errorf("next sweep want: %v got: %v", c.wantNextSweep, v.nextSweep)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package httplog
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+)
+
+// Sink receives access log records. See Server.Sinks.
+type Sink interface {
+	WriteRecord(rec *AccessRecord) error
+}
+
+// Publisher publishes a message to a subject or topic. A *nats.Conn
+// satisfies this interface; other clients, such as Kafka producers, can be
+// adapted with PublisherFunc.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// PublisherFunc is an adapter to allow the use of ordinary functions as
+// Publishers.
+type PublisherFunc func(subject string, data []byte) error
+
+// Publish calls f(subject, data).
+func (f PublisherFunc) Publish(subject string, data []byte) error {
+	return f(subject, data)
+}
+
+type publisherSink struct {
+	publisher Publisher
+	subject   string
+}
+
+// NewPublisherSink returns a Sink which publishes each record to subject as
+// JSON. The returned Sink publishes synchronously; wrap it with
+// NewAsyncSink to keep slow brokers out of the logging path.
+func NewPublisherSink(publisher Publisher, subject string) Sink {
+	return &publisherSink{publisher: publisher, subject: subject}
+}
+
+func (s *publisherSink) WriteRecord(rec *AccessRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.publisher.Publish(s.subject, data)
+}
+
+func (s *publisherSink) Close() error {
+	if closer, ok := s.publisher.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// AsyncSink writes records to another Sink from a single goroutine. Records
+// are held in a bounded queue; when the queue is full new records are
+// dropped and counted.
+type AsyncSink struct {
+	sink    Sink
+	queue   *jobQueue
+	dropped int64
+}
+
+// NewAsyncSink returns an AsyncSink which writes to sink. queueSize is the
+// maximum number of records waiting to be written; the default is 1024.
+func NewAsyncSink(sink Sink, queueSize int) *AsyncSink {
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	s := &AsyncSink{sink: sink}
+	s.queue = newJobQueue(queueSize, 1, OverflowDropNewest, s.drop)
+	return s
+}
+
+// WriteRecord queues rec without blocking. If the queue is full or the sink
+// is closed the record is dropped.
+func (s *AsyncSink) WriteRecord(rec *AccessRecord) error {
+	ok := s.queue.push(func() {
+		if err := s.sink.WriteRecord(rec); err != nil {
+			sinkErrorsTotal.Inc()
+		}
+	})
+	if !ok {
+		s.drop()
+	}
+	return nil
+}
+
+// Dropped returns the number of records dropped because the queue was full.
+func (s *AsyncSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close waits for queued records to be written and closes the underlying
+// Sink if it implements io.Closer.
+func (s *AsyncSink) Close() error {
+	s.queue.close(0)
+
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *AsyncSink) drop() {
+	atomic.AddInt64(&s.dropped, 1)
+	sinkDroppedTotal.Inc()
+}
+
+func (svr *Server) writeSinks(rec *AccessRecord) {
+	for _, sink := range svr.Sinks {
+		if err := sink.WriteRecord(rec); err != nil {
+			sinkErrorsTotal.Inc()
+		}
+	}
+}
+
+func (svr *Server) closeSinks() {
+	for _, sink := range svr.Sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				svr.newEntry().Errorf("closing sink: %v", err)
+			}
+		}
+	}
+}
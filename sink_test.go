@@ -0,0 +1,220 @@
+package httplog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink records the handler of each record written, blocking each
+// write until release is closed.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+
+	mtx      sync.Mutex
+	handlers []string
+	closed   bool
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (s *blockingSink) WriteRecord(rec *AccessRecord) error {
+	s.started <- struct{}{}
+	<-s.release
+	s.mtx.Lock()
+	s.handlers = append(s.handlers, rec.Handler)
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	s.mtx.Unlock()
+	return nil
+}
+
+func TestAsyncSinkQueue(t *testing.T) {
+	cases := []struct {
+		name        string
+		queueSize   int
+		writes      int
+		wantWritten int
+		wantDropped int64
+	}{
+		{name: "under limit", queueSize: 4, writes: 3, wantWritten: 3},
+		{name: "at limit", queueSize: 2, writes: 3, wantWritten: 3},
+		{name: "over limit", queueSize: 2, writes: 6, wantWritten: 3, wantDropped: 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			inner := newBlockingSink()
+			sink := NewAsyncSink(inner, c.queueSize)
+
+			// act
+			// the first record is taken by the worker, which blocks; the
+			// rest fill the queue
+			_ = sink.WriteRecord(&AccessRecord{Handler: "h0"})
+			<-inner.started
+			for i := 1; i < c.writes; i++ {
+				if err := sink.WriteRecord(&AccessRecord{Handler: "h"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dropped := sink.Dropped()
+			close(inner.release)
+			err := sink.Close()
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped != c.wantDropped {
+				t.Errorf("dropped want: %d got: %d", c.wantDropped, dropped)
+			}
+			if len(inner.handlers) != c.wantWritten {
+				t.Errorf("written want: %d got: %d", c.wantWritten, len(inner.handlers))
+			}
+			if !inner.closed {
+				t.Error("want inner sink closed")
+			}
+		})
+	}
+}
+
+func TestAsyncSinkWriteAfterClose(t *testing.T) {
+	// arrange
+	inner := newBlockingSink()
+	close(inner.release)
+	sink := NewAsyncSink(inner, 0)
+	_ = sink.Close()
+
+	// act
+	err := sink.WriteRecord(&AccessRecord{Handler: "late"})
+
+	// assert
+	if err != nil {
+		t.Errorf("want nil error got: %v", err)
+	}
+	if len(inner.handlers) != 0 {
+		t.Errorf("want no records written got: %v", inner.handlers)
+	}
+	if got := sink.Dropped(); got != 1 {
+		t.Errorf("dropped want: 1 got: %d", got)
+	}
+}
+
+func TestAsyncSinkFlushedOnShutdown(t *testing.T) {
+	// arrange
+	inner := newBlockingSink()
+	close(inner.release)
+	sink := NewAsyncSink(inner, 0)
+
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	s.ShutdownTimeout = 5 * time.Second
+	handler := s.Handle(Handler{Name: "test", Func: func(*http.Request, Entry) (Response, error) {
+		return Response{}, nil
+	}})
+	for i := 0; i < 10; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	// act
+	s.Shutdown()
+
+	// assert
+	if len(inner.handlers) != 10 {
+		t.Errorf("written want: 10 got: %d", len(inner.handlers))
+	}
+	if !inner.closed {
+		t.Error("want inner sink closed")
+	}
+}
+
+type closingPublisher struct {
+	subject string
+	data    []byte
+	err     error
+	closed  bool
+}
+
+func (p *closingPublisher) Publish(subject string, data []byte) error {
+	p.subject, p.data = subject, data
+	return p.err
+}
+
+func (p *closingPublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPublisherSink(t *testing.T) {
+	publishErr := errors.New("broker down")
+
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{name: "published"},
+		{name: "publish error", err: publishErr},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			pub := &closingPublisher{err: c.err}
+			sink := NewPublisherSink(pub, "access")
+			rec := &AccessRecord{Handler: "test", Message: "GET /", Fields: map[string]interface{}{"status": 200}}
+
+			// act
+			err := sink.WriteRecord(rec)
+			closeErr := sink.(interface{ Close() error }).Close()
+
+			// assert
+			if !errors.Is(err, c.err) {
+				t.Errorf("error want: %v got: %v", c.err, err)
+			}
+			if closeErr != nil || !pub.closed {
+				t.Errorf("want publisher closed got: %v %v", pub.closed, closeErr)
+			}
+			if pub.subject != "access" {
+				t.Errorf("subject want: access got: %s", pub.subject)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(pub.data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["handler"] != "test" || got["msg"] != "GET /" || got["status"] != float64(200) {
+				t.Errorf("record want flat test GET / 200 got: %v", got)
+			}
+		})
+	}
+}
+
+func TestPublisherFunc(t *testing.T) {
+	// arrange
+	var got string
+	pub := PublisherFunc(func(subject string, data []byte) error {
+		got = subject + ":" + string(data)
+		return nil
+	})
+
+	// act
+	err := pub.Publish("s", []byte("d"))
+
+	// assert
+	if err != nil || got != "s:d" {
+		t.Errorf("want: s:d <nil> got: %s %v", got, err)
+	}
+}
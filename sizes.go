@@ -0,0 +1,41 @@
+package httplog
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	return n, err
+}
+
+// countRequestBody replaces r's body with a countingBody, returning a
+// function reporting the request's size: its Content-Length when known,
+// otherwise the number of bytes read.
+func countRequestBody(r *http.Request) func() int64 {
+	if r.Body == nil || r.Body == http.NoBody {
+		return func() int64 { return 0 }
+	}
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	return func() int64 {
+		if r.ContentLength >= 0 {
+			return r.ContentLength
+		}
+		return atomic.LoadInt64(&body.n)
+	}
+}
+
+func observeSizes(handlerName, method string, requestBytes int64, responseBytes int) {
+	httpRequestSize.WithLabelValues(handlerName, method).Observe(float64(requestBytes))
+	httpResponseSize.WithLabelValues(handlerName, method).Observe(float64(responseBytes))
+}
@@ -0,0 +1,102 @@
+package httplog
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCountRequestBody(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    io.Reader
+		chunked bool
+		read    bool
+		want    int64
+	}{
+		{name: "no body", want: 0},
+		{name: "content length", body: strings.NewReader("hello"), read: true, want: 5},
+		{name: "content length unread", body: strings.NewReader("hello"), want: 5},
+		{name: "chunked read", body: strings.NewReader("hello world"), chunked: true, read: true, want: 11},
+		{name: "chunked unread", body: strings.NewReader("hello world"), chunked: true, want: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			r := httptest.NewRequest("POST", "/", c.body)
+			if c.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			}
+
+			// act
+			size := countRequestBody(r)
+			if c.read {
+				if _, err := ioutil.ReadAll(r.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// assert
+			if got := size(); got != c.want {
+				t.Errorf("size want: %d got: %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSizeHistograms(t *testing.T) {
+	cases := []struct {
+		name         string
+		method       string
+		body         string
+		chunked      bool
+		response     string
+		wantRequest  float64
+		wantResponse float64
+	}{
+		{name: "empty", method: "GET"},
+		{name: "request body", method: "POST", body: "hello", wantRequest: 5},
+		{name: "chunked request body", method: "PUT", body: "hello world", chunked: true, wantRequest: 11},
+		{name: "response body", method: "GET", response: "some response", wantResponse: 13},
+		{name: "both", method: "POST", body: "abc", response: "defgh", wantRequest: 3, wantResponse: 5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			name := "sizes " + c.name
+			handler := Handler{Name: name, Func: func(r *http.Request, _ Entry) (Response, error) {
+				if _, err := ioutil.ReadAll(r.Body); err != nil {
+					return Response{}, err
+				}
+				return Response{Body: c.response}, nil
+			}}
+			r := httptest.NewRequest(c.method, "/", strings.NewReader(c.body))
+			if c.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			}
+
+			// act
+			s.Handle(handler)(httptest.NewRecorder(), r)
+			s.Shutdown()
+
+			// assert
+			count, sum := histogramValue(t, httpRequestSize.WithLabelValues(name, c.method))
+			if count != 1 || sum != c.wantRequest {
+				t.Errorf("http_request_size_bytes want: 1 %v got: %d %v", c.wantRequest, count, sum)
+			}
+			count, sum = histogramValue(t, httpResponseSize.WithLabelValues(name, c.method))
+			if count != 1 || sum != c.wantResponse {
+				t.Errorf("http_response_size_bytes want: 1 %v got: %d %v", c.wantResponse, count, sum)
+			}
+		})
+	}
+}
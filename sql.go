@@ -0,0 +1,319 @@
+package httplog
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+const defaultMaxQueryLength = 1024
+
+// SQLOptions configures WrapSQLDriver and WrapSQLConnector.
+type SQLOptions struct {
+	// SlowThreshold is the duration at which a query is logged in its own
+	// entry, "slow query", with query, sql_op, time_taken, and the
+	// request_id and handler of the request it was made for. Zero disables
+	// it.
+	SlowThreshold time.Duration
+	// MaxQueryLength truncates the SQL logged for slow queries. The
+	// default is 1024 bytes.
+	MaxQueryLength int
+	// NewLogEntry creates the entries for slow queries made outside a
+	// request served by Handle. If nil they aren't logged.
+	NewLogEntry func() Entry
+}
+
+// WrapSQLDriver returns a driver.Driver which times the queries and
+// statements run through d. Register it under a new name and open the
+// database with it:
+//
+//	sql.Register("postgres+httplog", httplog.WrapSQLDriver(&pq.Driver{}, httplog.SQLOptions{}))
+//	db, err := sql.Open("postgres+httplog", dsn)
+//
+// Queries made with the context of a request served by Handle, for example
+// with db.QueryContext(r.Context(), ...), are summed onto its access log as
+// db_queries, db_time_ms, and db_errors, and observed in
+// httplog_db_query_duration_seconds. Query time is the time until the
+// driver returns the rows, not the time spent reading them.
+func WrapSQLDriver(d driver.Driver, opts SQLOptions) driver.Driver {
+	return &sqlDriver{Driver: d, opts: opts}
+}
+
+// WrapSQLConnector returns a driver.Connector which times queries as
+// WrapSQLDriver does, for use with sql.OpenDB.
+func WrapSQLConnector(c driver.Connector, opts SQLOptions) driver.Connector {
+	return &sqlConnector{Connector: c, driver: &sqlDriver{Driver: c.Driver(), opts: opts}}
+}
+
+type sqlDriver struct {
+	driver.Driver
+	opts SQLOptions
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: conn, opts: d.opts}, nil
+}
+
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	dc, ok := d.Driver.(driver.DriverContext)
+	if !ok {
+		return &sqlNameConnector{name: name, driver: d}, nil
+	}
+	c, err := dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConnector{Connector: c, driver: d}, nil
+}
+
+type sqlConnector struct {
+	driver.Connector
+	driver *sqlDriver
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: conn, opts: c.driver.opts}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqlNameConnector connects with a driver which doesn't implement
+// driver.DriverContext.
+type sqlNameConnector struct {
+	name   string
+	driver *sqlDriver
+}
+
+func (c *sqlNameConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *sqlNameConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type sqlConn struct {
+	driver.Conn
+	opts SQLOptions
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: stmt, query: query, opts: c.opts}, nil
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: stmt, query: query, opts: c.opts}, nil
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("httplog: driver doesn't support transaction options")
+	}
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql prepares the statement instead
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := ec.ExecContext(ctx, query, args)
+	observeQuery(ctx, c.opts, "exec", query, start, err)
+	return res, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := qc.QueryContext(ctx, query, args)
+	observeQuery(ctx, c.opts, "query", query, start, err)
+	return rows, err
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type sqlStmt struct {
+	driver.Stmt
+	query string
+	opts  SQLOptions
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = ec.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = s.Stmt.Exec(values) //nolint:staticcheck
+		}
+	}
+	observeQuery(ctx, s.opts, "exec", s.query, start, err)
+	return res, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = qc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.Stmt.Query(values) //nolint:staticcheck
+		}
+	}
+	observeQuery(ctx, s.opts, "query", s.query, start, err)
+	return rows, err
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts args for drivers without context support, which
+// don't support named parameters.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("httplog: driver doesn't support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// observeQuery records a query made with ctx on its request's access log
+// and in httplog_db_query_duration_seconds.
+func observeQuery(ctx context.Context, opts SQLOptions, op, query string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	duration := time.Since(start)
+
+	state := getRequestState(ctx)
+	handlerName := ""
+	if state != nil {
+		handlerName = state.handlerName
+		state.mtx.Lock()
+		state.dbQueries++
+		state.dbTime += duration
+		if err != nil {
+			state.dbErrors++
+		}
+		state.mtx.Unlock()
+	}
+	dbQueryDuration.WithLabelValues(handlerName, op).Observe(duration.Seconds())
+
+	if opts.SlowThreshold <= 0 || duration < opts.SlowThreshold {
+		return
+	}
+	var entry Entry
+	fields := map[string]interface{}{
+		"query":      truncateQuery(query, opts.MaxQueryLength),
+		"sql_op":     op,
+		"time_taken": int64(duration / time.Millisecond),
+	}
+	if state != nil {
+		entry = state.svr.newEntry()
+		fields["request_id"] = state.requestID
+		fields["handler"] = state.handlerName
+	} else if opts.NewLogEntry != nil {
+		entry = opts.NewLogEntry()
+	} else {
+		return
+	}
+	entry.AddFields(fields)
+	if err != nil {
+		entry.AddError(err)
+	}
+	entry.Warn("slow query")
+}
+
+func truncateQuery(query string, max int) string {
+	if max <= 0 {
+		max = defaultMaxQueryLength
+	}
+	if len(query) <= max {
+		return query
+	}
+	return query[:max] + "..."
+}
+
+// addDBFields adds the totals of the queries made while serving a request.
+func (state *requestState) addDBFields(fields map[string]interface{}) {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	if state.dbQueries == 0 {
+		return
+	}
+	fields["db_queries"] = state.dbQueries
+	fields["db_time_ms"] = int64(state.dbTime / time.Millisecond)
+	if state.dbErrors > 0 {
+		fields["db_errors"] = state.dbErrors
+	}
+}
@@ -0,0 +1,104 @@
+package httplog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapSQLDriver(t *testing.T) {
+	// arrange
+	sql.Register("httplog-test", WrapSQLDriver(testDriver{}, SQLOptions{}))
+	db, err := sql.Open("httplog-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		var n int
+		if err := db.QueryRowContext(r.Context(), "SELECT 1").Scan(&n); err != nil {
+			return Response{}, err
+		}
+		// exec is prepared, since the connection doesn't implement ExecerContext
+		if _, err := db.ExecContext(r.Context(), "UPDATE t SET x = ?", 1); err != nil {
+			return Response{}, err
+		}
+		if _, err := db.ExecContext(r.Context(), "fail"); err == nil {
+			t.Error("want error")
+		}
+		return Response{}, nil
+	}}
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Shutdown()
+
+	// assert
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	fields := sink.records[0].Fields
+	if fields["db_queries"] != 3 || fields["db_errors"] != 1 {
+		t.Errorf("want 3 queries and 1 error got: %v and %v", fields["db_queries"], fields["db_errors"])
+	}
+	if _, ok := fields["db_time_ms"]; !ok {
+		t.Error("want db_time_ms")
+	}
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) { return testConn{}, nil }
+
+type testConn struct{}
+
+func (testConn) Prepare(query string) (driver.Stmt, error) { return testStmt{query: query}, nil }
+func (testConn) Close() error                              { return nil }
+func (testConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (testConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return &testRows{}, nil
+}
+
+type testStmt struct {
+	query string
+}
+
+func (testStmt) Close() error  { return nil }
+func (testStmt) NumInput() int { return -1 }
+
+func (s testStmt) Exec([]driver.Value) (driver.Result, error) {
+	if s.query == "fail" {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (testStmt) Query([]driver.Value) (driver.Rows, error) { return &testRows{}, nil }
+
+type testRows struct {
+	done bool
+}
+
+func (*testRows) Columns() []string { return []string{"n"} }
+func (*testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
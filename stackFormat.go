@@ -0,0 +1,120 @@
+package httplog
+
+import (
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StackPathFormat determines how file paths are rendered in stack frames.
+type StackPathFormat int
+
+const (
+	// StackPathTrimmed trims the GOPATH from paths, keeping as many
+	// trailing directories as the package's import path has, for example
+	// "github.com/judwhite/httplog/server.go". Paths with fewer
+	// directories, such as module checkouts at a short path, are unchanged.
+	// This is the default.
+	StackPathTrimmed StackPathFormat = iota
+	// StackPathFull renders the absolute path of the file when the binary
+	// was built.
+	StackPathFull
+	// StackPathModule renders paths relative to the root of the file's
+	// module, for example "internal/orders/handler.go", and sets
+	// StackFrame.Module. Files outside a known module, such as the standard
+	// library, are rendered as with StackPathTrimmed.
+	StackPathModule
+)
+
+// ErrorStackPaths returns the stack trace captured with err, innermost frame
+// first, with paths rendered in format. It returns nil if err doesn't carry
+// a stack trace. ErrorStack is ErrorStackPaths with StackPathTrimmed.
+func ErrorStackPaths(err error, format StackPathFormat) []StackFrame {
+	errStack, ok := err.(*errorStack)
+	if !ok {
+		return nil
+	}
+	st := errStack.StackTrace()
+	frames := make([]StackFrame, 0, len(st))
+	for _, f := range st {
+		frames = append(frames, f.stackFrame(format))
+	}
+	return frames
+}
+
+func (f frame) stackFrame(format StackPathFormat) StackFrame {
+	fn := runtime.FuncForPC(f.pc())
+	if fn == nil {
+		return StackFrame{Path: "unknown", Filename: "unknown"}
+	}
+	// resolve the frame once rather than through each of frame's methods
+	name := fn.Name()
+	file, line := fn.FileLine(f.pc())
+	sf := StackFrame{
+		Path:     trimGOPATH(name, file),
+		Filename: path.Base(file),
+		Func:     funcName(name),
+		Line:     line,
+	}
+	switch format {
+	case StackPathFull:
+		sf.Path = file
+	case StackPathModule:
+		pkg := funcPackage(name)
+		if mod, ok := moduleOf(pkg); ok {
+			sf.Module = mod
+			sf.Path = strings.TrimPrefix(strings.TrimPrefix(pkg, mod), "/")
+			if sf.Path != "" {
+				sf.Path += "/"
+			}
+			sf.Path += sf.Filename
+		}
+	}
+	return sf
+}
+
+// funcPackage returns the import path of the package of a function named as
+// by runtime.Func.Name, for example "github.com/judwhite/httplog" for
+// "github.com/judwhite/httplog.(*Server).Handle.func1".
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot != -1 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+var (
+	buildModulesOnce sync.Once
+	buildModules     []string
+)
+
+// moduleOf returns the module in the binary's build info which contains
+// pkg, preferring the longest match for nested modules.
+func moduleOf(pkg string) (string, bool) {
+	buildModulesOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if bi.Main.Path != "" {
+			buildModules = append(buildModules, bi.Main.Path)
+		}
+		for _, dep := range bi.Deps {
+			buildModules = append(buildModules, dep.Path)
+		}
+		sort.Slice(buildModules, func(i, j int) bool {
+			return len(buildModules[i]) > len(buildModules[j])
+		})
+	})
+
+	for _, mod := range buildModules {
+		if pkg == mod || strings.HasPrefix(pkg, mod+"/") {
+			return mod, true
+		}
+	}
+	return "", false
+}
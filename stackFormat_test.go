@@ -0,0 +1,56 @@
+package httplog
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorStackPaths(t *testing.T) {
+	// arrange
+	err := withStack(errors.New("test"))
+
+	cases := []struct {
+		format     StackPathFormat
+		wantPath   func(string) bool
+		wantModule string
+	}{
+		{StackPathTrimmed, func(p string) bool { return strings.HasSuffix(p, "/stackFormat_test.go") }, ""},
+		{StackPathFull, filepath.IsAbs, ""},
+		{StackPathModule, func(p string) bool { return p == "stackFormat_test.go" }, "github.com/judwhite/httplog"},
+	}
+
+	for _, c := range cases {
+		// act
+		frames := ErrorStackPaths(err, c.format)
+
+		// assert
+		if len(frames) == 0 {
+			t.Fatalf("format %d: want frames", c.format)
+		}
+		top := frames[0]
+		if !c.wantPath(top.Path) {
+			t.Errorf("format %d: unexpected path %s", c.format, top.Path)
+		}
+		if top.Module != c.wantModule {
+			t.Errorf("format %d: module want: %q got: %q", c.format, c.wantModule, top.Module)
+		}
+		if top.Filename != "stackFormat_test.go" || top.Func != "TestErrorStackPaths" {
+			t.Errorf("format %d: want stackFormat_test.go TestErrorStackPaths got: %s %s", c.format, top.Filename, top.Func)
+		}
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	cases := map[string]string{
+		"github.com/judwhite/httplog.(*Server).Handle.func1": "github.com/judwhite/httplog",
+		"main.main":                      "main",
+		"net/http.HandlerFunc.ServeHTTP": "net/http",
+	}
+	for name, want := range cases {
+		if got := funcPackage(name); got != want {
+			t.Errorf("%s: want: %s got: %s", name, want, got)
+		}
+	}
+}
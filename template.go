@@ -0,0 +1,76 @@
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sync"
+)
+
+// Templates is a registry of html/template templates parsed from the files
+// matching Pattern. Set it as Server.Templates and return a TemplateResponse
+// body to render one.
+type Templates struct {
+	// Pattern is a filepath.Glob pattern, for example "templates/*.html".
+	// Each template is named by its file name.
+	Pattern string
+	// Funcs are added to the templates before parsing.
+	Funcs template.FuncMap
+	// Reload parses the templates again before every render so edits are
+	// picked up without a restart. Use it in development only.
+	Reload bool
+
+	mtx  sync.Mutex
+	tmpl *template.Template
+}
+
+// TemplateResponse renders the template Name with Data. Use it as a
+// Response Body.
+type TemplateResponse struct {
+	Name string
+	Data interface{}
+}
+
+// Load parses the templates matching Pattern. It's called on first render
+// if it hasn't been called already; calling it at startup surfaces parse
+// errors early.
+func (t *Templates) Load() error {
+	tmpl, err := template.New("").Funcs(t.Funcs).ParseGlob(t.Pattern)
+	if err != nil {
+		return err
+	}
+
+	t.mtx.Lock()
+	t.tmpl = tmpl
+	t.mtx.Unlock()
+	return nil
+}
+
+func (t *Templates) render(name string, data interface{}) ([]byte, error) {
+	t.mtx.Lock()
+	tmpl := t.tmpl
+	t.mtx.Unlock()
+
+	if tmpl == nil || t.Reload {
+		if err := t.Load(); err != nil {
+			return nil, err
+		}
+		t.mtx.Lock()
+		tmpl = t.tmpl
+		t.mtx.Unlock()
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderTemplate renders resp with the Server's Templates.
+func (svr *Server) renderTemplate(resp TemplateResponse) ([]byte, error) {
+	if svr.Templates == nil {
+		return nil, fmt.Errorf("rendering template %q: Server.Templates is nil", resp.Name)
+	}
+	return svr.Templates.render(resp.Name, resp.Data)
+}
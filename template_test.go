@@ -0,0 +1,63 @@
+package httplog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateResponse(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.html"), []byte("<p>Hello, {{.}}</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name           string
+		template       string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"render", "hello.html", 200, "<p>Hello, &lt;Ada&gt;</p>"},
+		{"missing template", "missing.html", 500, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			sink := &recordingSink{}
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			s.Sinks = []Sink{sink}
+			s.Templates = &Templates{Pattern: filepath.Join(dir, "*.html")}
+			s.StackDepth = 1
+			handler := Handler{Name: "test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+				return Response{Body: TemplateResponse{Name: c.template, Data: "<Ada>"}}, nil
+			}}
+			w := httptest.NewRecorder()
+
+			// act
+			s.Handle(handler)(w, httptest.NewRequest("GET", "/", nil))
+			s.Shutdown()
+
+			// assert
+			if w.Code != c.expectedStatus || w.Body.String() != c.expectedBody {
+				t.Errorf("response want: %d %q got: %d %q", c.expectedStatus, c.expectedBody, w.Code, w.Body.String())
+			}
+			rec := sink.records[0]
+			if _, ok := rec.Fields["template_render_ms"]; !ok {
+				t.Error("want template_render_ms")
+			}
+			if c.expectedStatus == 500 {
+				if rec.Err == nil {
+					t.Fatal("want render error logged")
+				}
+				if n := len(ErrorStack(rec.Err)); n != 1 {
+					t.Errorf("frames want: 1 (StackDepth) got: %d", n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport is an http.RoundTripper which logs each outbound request.
+//
+// When the request's context belongs to a request served by Server.Handle
+// the outbound call is linked to it: the call is given its own request ID,
+// and the X-Request-ID, X-Parent-Request-ID, and X-Request-Depth headers are
+// sent so a downstream Server logs the same IDs. The outbound log entry is
+// created by the Server and includes parent_request_id and request_depth.
+//
+// DNS, connect, TLS handshake, and time to first byte durations are exported
+// in httplog_outbound_phase_duration_seconds, connection reuse in
+// httplog_outbound_connections_total, and in-flight requests per host in
+// httplog_outbound_in_flight. When Base is an *http.Transport with
+// MaxConnsPerHost set, httplog_outbound_pool_saturation reports in-flight
+// requests as a fraction of the limit. Calls slower than SlowThreshold are
+// logged with slow, dns_ms, connect_ms, tls_ms, ttfb_ms, conn_reused, and
+// conn_was_idle.
+//
+// Every outbound call is logged with the IP address it connected to as
+// remote_ip, which helps when debugging upstreams behind round-robin DNS.
+// DialTimeout, FallbackDelay, and DialPolicies configure how connections are
+// made; they apply only when Base is nil.
+//
+// When Cache is set GET and HEAD responses are cached following RFC 7234:
+// fresh responses are served from the cache, stale responses with an ETag or
+// Last-Modified header are revalidated, and unsafe requests invalidate
+// stored responses for their URL. Outbound calls are logged with cache (hit,
+// miss, revalidated, or bypass) and counted in
+// httplog_outbound_cache_requests_total.
+type Transport struct {
+	// Base is the RoundTripper used to make requests. The default is
+	// http.DefaultTransport.
+	Base http.RoundTripper
+	// NewLogEntry creates log entries for requests whose context doesn't
+	// belong to a Server. If nil a fallback logger is used.
+	NewLogEntry func() Entry
+	// SlowThreshold is the duration at which outbound calls are logged with
+	// their connection phases. Optional.
+	SlowThreshold time.Duration
+	// DialTimeout limits the time taken to establish a connection. The
+	// default is 30s.
+	DialTimeout time.Duration
+	// FallbackDelay is how long to wait for an IPv6 connection before
+	// racing an IPv4 connection (happy eyeballs). Zero uses the net.Dialer
+	// default of 300ms; a negative value disables the race.
+	FallbackDelay time.Duration
+	// DialPolicies configures timeouts, concurrency limits, and static
+	// resolution per host name, without port.
+	DialPolicies map[string]DialPolicy
+	// Cache stores responses. Optional; see NewMemoryResponseCache.
+	Cache ResponseCache
+	// CacheMaxEntryBytes is the largest response body cached. The default
+	// is 1 MiB.
+	CacheMaxEntryBytes int64
+
+	pool          hostPool
+	limits        hostLimits
+	dialOnce      sync.Once
+	dialTransport *http.Transport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base()
+
+	state := getRequestState(req.Context())
+
+	var entry Entry
+	fields := map[string]interface{}{
+		"method":          req.Method,
+		"url":             req.URL.Redacted(),
+		"downstream_host": req.URL.Host,
+	}
+
+	trace := newOutboundTrace()
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+	if state != nil {
+		entry = state.svr.newEntry()
+
+		requestID := newRequestID()
+		depth := state.depth + 1
+		req.Header.Set(requestIDHeader, requestID)
+		req.Header.Set(parentRequestIDHeader, state.requestID)
+		req.Header.Set(requestDepthHeader, strconv.Itoa(depth))
+
+		fields["request_id"] = requestID
+		fields["parent_request_id"] = state.requestID
+		fields["request_depth"] = depth
+		fields["handler"] = state.handlerName
+	} else if t.NewLogEntry != nil {
+		entry = t.NewLogEntry()
+	} else {
+		entry = &fallbackLogger{}
+	}
+
+	host := req.URL.Host
+	limit := maxConnsPerHost(base)
+	if policy, ok := t.DialPolicies[req.URL.Hostname()]; ok && policy.MaxConns > 0 {
+		limit = policy.MaxConns
+	}
+
+	start := time.Now()
+	var resp *http.Response
+	var err error
+	var cacheResult string
+	if t.Cache != nil {
+		resp, cacheResult, err = t.cachedRoundTrip(base, req, limit)
+	} else {
+		resp, err = t.roundTrip(base, req, limit)
+	}
+	duration := time.Since(start)
+
+	trace.observe(host)
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+
+	fields["http_status"] = status
+	fields["time_taken"] = int64(duration / time.Millisecond)
+	if cacheResult != "" {
+		fields["cache"] = cacheResult
+		outboundCacheRequestsTotal.WithLabelValues(host, cacheResult).Inc()
+	}
+	if ip := trace.remoteIP(); ip != "" {
+		fields["remote_ip"] = ip
+	}
+	if t.SlowThreshold > 0 && duration >= t.SlowThreshold {
+		fields["slow"] = true
+		trace.addFields(fields)
+	}
+	entry.AddFields(fields)
+
+	if state != nil {
+		state.addDownstream(DownstreamCall{
+			Host:   req.URL.Host,
+			Status: status,
+			Ms:     int64(duration / time.Millisecond),
+		})
+	}
+
+	if err != nil {
+		entry.AddError(err)
+		entry.Error("outbound request failed")
+	} else if status >= 500 {
+		entry.Error("outbound request")
+	} else if status >= 400 {
+		entry.Warn("outbound request")
+	} else {
+		entry.Info("outbound request")
+	}
+
+	return resp, err
+}
+
+// roundTrip sends req with base, waiting for a slot if the host's
+// DialPolicy limits concurrent requests.
+func (t *Transport) roundTrip(base http.RoundTripper, req *http.Request, limit int) (*http.Response, error) {
+	release := func() {}
+	if policy, ok := t.DialPolicies[req.URL.Hostname()]; ok {
+		var err error
+		if release, err = t.limits.acquire(req.Context(), req.URL.Host, policy.MaxConns); err != nil {
+			return nil, err
+		}
+	}
+
+	host := req.URL.Host
+	t.pool.acquire(host, limit)
+	resp, err := base.RoundTrip(req)
+	t.pool.release(host, limit)
+
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
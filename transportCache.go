@@ -0,0 +1,322 @@
+package httplog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultResponseCacheSize  = 32 << 20
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// ResponseCache stores responses for Transport. Values are opaque; a store
+// only needs to keep them by key, for example in Redis.
+type ResponseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryResponseCache is a size-bounded LRU ResponseCache.
+type MemoryResponseCache struct {
+	lru *lruCache
+}
+
+// NewMemoryResponseCache returns a MemoryResponseCache holding up to
+// maxBytes. The default is 32 MiB.
+func NewMemoryResponseCache(maxBytes int64) *MemoryResponseCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultResponseCacheSize
+	}
+	return &MemoryResponseCache{lru: newLRUCache(maxBytes)}
+}
+
+// Get implements ResponseCache.
+func (c *MemoryResponseCache) Get(key string) ([]byte, bool) { return c.lru.get(key) }
+
+// Set implements ResponseCache.
+func (c *MemoryResponseCache) Set(key string, value []byte) { c.lru.add(key, value) }
+
+// Delete implements ResponseCache.
+func (c *MemoryResponseCache) Delete(key string) { c.lru.delete(key) }
+
+// cacheableStatus lists the statuses which may be cached without explicit
+// freshness information (RFC 7231 section 6.1).
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cachedResponse is a stored response and the time it was received.
+type cachedResponse struct {
+	stored time.Time
+	resp   *http.Response
+	body   []byte
+}
+
+// cacheKey returns the key responses to method and u are stored under.
+func cacheKey(method string, u *url.URL) string {
+	return method + " " + u.String()
+}
+
+// cachedRoundTrip sends req through the Transport's Cache, returning the
+// response and the cache result: hit, miss, revalidated, or bypass.
+func (t *Transport) cachedRoundTrip(base http.RoundTripper, req *http.Request, limit int) (*http.Response, string, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		resp, err := t.roundTrip(base, req, limit)
+		// Unsafe methods invalidate stored responses for the URL
+		// (RFC 7234 section 4.4).
+		if err == nil && resp.StatusCode < 400 && req.Method != "OPTIONS" && req.Method != "TRACE" {
+			t.Cache.Delete(cacheKey("GET", req.URL))
+			t.Cache.Delete(cacheKey("HEAD", req.URL))
+		}
+		return resp, "bypass", err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		resp, err := t.roundTrip(base, req, limit)
+		return resp, "bypass", err
+	}
+
+	key := cacheKey(req.Method, req.URL)
+	cached := t.loadCached(key, req)
+
+	if cached != nil {
+		_, noCache := reqCC["no-cache"]
+		if !noCache && cached.fresh(time.Now(), reqCC) {
+			return cached.response(req), "hit", nil
+		}
+
+		if etag := cached.resp.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		} else if lm := cached.resp.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	resp, err := t.roundTrip(base, req, limit)
+	if err != nil {
+		return nil, "miss", err
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		// Update the stored headers with those of the 304 (RFC 7234
+		// section 4.3.4).
+		for name, values := range resp.Header {
+			cached.resp.Header[name] = values
+		}
+		cached.stored = time.Now()
+		t.storeCached(key, req, cached)
+		return cached.response(req), "revalidated", nil
+	}
+
+	if !storable(resp) {
+		return resp, "miss", nil
+	}
+
+	maxBytes := t.CacheMaxEntryBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxEntryBytes
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, "miss", err
+	}
+	if int64(len(body)) > maxBytes {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, "miss", nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.storeCached(key, req, &cachedResponse{stored: time.Now(), resp: resp, body: body})
+	return resp, "miss", nil
+}
+
+// storable reports whether resp may be stored (RFC 7234 section 3).
+func storable(resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	_, hasMaxAge := cc["max-age"]
+	return hasMaxAge ||
+		resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// loadCached returns the stored response for key if it matches req's Vary
+// headers.
+func (t *Transport) loadCached(key string, req *http.Request) *cachedResponse {
+	value, ok := t.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+
+	// stored as "<unix nanos>\n<request header dump>\n<response dump>"
+	parts := bytes.SplitN(value, []byte("\n"), 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	nanos, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	br := bufio.NewReader(bytes.NewReader(parts[1]))
+	storedReq, err := http.ReadRequest(br)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(br, storedReq)
+	if err != nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
+	for _, name := range strings.Split(resp.Header.Get("Vary"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && req.Header.Get(name) != storedReq.Header.Get(name) {
+			return nil
+		}
+	}
+
+	return &cachedResponse{stored: time.Unix(0, nanos), resp: resp, body: body}
+}
+
+func (t *Transport) storeCached(key string, req *http.Request, cached *cachedResponse) {
+	var buf bytes.Buffer
+	buf.WriteString(strconv.FormatInt(cached.stored.UnixNano(), 10))
+	buf.WriteByte('\n')
+
+	// The request line and Vary headers are kept to match later requests.
+	storedReq, err := http.NewRequest(req.Method, req.URL.String(), nil)
+	if err != nil {
+		return
+	}
+	for _, name := range strings.Split(cached.resp.Header.Get("Vary"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			storedReq.Header.Set(name, req.Header.Get(name))
+		}
+	}
+	if err := storedReq.Write(&buf); err != nil {
+		return
+	}
+
+	resp := *cached.resp
+	resp.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+	resp.ContentLength = int64(len(cached.body))
+	resp.TransferEncoding = nil
+	dump, err := httputil.DumpResponse(&resp, true)
+	if err != nil {
+		return
+	}
+	buf.Write(dump)
+
+	t.Cache.Set(key, buf.Bytes())
+}
+
+// fresh reports whether the response can be served without revalidation
+// (RFC 7234 section 4.2).
+func (c *cachedResponse) fresh(now time.Time, reqCC map[string]string) bool {
+	respCC := parseCacheControl(c.resp.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+
+	lifetime, ok := freshnessLifetime(c.resp.Header, respCC)
+	if !ok {
+		return false
+	}
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil && time.Duration(maxAge)*time.Second < lifetime {
+			lifetime = time.Duration(maxAge) * time.Second
+		}
+	}
+
+	return c.age(now) < lifetime
+}
+
+// age returns the response's current age (RFC 7234 section 4.2.3).
+func (c *cachedResponse) age(now time.Time) time.Duration {
+	age := now.Sub(c.stored)
+	if v, err := strconv.Atoi(c.resp.Header.Get("Age")); err == nil && v > 0 {
+		age += time.Duration(v) * time.Second
+	}
+	return age
+}
+
+func freshnessLifetime(h http.Header, cc map[string]string) (time.Duration, bool) {
+	if v, ok := cc["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil {
+			return time.Duration(maxAge) * time.Second, true
+		}
+	}
+
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, true // invalid Expires means already expired
+		}
+		return t.Sub(date), true
+	}
+	// heuristic freshness: 10% of the time since last modification
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10, true
+	}
+	return 0, false
+}
+
+// response returns a copy of the stored response for req.
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	resp := *c.resp
+	resp.Header = c.resp.Header.Clone()
+	resp.Header.Set("Age", strconv.Itoa(int(c.age(time.Now())/time.Second)))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(c.body))
+	resp.ContentLength = int64(len(c.body))
+	resp.Request = req
+	return &resp
+}
+
+// parseCacheControl returns the directives of h's Cache-Control header.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(h.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
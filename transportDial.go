@@ -0,0 +1,129 @@
+package httplog
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DialPolicy configures outbound connections to a host. See
+// Transport.DialPolicies.
+type DialPolicy struct {
+	// Timeout overrides Transport.DialTimeout for the host.
+	Timeout time.Duration
+	// MaxConns limits concurrent requests to the host. Requests over the
+	// limit wait for a slot until their context is done. Optional.
+	MaxConns int
+	// Addrs are IP addresses used instead of resolving the host with DNS.
+	// They're tried in order until a connection succeeds. Optional.
+	Addrs []string
+}
+
+// dialSettings reports whether the Transport has settings which require
+// its own dialer.
+func (t *Transport) dialSettings() bool {
+	return t.DialTimeout > 0 || t.FallbackDelay != 0 || len(t.DialPolicies) > 0
+}
+
+// base returns the RoundTripper requests are sent with. When Base is nil and
+// dial settings are configured, a clone of http.DefaultTransport using the
+// Transport's dialer is created on first use.
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	if !t.dialSettings() {
+		return http.DefaultTransport
+	}
+
+	t.dialOnce.Do(func() {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.DialContext = t.dialContext
+		t.dialTransport = tr
+	})
+	return t.dialTransport
+}
+
+func (t *Transport) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	policy := t.DialPolicies[host]
+
+	timeout := policy.Timeout
+	if timeout == 0 {
+		timeout = t.DialTimeout
+	}
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout:       timeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: t.FallbackDelay,
+	}
+
+	if len(policy.Addrs) == 0 {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	for _, ip := range policy.Addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// hostLimits limits concurrent requests per host according to
+// DialPolicy.MaxConns.
+type hostLimits struct {
+	mtx   sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquire waits for a slot for host. It returns a function releasing the
+// slot, or an error if ctx is done first.
+func (l *hostLimits) acquire(ctx context.Context, host string, limit int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mtx.Lock()
+	if l.slots == nil {
+		l.slots = make(map[string]chan struct{})
+	}
+	slots, ok := l.slots[host]
+	if !ok || cap(slots) != limit {
+		slots = make(chan struct{}, limit)
+		l.slots[host] = slots
+	}
+	l.mtx.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }, nil
+}
+
+// releaseOnClose releases a host slot when the response body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,177 @@
+package httplog
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// outboundTrace records the phases of an outbound request with httptrace.
+type outboundTrace struct {
+	mtx          sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart time.Time
+	connect      time.Duration
+	tlsStart     time.Time
+	tls          time.Duration
+	ttfb         time.Duration
+	gotConn      bool
+	reused       bool
+	wasIdle      bool
+	remoteAddr   net.Addr
+}
+
+func newOutboundTrace() *outboundTrace {
+	return &outboundTrace{start: time.Now()}
+}
+
+func (t *outboundTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mtx.Lock()
+			t.dnsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mtx.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mtx.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mtx.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mtx.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mtx.Lock()
+			if err == nil {
+				t.connect = time.Since(t.connectStart)
+			}
+			t.mtx.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mtx.Lock()
+			t.tlsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mtx.Lock()
+			t.tls = time.Since(t.tlsStart)
+			t.mtx.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mtx.Lock()
+			t.gotConn = true
+			t.reused = info.Reused
+			t.wasIdle = info.WasIdle
+			t.remoteAddr = info.Conn.RemoteAddr()
+			t.mtx.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mtx.Lock()
+			t.ttfb = time.Since(t.start)
+			t.mtx.Unlock()
+		},
+	}
+}
+
+// observe exports the recorded phases as metrics for host.
+func (t *outboundTrace) observe(host string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.dns > 0 {
+		outboundPhaseDuration.WithLabelValues(host, "dns").Observe(t.dns.Seconds())
+	}
+	if t.connect > 0 {
+		outboundPhaseDuration.WithLabelValues(host, "connect").Observe(t.connect.Seconds())
+	}
+	if t.tls > 0 {
+		outboundPhaseDuration.WithLabelValues(host, "tls").Observe(t.tls.Seconds())
+	}
+	if t.ttfb > 0 {
+		outboundPhaseDuration.WithLabelValues(host, "ttfb").Observe(t.ttfb.Seconds())
+	}
+	if t.gotConn {
+		outboundConnectionsTotal.WithLabelValues(host, strconv.FormatBool(t.reused)).Inc()
+	}
+}
+
+// remoteIP returns the IP address of the connection used, if any.
+func (t *outboundTrace) remoteIP() string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.remoteAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(t.remoteAddr.String())
+	if err != nil {
+		return t.remoteAddr.String()
+	}
+	return host
+}
+
+// addFields adds the recorded phases to fields in milliseconds.
+func (t *outboundTrace) addFields(fields map[string]interface{}) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	fields["dns_ms"] = int64(t.dns / time.Millisecond)
+	fields["connect_ms"] = int64(t.connect / time.Millisecond)
+	fields["tls_ms"] = int64(t.tls / time.Millisecond)
+	fields["ttfb_ms"] = int64(t.ttfb / time.Millisecond)
+	fields["conn_reused"] = t.reused
+	fields["conn_was_idle"] = t.wasIdle
+}
+
+// hostPool tracks in-flight requests per host to report pool saturation.
+type hostPool struct {
+	mtx      sync.Mutex
+	inFlight map[string]int
+}
+
+func (p *hostPool) acquire(host string, limit int) {
+	p.mtx.Lock()
+	if p.inFlight == nil {
+		p.inFlight = make(map[string]int)
+	}
+	p.inFlight[host]++
+	n := p.inFlight[host]
+	p.mtx.Unlock()
+	p.report(host, n, limit)
+}
+
+func (p *hostPool) release(host string, limit int) {
+	p.mtx.Lock()
+	p.inFlight[host]--
+	n := p.inFlight[host]
+	if n == 0 {
+		delete(p.inFlight, host)
+	}
+	p.mtx.Unlock()
+	p.report(host, n, limit)
+}
+
+func (p *hostPool) report(host string, n, limit int) {
+	outboundInFlight.WithLabelValues(host).Set(float64(n))
+	if limit > 0 {
+		outboundPoolSaturation.WithLabelValues(host).Set(float64(n) / float64(limit))
+	}
+}
+
+// maxConnsPerHost returns the connection limit of rt if it's known.
+func maxConnsPerHost(rt http.RoundTripper) int {
+	if t, ok := rt.(*http.Transport); ok {
+		return t.MaxConnsPerHost
+	}
+	return 0
+}
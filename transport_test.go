@@ -0,0 +1,153 @@
+package httplog
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransportLinksDownstreamRequest(t *testing.T) {
+	// arrange
+	var downstreamHeader http.Header
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamHeader = r.Header
+	}))
+	defer downstream.Close()
+
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	defer s.Shutdown()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	handler := Handler{Name: "test", Func: func(r *http.Request, _ Entry) (Response, error) {
+		req, err := http.NewRequest("GET", downstream.URL, nil)
+		if err != nil {
+			return Response{}, err
+		}
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			return Response{}, err
+		}
+		resp.Body.Close()
+		return Response{}, nil
+	}}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.Handle(handler)))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIDHeader, "parent-id")
+
+	// act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// assert
+	if got := resp.Header.Get(requestIDHeader); got != "parent-id" {
+		t.Errorf("response %s want: %q got: %q", requestIDHeader, "parent-id", got)
+	}
+	if got := downstreamHeader.Get(parentRequestIDHeader); got != "parent-id" {
+		t.Errorf("downstream %s want: %q got: %q", parentRequestIDHeader, "parent-id", got)
+	}
+	if got := downstreamHeader.Get(requestDepthHeader); got != "1" {
+		t.Errorf("downstream %s want: %q got: %q", requestDepthHeader, "1", got)
+	}
+	if got := downstreamHeader.Get(requestIDHeader); got == "" || got == "parent-id" {
+		t.Errorf("downstream %s want: new ID got: %q", requestIDHeader, got)
+	}
+}
+
+func TestTransportStaticResolution(t *testing.T) {
+	// arrange
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer downstream.Close()
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(downstream.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &Transport{
+		NewLogEntry: func() Entry { return &nullLogger{} },
+		DialPolicies: map[string]DialPolicy{
+			"upstream.invalid": {Addrs: []string{"127.0.0.1"}, MaxConns: 1},
+		},
+	}}
+
+	// act
+	resp, err := client.Get("http://upstream.invalid:" + port + "/")
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status want: %d got: %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestTransportCache(t *testing.T) {
+	// arrange
+	var requests, notModified int
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/revalidate" {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer downstream.Close()
+
+	client := &http.Client{Transport: &Transport{
+		NewLogEntry: func() Entry { return &nullLogger{} },
+		Cache:       NewMemoryResponseCache(0),
+	}}
+
+	get := func(path string) string {
+		resp, err := client.Get(downstream.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// act
+	bodies := []string{get("/fresh"), get("/fresh"), get("/revalidate"), get("/revalidate")}
+
+	// assert
+	for i, b := range bodies {
+		if b != "body" {
+			t.Errorf("request %d: body want: %q got: %q", i, "body", b)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("downstream requests want: 3 got: %d", requests)
+	}
+	if notModified != 1 {
+		t.Errorf("revalidations want: 1 got: %d", notModified)
+	}
+}
@@ -0,0 +1,166 @@
+package httplog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrUploadTooLarge is returned by ReceiveUpload when a part or the
+	// whole upload exceeds its limit.
+	ErrUploadTooLarge = errors.New("upload too large")
+	// ErrTooManyParts is returned by ReceiveUpload when the upload has more
+	// parts than allowed.
+	ErrTooManyParts = errors.New("too many upload parts")
+)
+
+// UploadOptions limits a multipart upload. Zero values mean no limit. Bytes
+// past a limit aren't passed to the part's writer, and once MaxTotalBytes
+// have been received any further part fails with ErrUploadTooLarge.
+type UploadOptions struct {
+	MaxPartBytes  int64
+	MaxTotalBytes int64
+	MaxParts      int
+}
+
+// UploadPart describes a part of a multipart upload.
+type UploadPart struct {
+	FormName string
+	FileName string
+	// DeclaredType is the part's Content-Type header as sent by the client.
+	DeclaredType string
+	// ContentType is sniffed from the part's first 512 bytes.
+	ContentType string
+	// Size is the number of bytes received. It's set once the part has
+	// been copied.
+	Size int64
+}
+
+// UploadResult summarizes a multipart upload received by ReceiveUpload.
+type UploadResult struct {
+	Parts      []UploadPart
+	TotalBytes int64
+	Duration   time.Duration
+}
+
+// ReceiveUpload streams each part of r's multipart body to the writer
+// returned by dest. dest is called after the part's content type has been
+// sniffed; returning a nil writer discards the part. Parts are copied as they
+// arrive, so uploads aren't held in memory.
+//
+// The following keys are added to entry, including when an error is
+// returned:
+//
+//	upload_parts         The number of parts received.
+//	upload_bytes         The total number of bytes received.
+//	upload_parse_ms      The time taken to receive the upload in milliseconds.
+func ReceiveUpload(r *http.Request, entry Entry, opts UploadOptions, dest func(part *UploadPart) (io.Writer, error)) (*UploadResult, error) {
+	start := time.Now()
+	result := &UploadResult{}
+
+	defer func() {
+		result.Duration = time.Since(start)
+		entry.AddFields(map[string]interface{}{
+			"upload_parts":    len(result.Parts),
+			"upload_bytes":    result.TotalBytes,
+			"upload_parse_ms": int64(result.Duration / time.Millisecond),
+		})
+	}()
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if opts.MaxParts > 0 && len(result.Parts) >= opts.MaxParts {
+			part.Close()
+			return result, ErrTooManyParts
+		}
+
+		n, err := receivePart(part, result, opts, dest)
+		part.Close()
+		result.TotalBytes += n
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+func receivePart(part *multipart.Part, result *UploadResult, opts UploadOptions, dest func(part *UploadPart) (io.Writer, error)) (int64, error) {
+	// limited is false when neither limit applies, which is distinct from
+	// a limit of zero bytes left
+	limit, limited := opts.MaxPartBytes, opts.MaxPartBytes > 0
+	tooLarge := func() error {
+		return fmt.Errorf("%w: part %q exceeds %d bytes", ErrUploadTooLarge, part.FormName(), limit)
+	}
+	if opts.MaxTotalBytes > 0 {
+		remaining := opts.MaxTotalBytes - result.TotalBytes
+		if remaining <= 0 {
+			return 0, fmt.Errorf("%w: upload exceeds %d bytes", ErrUploadTooLarge, opts.MaxTotalBytes)
+		}
+		if !limited || remaining < limit {
+			limit, limited = remaining, true
+			tooLarge = func() error {
+				return fmt.Errorf("%w: upload exceeds %d bytes", ErrUploadTooLarge, opts.MaxTotalBytes)
+			}
+		}
+	}
+
+	var src io.Reader = part
+	if limited {
+		src = io.LimitReader(part, limit)
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(src, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return int64(n), err
+	}
+	sniff = sniff[:n]
+
+	up := UploadPart{
+		FormName:     part.FormName(),
+		FileName:     part.FileName(),
+		DeclaredType: part.Header.Get("Content-Type"),
+		ContentType:  http.DetectContentType(sniff),
+	}
+
+	w, err := dest(&up)
+	if err != nil {
+		return int64(n), err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	size, err := io.Copy(w, io.MultiReader(bytes.NewReader(sniff), src))
+	up.Size = size
+	result.Parts = append(result.Parts, up)
+	if err != nil {
+		return size, err
+	}
+	// a part filling its limit is too large if anything is left; the
+	// extra byte isn't passed to dest
+	if limited && size == limit {
+		var extra [1]byte
+		if n, _ := io.ReadFull(part, extra[:]); n > 0 {
+			return size, tooLarge()
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,84 @@
+package httplog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReceiveUpload(t *testing.T) {
+	cases := []struct {
+		name          string
+		parts         []string
+		opts          UploadOptions
+		expectedErr   error
+		expectedParts int
+		expectedBytes int64
+	}{
+		{"no limits", []string{"aaaa", "bbbb"}, UploadOptions{}, nil, 2, 8},
+		{"part at limit", []string{"aaaa"}, UploadOptions{MaxPartBytes: 4}, nil, 1, 4},
+		{"part over limit", []string{"aaaaa"}, UploadOptions{MaxPartBytes: 4}, ErrUploadTooLarge, 1, 4},
+		{"total over limit", []string{"aaaa", "bbbb"}, UploadOptions{MaxTotalBytes: 6}, ErrUploadTooLarge, 2, 6},
+		{"total exhausted at boundary", []string{"aaaa", "bbbb", "c"}, UploadOptions{MaxTotalBytes: 8}, ErrUploadTooLarge, 2, 8},
+		{"total at limit", []string{"aaaa", "bbbb"}, UploadOptions{MaxTotalBytes: 8}, nil, 2, 8},
+		{"part limit under total", []string{"aaaa", "bbbbbb"}, UploadOptions{MaxPartBytes: 5, MaxTotalBytes: 100}, ErrUploadTooLarge, 2, 9},
+		{"too many parts", []string{"a", "b", "c"}, UploadOptions{MaxParts: 2}, ErrTooManyParts, 2, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for i, content := range c.parts {
+				fw, err := mw.CreateFormFile("file", string(rune('a'+i))+".txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = io.WriteString(fw, content)
+			}
+			_ = mw.Close()
+			r := httptest.NewRequest("POST", "/", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			entry := newFieldEntry(&nullLogger{})
+
+			var received []*bytes.Buffer
+			dest := func(_ *UploadPart) (io.Writer, error) {
+				buf := &bytes.Buffer{}
+				received = append(received, buf)
+				return buf, nil
+			}
+
+			// act
+			result, err := ReceiveUpload(r, entry, c.opts, dest)
+
+			// assert
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("err want: %v got: %v", c.expectedErr, err)
+			}
+			if len(result.Parts) != c.expectedParts {
+				t.Errorf("parts want: %d got: %d", c.expectedParts, len(result.Parts))
+			}
+			if result.TotalBytes != c.expectedBytes {
+				t.Errorf("total bytes want: %d got: %d", c.expectedBytes, result.TotalBytes)
+			}
+			var written int64
+			for i, buf := range received {
+				written += int64(buf.Len())
+				if !strings.HasPrefix(c.parts[i], buf.String()) {
+					t.Errorf("part %d got: %q", i, buf.String())
+				}
+			}
+			if written != c.expectedBytes {
+				t.Errorf("bytes passed to dest want: %d got: %d", c.expectedBytes, written)
+			}
+			if got := entry.fields["upload_bytes"]; got != c.expectedBytes {
+				t.Errorf("upload_bytes want: %d got: %v", c.expectedBytes, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package httplog
+
+import (
+	"errors"
+	"strings"
+)
+
+// ValidationError describes a request field which failed validation. Reason
+// should be a short, stable identifier such as "required" or "too_long"
+// since it's used as a metric label.
+type ValidationError struct {
+	Field   string
+	Reason  string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Message != "" {
+		return e.Field + ": " + e.Message
+	}
+	return e.Field + ": " + e.Reason
+}
+
+// ValidationErrors is returned by handlers to report one or more invalid
+// fields. Server counts each failure in the
+// http_request_validation_failures_total metric by handler, field, and
+// reason, and logs them as validation_failures.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, ve := range e {
+		msgs[i] = ve.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validationFailures returns the validation errors in err's chain.
+func validationFailures(err error) []ValidationError {
+	var verrs ValidationErrors
+	if errors.As(err, &verrs) {
+		return verrs
+	}
+	var verr ValidationError
+	if errors.As(err, &verr) {
+		return []ValidationError{verr}
+	}
+	return nil
+}
+
+func observeValidation(rec *AccessRecord, err error) {
+	failures := validationFailures(err)
+	if len(failures) == 0 {
+		return
+	}
+
+	logged := make([]string, len(failures))
+	for i, f := range failures {
+		validationFailuresTotal.WithLabelValues(rec.Handler, f.Field, f.Reason).Inc()
+		logged[i] = f.Field + ":" + f.Reason
+	}
+	rec.Fields["validation_failures"] = logged
+}
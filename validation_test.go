@@ -0,0 +1,97 @@
+package httplog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestValidationErrorsError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "reason", err: ValidationError{Field: "email", Reason: "required"}, want: "email: required"},
+		{name: "message", err: ValidationError{Field: "email", Reason: "invalid", Message: "not an address"}, want: "email: not an address"},
+		{
+			name: "list",
+			err:  ValidationErrors{{Field: "email", Reason: "required"}, {Field: "name", Reason: "too_long"}},
+			want: "email: required; name: too_long",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := c.err.Error()
+
+			// assert
+			if got != c.want {
+				t.Errorf("want: %q got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestValidationFailures(t *testing.T) {
+	single := ValidationError{Field: "email", Reason: "required"}
+	list := ValidationErrors{single, {Field: "name", Reason: "too_long"}}
+
+	cases := []struct {
+		name string
+		err  error
+		want []ValidationError
+	}{
+		{name: "nil", err: nil, want: nil},
+		{name: "other error", err: errors.New("boom"), want: nil},
+		{name: "single", err: single, want: []ValidationError{single}},
+		{name: "list", err: list, want: list},
+		{name: "wrapped list", err: fmt.Errorf("decoding: %w", list), want: list},
+		{name: "with stack", err: withStack(single), want: []ValidationError{single}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := validationFailures(c.err)
+
+			// assert
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsLogged(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	handler := Handler{Name: "validation_test", Func: func(_ *http.Request, _ Entry) (Response, error) {
+		return Response{Status: http.StatusBadRequest}, ValidationErrors{
+			{Field: "email", Reason: "required"},
+			{Field: "name", Reason: "too_long"},
+		}
+	}}
+	counter := validationFailuresTotal.WithLabelValues("validation_test", "email", "required")
+	before := counterValue(t, counter)
+
+	// act
+	s.Handle(handler)(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	s.Shutdown()
+
+	// assert
+	want := []string{"email:required", "name:too_long"}
+	if got := sink.records[0].Fields["validation_failures"]; !reflect.DeepEqual(want, got) {
+		t.Errorf("validation_failures want: %v got: %v", want, got)
+	}
+	if got := counterValue(t, counter) - before; got != 1 {
+		t.Errorf("http_request_validation_failures_total want: +1 got: %+v", got)
+	}
+}
@@ -0,0 +1,175 @@
+package httplog
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionScheme selects where a VersionedHandler reads the requested API
+// version from.
+type VersionScheme int
+
+const (
+	// VersionPath reads the version from the first path segment, for
+	// example "/v2/orders". The segment is removed before the version's
+	// handler is called. Paths without a segment shaped like a version,
+	// "v" followed by a digit, are served by Default.
+	VersionPath VersionScheme = iota
+	// VersionHeader reads the version from a request header.
+	VersionHeader
+	// VersionMediaType reads the version from the Accept header's version
+	// parameter, "application/json; version=2", or a vendor media type,
+	// "application/vnd.example.v2+json".
+	VersionMediaType
+)
+
+const defaultVersionHeader = "API-Version"
+
+var (
+	vendorVersion = regexp.MustCompile(`\.(v[0-9][0-9a-zA-Z.]*)\+`)
+	pathVersion   = regexp.MustCompile(`^v[0-9][0-9a-zA-Z.]*$`)
+)
+
+// APIVersion is one version served by a VersionedHandler.
+type APIVersion struct {
+	// Name is the version, for example "v2".
+	Name string
+	// Func serves requests for the version.
+	Func loggedHandler
+	// Deprecated marks the version deprecated. Responses include the
+	// Deprecation header and, when Sunset is set, the Sunset header.
+	Deprecated bool
+	// Deprecation is when the version was deprecated. Optional.
+	Deprecation time.Time
+	// Sunset is when the version will be removed. Optional.
+	Sunset time.Time
+}
+
+// VersionedHandler dispatches requests to one of several API versions.
+// Use Handler to serve it with Server.Handle.
+//
+// The version served is logged as api_version, returned in the API-Version
+// response header, and counted in httplog_api_version_requests_total so
+// client migration off old versions can be tracked.
+type VersionedHandler struct {
+	// Name is the Handler's name.
+	Name string
+	// Scheme selects how the version is read from requests.
+	Scheme VersionScheme
+	// Header is the request header read by VersionHeader. The default is
+	// API-Version.
+	Header string
+	// Versions lists the versions served.
+	Versions []APIVersion
+	// Default is the version served to requests which don't specify one.
+	// When empty they're rejected with StatusBadRequest (400).
+	Default string
+}
+
+// Handler returns a Handler which serves v. Requests for unknown versions
+// respond with StatusNotFound (404) for VersionPath, StatusNotAcceptable
+// (406) for VersionMediaType, and StatusBadRequest (400) for VersionHeader.
+func (v *VersionedHandler) Handler() Handler {
+	versions := make(map[string]APIVersion, len(v.Versions))
+	for _, version := range v.Versions {
+		versions[version.Name] = version
+	}
+
+	return Handler{
+		Name: v.Name,
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			name := v.requestedVersion(r)
+			if name == "" {
+				name = v.Default
+			}
+
+			version, ok := versions[name]
+			if !ok {
+				if name != "" {
+					entry.AddField("api_version_unknown", name)
+				}
+				return Response{Status: v.unknownStatus()}, nil
+			}
+			entry.AddField("api_version", name)
+			apiVersionRequestsTotal.WithLabelValues(v.Name, name).Inc()
+
+			resp, err := version.Func(r, entry)
+			resp.Headers = append(resp.Headers, Header{Name: defaultVersionHeader, Value: name})
+			if version.Deprecated {
+				entry.AddField("deprecated_version", true)
+				resp.Headers = append(resp.Headers, deprecationHeaders(version.Deprecation, version.Sunset)...)
+			}
+			return resp, err
+		},
+	}
+}
+
+// requestedVersion returns the version requested by r, removing the version
+// segment from the path for VersionPath.
+func (v *VersionedHandler) requestedVersion(r *http.Request) string {
+	switch v.Scheme {
+	case VersionPath:
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		segment, rest, _ := strings.Cut(path, "/")
+		for _, version := range v.Versions {
+			if version.Name == segment {
+				r.URL.Path = "/" + rest
+				r.URL.RawPath = ""
+				return segment
+			}
+		}
+		// an unknown segment shaped like a version isn't served by Default
+		if pathVersion.MatchString(segment) {
+			return segment
+		}
+		return ""
+	case VersionHeader:
+		header := v.Header
+		if header == "" {
+			header = defaultVersionHeader
+		}
+		return strings.TrimSpace(r.Header.Get(header))
+	case VersionMediaType:
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+			if err != nil {
+				continue
+			}
+			if version := params["version"]; version != "" {
+				return version
+			}
+			if m := vendorVersion.FindStringSubmatch(mediaType); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+func (v *VersionedHandler) unknownStatus() int {
+	switch v.Scheme {
+	case VersionPath:
+		return http.StatusNotFound
+	case VersionMediaType:
+		return http.StatusNotAcceptable
+	}
+	return http.StatusBadRequest
+}
+
+// deprecationHeaders returns the Deprecation (RFC 9745) and Sunset (RFC
+// 8594) headers. Deprecation is "@<unix seconds>" when the date is known.
+func deprecationHeaders(deprecation, sunset time.Time) []Header {
+	value := "true"
+	if !deprecation.IsZero() {
+		value = "@" + strconv.FormatInt(deprecation.Unix(), 10)
+	}
+	headers := []Header{{Name: "Deprecation", Value: value}}
+	if !sunset.IsZero() {
+		headers = append(headers, Header{Name: "Sunset", Value: sunset.UTC().Format(http.TimeFormat)})
+	}
+	return headers
+}
@@ -0,0 +1,104 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionedHandler(t *testing.T) {
+	serve := func(name string) loggedHandler {
+		return func(r *http.Request, _ Entry) (Response, error) {
+			return Response{Body: name + " " + r.URL.Path}, nil
+		}
+	}
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	versions := []APIVersion{
+		{Name: "v1", Func: serve("v1"), Deprecated: true, Sunset: sunset},
+		{Name: "v2", Func: serve("v2")},
+	}
+
+	cases := []struct {
+		Name   string
+		Scheme VersionScheme
+		Path   string
+		Header string
+		Value  string
+		Status int
+		Body   string
+		Sunset string
+	}{
+		{"path", VersionPath, "/v2/orders", "", "", 200, "v2 /orders", ""},
+		{"path deprecated", VersionPath, "/v1/orders", "", "", 200, "v1 /orders", "Fri, 01 Jan 2027 00:00:00 GMT"},
+		{"path unknown", VersionPath, "/v9/orders", "", "", 404, "", ""},
+		{"header", VersionHeader, "/orders", "API-Version", "v1", 200, "v1 /orders", "Fri, 01 Jan 2027 00:00:00 GMT"},
+		{"header default", VersionHeader, "/orders", "", "", 200, "v2 /orders", ""},
+		{"header unknown", VersionHeader, "/orders", "API-Version", "v3", 400, "", ""},
+		{"media type param", VersionMediaType, "/orders", "Accept", "application/json; version=v1", 200, "v1 /orders", "Fri, 01 Jan 2027 00:00:00 GMT"},
+		{"media type vendor", VersionMediaType, "/orders", "Accept", "application/vnd.example.v2+json", 200, "v2 /orders", ""},
+		{"media type unknown", VersionMediaType, "/orders", "Accept", "application/vnd.example.v7+json", 406, "", ""},
+	}
+
+	for _, c := range cases {
+		// arrange
+		var s Server
+		s.NewLogEntry = func() Entry { return &nullLogger{} }
+		v := &VersionedHandler{Name: "orders", Scheme: c.Scheme, Versions: versions, Default: "v2"}
+		r := httptest.NewRequest("GET", c.Path, nil)
+		if c.Header != "" {
+			r.Header.Set(c.Header, c.Value)
+		}
+		w := httptest.NewRecorder()
+
+		// act
+		s.Handle(v.Handler())(w, r)
+		s.Shutdown()
+
+		// assert
+		if w.Code != c.Status {
+			t.Errorf("%s: status want: %d got: %d", c.Name, c.Status, w.Code)
+		}
+		if c.Body != "" && w.Body.String() != c.Body {
+			t.Errorf("%s: body want: %q got: %q", c.Name, c.Body, w.Body.String())
+		}
+		if got := w.Header().Get("Sunset"); got != c.Sunset {
+			t.Errorf("%s: Sunset want: %q got: %q", c.Name, c.Sunset, got)
+		}
+		if deprecated := w.Header().Get("Deprecation") != ""; deprecated != (c.Sunset != "") {
+			t.Errorf("%s: Deprecation header want: %v got: %v", c.Name, c.Sunset != "", deprecated)
+		}
+	}
+}
+
+func TestHandlerDeprecated(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+	handler := Handler{
+		Name:       "legacy_orders",
+		Deprecated: true,
+		Sunset:     time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC),
+		Func: func(_ *http.Request, entry Entry) (Response, error) {
+			return Response{}, nil
+		},
+	}
+	w := httptest.NewRecorder()
+
+	// act
+	s.Handle(handler)(w, httptest.NewRequest("GET", "/orders", nil))
+	s.Shutdown()
+
+	// assert
+	if got := w.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation want: true got: %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("Sunset want: Wed, 30 Jun 2027 00:00:00 GMT got: %q", got)
+	}
+	if got := sink.records[0].Fields["deprecated_endpoint"]; got != true {
+		t.Errorf("deprecated_endpoint want: true got: %v", got)
+	}
+}
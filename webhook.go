@@ -0,0 +1,452 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookAttempts         = 5
+	defaultWebhookBackoff          = time.Second
+	defaultWebhookMaxBackoff       = 5 * time.Minute
+	defaultWebhookBreakerThreshold = 5
+	defaultWebhookBreakerCooldown  = time.Minute
+	defaultWebhookQueueSize        = 1024
+	defaultWebhookWorkers          = 4
+)
+
+// ErrWebhookQueueFull is returned by WebhookDispatcher.Send when the
+// delivery queue is full or the dispatcher is closed.
+var ErrWebhookQueueFull = errors.New("webhook queue full")
+
+// WebhookDispatcher delivers webhooks in the background through Transport.
+//
+// Deliveries which fail with a network error, a 5xx, or a 429 are retried
+// with exponential backoff. After BreakerThreshold consecutive failures to a
+// destination host its circuit opens and deliveries to it are deferred
+// until BreakerCooldown passes; deferrals count as attempts. Then a single
+// trial delivery is sent while the others wait for it: success closes the
+// circuit and releases them, and failure opens it again. Each attempt is
+// logged with webhook_id,
+// webhook_url, attempt, http_status, time_taken, and outcome (delivered,
+// retry, failed, or circuit_open), and counted in
+// httplog_webhook_deliveries_total.
+type WebhookDispatcher struct {
+	// NewLogEntry creates delivery log entries. If nil a fallback logger is
+	// used.
+	NewLogEntry func() Entry
+	// KeyID and Key sign deliveries with SignRequest when Key is set.
+	KeyID string
+	Key   []byte
+	// MaxAttempts is the number of times a delivery is attempted. The
+	// default is 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles with each
+	// attempt up to MaxBackoff. The defaults are 1s and 5m.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold and BreakerCooldown configure per-host circuit
+	// breaking. The defaults are 5 failures and 1m.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// QueueSize and Workers size the delivery queue. The defaults are 1024
+	// and 4.
+	QueueSize int
+	Workers   int
+	// Client sends deliveries. The default client uses Transport and has a
+	// 30s timeout.
+	Client *http.Client
+
+	queueOnce sync.Once
+	queue     *jobQueue
+	pending   sync.WaitGroup
+
+	clientOnce sync.Once
+	client     *http.Client
+
+	mtx sync.Mutex
+	// closing is set when Close starts, rejecting new deliveries while
+	// pending ones drain; closed is set once they have, rejecting retries.
+	closing  bool
+	closed   bool
+	breakers map[string]*circuitBreaker
+}
+
+type webhookDelivery struct {
+	id        string
+	url       string
+	host      string
+	payload   []byte
+	attempt   int
+	requestID string
+}
+
+// Send queues payload for delivery to rawURL as JSON. If ctx belongs to a
+// request served by Server.Handle the request's ID is logged with each
+// attempt as parent_request_id. It returns the delivery's ID, which is also
+// sent in the X-Webhook-ID header so receivers can discard duplicates.
+func (d *WebhookDispatcher) Send(ctx context.Context, rawURL string, payload []byte) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	del := &webhookDelivery{
+		id:        newRequestID(),
+		url:       rawURL,
+		host:      u.Host,
+		payload:   payload,
+		requestID: RequestIDFromContext(ctx),
+	}
+
+	d.mtx.Lock()
+	if d.closing {
+		d.mtx.Unlock()
+		webhookDeliveriesTotal.WithLabelValues(del.host, "dropped").Inc()
+		return "", ErrWebhookQueueFull
+	}
+	// added under mtx so it can't race with Close's Wait
+	d.pending.Add(1)
+	d.mtx.Unlock()
+	if !d.enqueue(del) {
+		d.pending.Done()
+		webhookDeliveriesTotal.WithLabelValues(del.host, "dropped").Inc()
+		return "", ErrWebhookQueueFull
+	}
+	return del.id, nil
+}
+
+// Close stops accepting deliveries and waits up to timeout for queued
+// deliveries and retries to finish. Retries still waiting when the timeout
+// elapses are abandoned. A timeout <= 0 waits indefinitely.
+func (d *WebhookDispatcher) Close(timeout time.Duration) bool {
+	d.mtx.Lock()
+	d.closing = true
+	d.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+
+	finished := true
+	if timeout <= 0 {
+		<-done
+	} else {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			finished = false
+		}
+	}
+
+	d.mtx.Lock()
+	d.closed = true
+	d.mtx.Unlock()
+	d.getQueue().close(timeout)
+	return finished
+}
+
+func (d *WebhookDispatcher) getQueue() *jobQueue {
+	d.queueOnce.Do(func() {
+		size := d.QueueSize
+		if size <= 0 {
+			size = defaultWebhookQueueSize
+		}
+		workers := d.Workers
+		if workers <= 0 {
+			workers = defaultWebhookWorkers
+		}
+		d.queue = newJobQueue(size, workers, OverflowBlock, nil)
+	})
+	return d.queue
+}
+
+// enqueue queues del, returning false if the queue is full or closed.
+func (d *WebhookDispatcher) enqueue(del *webhookDelivery) bool {
+	d.mtx.Lock()
+	closed := d.closed
+	d.mtx.Unlock()
+	if closed {
+		return false
+	}
+
+	return d.getQueue().tryPush(func() { d.deliver(del) })
+}
+
+func (d *WebhookDispatcher) deliver(del *webhookDelivery) {
+	breaker := d.breaker(del.host)
+	allowed, wait := breaker.acquire(time.Now(), func() { d.retry(del, 0) })
+	if !allowed && wait == 0 {
+		// a trial delivery is in flight; del is requeued when it completes
+		return
+	}
+
+	del.attempt++
+
+	entry := d.newEntry()
+	fields := map[string]interface{}{
+		"webhook_id":  del.id,
+		"webhook_url": redactURL(del.url),
+		"attempt":     del.attempt,
+	}
+	if del.requestID != "" {
+		fields["parent_request_id"] = del.requestID
+	}
+
+	if !allowed {
+		if del.attempt >= d.maxAttempts() {
+			fields["outcome"] = "failed"
+			fields["circuit_open"] = true
+			entry.AddFields(fields)
+			entry.Error("webhook delivery failed")
+			webhookDeliveriesTotal.WithLabelValues(del.host, "failed").Inc()
+			d.pending.Done()
+			return
+		}
+		fields["outcome"] = "circuit_open"
+		entry.AddFields(fields)
+		entry.Warn("webhook delivery deferred")
+		webhookDeliveriesTotal.WithLabelValues(del.host, "circuit_open").Inc()
+		d.retry(del, wait)
+		return
+	}
+
+	start := time.Now()
+	status, err := d.post(del)
+	duration := time.Since(start)
+	webhookDeliveryDuration.WithLabelValues(del.host).Observe(duration.Seconds())
+
+	fields["http_status"] = status
+	fields["time_taken"] = int64(duration / time.Millisecond)
+
+	if err == nil && status >= 200 && status < 300 {
+		breaker.success()
+		fields["outcome"] = "delivered"
+		entry.AddFields(fields)
+		entry.Info("webhook delivered")
+		webhookDeliveriesTotal.WithLabelValues(del.host, "delivered").Inc()
+		d.pending.Done()
+		return
+	}
+
+	retryable := err != nil || status >= 500 || status == http.StatusTooManyRequests
+	if retryable {
+		breaker.failure(time.Now(), d.breakerThreshold(), d.breakerCooldown())
+	} else {
+		// the host is up, though it rejected the delivery
+		breaker.success()
+	}
+	if err != nil {
+		entry.AddError(err)
+	}
+
+	if retryable && del.attempt < d.maxAttempts() {
+		fields["outcome"] = "retry"
+		entry.AddFields(fields)
+		entry.Warn("webhook delivery failed")
+		webhookDeliveriesTotal.WithLabelValues(del.host, "retry").Inc()
+		d.retry(del, d.backoff(del.attempt))
+		return
+	}
+
+	fields["outcome"] = "failed"
+	entry.AddFields(fields)
+	entry.Error("webhook delivery failed")
+	webhookDeliveriesTotal.WithLabelValues(del.host, "failed").Inc()
+	d.pending.Done()
+}
+
+// retry requeues del after delay. Deliveries which can't be requeued are
+// abandoned.
+func (d *WebhookDispatcher) retry(del *webhookDelivery, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if !d.enqueue(del) {
+			entry := d.newEntry()
+			entry.AddFields(map[string]interface{}{
+				"webhook_id":  del.id,
+				"webhook_url": redactURL(del.url),
+				"attempt":     del.attempt,
+				"outcome":     "abandoned",
+			})
+			entry.Error("webhook delivery abandoned")
+			webhookDeliveriesTotal.WithLabelValues(del.host, "abandoned").Inc()
+			d.pending.Done()
+		}
+	})
+}
+
+func (d *WebhookDispatcher) post(del *webhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", del.url, bytes.NewReader(del.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", del.id)
+	if del.requestID != "" {
+		req.Header.Set(parentRequestIDHeader, del.requestID)
+	}
+	if len(d.Key) > 0 {
+		SignRequest(req, d.KeyID, d.Key, del.payload)
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (d *WebhookDispatcher) httpClient() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	d.clientOnce.Do(func() {
+		d.client = &http.Client{Transport: &Transport{NewLogEntry: d.NewLogEntry}, Timeout: 30 * time.Second}
+	})
+	return d.client
+}
+
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = defaultWebhookBackoff
+	}
+	maxBackoff := d.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultWebhookMaxBackoff
+	}
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func (d *WebhookDispatcher) maxAttempts() int {
+	if d.MaxAttempts <= 0 {
+		return defaultWebhookAttempts
+	}
+	return d.MaxAttempts
+}
+
+func (d *WebhookDispatcher) breakerThreshold() int {
+	if d.BreakerThreshold <= 0 {
+		return defaultWebhookBreakerThreshold
+	}
+	return d.BreakerThreshold
+}
+
+func (d *WebhookDispatcher) breakerCooldown() time.Duration {
+	if d.BreakerCooldown <= 0 {
+		return defaultWebhookBreakerCooldown
+	}
+	return d.BreakerCooldown
+}
+
+func (d *WebhookDispatcher) breaker(host string) *circuitBreaker {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.breakers == nil {
+		d.breakers = make(map[string]*circuitBreaker)
+	}
+	b, ok := d.breakers[host]
+	if !ok {
+		b = &circuitBreaker{}
+		d.breakers[host] = b
+	}
+	return b
+}
+
+func (d *WebhookDispatcher) newEntry() Entry {
+	if d.NewLogEntry != nil {
+		return d.NewLogEntry()
+	}
+	return &fallbackLogger{}
+}
+
+// circuitBreaker opens after consecutive failures. Once its cooldown passes
+// it's half-open: a single trial request is allowed while others wait for
+// its outcome.
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+	// trial is set while the half-open breaker's trial request is in flight.
+	trial bool
+	// waiting are resumed when the trial request completes.
+	waiting []func()
+}
+
+// acquire reports whether a request may be sent now. If the breaker is open
+// it returns how long until the cooldown passes. If a trial request is in
+// flight it returns a wait of 0 and calls resume when the trial completes.
+func (b *circuitBreaker) acquire(now time.Time, resume func()) (bool, time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if now.Before(b.openUntil) {
+		return false, b.openUntil.Sub(now)
+	}
+	if b.trial {
+		b.waiting = append(b.waiting, resume)
+		return false, 0
+	}
+	if !b.openUntil.IsZero() {
+		b.trial = true
+	}
+	return true, 0
+}
+
+func (b *circuitBreaker) success() {
+	b.mtx.Lock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	waiting := b.endTrial()
+	b.mtx.Unlock()
+
+	for _, resume := range waiting {
+		resume()
+	}
+}
+
+func (b *circuitBreaker) failure(now time.Time, threshold int, cooldown time.Duration) {
+	b.mtx.Lock()
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+	waiting := b.endTrial()
+	b.mtx.Unlock()
+
+	// waiting requests find the breaker open again and are deferred
+	for _, resume := range waiting {
+		resume()
+	}
+}
+
+// endTrial ends the trial request, returning the requests waiting for it.
+// b.mtx must be held.
+func (b *circuitBreaker) endTrial() []func() {
+	b.trial = false
+	waiting := b.waiting
+	b.waiting = nil
+	return waiting
+}
+
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Sprintf("<invalid url: %d bytes>", len(rawURL))
+	}
+	return u.Redacted()
+}
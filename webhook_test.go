@@ -0,0 +1,175 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcherRetries(t *testing.T) {
+	// arrange
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(SignatureHeader) == "" {
+			t.Error("want signed delivery")
+		}
+	}))
+	defer ts.Close()
+
+	d := &WebhookDispatcher{
+		NewLogEntry: func() Entry { return &nullLogger{} },
+		KeyID:       "k1",
+		Key:         []byte("secret"),
+		Backoff:     time.Millisecond,
+	}
+
+	// act
+	if _, err := d.Send(context.Background(), ts.URL, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	finished := d.Close(5 * time.Second)
+
+	// assert
+	if !finished {
+		t.Error("Close timed out")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts want: 3 got: %d", got)
+	}
+}
+
+func TestWebhookDispatcherCircuitOpenMaxAttempts(t *testing.T) {
+	// arrange
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	d := &WebhookDispatcher{
+		NewLogEntry:      func() Entry { return &nullLogger{} },
+		MaxAttempts:      2,
+		Backoff:          time.Millisecond,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Hour,
+	}
+
+	// act
+	if _, err := d.Send(context.Background(), ts.URL, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	finished := d.Close(5 * time.Second)
+
+	// assert
+	if !finished {
+		t.Error("delivery deferred past MaxAttempts while the circuit was open")
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("requests want: 1 got: %d", got)
+	}
+}
+
+func TestWebhookDispatcherHalfOpen(t *testing.T) {
+	// arrange
+	var requests, trialDone int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&trialDone, 1)
+			return
+		}
+		if atomic.LoadInt32(&trialDone) == 0 {
+			t.Error("delivery sent while the trial delivery was in flight")
+		}
+	}))
+	defer ts.Close()
+
+	d := &WebhookDispatcher{
+		NewLogEntry:      func() Entry { return &nullLogger{} },
+		BreakerThreshold: 1,
+		BreakerCooldown:  50 * time.Millisecond,
+	}
+	host := ts.Listener.Addr().String()
+	d.breaker(host).failure(time.Now(), 1, 50*time.Millisecond)
+
+	// act
+	for i := 0; i < 5; i++ {
+		if _, err := d.Send(context.Background(), ts.URL, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finished := d.Close(5 * time.Second)
+
+	// assert
+	if !finished {
+		t.Error("Close timed out")
+	}
+	if got := atomic.LoadInt32(&requests); got != 5 {
+		t.Errorf("requests want: 5 got: %d", got)
+	}
+}
+
+func TestWebhookDispatcherDefaultClient(t *testing.T) {
+	// arrange
+	d := &WebhookDispatcher{}
+
+	// act
+	first, second := d.httpClient(), d.httpClient()
+
+	// assert
+	if first != second {
+		t.Error("want the default client reused")
+	}
+}
+
+func TestWebhookDispatcherSendDuringClose(t *testing.T) {
+	// arrange
+	var requests int32
+	received := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(received)
+		}
+		<-release
+	}))
+	defer ts.Close()
+
+	d := &WebhookDispatcher{NewLogEntry: func() Entry { return &nullLogger{} }}
+	if _, err := d.Send(context.Background(), ts.URL, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	closed := make(chan bool)
+	go func() { closed <- d.Close(5 * time.Second) }()
+	for closing := false; !closing; {
+		time.Sleep(time.Millisecond)
+		d.mtx.Lock()
+		closing = d.closing
+		d.mtx.Unlock()
+	}
+
+	// act
+	_, err := d.Send(context.Background(), ts.URL, []byte(`{}`))
+	close(release)
+	finished := <-closed
+
+	// assert
+	if err != ErrWebhookQueueFull {
+		t.Errorf("Send during Close want: %v got: %v", ErrWebhookQueueFull, err)
+	}
+	if !finished {
+		t.Error("Close timed out")
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("requests want: 1 got: %d", got)
+	}
+}
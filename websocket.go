@@ -0,0 +1,366 @@
+package httplog
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// close codes logged when a connection ends without a close code
+	wsCloseNoStatus = 1005
+	wsCloseAbnormal = 1006
+)
+
+// WebSocketHandler serves WebSocket connections. See Server.HandleWebSocket.
+type WebSocketHandler struct {
+	Name string
+	// Protocols lists the subprotocols supported, in order of preference.
+	// The first one the client offers is selected. Optional.
+	Protocols []string
+	// CheckOrigin reports whether the request's Origin is allowed. The
+	// default allows requests without an Origin header and those whose
+	// Origin host matches the request's Host.
+	CheckOrigin func(r *http.Request) bool
+	// Func serves the connection once the handshake is complete, reading
+	// and writing WebSocket frames on conn, for example with
+	// github.com/gobwas/ws/wsutil. The connection is closed when it
+	// returns.
+	Func func(r *http.Request, entry Entry, conn *WebSocketConn) error
+}
+
+// HandleWebSocket returns a function which upgrades requests to the
+// WebSocket protocol and serves them with h. Requests which aren't valid
+// upgrades are rejected with StatusBadRequest (400), StatusForbidden (403)
+// for a disallowed Origin, or StatusUpgradeRequired (426) for an
+// unsupported version.
+//
+// The upgrade is logged as "websocket upgraded" with request_id and
+// handler. The connection counts as open for Shutdown until Func returns;
+// WebSocketConn.Done reports when Shutdown starts so Func can close with
+// code 1001. The access log is written when the connection closes, with
+// http_status 101, time_taken covering the whole connection, bytes_sent,
+// and ws_protocol, ws_bytes_in, ws_bytes_out, ws_close_code, and
+// ws_close_initiator ("client" or "server"). A connection which ends
+// without a close frame is logged with ws_close_code 1006.
+//
+// Since the request holds a slot for the life of the connection,
+// MaxConcurrentRequests must allow for open sockets.
+func (svr *Server) HandleWebSocket(h WebSocketHandler) func(w http.ResponseWriter, r *http.Request) {
+	checkOrigin := h.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	return svr.Handle(Handler{
+		Name:    h.Name,
+		Methods: []string{"GET"},
+		Func: func(r *http.Request, entry Entry) (Response, error) {
+			if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+				entry.AddField("ws_invalid_upgrade", true)
+				return Response{Status: http.StatusBadRequest}, nil
+			}
+			if r.Header.Get("Sec-WebSocket-Version") != "13" {
+				return Response{Status: http.StatusUpgradeRequired, Headers: []Header{{Name: "Sec-WebSocket-Version", Value: "13"}}}, nil
+			}
+			key := r.Header.Get("Sec-WebSocket-Key")
+			if key == "" {
+				entry.AddField("ws_invalid_upgrade", true)
+				return Response{Status: http.StatusBadRequest}, nil
+			}
+			if !checkOrigin(r) {
+				entry.AddField("ws_origin_rejected", r.Header.Get("Origin"))
+				return Response{Status: http.StatusForbidden}, nil
+			}
+			protocol := selectProtocol(h.Protocols, r.Header)
+
+			return Response{Raw: func(w http.ResponseWriter) (int, int, error) {
+				conn, err := svr.upgrade(w, r, key, protocol)
+				if err != nil {
+					return http.StatusInternalServerError, 0, err
+				}
+
+				upgraded := svr.newEntry()
+				upgraded.AddFields(map[string]interface{}{
+					"request_id": RequestIDFromContext(r.Context()),
+					"handler":    h.Name,
+				})
+				upgraded.Info("websocket upgraded")
+
+				_, err = callRecover(func() error { return h.Func(r, entry, conn) })
+				closeErr := conn.Close()
+				if err == nil && closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+					err = closeErr
+				}
+				entry.AddFields(conn.logFields())
+				return http.StatusSwitchingProtocols, int(conn.bytesOut), err
+			}}, nil
+		},
+	})
+}
+
+// upgrade completes the handshake on the hijacked connection.
+func (svr *Server) upgrade(w http.ResponseWriter, r *http.Request, key, protocol string) (*WebSocketConn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("httplog: websocket: ResponseWriter doesn't support hijacking")
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// clear the http.Server's read and write deadlines
+	_ = netConn.SetDeadline(time.Time{})
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n")
+	if protocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	if requestID := w.Header().Get(requestIDHeader); requestID != "" {
+		b.WriteString(requestIDHeader + ": " + requestID + "\r\n")
+	}
+	b.WriteString("\r\n")
+	if _, err := rw.WriteString(b.String()); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &WebSocketConn{
+		Conn:     netConn,
+		reader:   rw.Reader,
+		Protocol: protocol,
+		done:     svr.stopping(),
+	}, nil
+}
+
+// WebSocketConn is an upgraded WebSocket connection. Reads and writes carry
+// raw WebSocket frames; they're counted and scanned for close frames so the
+// connection can be logged when it closes.
+type WebSocketConn struct {
+	net.Conn
+	// Protocol is the selected subprotocol, if any.
+	Protocol string
+
+	reader *bufio.Reader
+	done   <-chan struct{}
+
+	mtx            sync.Mutex
+	bytesIn        int64
+	bytesOut       int64
+	in, out        frameScanner
+	closeCode      int
+	closeInitiator string
+}
+
+// Read reads frames sent by the client, including any buffered during the
+// handshake.
+func (c *WebSocketConn) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.mtx.Lock()
+	c.bytesIn += int64(n)
+	if code := c.in.scan(p[:n]); code != 0 && c.closeCode == 0 {
+		c.closeCode, c.closeInitiator = code, "client"
+	}
+	c.mtx.Unlock()
+	return n, err
+}
+
+// Write writes frames to the client.
+func (c *WebSocketConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.mtx.Lock()
+	c.bytesOut += int64(n)
+	if code := c.out.scan(p[:n]); code != 0 && c.closeCode == 0 {
+		c.closeCode, c.closeInitiator = code, "server"
+	}
+	c.mtx.Unlock()
+	return n, err
+}
+
+// Done returns a channel which is closed when the Server's Shutdown starts.
+// Handlers should then close the connection with code 1001 (going away).
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *WebSocketConn) logFields() map[string]interface{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	fields := map[string]interface{}{
+		"ws_bytes_in":  c.bytesIn,
+		"ws_bytes_out": c.bytesOut,
+	}
+	if c.Protocol != "" {
+		fields["ws_protocol"] = c.Protocol
+	}
+	if c.closeCode == 0 {
+		fields["ws_close_code"] = wsCloseAbnormal
+	} else {
+		fields["ws_close_code"] = c.closeCode
+		fields["ws_close_initiator"] = c.closeInitiator
+	}
+	return fields
+}
+
+// frameScanner follows the WebSocket frames in one direction of a
+// connection to find the close frame's status code.
+type frameScanner struct {
+	header    [14]byte
+	headerLen int
+	// headerSize is the full header size, known once two bytes are read.
+	headerSize int
+
+	inPayload   bool
+	opcode      byte
+	mask        []byte
+	payloadLeft uint64
+	payloadPos  uint64
+	closeBytes  []byte
+}
+
+// scan consumes p and returns the status code of a close frame completed
+// within it, or 0.
+func (s *frameScanner) scan(p []byte) int {
+	code := 0
+	for len(p) > 0 {
+		if !s.inPayload {
+			s.header[s.headerLen] = p[0]
+			s.headerLen++
+			p = p[1:]
+			if s.headerLen == 2 {
+				s.headerSize = 2
+				switch s.header[1] & 0x7f {
+				case 126:
+					s.headerSize += 2
+				case 127:
+					s.headerSize += 8
+				}
+				if s.header[1]&0x80 != 0 {
+					s.headerSize += 4
+				}
+			}
+			if s.headerLen >= 2 && s.headerLen == s.headerSize {
+				s.startPayload()
+				if s.payloadLeft == 0 {
+					if c := s.endFrame(); c != 0 {
+						code = c
+					}
+				}
+			}
+			continue
+		}
+
+		n := uint64(len(p))
+		if n > s.payloadLeft {
+			n = s.payloadLeft
+		}
+		if s.opcode == 0x8 {
+			for i := uint64(0); i < n && len(s.closeBytes) < 2; i++ {
+				b := p[i]
+				if s.mask != nil {
+					b ^= s.mask[(s.payloadPos+i)%4]
+				}
+				s.closeBytes = append(s.closeBytes, b)
+			}
+		}
+		s.payloadPos += n
+		s.payloadLeft -= n
+		p = p[n:]
+		if s.payloadLeft == 0 {
+			if c := s.endFrame(); c != 0 {
+				code = c
+			}
+		}
+	}
+	return code
+}
+
+func (s *frameScanner) startPayload() {
+	s.opcode = s.header[0] & 0x0f
+	length := uint64(s.header[1] & 0x7f)
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(s.header[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(s.header[2:10])
+	}
+	s.mask = nil
+	if s.header[1]&0x80 != 0 {
+		s.mask = append([]byte(nil), s.header[s.headerSize-4:s.headerSize]...)
+	}
+	s.inPayload = true
+	s.payloadLeft = length
+	s.payloadPos = 0
+	s.closeBytes = s.closeBytes[:0]
+}
+
+// endFrame resets the scanner for the next frame, returning the status code
+// if the frame was a close frame.
+func (s *frameScanner) endFrame() int {
+	s.inPayload = false
+	s.headerLen = 0
+	s.headerSize = 0
+	if s.opcode != 0x8 {
+		return 0
+	}
+	if len(s.closeBytes) < 2 {
+		return wsCloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(s.closeBytes))
+}
+
+// headerHasToken reports whether the comma separated header name contains
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func selectProtocol(supported []string, h http.Header) string {
+	for _, p := range supported {
+		if headerHasToken(h, "Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// stopping returns a channel which is closed when Shutdown starts.
+func (svr *Server) stopping() chan struct{} {
+	svr.stoppingOnce.Do(func() {
+		svr.stoppingCh = make(chan struct{})
+	})
+	return svr.stoppingCh
+}
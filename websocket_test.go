@@ -0,0 +1,154 @@
+package httplog
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleWebSocket(t *testing.T) {
+	// arrange
+	sink := &recordingSink{}
+	var s Server
+	s.NewLogEntry = func() Entry { return &nullLogger{} }
+	s.Sinks = []Sink{sink}
+
+	handler := s.HandleWebSocket(WebSocketHandler{
+		Name:      "echo",
+		Protocols: []string{"chat"},
+		Func: func(_ *http.Request, _ Entry, conn *WebSocketConn) error {
+			buf := make([]byte, 64)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return nil
+				}
+				// echo the client's close frame unmasked
+				if n >= 2 && buf[0]&0x0f == 0x8 {
+					payload := make([]byte, n-6)
+					for i := range payload {
+						payload[i] = buf[6+i] ^ buf[2+i%4]
+					}
+					_, err := conn.Write(append([]byte{0x88, byte(len(payload))}, payload...))
+					return err
+				}
+			}
+		},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// act
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+ts.Listener.Addr().String()+"\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Protocol: other, chat\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// masked close frame with code 1000
+	mask := []byte{1, 2, 3, 4}
+	_, _ = conn.Write([]byte{0x88, 0x82, mask[0], mask[1], mask[2], mask[3], 0x03 ^ mask[0], 0xe8 ^ mask[1]})
+	reply, _ := io.ReadAll(br)
+	s.Shutdown()
+
+	// assert
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) ||
+		resp.Header.Get("Sec-WebSocket-Protocol") != "chat" {
+		t.Fatalf("unexpected handshake %d %v", resp.StatusCode, resp.Header)
+	}
+	if string(reply) != "\x88\x02\x03\xe8" {
+		t.Errorf("close reply want: % x got: % x", "\x88\x02\x03\xe8", reply)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("records want: 1 got: %d", len(sink.records))
+	}
+	fields := sink.records[0].Fields
+	if fields["http_status"] != http.StatusSwitchingProtocols || fields["ws_close_code"] != 1000 ||
+		fields["ws_close_initiator"] != "client" || fields["ws_protocol"] != "chat" ||
+		fields["ws_bytes_in"] != int64(8) || fields["ws_bytes_out"] != int64(4) || fields["bytes_sent"] != 4 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestHandleWebSocketRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"not upgrade", map[string]string{}, http.StatusBadRequest},
+		{"version", map[string]string{"Connection": "upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "x"}, http.StatusUpgradeRequired},
+		{"origin", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "x", "Origin": "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s Server
+			s.NewLogEntry = func() Entry { return &nullLogger{} }
+			handler := s.HandleWebSocket(WebSocketHandler{Name: "ws", Func: func(_ *http.Request, _ Entry, _ *WebSocketConn) error { return nil }})
+			req := httptest.NewRequest("GET", "http://api.example/", nil)
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			// act
+			handler(rec, req)
+			s.Shutdown()
+
+			// assert
+			if rec.Code != c.status {
+				t.Errorf("status want: %d got: %d", c.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestFrameScanner(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames string
+		code   int
+	}{
+		{"text then close", "\x81\x02hi\x88\x02\x03\xe9", 1001},
+		{"close without code", "\x88\x00", wsCloseNoStatus},
+		{"extended length", "\x82\x7e\x00\x80" + strings.Repeat("x", 128) + "\x88\x02\x0f\xa0", 4000},
+		{"no close", "\x81\x02hi", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var s frameScanner
+			code := 0
+
+			// act
+			for i := 0; i < len(c.frames); i++ {
+				if got := s.scan([]byte(c.frames[i : i+1])); got != 0 {
+					code = got
+				}
+			}
+
+			// assert
+			if code != c.code {
+				t.Errorf("code want: %d got: %d", c.code, code)
+			}
+		})
+	}
+}